@@ -0,0 +1,46 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/hyzual/mike-sierra-sierra/database"
+)
+
+// migrateDatabase has nothing left to do: the database is migrated when it is opened
+func migrateDatabase(_ context.Context, _ *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	fmt.Println("The database schema is up to date")
+	return nil
+}
+
+func backupDatabase(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := database.Backup(ctx, db, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up the database to %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/config"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/library"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func scanLibrary(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("library scan", flag.ContinueOnError)
+	roots := config.Default().Library.Roots
+	if value, ok := os.LookupEnv("MIKE_LIBRARY_ROOTS"); ok {
+		if err := roots.Set(value); err != nil {
+			return fmt.Errorf("invalid MIKE_LIBRARY_ROOTS: %w", err)
+		}
+	}
+	flags.Var(&roots, "roots", "folders of the music library, like name=path,name=path")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	libraryConfig := config.LibraryConfig{Roots: roots}
+	filesystem, err := music.NewRootsFileSystem(adapter.NewLibraryRoots(libraryConfig.RootPaths()))
+	if err != nil {
+		return fmt.Errorf("could not open the music library: %w", err)
+	}
+	report, err := music.NewScanner(filesystem, library.NewDAO(db)).Scan(ctx)
+	if err != nil {
+		return fmt.Errorf("could not scan the music library: %w", err)
+	}
+	fmt.Printf(
+		"Scanned the music library: %d folders, %d songs (%d new or changed), %d playlists\n",
+		report.Folders,
+		report.Songs,
+		report.ReadSongs,
+		report.Playlists,
+	)
+	for _, folderPath := range report.UnreadableFolders {
+		fmt.Printf("Could not read the folder %s\n", folderPath)
+	}
+	for _, filePath := range report.UnreadableFiles {
+		fmt.Printf("Could not read the playlist file %s\n", filePath)
+	}
+	return nil
+}
+
+func showLibraryStatistics(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	statistics, err := library.NewDAO(db).GetStatistics(ctx)
+	if err != nil {
+		return err
+	}
+	lastScan := "never"
+	if !statistics.LastScan.IsZero() {
+		lastScan = statistics.LastScan.Format(time.RFC3339)
+	}
+	fmt.Printf("Folders:   %d\n", statistics.Folders)
+	fmt.Printf("Songs:     %d\n", statistics.Songs)
+	fmt.Printf("Covers:    %d\n", statistics.Covers)
+	fmt.Printf("Playlists: %d\n", statistics.Playlists)
+	fmt.Printf("Duration:  %s\n", time.Duration(statistics.Duration)*time.Second)
+	fmt.Printf("Size:      %.1f MiB\n", float64(statistics.Size)/(1<<20))
+	fmt.Printf("Last scan: %s\n", lastScan)
+	return nil
+}
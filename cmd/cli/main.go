@@ -17,8 +17,119 @@
 
 package main
 
-import "fmt"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hyzual/mike-sierra-sierra/config"
+	"github.com/hyzual/mike-sierra-sierra/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const version = "v0.1.0"
+
+// command is a subcommand of the CLI, for example "user create"
+type command struct {
+	usage       string // Arguments of the command. For example "[-role admin|listener] -email <email> -username <username>"
+	description string
+	run         func(ctx context.Context, db *sql.DB, args []string) error
+}
+
+// errUsage is returned by commands called with wrong arguments, the usage of the command is then printed
+var errUsage = errors.New("wrong arguments")
+
+var commands = map[string]command{
+	"user list":           {"", "List the users", listUsers},
+	"user create":         {"[-role admin|listener] -email <email> -username <username>", "Create a user, the password is read from the standard input", createUser},
+	"user reset-password": {"<username>", "Replace the password of the user, read from the standard input, and sign them out", resetPassword},
+	"user delete":         {"<username>", "Delete the user and their playlists and sign them out", deleteUser},
+	"library scan":        {"[-roots <name>=<path>,...]", "Scan the music library and update its index", scanLibrary},
+	"library stats":       {"", "Show statistics about the library index", showLibraryStatistics},
+	"database migrate":    {"", "Apply the database migrations", migrateDatabase},
+	"database backup":     {"<destination>", "Copy the database to a new file", backupDatabase},
+	"session list":        {"", "List the active sessions", listSessions},
+	"session revoke":      {"<session-id> | -user <username>", "Revoke a session or all the sessions of a user", revokeSessions},
+}
 
 func main() {
-	fmt.Println("Mike-Sierra-Sierra CLI v0.1.0")
+	flags := flag.NewFlagSet("mike", flag.ExitOnError)
+	databasePath := flags.String("database", defaultPath("MIKE_DATABASE_PATH", config.Default().Database.Path), "path to the SQLite database file")
+	flags.Usage = func() { printUsage(flags) }
+	_ = flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) < 2 {
+		printUsage(flags)
+		os.Exit(2)
+	}
+	name := args[0] + " " + args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage(flags)
+		os.Exit(2)
+	}
+
+	db, err := openDatabase(*databasePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	err = cmd.run(context.Background(), db, args[2:])
+	db.Close()
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "Usage: mike [-database <path>] %s %s\n", name, cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage(flags *flag.FlagSet) {
+	output := flags.Output()
+	fmt.Fprintf(output, "Mike-Sierra-Sierra CLI %s\n\nUsage: mike [-database <path>] <command> [arguments]\n\n", version)
+	flags.PrintDefaults()
+	fmt.Fprintln(output, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(output, "  %s\n", strings.TrimSpace(name+" "+cmd.usage))
+		fmt.Fprintf(output, "    \t%s\n", cmd.description)
+	}
+}
+
+// defaultPath returns the path set in the environment variable for the webserver, or the webserver's default path
+func defaultPath(env string, defaultValue string) string {
+	if value, ok := os.LookupEnv(env); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// openDatabase opens the same database as the webserver and migrates it, so that commands
+// always work on an up-to-date schema. The database file must exist.
+func openDatabase(databasePath string) (*sql.DB, error) {
+	if _, err := os.Stat(databasePath); err != nil {
+		return nil, fmt.Errorf("could not find the database: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+databasePath+"?mode=rw&"+database.ConnectionOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the database: %w", err)
+	}
+	if err = database.Migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate the database: %w", err)
+	}
+	return db, nil
 }
@@ -0,0 +1,109 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	sqlitestore "github.com/hyzual/sessionup-sqlitestore"
+)
+
+func listSessions(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	// The store creates the sessions table when the webserver has never run
+	if _, err := sqlitestore.New(db, user.SessionsTable, 0); err != nil {
+		return fmt.Errorf("could not open the sessions store: %w", err)
+	}
+	sessions, err := user.NewSessionDAO(db).ListSessions(ctx)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tUSER\tCREATED\tEXPIRES\tIP\tBROWSER\tOS")
+	for _, session := range sessions {
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.ID,
+			session.Username,
+			session.CreatedAt.Local().Format(time.RFC3339),
+			session.ExpiresAt.Local().Format(time.RFC3339),
+			session.IP,
+			session.Browser,
+			session.OS,
+		)
+	}
+	return writer.Flush()
+}
+
+func revokeSessions(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("session revoke", flag.ContinueOnError)
+	username := flags.String("user", "", "revoke all the sessions of this user")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *username != "" {
+		if flags.NArg() != 0 {
+			return errUsage
+		}
+		account, err := findUser(ctx, user.NewAccountDAO(db), *username)
+		if err != nil {
+			return err
+		}
+		if err = revokeUserSessions(ctx, db, account.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked the sessions of %s\n", account.Username)
+		return nil
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	store, err := sqlitestore.New(db, user.SessionsTable, 0)
+	if err != nil {
+		return fmt.Errorf("could not open the sessions store: %w", err)
+	}
+	if err = store.DeleteByID(ctx, flags.Arg(0)); err != nil {
+		return fmt.Errorf("could not revoke the session: %w", err)
+	}
+	fmt.Println("Revoked the session")
+	return nil
+}
+
+// revokeUserSessions signs the user out everywhere
+func revokeUserSessions(ctx context.Context, db *sql.DB, userID uint) error {
+	store, err := sqlitestore.New(db, user.SessionsTable, 0)
+	if err != nil {
+		return fmt.Errorf("could not open the sessions store: %w", err)
+	}
+	// Sessions are keyed by the user's identifier, see the sign-in handler
+	if err = store.DeleteByUserKey(ctx, strconv.FormatUint(uint64(userID), 10)); err != nil {
+		return fmt.Errorf("could not revoke the sessions of user #%d: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+)
+
+func listUsers(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	accounts, err := user.NewAccountDAO(db).ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tUSERNAME\tEMAIL\tROLE\tDISABLED")
+	for _, account := range accounts {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%t\n", account.ID, account.Username, account.Email, account.Role, account.Disabled)
+	}
+	return writer.Flush()
+}
+
+func createUser(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	role := flags.String("role", string(user.RoleListener), "role of the user")
+	email := flags.String("email", "", "email of the user")
+	username := flags.String("username", "", "username of the user")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *email == "" || *username == "" {
+		return errUsage
+	}
+	if !user.Role(*role).IsValid() {
+		return fmt.Errorf("unknown role %s", *role)
+	}
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	form := &user.RegistrationForm{Email: *email, Password: password, Username: *username}
+	registration, err := user.NewRegistration(form, user.Role(*role))
+	if err != nil {
+		return err
+	}
+	account, err := user.NewAccountDAO(db).SaveUser(ctx, registration)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created the user #%d %s\n", account.ID, account.Username)
+	return nil
+}
+
+func resetPassword(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	accountStore := user.NewAccountDAO(db)
+	account, err := findUser(ctx, accountStore, args[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	passwordHash, err := user.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err = accountStore.UpdatePassword(ctx, account.ID, passwordHash); err != nil {
+		return err
+	}
+	if err = revokeUserSessions(ctx, db, account.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Replaced the password of %s\n", account.Username)
+	return nil
+}
+
+func deleteUser(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	accountStore := user.NewAccountDAO(db)
+	account, err := findUser(ctx, accountStore, args[0])
+	if err != nil {
+		return err
+	}
+	if err = accountStore.DeleteUser(ctx, account.ID); err != nil {
+		return err
+	}
+	if err = revokeUserSessions(ctx, db, account.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted the user %s\n", account.Username)
+	return nil
+}
+
+// findUser returns the user with the given username. It returns user.ErrUserNotFound when there is no such user.
+func findUser(ctx context.Context, accountStore user.AccountStore, username string) (*user.Account, error) {
+	accounts, err := accountStore.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if account.Username == username {
+			return &account, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", user.ErrUserNotFound, username)
+}
+
+// readPassword reads the first line of input so that the password can be typed or piped.
+// The password is never given as argument, it would be visible in the shell history and in the process list.
+func readPassword(input io.Reader) (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("could not read the password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("the password cannot be empty")
+	}
+	return password, nil
+}
@@ -18,7 +18,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -28,94 +31,273 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/hyzual/mike-sierra-sierra"
+	"github.com/hyzual/mike-sierra-sierra/config"
+	"github.com/hyzual/mike-sierra-sierra/database"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/library"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter/rest"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/app"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/subsonic"
 	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
 	sqlitestore "github.com/hyzual/sessionup-sqlitestore"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/swithek/sessionup"
 )
 
-const disableHTTPSEnv = "MIKE_DISABLE_HTTPS"
-
 func main() {
+	conf, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("could not load the configuration: %v", err)
+	}
 	cwd, err := mike.Cwd()
 	if err != nil {
 		log.Fatalf("could not read the current working directory: %v", err)
 	}
-	db, err := sql.Open("sqlite3", "file:database/file/mike.db?mode=rwc&cache=shared")
+	db, err := sql.Open("sqlite3", "file:"+conf.Database.Path+"?mode=rwc&"+database.ConnectionOptions)
 	if err != nil {
 		log.Fatalf("could not connect to the database: %v", err)
 	}
 	db.SetConnMaxLifetime(1 * time.Hour)
+	if err = database.Migrate(context.Background(), db); err != nil {
+		log.Fatalf("could not migrate the database: %v", err)
+	}
 
 	userStore := user.NewDAO(db)
+	accountStore := user.NewAccountDAO(db)
+	secretKey, err := adapter.ReadOrCreateKey(conf.Security.SecretKeyFile)
+	if err != nil {
+		log.Fatalf("could not load the secret key: %v", err)
+	}
+	secretCipher, err := user.NewSecretCipher(secretKey)
+	if err != nil {
+		log.Fatalf("could not create the secret cipher: %v", err)
+	}
+	twoFactor := user.NewTwoFactorAuthenticator(user.NewTwoFactorDAO(db), secretCipher)
+	throttleStore := user.NewSignInThrottleDAO(db)
 	assetsPath := path.Join(cwd, "assets")
 	assetsLoader := adapter.NewBasePathJoiner(cwd)
 	templatesPath := path.Join(cwd, "templates")
 	templateExecutor := adapter.NewTemplateExecutor(templatesPath)
-	musicLoader := adapter.NewBasePathJoiner(music.MusicPath)
+	musicLoader := adapter.NewRootsPathJoiner(conf.Library.RootPaths())
 	assetsResolver := adapter.NewAssetsResolver(os.DirFS(assetsPath), "/assets")
 
-	sessionStore, err := sqlitestore.New(db, "sessions", time.Minute*30)
+	sessionLifetime := conf.Sessions.Lifetime.Duration
+	sessionStore, err := sqlitestore.New(db, user.SessionsTable, sessionLifetime)
 	if err != nil {
 		log.Fatalf("error while creating a new sessions Store: %v", err)
 	}
 	sessionManager := sessionup.NewManager(
 		sessionStore,
 		sessionup.CookieName("id"),
+		sessionup.ExpiresIn(sessionLifetime),
 		sessionup.Reject(server.HandleUnauthorized),
 	)
 	router := mux.NewRouter()
 	decoder := schema.NewDecoder()
+	csrf := server.NewCSRFProtection(secretKey)
+	signInThrottle := user.NewSignInThrottle(throttleStore)
 	user.Register(
 		router,
 		templateExecutor,
 		assetsResolver,
 		userStore,
+		accountStore,
+		twoFactor,
+		signInThrottle,
 		sessionManager,
+		csrf,
 		decoder,
 	)
-	musicDirFS := os.DirFS(music.MusicPath)
-	musicLibraryFileSystem := adapter.NewOSFileSystem(musicDirFS, musicLoader)
-	explorer := music.NewMusicLibraryExplorer(musicLibraryFileSystem)
-	rest.Register(router, sessionManager, explorer)
+	musicLibraryFileSystem, err := music.NewRootsFileSystem(adapter.NewLibraryRoots(conf.Library.RootPaths()))
+	if err != nil {
+		log.Fatalf("could not open the music library: %v", err)
+	}
+	libraryIndex := library.NewDAO(db)
+	explorer := music.NewIndexedMusicLibraryExplorer(libraryIndex)
+	scanner := music.NewScanner(musicLibraryFileSystem, libraryIndex)
+	go synchronizeLibrary(conf.Library, scanner, musicLibraryFileSystem)
+	searcher := music.NewSearcher(libraryIndex)
+	playlistStore := library.NewPlaylistDAO(db)
+	playStore := library.NewPlayDAO(db)
+	scrobbleStore := library.NewScrobbleDAO(db)
+	nowPlaying := startScrobbling(conf.Scrobbling, scrobbleStore)
+	coverCache, err := adapter.NewDiskCoverCache(conf.Cache.CoversPath, conf.Cache.CoversMaxSize.Bytes)
+	if err != nil {
+		log.Fatalf("could not create the cover cache: %v", err)
+	}
+	coverLoader := music.NewCoverLoader(musicLibraryFileSystem, libraryIndex, coverCache)
+	rest.Register(
+		router,
+		sessionManager,
+		music.NewFolderBrowser(libraryIndex),
+		libraryIndex,
+		searcher,
+		libraryIndex,
+		playlistStore,
+		libraryIndex,
+		coverLoader,
+		playStore,
+		scrobbleStore,
+		userStore,
+		accountStore,
+		throttleStore,
+		secretCipher,
+	)
 	app.Register(
 		router,
 		templateExecutor,
 		assetsResolver,
 		userStore,
 		sessionManager,
+		csrf,
+	)
+	transcoder, transcodeCache := newTranscoder(conf.Transcoding.FFmpegPath, conf.Cache)
+	subsonic.Register(
+		router,
+		explorer,
+		libraryIndex,
+		searcher,
+		playlistStore,
+		coverLoader,
+		server.NewStreamHandler(musicLibraryFileSystem, musicLoader, transcoder, transcodeCache, playStore, nowPlaying),
+		userStore,
+		signInThrottle,
+		secretCipher,
 	)
 	server.Register(
 		router,
 		sessionManager,
 		assetsLoader,
+		musicLibraryFileSystem,
 		musicLoader,
+		transcoder,
+		transcodeCache,
+		playStore,
+		nowPlaying,
 	)
 
-	var port string
-	isHTTPSDisabled := os.Getenv(disableHTTPSEnv) != ""
-	if isHTTPSDisabled {
-		port = "8080"
-	} else {
-		port = "8443"
-	}
 	srv := &http.Server{
 		Handler:      router,
-		Addr:         ":" + port,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		Addr:         conf.Server.Address(),
+		WriteTimeout: conf.Server.WriteTimeout.Duration,
+		ReadTimeout:  conf.Server.ReadTimeout.Duration,
 	}
-	if isHTTPSDisabled {
+	if conf.Server.DisableHTTPS {
 		err = srv.ListenAndServe()
 	} else {
-		err = srv.ListenAndServeTLS("./secrets/cert.pem", "./secrets/key.pem")
+		err = srv.ListenAndServeTLS(conf.Server.CertFile, conf.Server.KeyFile)
+	}
+	if err != nil {
+		log.Fatalf("could not listen on %s %v", srv.Addr, err)
+	}
+}
+
+// newTranscoder returns a nil Transcoder when ffmpeg is not installed, music files are then always served as they are
+func newTranscoder(ffmpegPath string, conf config.CacheConfig) (music.Transcoder, adapter.TranscodeCache) {
+	transcoder, err := adapter.NewFFmpegTranscoder(ffmpegPath)
+	if err != nil {
+		log.Printf("transcoding is disabled: %v", err)
+		return nil, nil
 	}
+	transcodeCache, err := adapter.NewDiskTranscodeCache(conf.TranscodesPath, conf.TranscodesMaxSize.Bytes)
 	if err != nil {
-		log.Fatalf("could not listen on port %s %v", port, err)
+		log.Fatalf("could not create the transcode cache: %v", err)
+	}
+	return transcoder, transcodeCache
+}
+
+// startScrobbling submits the queued plays in the background. It returns nil when scrobbling is disabled.
+func startScrobbling(conf config.ScrobblingConfig, scrobbleStore music.ScrobbleStore) music.NowPlayingNotifier {
+	if conf.ListenBrainzURL == "" {
+		log.Printf("scrobbling is disabled")
+		return nil
+	}
+	submitter := music.NewScrobbleSubmitter(adapter.NewListenBrainzScrobbler(conf.ListenBrainzURL), scrobbleStore)
+	go submitScrobbles(submitter, conf.Interval.Duration)
+	return submitter
+}
+
+// submitScrobbles submits the queued plays every interval
+func submitScrobbles(submitter *music.ScrobbleSubmitter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := submitter.SubmitPending(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("could not submit the queued plays: %v", err)
+			continue
+		}
+		if report.Submitted != 0 {
+			log.Printf("submitted %d plays to the scrobbling service", report.Submitted)
+		}
+		if report.Dropped != 0 {
+			log.Printf("dropped %d plays rejected by the scrobbling service", report.Dropped)
+		}
+		for _, failure := range report.Failures {
+			log.Printf("%v", failure)
+		}
+	}
+}
+
+// librarySettleDelay is how long the library must stay unchanged before the changed folders are scanned
+const librarySettleDelay = 5 * time.Second
+
+// synchronizeLibrary updates the library index, then keeps it in sync with the files of the library roots.
+// Until the first scan finishes, the library appears empty.
+func synchronizeLibrary(conf config.LibraryConfig, scanner music.Scanner, filesystem music.MusicLibraryFileSystem) {
+	synchronizer := music.NewLibrarySynchronizer(
+		scanner,
+		filesystem,
+		librarySettleDelay,
+		conf.ScanInterval.Duration,
+		logScan,
+	)
+	var changes <-chan string
+	if conf.Watch {
+		watcher, err := adapter.NewLibraryWatcher(conf.RootPaths())
+		if err != nil {
+			log.Printf("could not watch the music library, relying on periodic scans: %v", err)
+		} else {
+			defer watcher.Close()
+			go logWatchErrors(watcher.Errors())
+			changes = watcher.Changes()
+		}
+	}
+	synchronizer.Run(context.Background(), changes)
+}
+
+func logWatchErrors(errs <-chan error) {
+	for err := range errs {
+		log.Printf("error while watching the music library: %v", err)
+	}
+}
+
+func logScan(folderPath string, report *music.ScanReport, err error) {
+	scanned := "the music library"
+	if folderPath != "." {
+		scanned = "the folder " + folderPath
+	}
+	if err != nil {
+		log.Printf("could not scan %s: %v", scanned, err)
+		return
+	}
+	log.Printf(
+		"scanned %s: %d folders, %d songs (%d new or changed), %d playlists",
+		scanned,
+		report.Folders,
+		report.Songs,
+		report.ReadSongs,
+		report.Playlists,
+	)
+	for _, folderPath := range report.UnreadableFolders {
+		log.Printf("could not read the folder %s during the scan", folderPath)
+	}
+	for _, filePath := range report.UnreadableFiles {
+		log.Printf("could not read the playlist %s during the scan", filePath)
 	}
 }
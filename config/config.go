@@ -0,0 +1,445 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package config loads the configuration of the webserver. Every setting has a default value
+that can be overridden, in order of precedence, by a TOML file, by a MIKE_* environment variable
+and by a command-line flag. See mike.example.toml for the settings and their defaults.
+*/
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	// envPrefix prefixes the environment variables. The environment variable of the
+	// "https-port" flag is MIKE_HTTPS_PORT
+	envPrefix = "MIKE_"
+	// configFileFlag is the flag giving the path to the TOML file. Its environment variable is MIKE_CONFIG
+	configFileFlag = "config"
+)
+
+// ErrInvalidConfig is returned when a setting has a value that does not make sense
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config holds all the settings of the webserver
+type Config struct {
+	Database    DatabaseConfig    `toml:"database"`
+	Server      ServerConfig      `toml:"server"`
+	Library     LibraryConfig     `toml:"library"`
+	Sessions    SessionsConfig    `toml:"sessions"`
+	Transcoding TranscodingConfig `toml:"transcoding"`
+	Cache       CacheConfig       `toml:"cache"`
+	Scrobbling  ScrobblingConfig  `toml:"scrobbling"`
+	Security    SecurityConfig    `toml:"security"`
+}
+
+// DatabaseConfig holds the settings of the SQLite database
+type DatabaseConfig struct {
+	Path string `toml:"path"` // Path to the database file. It is created when it does not exist.
+}
+
+// ServerConfig holds the settings of the HTTP server
+type ServerConfig struct {
+	DisableHTTPS bool     `toml:"disable_https"` // Serve HTTP on HTTPPort instead of HTTPS on HTTPSPort
+	HTTPPort     uint     `toml:"http_port"`
+	HTTPSPort    uint     `toml:"https_port"`
+	CertFile     string   `toml:"cert_file"` // Path to the TLS certificate
+	KeyFile      string   `toml:"key_file"`  // Path to the TLS private key
+	ReadTimeout  Duration `toml:"read_timeout"`
+	// WriteTimeout bounds the time to write a response, including the songs streamed. Zero disables it.
+	WriteTimeout Duration `toml:"write_timeout"`
+}
+
+// Address returns the address the server listens on. For example ":8443"
+func (s ServerConfig) Address() string {
+	if s.DisableHTTPS {
+		return fmt.Sprintf(":%d", s.HTTPPort)
+	}
+	return fmt.Sprintf(":%d", s.HTTPSPort)
+}
+
+// LibraryConfig holds the settings of the music library
+type LibraryConfig struct {
+	Roots LibraryRoots `toml:"roots"` // Folders containing the music files
+	// Watch tells whether the roots are watched to update the library index as soon as files change
+	Watch bool `toml:"watch"`
+	// ScanInterval is how often the whole library is scanned, for the changes that cannot be watched. Zero disables it.
+	ScanInterval Duration `toml:"scan_interval"`
+}
+
+// LibraryRoot is a named folder of the music library. Its name is the top-level folder of the library
+// where its files appear, and it is part of their URIs.
+type LibraryRoot struct {
+	Name string `toml:"name"`
+	Path string `toml:"path"` // Path to the folder containing the music files
+}
+
+// LibraryRoots is written like "lossless=/mnt/flac,podcasts=/srv/podcasts" in the environment and flags
+type LibraryRoots []LibraryRoot
+
+// String implements flag.Value
+func (r *LibraryRoots) String() string {
+	if r == nil {
+		return ""
+	}
+	roots := make([]string, 0, len(*r))
+	for _, root := range *r {
+		roots = append(roots, root.Name+"="+root.Path)
+	}
+	return strings.Join(roots, ",")
+}
+
+// Set implements flag.Value. It replaces all the roots.
+func (r *LibraryRoots) Set(value string) error {
+	roots := LibraryRoots{}
+	for _, root := range strings.Split(value, ",") {
+		parts := strings.SplitN(root, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("%q is not written like name=path", root)
+		}
+		roots = append(roots, LibraryRoot{Name: parts[0], Path: parts[1]})
+	}
+	*r = roots
+	return nil
+}
+
+// RootPaths maps the name of each root to its path
+func (l LibraryConfig) RootPaths() map[string]string {
+	paths := make(map[string]string, len(l.Roots))
+	for _, root := range l.Roots {
+		paths[root.Name] = root.Path
+	}
+	return paths
+}
+
+// SessionsConfig holds the settings of the sessions of signed-in users
+type SessionsConfig struct {
+	// Lifetime is how long users stay signed in. Expired sessions are also cleaned up at this interval.
+	Lifetime Duration `toml:"lifetime"`
+}
+
+// TranscodingConfig holds the settings of the transcoding of songs
+type TranscodingConfig struct {
+	FFmpegPath string `toml:"ffmpeg_path"` // ffmpeg executable, looked up in the PATH when it is not a path
+}
+
+// CacheConfig holds the settings of the disk caches. When a cache takes more than its maximum size,
+// its least recently used files are removed.
+type CacheConfig struct {
+	CoversPath        string `toml:"covers_path"` // Folder of the resized covers. It is created when it does not exist.
+	CoversMaxSize     Size   `toml:"covers_max_size"`
+	TranscodesPath    string `toml:"transcodes_path"` // Folder of the finished transcodes. It is created when it does not exist.
+	TranscodesMaxSize Size   `toml:"transcodes_max_size"`
+}
+
+// ScrobblingConfig holds the settings of the forwarding of plays to a scrobbling service
+type ScrobblingConfig struct {
+	// ListenBrainzURL is the base URL of the ListenBrainz API. Scrobbling is disabled when it is empty.
+	ListenBrainzURL string   `toml:"listenbrainz_url"`
+	Interval        Duration `toml:"interval"` // How often the queued plays are submitted
+}
+
+// SecurityConfig holds the settings protecting the accounts of the users
+type SecurityConfig struct {
+	// SecretKeyFile is the path to the key encrypting the secrets saved in the database, such as the
+	// two-factor authentication secrets. It is generated when the file does not exist.
+	SecretKeyFile string `toml:"secret_key_file"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Database: DatabaseConfig{Path: "database/file/mike.db"},
+		Server: ServerConfig{
+			HTTPPort:     8080,
+			HTTPSPort:    8443,
+			CertFile:     "./secrets/cert.pem",
+			KeyFile:      "./secrets/key.pem",
+			ReadTimeout:  Duration{15 * time.Second},
+			WriteTimeout: Duration{0},
+		},
+		Library: LibraryConfig{
+			Roots:        LibraryRoots{{Name: "music", Path: "/music"}},
+			Watch:        true,
+			ScanInterval: Duration{time.Hour},
+		},
+		Sessions:    SessionsConfig{Lifetime: Duration{30 * time.Minute}},
+		Transcoding: TranscodingConfig{FFmpegPath: "ffmpeg"},
+		Cache: CacheConfig{
+			CoversPath:        "./cache/covers",
+			CoversMaxSize:     Size{256 << 20},
+			TranscodesPath:    "./cache/transcodes",
+			TranscodesMaxSize: Size{4 << 30},
+		},
+		Scrobbling: ScrobblingConfig{ListenBrainzURL: "https://api.listenbrainz.org", Interval: Duration{time.Minute}},
+		Security:   SecurityConfig{SecretKeyFile: "./secrets/secret.key"},
+	}
+}
+
+// Load reads the configuration from the TOML file, the environment and the command-line arguments
+// (without the program name), then validates it. lookupEnv is usually os.LookupEnv.
+func Load(args []string, lookupEnv func(key string) (string, bool)) (*Config, error) {
+	config := Default()
+	flags := newFlagSet(config)
+	configFile := flags.String(configFileFlag, "", "path to a TOML configuration file")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	// Flags are parsed first to find the configuration file, but they take precedence over it.
+	commandLine := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = f.Value.String()
+	})
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(envName(configFileFlag))
+	}
+	*config = *Default()
+	if *configFile != "" {
+		if err := readFile(*configFile, config); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok || f.Name == configFileFlag || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%w: invalid value %q for %s: %v", ErrInvalidConfig, value, envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range commandLine {
+		if err = flags.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// newFlagSet binds a flag to every setting of config
+func newFlagSet(config *Config) *flag.FlagSet {
+	flags := flag.NewFlagSet("webserver", flag.ContinueOnError)
+	flags.StringVar(&config.Database.Path, "database-path", config.Database.Path, "path to the SQLite database file")
+	flags.BoolVar(&config.Server.DisableHTTPS, "disable-https", config.Server.DisableHTTPS, "serve HTTP instead of HTTPS")
+	flags.UintVar(&config.Server.HTTPPort, "http-port", config.Server.HTTPPort, "port of the server when HTTPS is disabled")
+	flags.UintVar(&config.Server.HTTPSPort, "https-port", config.Server.HTTPSPort, "port of the server")
+	flags.StringVar(&config.Server.CertFile, "cert-file", config.Server.CertFile, "path to the TLS certificate")
+	flags.StringVar(&config.Server.KeyFile, "key-file", config.Server.KeyFile, "path to the TLS private key")
+	flags.Var(&config.Server.ReadTimeout, "read-timeout", "maximum duration for reading requests")
+	flags.Var(&config.Server.WriteTimeout, "write-timeout", "maximum duration for writing responses, 0 to disable")
+	flags.Var(&config.Library.Roots, "library-roots", "folders of the music library, like name=path,name=path")
+	flags.BoolVar(&config.Library.Watch, "library-watch", config.Library.Watch, "update the library as soon as files change")
+	flags.Var(&config.Library.ScanInterval, "library-scan-interval", "how often the whole library is scanned, 0 to disable")
+	flags.Var(&config.Sessions.Lifetime, "session-lifetime", "how long users stay signed in")
+	flags.StringVar(&config.Transcoding.FFmpegPath, "ffmpeg-path", config.Transcoding.FFmpegPath, "ffmpeg executable")
+	flags.StringVar(&config.Cache.CoversPath, "covers-cache-path", config.Cache.CoversPath, "folder of the resized covers")
+	flags.Var(&config.Cache.CoversMaxSize, "covers-cache-max-size", "maximum size of the resized covers, like 256MiB")
+	flags.StringVar(&config.Cache.TranscodesPath, "transcodes-cache-path", config.Cache.TranscodesPath, "folder of the finished transcodes")
+	flags.Var(&config.Cache.TranscodesMaxSize, "transcodes-cache-max-size", "maximum size of the finished transcodes, like 4GiB")
+	flags.StringVar(&config.Scrobbling.ListenBrainzURL, "listenbrainz-url", config.Scrobbling.ListenBrainzURL, "base URL of the ListenBrainz API, empty to disable scrobbling")
+	flags.Var(&config.Scrobbling.Interval, "scrobbling-interval", "how often the queued plays are submitted")
+	flags.StringVar(&config.Security.SecretKeyFile, "secret-key-file", config.Security.SecretKeyFile, "path to the key encrypting the secrets saved in the database")
+	return flags
+}
+
+// envName returns the environment variable overriding the flag. For example MIKE_HTTPS_PORT for "https-port"
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readFile decodes the TOML file into config. Unknown settings are refused, they are most likely typos.
+func readFile(configFile string, config *Config) error {
+	metadata, err := toml.DecodeFile(configFile, config)
+	if err != nil {
+		return fmt.Errorf("could not read the configuration file %s: %w", configFile, err)
+	}
+	if undecoded := metadata.Undecoded(); len(undecoded) != 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return fmt.Errorf("%w: unknown settings in %s: %s", ErrInvalidConfig, configFile, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// Validate checks that every setting has a value that makes sense
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Database.Path == "" {
+		problems = append(problems, "the database path is empty")
+	}
+	for _, port := range []uint{c.Server.HTTPPort, c.Server.HTTPSPort} {
+		if port == 0 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("the port %d is not between 1 and 65535", port))
+		}
+	}
+	if !c.Server.DisableHTTPS {
+		for _, file := range []string{c.Server.CertFile, c.Server.KeyFile} {
+			if _, err := os.Stat(file); err != nil {
+				problems = append(problems, fmt.Sprintf("the TLS file cannot be read: %v", err))
+			}
+		}
+	}
+	if c.Server.ReadTimeout.Duration <= 0 {
+		problems = append(problems, "the read timeout must be positive")
+	}
+	if c.Server.WriteTimeout.Duration < 0 {
+		problems = append(problems, "the write timeout must not be negative")
+	}
+	problems = append(problems, c.Library.validateRoots()...)
+	if c.Library.ScanInterval.Duration < 0 {
+		problems = append(problems, "the library scan interval must not be negative")
+	}
+	if c.Sessions.Lifetime.Duration < time.Minute {
+		problems = append(problems, "the session lifetime must be at least one minute")
+	}
+	if c.Transcoding.FFmpegPath == "" {
+		problems = append(problems, "the ffmpeg path is empty")
+	}
+	if c.Cache.CoversPath == "" || c.Cache.TranscodesPath == "" {
+		problems = append(problems, "the cache paths are empty")
+	}
+	if c.Cache.CoversMaxSize.Bytes <= 0 || c.Cache.TranscodesMaxSize.Bytes <= 0 {
+		problems = append(problems, "the maximum sizes of the caches must be positive")
+	}
+	if c.Scrobbling.ListenBrainzURL != "" {
+		if parsed, err := url.Parse(c.Scrobbling.ListenBrainzURL); err != nil || parsed.Host == "" ||
+			(parsed.Scheme != "http" && parsed.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("the ListenBrainz URL %q is not an HTTP URL", c.Scrobbling.ListenBrainzURL))
+		}
+	}
+	if c.Scrobbling.Interval.Duration < time.Second {
+		problems = append(problems, "the scrobbling interval must be at least one second")
+	}
+	if c.Security.SecretKeyFile == "" {
+		problems = append(problems, "the secret key path is empty")
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateRoots checks that roots have unique names that can be folder names, and that their paths are folders
+func (l LibraryConfig) validateRoots() []string {
+	if len(l.Roots) == 0 {
+		return []string{"the music library has no root"}
+	}
+	var problems []string
+	names := make(map[string]bool, len(l.Roots))
+	for _, root := range l.Roots {
+		if !music.IsValidRootName(root.Name) {
+			problems = append(problems, fmt.Sprintf("the library root name %q cannot be a folder name", root.Name))
+		}
+		if names[root.Name] {
+			problems = append(problems, fmt.Sprintf("the library root name %q is used twice", root.Name))
+		}
+		names[root.Name] = true
+		if info, err := os.Stat(root.Path); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("the path %s of the library root %s is not a folder", root.Path, root.Name))
+		}
+	}
+	return problems
+}
+
+// Duration is a time.Duration written like "15s" or "1h30m" in the TOML file, the environment and flags
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for the TOML file
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// Set implements flag.Value
+func (d *Duration) Set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// Size is a number of bytes written like "256MiB", "4GiB" or "1048576" in the TOML file, the environment and flags
+type Size struct {
+	Bytes int64
+}
+
+// sizeUnits are the units of sizes, largest first
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}}
+
+// UnmarshalText implements encoding.TextUnmarshaler for the TOML file
+func (s *Size) UnmarshalText(text []byte) error {
+	return s.Set(string(text))
+}
+
+// Set implements flag.Value
+func (s *Size) Set(value string) error {
+	number, unitBytes := value, int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			number, unitBytes = strings.TrimSuffix(value, unit.suffix), unit.bytes
+			break
+		}
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || count > math.MaxInt64/unitBytes || count < math.MinInt64/unitBytes {
+		return fmt.Errorf("%q is not a size like 256MiB", value)
+	}
+	s.Bytes = count * unitBytes
+	return nil
+}
+
+// String implements flag.Value. It uses the largest unit the size is a multiple of.
+func (s *Size) String() string {
+	if s == nil {
+		return ""
+	}
+	for _, unit := range sizeUnits {
+		if s.Bytes != 0 && s.Bytes%unit.bytes == 0 {
+			return strconv.FormatInt(s.Bytes/unit.bytes, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(s.Bytes, 10)
+}
@@ -0,0 +1,217 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	musicPath := t.TempDir()
+	// The default music path and TLS files do not exist in the tests
+	baseEnv := map[string]string{"MIKE_LIBRARY_ROOTS": "music=" + musicPath, "MIKE_DISABLE_HTTPS": "1"}
+
+	t.Run("without overrides, it returns the defaults", func(t *testing.T) {
+		got, err := Load(nil, lookupIn(baseEnv))
+		assertNoError(t, err)
+		want := Default()
+		want.Library.Roots[0].Path = musicPath
+		want.Server.DisableHTTPS = true
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("the file overrides the defaults, the environment overrides the file and flags override the environment", func(t *testing.T) {
+		configFile := writeConfigFile(t, `
+[server]
+http_port = 8000
+read_timeout = "1m"
+write_timeout = "2m"
+
+[sessions]
+lifetime = "12h"
+`)
+		env := withEnv(baseEnv, map[string]string{"MIKE_CONFIG": configFile, "MIKE_HTTP_PORT": "9000", "MIKE_WRITE_TIMEOUT": "3m"})
+		got, err := Load([]string{"-write-timeout", "4m"}, lookupIn(env))
+		assertNoError(t, err)
+
+		if got.Server.HTTPPort != 9000 {
+			t.Errorf("expected the environment to override the file, got port %d", got.Server.HTTPPort)
+		}
+		if got.Server.ReadTimeout.Duration != time.Minute || got.Sessions.Lifetime.Duration != 12*time.Hour {
+			t.Errorf("expected the file to override the defaults, got %+v", got)
+		}
+		if got.Server.WriteTimeout.Duration != 4*time.Minute {
+			t.Errorf("expected the flag to override the environment, got %v", got.Server.WriteTimeout)
+		}
+		if got.Server.Address() != ":9000" {
+			t.Errorf("unexpected address %s", got.Server.Address())
+		}
+	})
+
+	t.Run("the -config flag takes precedence over MIKE_CONFIG", func(t *testing.T) {
+		configFile := writeConfigFile(t, "[server]\nhttp_port = 8000\n")
+		env := withEnv(baseEnv, map[string]string{"MIKE_CONFIG": filepath.Join(t.TempDir(), "missing.toml")})
+		got, err := Load([]string{"-config", configFile}, lookupIn(env))
+		assertNoError(t, err)
+		if got.Server.HTTPPort != 8000 {
+			t.Errorf("expected the file of the flag to be read, got port %d", got.Server.HTTPPort)
+		}
+	})
+
+	t.Run("the example file contains the defaults", func(t *testing.T) {
+		env := withEnv(baseEnv, map[string]string{"MIKE_CONFIG": "../mike.example.toml"})
+		got, err := Load(nil, lookupIn(env))
+		assertNoError(t, err)
+		want := Default()
+		want.Library.Roots[0].Path = musicPath
+		want.Server.DisableHTTPS = true
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("it reads the library roots from the file and from the environment", func(t *testing.T) {
+		podcastsPath := t.TempDir()
+		configFile := writeConfigFile(t, `
+[[library.roots]]
+name = "lossless"
+path = "`+musicPath+`"
+
+[[library.roots]]
+name = "podcasts"
+path = "`+podcastsPath+`"
+`)
+		env := withEnv(baseEnv, map[string]string{"MIKE_CONFIG": configFile})
+		delete(env, "MIKE_LIBRARY_ROOTS")
+		got, err := Load(nil, lookupIn(env))
+		assertNoError(t, err)
+		want := LibraryRoots{{Name: "lossless", Path: musicPath}, {Name: "podcasts", Path: podcastsPath}}
+		if !reflect.DeepEqual(got.Library.Roots, want) {
+			t.Errorf("got roots %+v, want %+v", got.Library.Roots, want)
+		}
+
+		env["MIKE_LIBRARY_ROOTS"] = "shared=" + podcastsPath
+		got, err = Load(nil, lookupIn(env))
+		assertNoError(t, err)
+		want = LibraryRoots{{Name: "shared", Path: podcastsPath}}
+		if !reflect.DeepEqual(got.Library.Roots, want) {
+			t.Errorf("expected the environment to replace the roots, got %+v", got.Library.Roots)
+		}
+		wantPaths := map[string]string{"shared": podcastsPath}
+		if !reflect.DeepEqual(got.Library.RootPaths(), wantPaths) {
+			t.Errorf("got root paths %v, want %v", got.Library.RootPaths(), wantPaths)
+		}
+	})
+
+	t.Run("it reads the sizes of the caches with their unit", func(t *testing.T) {
+		configFile := writeConfigFile(t, "[cache]\ncovers_max_size = \"512KiB\"\ntranscodes_path = \"/var/cache/mike\"\n")
+		env := withEnv(baseEnv, map[string]string{"MIKE_CONFIG": configFile, "MIKE_TRANSCODES_CACHE_MAX_SIZE": "1500"})
+		got, err := Load([]string{"-transcodes-cache-max-size", "1TiB"}, lookupIn(env))
+		assertNoError(t, err)
+
+		want := CacheConfig{
+			CoversPath:        "./cache/covers",
+			CoversMaxSize:     Size{512 << 10},
+			TranscodesPath:    "/var/cache/mike",
+			TranscodesMaxSize: Size{1 << 40},
+		}
+		if got.Cache != want {
+			t.Errorf("got cache settings %+v, want %+v", got.Cache, want)
+		}
+		got, err = Load(nil, lookupIn(env))
+		assertNoError(t, err)
+		if got.Cache.TranscodesMaxSize.Bytes != 1500 || got.Cache.TranscodesMaxSize.String() != "1500" {
+			t.Errorf("expected a size in bytes, got %v", got.Cache.TranscodesMaxSize)
+		}
+	})
+
+	for name, test := range map[string]struct {
+		file string
+		env  map[string]string
+	}{
+		"an unknown setting in the file":      {file: "[server]\nhttp_prot = 8000\n"},
+		"an invalid environment variable":     {env: map[string]string{"MIKE_HTTPS_PORT": "https"}},
+		"an out of range port":                {env: map[string]string{"MIKE_HTTP_PORT": "70000"}},
+		"a negative timeout":                  {env: map[string]string{"MIKE_READ_TIMEOUT": "-1s"}},
+		"a negative write timeout":            {env: map[string]string{"MIKE_WRITE_TIMEOUT": "-1s"}},
+		"no read timeout":                     {env: map[string]string{"MIKE_READ_TIMEOUT": "0s"}},
+		"a library root that is not a folder": {env: map[string]string{"MIKE_LIBRARY_ROOTS": "music=/does/not/exist"}},
+		"a library root without a path":       {env: map[string]string{"MIKE_LIBRARY_ROOTS": "music"}},
+		"an invalid library root name":        {env: map[string]string{"MIKE_LIBRARY_ROOTS": "lossless/flac=" + musicPath}},
+		"two library roots with one name":     {env: map[string]string{"MIKE_LIBRARY_ROOTS": "music=" + musicPath + ",music=" + musicPath}},
+		"missing TLS files":                   {env: map[string]string{"MIKE_DISABLE_HTTPS": "false", "MIKE_CERT_FILE": "/does/not/exist"}},
+		"a negative library scan interval":    {env: map[string]string{"MIKE_LIBRARY_SCAN_INTERVAL": "-1h"}},
+		"a ListenBrainz URL that is not HTTP": {env: map[string]string{"MIKE_LISTENBRAINZ_URL": "ftp://listenbrainz.example.com"}},
+		"an empty secret key path":            {env: map[string]string{"MIKE_SECRET_KEY_FILE": ""}},
+		"an invalid cache size":               {env: map[string]string{"MIKE_COVERS_CACHE_MAX_SIZE": "256MB"}},
+		"a cache size of zero":                {env: map[string]string{"MIKE_TRANSCODES_CACHE_MAX_SIZE": "0"}},
+		"an empty cache path":                 {file: "[cache]\ncovers_path = \"\"\n"},
+	} {
+		t.Run("given "+name+", it returns ErrInvalidConfig", func(t *testing.T) {
+			env := withEnv(baseEnv, test.env)
+			if test.file != "" {
+				env["MIKE_CONFIG"] = writeConfigFile(t, test.file)
+			}
+			_, err := Load(nil, lookupIn(env))
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func lookupIn(env map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func withEnv(base map[string]string, overrides map[string]string) map[string]string {
+	env := make(map[string]string)
+	for key, value := range base {
+		env[key] = value
+	}
+	for key, value := range overrides {
+		env[key] = value
+	}
+	return env
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "mike.toml")
+	if err := os.WriteFile(configFile, []byte(contents), 0o600); err != nil {
+		t.Fatalf("could not write the configuration file: %v", err)
+	}
+	return configFile
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("did not expect an error, got one %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrBackupExists is returned when the destination of a backup already exists
+var ErrBackupExists = errors.New("the backup file already exists")
+
+// Backup copies the database to a new file at destination. It is safe to run while the server
+// is using the database: the copy is consistent.
+func Backup(ctx context.Context, db *sql.DB, destination string) error {
+	if _, err := os.Stat(destination); err == nil {
+		return fmt.Errorf("%w: %s", ErrBackupExists, destination)
+	}
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", destination); err != nil {
+		return fmt.Errorf("could not back up the database to %s: %w", destination, err)
+	}
+	return nil
+}
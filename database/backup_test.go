@@ -0,0 +1,46 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	db := newEmptyDatabase(t)
+	assertNoError(t, Migrate(ctx, db))
+	destination := filepath.Join(t.TempDir(), "backup.db")
+
+	assertNoError(t, Backup(ctx, db, destination))
+	backup, err := sql.Open("sqlite3", "file:"+destination+"?mode=ro")
+	assertNoError(t, err)
+	defer backup.Close()
+	assertTableExists(t, backup, "user")
+
+	t.Run("given an existing file, it does not overwrite it", func(t *testing.T) {
+		err := Backup(ctx, db, destination)
+		if !errors.Is(err, ErrBackupExists) {
+			t.Errorf("expected ErrBackupExists, got %v", err)
+		}
+	})
+}
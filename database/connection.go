@@ -0,0 +1,25 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+// ConnectionOptions are the options of the SQLite connections to the database, to add to the query of its
+// "file:" URI. Transactions take the write lock as soon as they begin and wait up to five seconds for other
+// connections to release it, so that concurrent edits run one after the other instead of failing.
+// The shared cache must not be used: its table locks make concurrent connections fail at once, whatever the
+// busy timeout.
+const ConnectionOptions = "_txlock=immediate&_busy_timeout=5000"
@@ -0,0 +1,185 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package database creates and upgrades the SQLite database schema.
+Migrations are SQL scripts embedded in the binary. Their file name starts with
+their version number, for example "0002_add_plays.sql". Versions start at 1 and
+must follow each other without gaps.
+*/
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of the application
+var ErrSchemaTooNew = errors.New("the database schema is newer than the ones known by this version")
+
+// migration is a SQL script that upgrades the schema to its version
+type migration struct {
+	version uint
+	name    string
+	script  string
+}
+
+// Migrate brings the database schema up to date. Each migration runs in its own transaction,
+// so that a failed migration leaves the database at the previous version.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	migrations, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		return fmt.Errorf("could not read the embedded migrations: %w", err)
+	}
+	return migrate(ctx, db, migrations)
+}
+
+func migrate(ctx context.Context, db *sql.DB, migrationsFS fs.FS) error {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return err
+	}
+	current, err := currentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	latest := uint(len(migrations))
+	if current > latest {
+		return fmt.Errorf("%w: the database is at version %d, the latest known version is %d", ErrSchemaTooNew, current, latest)
+	}
+	for _, m := range migrations[current:] {
+		if err = apply(ctx, db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMigrations returns the migrations sorted by version
+func loadMigrations(migrationsFS fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not list the migrations: %w", err)
+	}
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		prefix := strings.SplitN(entry.Name(), "_", 2)[0]
+		version, err := strconv.ParseUint(strings.TrimSuffix(prefix, ".sql"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("the migration %s does not start with a version number", entry.Name())
+		}
+		// ReadDir sorts entries by name, so versions must follow each other
+		if uint(version) != uint(len(migrations))+1 {
+			return nil, fmt.Errorf("the migration %s should have version %d", entry.Name(), len(migrations)+1)
+		}
+		script, err := fs.ReadFile(migrationsFS, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("could not read the migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, migration{uint(version), entry.Name(), string(script)})
+	}
+	return migrations, nil
+}
+
+const createVersionTableQuery = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER NOT NULL PRIMARY KEY,
+	applied_at INTEGER NOT NULL
+)`
+
+// currentVersion creates the schema_version table if needed and returns the version of the schema.
+// Databases created with the install.sql script from before migrations existed are at version 1.
+func currentVersion(ctx context.Context, db *sql.DB) (uint, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin a transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	var hasVersionTable, hasUserTable bool
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT
+			EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'),
+			EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'user')`,
+	)
+	if err = row.Scan(&hasVersionTable, &hasUserTable); err != nil {
+		return 0, fmt.Errorf("could not read the database tables: %w", err)
+	}
+	if !hasVersionTable {
+		if _, err = tx.ExecContext(ctx, createVersionTableQuery); err != nil {
+			return 0, fmt.Errorf("could not create the schema_version table: %w", err)
+		}
+		if hasUserTable {
+			if err = insertVersion(ctx, tx, 1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	var version uint
+	if err = tx.QueryRowContext(ctx, "SELECT IFNULL(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read the schema version: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit the schema_version table: %w", err)
+	}
+	return version, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin a transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	if _, err = tx.ExecContext(ctx, m.script); err != nil {
+		return fmt.Errorf("could not apply the migration %s: %w", m.name, err)
+	}
+	if err = insertVersion(ctx, tx, m.version); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit the migration %s: %w", m.name, err)
+	}
+	return nil
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, version uint) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO schema_version(version, applied_at) VALUES (?, ?)",
+		version,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not save the schema version %d: %w", version, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+CREATE TABLE "library_scan" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"started_at"	INTEGER NOT NULL,
+	"finished_at"	INTEGER
+);
+
+CREATE TABLE "folder" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"path"	TEXT NOT NULL UNIQUE,
+	"name"	TEXT NOT NULL,
+	"parent_id"	INTEGER,
+	"cover_id"	INTEGER,
+	"scan_id"	INTEGER NOT NULL
+);
+
+CREATE INDEX "folder_parent_id" ON "folder" ("parent_id");
+
+CREATE TABLE "song" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"folder_id"	INTEGER NOT NULL,
+	"path"	TEXT NOT NULL UNIQUE,
+	"title"	TEXT NOT NULL,
+	"artist"	TEXT NOT NULL DEFAULT '',
+	"album"	TEXT NOT NULL DEFAULT '',
+	"track_number"	INTEGER NOT NULL DEFAULT 0,
+	"disk_number"	INTEGER NOT NULL DEFAULT 0,
+	"duration"	INTEGER NOT NULL DEFAULT 0,
+	"type"	TEXT NOT NULL,
+	"modification_time"	INTEGER NOT NULL,
+	"size"	INTEGER NOT NULL,
+	"has_picture"	INTEGER NOT NULL DEFAULT 0,
+	"cover_id"	INTEGER,
+	"scan_id"	INTEGER NOT NULL
+);
+
+CREATE INDEX "song_folder_id" ON "song" ("folder_id");
+
+CREATE TABLE "cover" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"path"	TEXT NOT NULL UNIQUE,
+	"embedded"	INTEGER NOT NULL DEFAULT 0,
+	"modification_time"	INTEGER NOT NULL,
+	"size"	INTEGER NOT NULL,
+	"scan_id"	INTEGER NOT NULL
+);
+
+CREATE VIRTUAL TABLE "song_search" USING fts4(
+	"title",
+	"artist",
+	"album",
+	"folder",
+	tokenize=unicode61 "remove_diacritics=1"
+);
+
+CREATE TRIGGER "song_search_insert" AFTER INSERT ON "song" BEGIN
+	INSERT INTO song_search(docid, title, artist, album, folder)
+		SELECT new.id, new.title, new.artist, new.album, CASE folder.path WHEN '.' THEN '' ELSE folder.name END
+		FROM folder WHERE folder.id = new.folder_id;
+END;
+
+CREATE TRIGGER "song_search_update" AFTER UPDATE OF "title", "artist", "album", "folder_id" ON "song"
+	WHEN old.title IS NOT new.title OR old.artist IS NOT new.artist OR old.album IS NOT new.album OR old.folder_id IS NOT new.folder_id
+BEGIN
+	DELETE FROM song_search WHERE docid = old.id;
+	INSERT INTO song_search(docid, title, artist, album, folder)
+		SELECT new.id, new.title, new.artist, new.album, CASE folder.path WHEN '.' THEN '' ELSE folder.name END
+		FROM folder WHERE folder.id = new.folder_id;
+END;
+
+CREATE TRIGGER "song_search_delete" AFTER DELETE ON "song" BEGIN
+	DELETE FROM song_search WHERE docid = old.id;
+END;
+
+CREATE TABLE "library_playlist" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"path"	TEXT NOT NULL UNIQUE,
+	"name"	TEXT NOT NULL,
+	"scan_id"	INTEGER NOT NULL
+);
+
+CREATE TABLE "library_playlist_entry" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"library_playlist_id"	INTEGER NOT NULL,
+	"song_path"	TEXT NOT NULL,
+	"position"	INTEGER NOT NULL
+);
+
+CREATE INDEX "library_playlist_entry_library_playlist_id" ON "library_playlist_entry" ("library_playlist_id", "position");
+
+CREATE TRIGGER "library_playlist_delete_entries" AFTER DELETE ON "library_playlist" BEGIN
+	DELETE FROM library_playlist_entry WHERE library_playlist_id = old.id;
+END;
@@ -0,0 +1,38 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Accounts are made unique by their email and their username, and get a role.
+-- Accounts that existed before roles were introduced were created by the administrator of the instance.
+ALTER TABLE "user" ADD COLUMN "role" TEXT NOT NULL DEFAULT 'listener';
+ALTER TABLE "user" ADD COLUMN "disabled" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "user" ADD COLUMN "subsonic_password" BLOB;
+UPDATE "user" SET role = 'admin';
+
+CREATE UNIQUE INDEX "user_email" ON "user" ("email");
+CREATE UNIQUE INDEX "user_username" ON "user" ("username");
+
+CREATE TABLE "invitation" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"token_hash"	BLOB NOT NULL UNIQUE,
+	"role"	TEXT NOT NULL,
+	"created_by"	INTEGER NOT NULL,
+	"expires_at"	INTEGER NOT NULL
+);
+
+CREATE TRIGGER "user_delete_invitations" AFTER DELETE ON "user" BEGIN
+	DELETE FROM invitation WHERE created_by = old.id;
+END;
@@ -0,0 +1,45 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+CREATE TABLE "playlist" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"user_id"	INTEGER NOT NULL,
+	"name"	TEXT NOT NULL
+);
+
+CREATE INDEX "playlist_user_id" ON "playlist" ("user_id");
+
+CREATE TABLE "playlist_entry" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"playlist_id"	INTEGER NOT NULL,
+	"song_id"	INTEGER NOT NULL,
+	"position"	INTEGER NOT NULL
+);
+
+CREATE INDEX "playlist_entry_playlist_id" ON "playlist_entry" ("playlist_id", "position");
+
+CREATE TRIGGER "user_delete_playlists" AFTER DELETE ON "user" BEGIN
+	DELETE FROM playlist WHERE user_id = old.id;
+END;
+
+CREATE TRIGGER "playlist_delete_entries" AFTER DELETE ON "playlist" BEGIN
+	DELETE FROM playlist_entry WHERE playlist_id = old.id;
+END;
+
+CREATE TRIGGER "song_delete_playlist_entries" AFTER DELETE ON "song" BEGIN
+	DELETE FROM playlist_entry WHERE song_id = old.id;
+END;
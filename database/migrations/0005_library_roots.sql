@@ -0,0 +1,49 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- The music library is made of named roots, which are its top-level folders.
+-- The library indexed so far becomes the root named "music", the default root, so that
+-- folders and songs keep their identifiers and playlists keep their songs.
+UPDATE folder SET path = 'music/' || path WHERE path <> '.';
+UPDATE folder SET path = 'music', name = 'music' WHERE path = '.';
+INSERT INTO folder(path, name, parent_id, cover_id, scan_id)
+	SELECT '.', '.', NULL, NULL, scan_id FROM folder WHERE path = 'music';
+UPDATE folder SET parent_id = (SELECT id FROM folder WHERE path = '.') WHERE path = 'music';
+
+UPDATE song SET path = 'music/' || path;
+UPDATE cover SET path = 'music/' || path;
+UPDATE library_playlist SET path = 'music/' || path;
+UPDATE library_playlist_entry SET song_path = 'music/' || song_path;
+
+-- Roots are not artists or albums, their names are not searched like the names of other folders
+DROP TRIGGER "song_search_insert";
+DROP TRIGGER "song_search_update";
+
+CREATE TRIGGER "song_search_insert" AFTER INSERT ON "song" BEGIN
+	INSERT INTO song_search(docid, title, artist, album, folder)
+		SELECT new.id, new.title, new.artist, new.album, CASE instr(folder.path, '/') WHEN 0 THEN '' ELSE folder.name END
+		FROM folder WHERE folder.id = new.folder_id;
+END;
+
+CREATE TRIGGER "song_search_update" AFTER UPDATE OF "title", "artist", "album", "folder_id" ON "song"
+	WHEN old.title IS NOT new.title OR old.artist IS NOT new.artist OR old.album IS NOT new.album OR old.folder_id IS NOT new.folder_id
+BEGIN
+	DELETE FROM song_search WHERE docid = old.id;
+	INSERT INTO song_search(docid, title, artist, album, folder)
+		SELECT new.id, new.title, new.artist, new.album, CASE instr(folder.path, '/') WHEN 0 THEN '' ELSE folder.name END
+		FROM folder WHERE folder.id = new.folder_id;
+END;
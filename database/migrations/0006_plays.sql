@@ -0,0 +1,35 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Songs listened to by users, recorded when enough of a song is streamed or when a client reports it
+CREATE TABLE "play" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"user_id"	INTEGER NOT NULL,
+	"song_id"	INTEGER NOT NULL,
+	"played_at"	INTEGER NOT NULL
+);
+
+CREATE INDEX "play_user_id" ON "play" ("user_id", "played_at");
+CREATE INDEX "play_song_id" ON "play" ("song_id");
+
+CREATE TRIGGER "user_delete_plays" AFTER DELETE ON "user" BEGIN
+	DELETE FROM play WHERE play.user_id = old.id;
+END;
+
+CREATE TRIGGER "song_delete_plays" AFTER DELETE ON "song" BEGIN
+	DELETE FROM play WHERE play.song_id = old.id;
+END;
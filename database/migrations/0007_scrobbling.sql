@@ -0,0 +1,46 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Tokens of the users who forward their plays to a scrobbling service such as ListenBrainz
+CREATE TABLE "scrobbling_token" (
+	"user_id"	INTEGER NOT NULL PRIMARY KEY,
+	"token"	TEXT NOT NULL
+);
+
+-- Plays waiting to be submitted to the scrobbling service. retry_at is zero until a submission fails.
+CREATE TABLE "scrobble" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"play_id"	INTEGER NOT NULL UNIQUE,
+	"attempts"	INTEGER NOT NULL DEFAULT 0,
+	"retry_at"	INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX "scrobble_retry_at" ON "scrobble" ("retry_at");
+
+CREATE TRIGGER "play_insert_scrobble" AFTER INSERT ON "play"
+WHEN EXISTS (SELECT 1 FROM scrobbling_token WHERE scrobbling_token.user_id = new.user_id)
+BEGIN
+	INSERT INTO scrobble(play_id) VALUES (new.id);
+END;
+
+CREATE TRIGGER "play_delete_scrobble" AFTER DELETE ON "play" BEGIN
+	DELETE FROM scrobble WHERE scrobble.play_id = old.id;
+END;
+
+CREATE TRIGGER "user_delete_scrobbling_token" AFTER DELETE ON "user" BEGIN
+	DELETE FROM scrobbling_token WHERE scrobbling_token.user_id = old.id;
+END;
@@ -0,0 +1,87 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Artists, albums and genres are derived from the tags of the songs. Triggers create them with the first of their
+-- songs and remove them with the last one, so that they keep their identifiers from one scan to the next.
+ALTER TABLE "song" ADD COLUMN "genre" TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX "song_artist" ON "song" ("artist");
+CREATE INDEX "song_album" ON "song" ("album", "artist");
+CREATE INDEX "song_genre" ON "song" ("genre");
+
+CREATE TABLE "artist" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"name"	TEXT NOT NULL UNIQUE
+);
+
+-- Albums are told apart by their name and the name of their artist, which can be empty
+CREATE TABLE "album" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"name"	TEXT NOT NULL,
+	"artist"	TEXT NOT NULL,
+	UNIQUE("name", "artist")
+);
+
+CREATE INDEX "album_artist" ON "album" ("artist");
+
+CREATE TABLE "genre" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"name"	TEXT NOT NULL UNIQUE
+);
+
+-- The upserts of the scans would override an OR IGNORE conflict clause, existing rows are looked for instead
+CREATE TRIGGER "song_catalog_insert" AFTER INSERT ON "song" BEGIN
+	INSERT INTO artist(name) SELECT new.artist WHERE new.artist <> ''
+		AND NOT EXISTS (SELECT 1 FROM artist WHERE artist.name = new.artist);
+	INSERT INTO album(name, artist) SELECT new.album, new.artist WHERE new.album <> ''
+		AND NOT EXISTS (SELECT 1 FROM album WHERE album.name = new.album AND album.artist = new.artist);
+	INSERT INTO genre(name) SELECT new.genre WHERE new.genre <> ''
+		AND NOT EXISTS (SELECT 1 FROM genre WHERE genre.name = new.genre);
+END;
+
+CREATE TRIGGER "song_catalog_update" AFTER UPDATE OF "artist", "album", "genre" ON "song"
+	WHEN old.artist IS NOT new.artist OR old.album IS NOT new.album OR old.genre IS NOT new.genre
+BEGIN
+	INSERT INTO artist(name) SELECT new.artist WHERE new.artist <> ''
+		AND NOT EXISTS (SELECT 1 FROM artist WHERE artist.name = new.artist);
+	INSERT INTO album(name, artist) SELECT new.album, new.artist WHERE new.album <> ''
+		AND NOT EXISTS (SELECT 1 FROM album WHERE album.name = new.album AND album.artist = new.artist);
+	INSERT INTO genre(name) SELECT new.genre WHERE new.genre <> ''
+		AND NOT EXISTS (SELECT 1 FROM genre WHERE genre.name = new.genre);
+	DELETE FROM artist WHERE artist.name = old.artist
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.artist = old.artist);
+	DELETE FROM album WHERE album.name = old.album AND album.artist = old.artist
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.album = old.album AND song.artist = old.artist);
+	DELETE FROM genre WHERE genre.name = old.genre
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.genre = old.genre);
+END;
+
+CREATE TRIGGER "song_catalog_delete" AFTER DELETE ON "song" BEGIN
+	DELETE FROM artist WHERE artist.name = old.artist
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.artist = old.artist);
+	DELETE FROM album WHERE album.name = old.album AND album.artist = old.artist
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.album = old.album AND song.artist = old.artist);
+	DELETE FROM genre WHERE genre.name = old.genre
+		AND NOT EXISTS (SELECT 1 FROM song WHERE song.genre = old.genre);
+END;
+
+INSERT INTO artist(name) SELECT DISTINCT song.artist FROM song WHERE song.artist <> '' ORDER BY song.artist;
+INSERT INTO album(name, artist) SELECT DISTINCT song.album, song.artist FROM song WHERE song.album <> ''
+	ORDER BY song.artist, song.album;
+
+-- Genres were not read by earlier scans: forget when the files were last read so that the next scan reads them again
+UPDATE song SET modification_time = 0;
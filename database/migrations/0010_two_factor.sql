@@ -0,0 +1,46 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Secrets of the users who sign in with a time-based one-time password (TOTP), encrypted with the server's key.
+-- totp_secret is NULL until the user confirms their enrolment with a first code, totp_pending_secret
+-- holds the secret while they enrol. totp_last_step is the time step of the last accepted code, so that
+-- a code cannot be used twice.
+ALTER TABLE "user" ADD COLUMN "totp_secret" BLOB;
+ALTER TABLE "user" ADD COLUMN "totp_pending_secret" BLOB;
+ALTER TABLE "user" ADD COLUMN "totp_last_step" INTEGER NOT NULL DEFAULT 0;
+
+-- Single-use codes to sign in when the user has lost their authenticator
+CREATE TABLE "recovery_code" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"user_id"	INTEGER NOT NULL,
+	"code_hash"	BLOB NOT NULL
+);
+
+CREATE INDEX "recovery_code_user_id" ON "recovery_code" ("user_id");
+
+-- Users who gave the right password and still have to give a code to sign in
+CREATE TABLE "sign_in_challenge" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"token_hash"	BLOB NOT NULL UNIQUE,
+	"user_id"	INTEGER NOT NULL,
+	"expires_at"	INTEGER NOT NULL
+);
+
+CREATE TRIGGER "user_delete_two_factor" AFTER DELETE ON "user" BEGIN
+	DELETE FROM recovery_code WHERE recovery_code.user_id = old.id;
+	DELETE FROM sign_in_challenge WHERE sign_in_challenge.user_id = old.id;
+END;
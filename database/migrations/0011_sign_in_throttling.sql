@@ -0,0 +1,37 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Failed sign-in attempts in a row of each IP address and of each account, to lock them out for longer
+-- and longer. subject is written like "ip:192.0.2.1" or "account:mike@example.com".
+CREATE TABLE "sign_in_throttle" (
+	"subject"	TEXT NOT NULL PRIMARY KEY,
+	"failures"	INTEGER NOT NULL DEFAULT 0,
+	"last_failure_at"	INTEGER NOT NULL,
+	"locked_until"	INTEGER NOT NULL DEFAULT 0
+);
+
+-- Audit log of the failed sign-in attempts. account is the email typed in the sign-in form, it may not
+-- match any user.
+CREATE TABLE "sign_in_failure" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"attempted_at"	INTEGER NOT NULL,
+	"ip"	TEXT NOT NULL,
+	"account"	TEXT NOT NULL,
+	"reason"	TEXT NOT NULL
+);
+
+CREATE INDEX "sign_in_failure_attempted_at" ON "sign_in_failure" ("attempted_at");
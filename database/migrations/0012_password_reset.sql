@@ -0,0 +1,28 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+-- Single-use links created by administrators to let a user choose a new password
+CREATE TABLE "password_reset" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"token_hash"	BLOB NOT NULL UNIQUE,
+	"user_id"	INTEGER NOT NULL,
+	"created_by"	INTEGER NOT NULL,
+	"expires_at"	INTEGER NOT NULL
+);
+
+CREATE TRIGGER "user_delete_password_resets" AFTER DELETE ON "user" BEGIN
+	DELETE FROM password_reset WHERE password_reset.user_id = old.id OR password_reset.created_by = old.id;
+END;
@@ -0,0 +1,77 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+-- Plays outlive their songs. When the scanner removes a song, for example because its file was renamed
+-- or moved, its plays go to the song with the same tags, or wait for a song at the same path or with the
+-- same tags. Plays keep the path and the tags of their song to find it again. Songs without artist or album
+-- are only found again by path: untagged songs are named after their file, "01.mp3" would match any album.
+DROP TRIGGER "play_insert_scrobble";
+DROP TRIGGER "play_delete_scrobble";
+DROP TRIGGER "song_delete_plays";
+
+CREATE TABLE "play_without_song_details" AS SELECT id, user_id, song_id, played_at FROM play;
+DROP TABLE "play";
+
+CREATE TABLE "play" (
+	"id"	INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	"user_id"	INTEGER NOT NULL,
+	"song_id"	INTEGER,
+	"song_path"	TEXT NOT NULL,
+	"song_title"	TEXT NOT NULL,
+	"song_artist"	TEXT NOT NULL,
+	"song_album"	TEXT NOT NULL,
+	"played_at"	INTEGER NOT NULL
+);
+
+INSERT INTO play(id, user_id, song_id, song_path, song_title, song_artist, song_album, played_at)
+	SELECT previous.id, previous.user_id, song.id, song.path, song.title, song.artist, song.album, previous.played_at
+	FROM play_without_song_details AS previous
+	JOIN song ON song.id = previous.song_id;
+DROP TABLE "play_without_song_details";
+
+CREATE INDEX "play_user_id" ON "play" ("user_id", "played_at");
+CREATE INDEX "play_song_id" ON "play" ("song_id");
+
+CREATE TRIGGER "play_insert_scrobble" AFTER INSERT ON "play"
+WHEN EXISTS (SELECT 1 FROM scrobbling_token WHERE scrobbling_token.user_id = new.user_id)
+BEGIN
+	INSERT INTO scrobble(play_id) VALUES (new.id);
+END;
+
+CREATE TRIGGER "play_delete_scrobble" AFTER DELETE ON "play" BEGIN
+	DELETE FROM scrobble WHERE scrobble.play_id = old.id;
+END;
+
+CREATE TRIGGER "song_delete_plays" AFTER DELETE ON "song" BEGIN
+	UPDATE play SET song_id = (
+		SELECT song.id FROM song
+		WHERE old.artist <> '' AND old.album <> ''
+			AND song.title = old.title AND song.artist = old.artist AND song.album = old.album
+		ORDER BY song.id LIMIT 1
+	) WHERE play.song_id = old.id;
+END;
+
+CREATE TRIGGER "song_insert_plays" AFTER INSERT ON "song" BEGIN
+	UPDATE play SET song_id = new.id
+	WHERE play.song_id IS NULL AND (
+		play.song_path = new.path
+		OR (
+			new.artist <> '' AND new.album <> ''
+			AND play.song_title = new.title AND play.song_artist = new.artist AND play.song_album = new.album
+		)
+	);
+END;
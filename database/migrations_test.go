@@ -0,0 +1,214 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	migrations := fstest.MapFS{
+		"0001_initial.sql":  {Data: []byte(`CREATE TABLE "user" ("id" INTEGER NOT NULL PRIMARY KEY);`)},
+		"0002_playlist.sql": {Data: []byte(`CREATE TABLE "playlist" ("id" INTEGER NOT NULL PRIMARY KEY);`)},
+	}
+
+	t.Run("given an empty database, it applies all the migrations", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		assertNoError(t, migrate(ctx, db, migrations))
+
+		assertVersionEquals(t, db, 2)
+		assertTableExists(t, db, "playlist")
+	})
+
+	t.Run("given an up-to-date database, it does nothing", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		assertNoError(t, migrate(ctx, db, migrations))
+		assertNoError(t, migrate(ctx, db, migrations))
+
+		assertVersionEquals(t, db, 2)
+	})
+
+	t.Run("given a database created before migrations existed, it considers it at version 1", func(t *testing.T) {
+		db := newBaselineDatabase(t)
+		assertNoError(t, migrate(ctx, db, migrations))
+
+		assertVersionEquals(t, db, 2)
+		assertTableExists(t, db, "playlist")
+	})
+
+	t.Run("given a database migrated by a newer version, it returns an error", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		assertNoError(t, migrate(ctx, db, migrations))
+
+		err := migrate(ctx, db, fstest.MapFS{"0001_initial.sql": migrations["0001_initial.sql"]})
+		if !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("expected ErrSchemaTooNew, got %v", err)
+		}
+	})
+
+	t.Run("when a migration fails, it rolls it back and keeps the previous version", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		err := migrate(ctx, db, fstest.MapFS{
+			"0001_initial.sql": migrations["0001_initial.sql"],
+			"0002_broken.sql":  {Data: []byte(`CREATE TABLE "broken" ("id" INTEGER); NOT SQL;`)},
+		})
+		if err == nil {
+			t.Fatal("expected an error but did not get one")
+		}
+
+		assertVersionEquals(t, db, 1)
+		var count int
+		if err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'").Scan(&count); err != nil {
+			t.Fatalf("could not read the database tables: %v", err)
+		}
+		if count != 0 {
+			t.Error("expected the failed migration to be rolled back")
+		}
+	})
+
+	t.Run("given a gap between migration versions, it returns an error", func(t *testing.T) {
+		err := migrate(ctx, newEmptyDatabase(t), fstest.MapFS{
+			"0001_initial.sql": migrations["0001_initial.sql"],
+			"0003_gap.sql":     migrations["0002_playlist.sql"],
+		})
+		if err == nil {
+			t.Error("expected an error but did not get one")
+		}
+	})
+
+	t.Run("the embedded migrations apply on an empty database", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		assertNoError(t, Migrate(ctx, db))
+		assertTableExists(t, db, "user")
+	})
+
+	t.Run("the embedded migrations upgrade a database created by install.sql", func(t *testing.T) {
+		db := newBaselineDatabase(t)
+		_, err := db.Exec(`INSERT INTO user(id, email, password, username) VALUES (1, 'mike@example.com', NULL, 'mike')`)
+		assertNoError(t, err)
+
+		assertNoError(t, Migrate(ctx, db))
+
+		all, err := fs.Sub(embeddedMigrations, "migrations")
+		assertNoError(t, err)
+		latest, err := loadMigrations(all)
+		assertNoError(t, err)
+		assertVersionEquals(t, db, uint(len(latest)))
+		assertTableExists(t, db, "folder")
+		var role string
+		assertNoError(t, db.QueryRow("SELECT role FROM user WHERE id = 1").Scan(&role))
+		if role != "admin" {
+			t.Errorf("expected the existing account to become an administrator, got %s", role)
+		}
+	})
+
+	t.Run("the library roots migration moves the indexed library under the music root", func(t *testing.T) {
+		db := newEmptyDatabase(t)
+		beforeRoots := fstest.MapFS{}
+		for _, name := range []string{"0001_initial.sql", "0002_library.sql", "0003_users.sql", "0004_playlists.sql"} {
+			script, err := embeddedMigrations.ReadFile("migrations/" + name)
+			assertNoError(t, err)
+			beforeRoots[name] = &fstest.MapFile{Data: script}
+		}
+		assertNoError(t, migrate(ctx, db, beforeRoots))
+		_, err := db.Exec(`INSERT INTO folder(id, path, name, parent_id, scan_id) VALUES (1, '.', '.', NULL, 1), (2, 'Nightwish', 'Nightwish', 1, 1);
+			INSERT INTO song(id, folder_id, path, title, type, modification_time, size, scan_id)
+				VALUES (7, 2, 'Nightwish/ghost.mp3', 'Ghost Love Score', 'audio/mpeg', 0, 0, 1);`)
+		assertNoError(t, err)
+
+		assertNoError(t, Migrate(ctx, db))
+
+		var songPath, parentPath string
+		err = db.QueryRow(`SELECT song.path, parent.path FROM song
+			JOIN folder ON folder.id = song.folder_id
+			JOIN folder AS parent ON parent.id = folder.parent_id
+			WHERE song.id = 7`).Scan(&songPath, &parentPath)
+		assertNoError(t, err)
+		if songPath != "music/Nightwish/ghost.mp3" || parentPath != "music" {
+			t.Errorf("expected the song to keep its identifier under the music root, got %s in a folder of %s", songPath, parentPath)
+		}
+		var rootParentPath string
+		err = db.QueryRow(`SELECT parent.path FROM folder JOIN folder AS parent ON parent.id = folder.parent_id
+			WHERE folder.path = 'music'`).Scan(&rootParentPath)
+		assertNoError(t, err)
+		if rootParentPath != "." {
+			t.Errorf("expected the music root to be in the top-level folder, got %s", rootParentPath)
+		}
+	})
+}
+
+func newEmptyDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "mike.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatalf("could not open the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// newBaselineDatabase returns a database created with the install.sql script from before migrations existed
+func newBaselineDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	install, err := os.ReadFile(filepath.Join("testdata", "install.sql"))
+	if err != nil {
+		t.Fatalf("could not read install.sql: %v", err)
+	}
+	db := newEmptyDatabase(t)
+	if _, err = db.Exec(string(install)); err != nil {
+		t.Fatalf("could not create the baseline schema: %v", err)
+	}
+	return db
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("did not expect an error, got one %v", err)
+	}
+}
+
+func assertVersionEquals(t *testing.T, db *sql.DB, want uint) {
+	t.Helper()
+	var got uint
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&got); err != nil {
+		t.Fatalf("could not read the schema version: %v", err)
+	}
+	if got != want {
+		t.Errorf("did not get the expected schema version, got %d, want %d", got, want)
+	}
+}
+
+func assertTableExists(t *testing.T, db *sql.DB, table string) {
+	t.Helper()
+	if _, err := db.Exec("SELECT * FROM \"" + table + "\""); err != nil {
+		t.Errorf("expected the table %s to exist, got %v", table, err)
+	}
+}
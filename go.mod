@@ -3,10 +3,14 @@ module github.com/hyzual/mike-sierra-sierra
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v0.4.1
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/schema v1.2.0
 	github.com/hyzual/sessionup-sqlitestore v1.1.1
 	github.com/mattn/go-sqlite3 v1.14.7
+	github.com/pquerna/otp v1.4.0
 	github.com/swithek/sessionup v1.4.0
 	golang.org/x/crypto v0.0.0-20210503195802-e9a32991a82e
+	golang.org/x/sys v0.7.0 // indirect
 )
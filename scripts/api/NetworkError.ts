@@ -17,7 +17,7 @@
 
 import { Ono } from "@jsdevtools/ono";
 
-type HTTPMethod = "GET";
+export type HTTPMethod = "GET" | "POST" | "PATCH" | "DELETE";
 
 export class NetworkError extends Error {
     constructor(
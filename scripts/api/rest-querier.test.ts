@@ -15,8 +15,13 @@
  *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
  */
 
-import { getFolder } from "./rest-querier";
-import type { Folder } from "scripts/types";
+import {
+    createInvitation,
+    deleteUser,
+    getFolder,
+    updateUser,
+} from "./rest-querier";
+import type { Folder, Invitation, User } from "scripts/types";
 
 describe(`rest-querier`, () => {
     let globalFetch: jest.SpyInstance;
@@ -105,4 +110,57 @@ describe(`rest-querier`, () => {
         }
         expect(result.value).toEqual(expected_folder);
     });
+
+    it(`updateUser() will PATCH the user with a JSON body and return the User`, async () => {
+        const expected_user: User = {
+            id: 3,
+            email: "listener@example.com",
+            username: "Listener",
+            role: "listener",
+            disabled: true,
+        };
+        mockFetchSuccess(expected_user);
+
+        const result = await updateUser(3, { disabled: true });
+        if (!result.isOk()) {
+            throw new Error("Did not expect an error but got one");
+        }
+        expect(result.value).toEqual(expected_user);
+        expect(globalFetch).toHaveBeenCalledWith("/api/users/3", {
+            method: "PATCH",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ disabled: true }),
+        });
+    });
+
+    it(`when the user cannot be deleted, deleteUser() will return an error`, async () => {
+        globalFetch.mockImplementation(() =>
+            Promise.resolve({
+                ok: false,
+                status: 409,
+                statusText: "Conflict",
+            })
+        );
+
+        const result = await deleteUser(27);
+        if (!result.isErr()) {
+            throw new Error("Expected an error but did not get one");
+        }
+        expect(result.error.message).toMatch("Could not DELETE /api/users/27");
+    });
+
+    it(`createInvitation() will return an Invitation`, async () => {
+        const expected_invitation: Invitation = {
+            uri: "/invitations/0123456789abcdef",
+            role: "listener",
+            expiresAt: "2021-06-01T12:00:00Z",
+        };
+        mockFetchSuccess(expected_invitation);
+
+        const result = await createInvitation("listener");
+        if (!result.isOk()) {
+            throw new Error("Did not expect an error but got one");
+        }
+        expect(result.value).toEqual(expected_invitation);
+    });
 });
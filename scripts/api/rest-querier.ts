@@ -17,7 +17,15 @@
 
 import { ono } from "@jsdevtools/ono";
 import { ok, err, ResultAsync } from "neverthrow";
-import type { Folder } from "../types";
+import type {
+    Folder,
+    Invitation,
+    NewUser,
+    Role,
+    User,
+    UserUpdate,
+} from "../types";
+import type { HTTPMethod } from "./NetworkError";
 import { NetworkError } from "./NetworkError";
 
 const wrapError = (e: unknown): Error =>
@@ -34,15 +42,77 @@ export const getFolder = (
     );
 };
 
+export const getUsers = (): ResultAsync<User[], Error | NetworkError> =>
+    getAPI("/api/users").andThen((response) =>
+        decodeJSON<User[]>(response, "Could not decode JSON into Users")
+    );
+
+export const createUser = (
+    user: NewUser
+): ResultAsync<User, Error | NetworkError> =>
+    sendJSON("POST", "/api/users", user).andThen((response) =>
+        decodeJSON<User>(response, "Could not decode JSON into User")
+    );
+
+export const updateUser = (
+    user_id: number,
+    update: UserUpdate
+): ResultAsync<User, Error | NetworkError> =>
+    sendJSON("PATCH", `/api/users/${user_id}`, update).andThen((response) =>
+        decodeJSON<User>(response, "Could not decode JSON into User")
+    );
+
+export const deleteUser = (
+    user_id: number
+): ResultAsync<Response, Error | NetworkError> =>
+    fetchAPI("DELETE", `/api/users/${user_id}`);
+
+export const createInvitation = (
+    role: Role
+): ResultAsync<Invitation, Error | NetworkError> =>
+    sendJSON("POST", "/api/invitations", { role }).andThen((response) =>
+        decodeJSON<Invitation>(
+            response,
+            "Could not decode JSON into Invitation"
+        )
+    );
+
+const decodeJSON = <T>(
+    response: Response,
+    message: string
+): ResultAsync<T, Error> =>
+    ResultAsync.fromPromise(response.json(), wrapError).mapErr((error) =>
+        ono(error, message)
+    );
+
 function getAPI(uri: string): ResultAsync<Response, Error | NetworkError> {
+    return fetchAPI("GET", uri);
+}
+
+function sendJSON(
+    method: HTTPMethod,
+    uri: string,
+    body: unknown
+): ResultAsync<Response, Error | NetworkError> {
+    return fetchAPI(method, uri, {
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body),
+    });
+}
+
+function fetchAPI(
+    method: HTTPMethod,
+    uri: string,
+    init: RequestInit = {}
+): ResultAsync<Response, Error | NetworkError> {
     return ResultAsync.fromPromise(
-        fetch(uri, { method: "GET" }),
+        fetch(uri, { ...init, method }),
         wrapError
     ).andThen((response) => {
         if (!response.ok) {
             return err(
                 new NetworkError(
-                    "GET",
+                    method,
                     uri,
                     response.status,
                     response.statusText
@@ -25,11 +25,13 @@ import "./folder-view/FolderCover";
 import "./folder-view/SongsList";
 import "./folder-view/SongLine";
 import "./music/MusicPlayer";
+import "./users/UsersAdmin";
 import { PlayQueueState } from "./music/PlayQueueState";
 
-type Page = "default" | "folders";
+type Page = "default" | "folders" | "users";
 const DEFAULT_PAGE: Page = "default";
 const FOLDERS_PAGE: Page = "folders";
+const USERS_PAGE: Page = "users";
 
 class AppRoot extends LitElement {
     private current_page: Page = DEFAULT_PAGE;
@@ -57,6 +59,10 @@ class AppRoot extends LitElement {
                 }
                 this.requestUpdate();
             })
+            .on("/users", () => {
+                this.current_page = USERS_PAGE;
+                this.requestUpdate();
+            })
             .resolve();
     }
 
@@ -107,6 +113,8 @@ class AppRoot extends LitElement {
                     .folder_path=${this.current_folder_path}
                     .play_queue=${this.play_queue}
                 ></mss-folder-details> `;
+            case USERS_PAGE:
+                return html`<mss-users-admin></mss-users-admin>`;
             case DEFAULT_PAGE:
             default:
                 return html`Home`;
@@ -56,4 +56,17 @@ describe(`FolderCover`, () => {
             "/folders/" + encodeURIComponent("manufacturing/gently")
         );
     });
+
+    it(`When the folder has no cover, it will display the default cover image`, () => {
+        const image = element.shadowRoot?.querySelector("img");
+        expect(image?.getAttribute("src")).toBe("<svg></svg>");
+    });
+
+    it(`When the folder has a cover, it will display it at the size of the grid`, async () => {
+        element.setAttribute("cover_uri", "/api/covers/12");
+        await element.updateComplete;
+
+        const image = element.shadowRoot?.querySelector("img");
+        expect(image?.getAttribute("src")).toBe("/api/covers/12?size=256");
+    });
 });
@@ -26,14 +26,19 @@ import svg from "../../../images/assets/no-cover.svg";
 const getFolderUri = (path: string): string =>
     `/folders/${encodeURIComponent(path)}`;
 
+// Folder covers are displayed in a 256px wide grid
+const COVER_SIZE = 256;
+
 export class FolderCover extends LitElement {
     folder_path!: string;
     folder_title!: string;
+    cover_uri = "";
 
     static get properties(): PropertyDeclarations {
         return {
             folder_path: { type: String },
             folder_title: { type: String },
+            cover_uri: { type: String },
         };
     }
 
@@ -70,13 +75,25 @@ export class FolderCover extends LitElement {
             class="folder-link"
             title="Browse folder"
         >
-            <img src="${svg}" alt="Default cover image" />
+            ${this.renderCover()}
             <div class="folder-header">
                 <span>${this.folder_title}</span>
             </div>
         </a>`;
     }
 
+    private renderCover(): TemplateResult {
+        if (this.cover_uri === "") {
+            return html`<img src="${svg}" alt="Default cover image" />`;
+        }
+        return html`<img
+            src="${this.cover_uri}?size=${COVER_SIZE}"
+            alt="Cover image"
+            width="${COVER_SIZE}"
+            height="${COVER_SIZE}"
+        />`;
+    }
+
     private navigate(event: Event): void {
         event.preventDefault();
         router.navigate(getFolderUri(this.folder_path));
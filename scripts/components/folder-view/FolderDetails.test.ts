@@ -40,8 +40,8 @@ describe("FolderDetails", () => {
     it(`renders a list of folders once the root folder is loaded`, async () => {
         const async_result = okAsync<Folder, Error>({
             folders: [
-                { name: "last", path: "last" },
-                { name: "direction", path: "direction" },
+                { name: "last", path: "last", coverUri: "" },
+                { name: "direction", path: "direction", coverUri: "" },
             ],
             songs: [],
         });
@@ -58,8 +58,8 @@ describe("FolderDetails", () => {
         and renders a list of folders`, async () => {
         const async_result = okAsync<Folder, Error>({
             folders: [
-                { name: "liquid", path: "live/liquid" },
-                { name: "wooden", path: "live/wooden" },
+                { name: "liquid", path: "live/liquid", coverUri: "" },
+                { name: "wooden", path: "live/wooden", coverUri: "" },
             ],
             songs: [],
         });
@@ -42,6 +42,7 @@ class FoldersList extends LitElement {
                 html`<mss-folder-cover
                     folder_title="${folder.name}"
                     folder_path="${folder.path}"
+                    cover_uri="${folder.coverUri}"
                 ></mss-folder-cover>`
         )}`;
     }
@@ -0,0 +1,57 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import { errAsync, okAsync } from "neverthrow";
+import * as rest_querier from "../../api/rest-querier";
+import type { User } from "../../types";
+import { UsersAdmin } from "./UsersAdmin";
+
+describe("UsersAdmin", () => {
+    afterEach(() => {
+        document.body.innerHTML = "";
+    });
+
+    it(`renders the users once they are loaded`, async () => {
+        const async_result = okAsync<User[], Error>([
+            {
+                id: 3,
+                email: "listener@example.com",
+                username: "Listener",
+                role: "listener",
+                disabled: false,
+            },
+        ]);
+        jest.spyOn(rest_querier, "getUsers").mockReturnValue(async_result);
+        const element = new UsersAdmin();
+        document.body.append(element);
+
+        await async_result;
+        await element.updateComplete;
+        expect(element.shadowRoot?.innerHTML).toContain("listener@example.com");
+    });
+
+    it(`when the users cannot be loaded, it renders an error`, async () => {
+        const async_result = errAsync<User[], Error>(new Error("Forbidden"));
+        jest.spyOn(rest_querier, "getUsers").mockReturnValue(async_result);
+        const element = new UsersAdmin();
+        document.body.append(element);
+
+        await async_result;
+        await element.updateComplete;
+        expect(element.shadowRoot?.innerHTML).toContain("An error occurred");
+    });
+});
@@ -0,0 +1,268 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+import type { PropertyDeclarations, TemplateResult } from "lit";
+import { css, html, LitElement } from "lit";
+import type { ResultAsync } from "neverthrow";
+import { NetworkError } from "../../api/NetworkError";
+import {
+    createInvitation,
+    createUser,
+    deleteUser,
+    getUsers,
+    updateUser,
+} from "../../api/rest-querier";
+import type { Role, User } from "../../types";
+
+const renderError = (error: Error | NetworkError): TemplateResult =>
+    error instanceof NetworkError
+        ? html`<p class="error">
+              An error occurred: Code ${error.statusCode}: ${error.statusText}
+          </p>`
+        : html`<p class="error">An error occurred: ${error.message}</p>`;
+
+export class UsersAdmin extends LitElement {
+    users: User[] = [];
+    error: Error | NetworkError | null = null;
+    invitation_link = "";
+    is_loading = true;
+
+    static get properties(): PropertyDeclarations {
+        return {
+            users: { attribute: false },
+            error: { attribute: false },
+            invitation_link: { attribute: false },
+            is_loading: { attribute: false },
+        };
+    }
+
+    static readonly styles = css`
+        :host {
+            display: block;
+            padding: 16px;
+        }
+
+        .users {
+            width: 100%;
+            border-collapse: collapse;
+        }
+
+        .users th,
+        .users td {
+            padding: 4px 8px;
+            text-align: left;
+        }
+
+        .disabled {
+            opacity: 0.5;
+        }
+
+        .forms {
+            display: flex;
+            gap: 32px;
+            margin-top: 16px;
+        }
+
+        .error {
+            padding: 8px 16px;
+            background-color: var(--error-color);
+        }
+    `;
+
+    connectedCallback(): void {
+        super.connectedCallback();
+        this.handle(getUsers(), (users) => {
+            this.users = users;
+        });
+    }
+
+    render(): TemplateResult {
+        if (this.is_loading) {
+            return html`<span>Loading ...</span>`;
+        }
+        return html`${this.error ? renderError(this.error) : ""}
+            <table class="users">
+                <thead>
+                    <tr>
+                        <th>Username</th>
+                        <th>Email</th>
+                        <th>Role</th>
+                        <th>Status</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody>
+                    ${this.users.map((user) => this.renderUser(user))}
+                </tbody>
+            </table>
+            <div class="forms">
+                <form @submit=${this.create}>
+                    <h3>Create a user</h3>
+                    <input
+                        name="email"
+                        type="email"
+                        placeholder="Email"
+                        required
+                    />
+                    <input name="username" placeholder="Username" required />
+                    <input
+                        name="password"
+                        type="password"
+                        placeholder="Password"
+                        autocomplete="new-password"
+                        maxlength="64"
+                        required
+                    />
+                    ${this.renderRoleSelect("listener")}
+                    <button type="submit">Create</button>
+                </form>
+                <form @submit=${this.invite}>
+                    <h3>Invite a user</h3>
+                    ${this.renderRoleSelect("listener")}
+                    <button type="submit">Create an invitation link</button>
+                    ${this.invitation_link
+                        ? html`<p>
+                              Send this link, it can be used once:
+                              <a href="${this.invitation_link}"
+                                  >${this.invitation_link}</a
+                              >
+                          </p>`
+                        : ""}
+                </form>
+            </div>`;
+    }
+
+    private renderUser(user: User): TemplateResult {
+        return html`<tr class="${user.disabled ? "disabled" : ""}">
+            <td>${user.username}</td>
+            <td>${user.email}</td>
+            <td>
+                <select
+                    @change=${(event: Event): void =>
+                        this.changeRole(
+                            user,
+                            (event.target as HTMLSelectElement).value as Role
+                        )}
+                >
+                    <option
+                        value="listener"
+                        ?selected=${user.role === "listener"}
+                    >
+                        Listener
+                    </option>
+                    <option value="admin" ?selected=${user.role === "admin"}>
+                        Administrator
+                    </option>
+                </select>
+            </td>
+            <td>
+                <button @click=${(): void => this.toggleDisabled(user)}>
+                    ${user.disabled ? "Enable" : "Disable"}
+                </button>
+            </td>
+            <td>
+                <button @click=${(): void => this.remove(user)}>Delete</button>
+            </td>
+        </tr>`;
+    }
+
+    private renderRoleSelect(selected: Role): TemplateResult {
+        return html`<select name="role">
+            <option value="listener" ?selected=${selected === "listener"}>
+                Listener
+            </option>
+            <option value="admin" ?selected=${selected === "admin"}>
+                Administrator
+            </option>
+        </select>`;
+    }
+
+    private create(event: Event): void {
+        event.preventDefault();
+        const form = event.target as HTMLFormElement;
+        const data = new FormData(form);
+        const new_user = {
+            email: String(data.get("email")),
+            username: String(data.get("username")),
+            password: String(data.get("password")),
+            role: String(data.get("role")) as Role,
+        };
+        this.handle(createUser(new_user), (user) => {
+            this.users = [...this.users, user];
+            form.reset();
+        });
+    }
+
+    private invite(event: Event): void {
+        event.preventDefault();
+        const data = new FormData(event.target as HTMLFormElement);
+        const role = String(data.get("role")) as Role;
+        this.handle(createInvitation(role), (invitation) => {
+            this.invitation_link = new URL(
+                invitation.uri,
+                window.location.origin
+            ).href;
+        });
+    }
+
+    private changeRole(user: User, role: Role): void {
+        this.handle(updateUser(user.id, { role }), (updated) =>
+            this.replace(updated)
+        );
+    }
+
+    private toggleDisabled(user: User): void {
+        this.handle(
+            updateUser(user.id, { disabled: !user.disabled }),
+            (updated) => this.replace(updated)
+        );
+    }
+
+    private remove(user: User): void {
+        if (!window.confirm(`Delete ${user.username} and their playlists?`)) {
+            return;
+        }
+        this.handle(deleteUser(user.id), () => {
+            this.users = this.users.filter((other) => other.id !== user.id);
+        });
+    }
+
+    private replace(updated: User): void {
+        this.users = this.users.map((user) =>
+            user.id === updated.id ? updated : user
+        );
+    }
+
+    private handle<T>(
+        result: ResultAsync<T, Error | NetworkError>,
+        onSuccess: (value: T) => void
+    ): void {
+        result.match(
+            (value) => {
+                this.error = null;
+                onSuccess(value);
+                this.is_loading = false;
+            },
+            (error) => {
+                this.error = error;
+                this.is_loading = false;
+            }
+        );
+    }
+}
+
+customElements.define("mss-users-admin", UsersAdmin);
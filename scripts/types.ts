@@ -28,9 +28,38 @@ export const NullSong: Song = {
 export interface SubFolder {
     readonly path: string;
     readonly name: string;
+    readonly coverUri: string;
 }
 
 export interface Folder {
     readonly folders: SubFolder[];
     readonly songs: Song[];
 }
+
+export type Role = "admin" | "listener";
+
+export interface User {
+    readonly id: number;
+    readonly email: string;
+    readonly username: string;
+    readonly role: Role;
+    readonly disabled: boolean;
+}
+
+export interface NewUser {
+    readonly email: string;
+    readonly username: string;
+    readonly password: string;
+    readonly role: Role;
+}
+
+export interface UserUpdate {
+    readonly role?: Role;
+    readonly disabled?: boolean;
+}
+
+export interface Invitation {
+    readonly uri: string;
+    readonly role: Role;
+    readonly expiresAt: string;
+}
@@ -0,0 +1,238 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// abandonedTemporaryFileAge is how old a temporary file of a cache must be to be removed. Younger files
+// may still be written, older ones were left by a server that stopped while writing them.
+const abandonedTemporaryFileAge = 24 * time.Hour
+
+// cachedPictureExtensions maps the MIME types of cached pictures to their file extension
+var cachedPictureExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// NewDiskCoverCache creates a new music.CoverCache storing pictures as files in directory.
+// It creates the directory when it does not exist. When the pictures take more than maximumSize bytes,
+// the least recently used ones are removed. See sizeLimitedDirectory for how strict the limit is.
+func NewDiskCoverCache(directory string, maximumSize int64) (music.CoverCache, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("could not create the cover cache directory %s: %w", directory, err)
+	}
+	cache := &diskCoverCache{&sizeLimitedDirectory{path: directory, maximumSize: maximumSize}}
+	if err := cache.directory.prune(); err != nil {
+		return nil, fmt.Errorf("could not clean the cover cache up: %w", err)
+	}
+	return cache, nil
+}
+
+// diskCoverCache implements music.CoverCache. Pictures are named after their key, their extension gives their MIME type.
+type diskCoverCache struct {
+	directory *sizeLimitedDirectory
+}
+
+func (d *diskCoverCache) Get(key string) (*music.Picture, bool) {
+	for mimeType, extension := range cachedPictureExtensions {
+		data, err := os.ReadFile(filepath.Join(d.directory.path, key+extension))
+		if err == nil {
+			d.directory.use(key + extension)
+			return &music.Picture{MIMEType: mimeType, Data: data}, true
+		}
+	}
+	return nil, false
+}
+
+// Put writes the picture to a temporary file first, so that concurrent Gets never read a partial picture
+func (d *diskCoverCache) Put(key string, picture *music.Picture) error {
+	extension, ok := cachedPictureExtensions[picture.MIMEType]
+	if !ok {
+		return fmt.Errorf("cannot cache pictures of type %s", picture.MIMEType)
+	}
+	file, err := d.directory.create(key + extension)
+	if err != nil {
+		return fmt.Errorf("could not create a file in the cover cache: %w", err)
+	}
+	if _, err = file.Write(picture.Data); err != nil {
+		file.Abort()
+		return fmt.Errorf("could not write the cover %s to the cache: %w", key, err)
+	}
+	if err = file.Commit(); err != nil {
+		return fmt.Errorf("could not save the cover %s in the cache: %w", key, err)
+	}
+	return nil
+}
+
+// TranscodeCache stores finished transcodes so that songs are not transcoded again
+type TranscodeCache interface {
+	// Open returns the transcode stored under key. It returns an error wrapping fs.ErrNotExist when there is none.
+	Open(key string) (*os.File, error)
+	// Create returns a file to write the transcode stored under key. Open does not see it until it is committed.
+	Create(key string) (*PendingFile, error)
+}
+
+// NewDiskTranscodeCache creates a new TranscodeCache storing transcodes as files in directory.
+// It creates the directory when it does not exist. When the transcodes take more than maximumSize bytes,
+// the least recently used ones are removed. See sizeLimitedDirectory for how strict the limit is.
+func NewDiskTranscodeCache(directory string, maximumSize int64) (TranscodeCache, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("could not create the transcode cache directory %s: %w", directory, err)
+	}
+	cache := &diskTranscodeCache{&sizeLimitedDirectory{path: directory, maximumSize: maximumSize}}
+	if err := cache.directory.prune(); err != nil {
+		return nil, fmt.Errorf("could not clean the transcode cache up: %w", err)
+	}
+	return cache, nil
+}
+
+// diskTranscodeCache implements TranscodeCache
+type diskTranscodeCache struct {
+	directory *sizeLimitedDirectory
+}
+
+func (d *diskTranscodeCache) Open(key string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(d.directory.path, key))
+	if err != nil {
+		return nil, err
+	}
+	d.directory.use(key)
+	return file, nil
+}
+
+func (d *diskTranscodeCache) Create(key string) (*PendingFile, error) {
+	file, err := d.directory.create(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a file in the transcode cache: %w", err)
+	}
+	return file, nil
+}
+
+// sizeLimitedDirectory is the directory of a disk cache whose files must not take more than maximumSize bytes.
+// The modification time of the files tells when they were last used. The directory is pruned each time a file
+// is committed, counting this file and the files still being written: it only goes over maximumSize while
+// files are being written, by their size at most.
+type sizeLimitedDirectory struct {
+	path        string
+	maximumSize int64
+	mutex       sync.Mutex // Prunes one at a time
+}
+
+// use marks the file as used now, so that it is removed after the files that were not used since
+func (d *sizeLimitedDirectory) use(name string) {
+	now := time.Now()
+	os.Chtimes(filepath.Join(d.path, name), now, now) //nolint:errcheck // The file is only removed sooner
+}
+
+// create returns a file to write the file name of the directory. The directory is pruned when it is committed.
+func (d *sizeLimitedDirectory) create(name string) (*PendingFile, error) {
+	file, err := os.CreateTemp(d.path, name+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &PendingFile{file, filepath.Join(d.path, name), d}, nil
+}
+
+// prune removes the least recently used files until they fit in maximumSize with the files being written,
+// and the abandoned temporary files. Files that cannot be removed, for example because they are being read
+// on Windows, are skipped.
+func (d *sizeLimitedDirectory) prune() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+	type cachedFile struct {
+		name   string
+		size   int64
+		usedAt time.Time
+	}
+	var (
+		files     []cachedFile
+		totalSize int64
+	)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue // Removed in the meantime
+		}
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			if time.Since(info.ModTime()) > abandonedTemporaryFileAge {
+				os.Remove(filepath.Join(d.path, entry.Name())) //nolint:errcheck // It will be tried again
+			} else {
+				totalSize += info.Size() // Being written, it cannot be removed
+			}
+			continue
+		}
+		files = append(files, cachedFile{entry.Name(), info.Size(), info.ModTime()})
+		totalSize += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].usedAt.Before(files[j].usedAt) })
+	for _, file := range files {
+		if totalSize <= d.maximumSize {
+			break
+		}
+		if os.Remove(filepath.Join(d.path, file.name)) == nil {
+			totalSize -= file.size
+		}
+	}
+	return nil
+}
+
+// PendingFile is a temporary file that replaces the file at its final path once committed.
+// Readers never see a partially written file.
+type PendingFile struct {
+	*os.File
+	finalPath string
+	directory *sizeLimitedDirectory
+}
+
+// Commit closes the file, moves it to its final path and makes room for it in the cache
+func (p *PendingFile) Commit() error {
+	err := p.Close()
+	if err == nil {
+		err = os.Rename(p.Name(), p.finalPath)
+	}
+	if err != nil {
+		os.Remove(p.Name()) //nolint:errcheck // The temporary file is useless either way
+		return err
+	}
+	if err = p.directory.prune(); err != nil {
+		return fmt.Errorf("could not clean the cache up: %w", err)
+	}
+	return nil
+}
+
+// Abort closes and removes the file
+func (p *PendingFile) Abort() {
+	p.Close()           //nolint:errcheck // The file is removed anyway
+	os.Remove(p.Name()) //nolint:errcheck // There is nothing to do when it cannot be removed
+}
@@ -0,0 +1,180 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestDiskCoverCache(t *testing.T) {
+	t.Run("it creates its directory and returns the pictures it stored", func(t *testing.T) {
+		directory := path.Join(t.TempDir(), "cache/covers")
+		cache, err := adapter.NewDiskCoverCache(directory, 1<<20)
+		tests.AssertNoError(t, err)
+
+		_, ok := cache.Get("abcdef")
+		if ok {
+			t.Error("expected an empty cache")
+		}
+		tests.AssertNoError(t, cache.Put("abcdef", &music.Picture{MIMEType: "image/png", Data: []byte("picture")}))
+
+		got, ok := cache.Get("abcdef")
+		if !ok || got.MIMEType != "image/png" || string(got.Data) != "picture" {
+			t.Errorf("expected the cached PNG picture, got %+v", got)
+		}
+		entries, _ := os.ReadDir(directory)
+		if len(entries) != 1 || entries[0].Name() != "abcdef.png" {
+			t.Errorf("expected only the cached picture in the cache directory, got %v", entries)
+		}
+	})
+
+	t.Run("it refuses pictures it cannot name", func(t *testing.T) {
+		cache, err := adapter.NewDiskCoverCache(t.TempDir(), 1<<20)
+		tests.AssertNoError(t, err)
+
+		err = cache.Put("abcdef", &music.Picture{MIMEType: "image/bmp", Data: []byte("picture")})
+		tests.AssertError(t, err)
+	})
+}
+
+func TestDiskCoverCacheEviction(t *testing.T) {
+	t.Run("when the pictures take more than the maximum size, it removes the least recently used first", func(t *testing.T) {
+		directory := t.TempDir()
+		cache, err := adapter.NewDiskCoverCache(directory, 12)
+		tests.AssertNoError(t, err)
+		for _, key := range []string{"first", "second"} {
+			tests.AssertNoError(t, cache.Put(key, &music.Picture{MIMEType: "image/jpeg", Data: []byte("cover!")}))
+		}
+		longAgo := time.Now().Add(-time.Hour)
+		tests.AssertNoError(t, os.Chtimes(path.Join(directory, "first.jpg"), longAgo, longAgo))
+		tests.AssertNoError(t, os.Chtimes(path.Join(directory, "second.jpg"), longAgo.Add(time.Minute), longAgo.Add(time.Minute)))
+
+		if _, ok := cache.Get("first"); !ok {
+			t.Fatal("expected the first cover to be cached")
+		}
+		tests.AssertNoError(t, cache.Put("third", &music.Picture{MIMEType: "image/jpeg", Data: []byte("cover!")}))
+
+		if _, ok := cache.Get("first"); !ok {
+			t.Error("expected the recently used cover to stay in the cache")
+		}
+		if _, ok := cache.Get("second"); ok {
+			t.Error("expected the least recently used cover to be removed")
+		}
+		assertDirectorySizeAtMost(t, directory, 12)
+	})
+}
+
+func TestDiskTranscodeCache(t *testing.T) {
+	t.Run("when the transcodes take more than the maximum size, it removes the least recently used first", func(t *testing.T) {
+		directory := t.TempDir()
+		cache, err := adapter.NewDiskTranscodeCache(directory, 12)
+		tests.AssertNoError(t, err)
+		writeTranscode(t, cache, directory, "first", time.Now().Add(-2*time.Hour))
+		writeTranscode(t, cache, directory, "second", time.Now().Add(-time.Hour))
+
+		first, err := cache.Open("first")
+		tests.AssertNoError(t, err)
+		first.Close()
+		writeTranscode(t, cache, directory, "third", time.Now())
+
+		for key, wantCached := range map[string]bool{"first": true, "second": false} {
+			_, err = os.Stat(path.Join(directory, key))
+			if wantCached && err != nil {
+				t.Errorf("expected the recently used transcode %s to stay in the cache, got %v", key, err)
+			}
+			if !wantCached && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected the least recently used transcode %s to be removed, got %v", key, err)
+			}
+		}
+		assertDirectorySizeAtMost(t, directory, 12)
+	})
+
+	t.Run("the transcodes being written count towards the maximum size", func(t *testing.T) {
+		directory := t.TempDir()
+		cache, err := adapter.NewDiskTranscodeCache(directory, 12)
+		tests.AssertNoError(t, err)
+		writeTranscode(t, cache, directory, "first", time.Now().Add(-time.Hour))
+		ongoing, err := cache.Create("second")
+		tests.AssertNoError(t, err)
+		defer ongoing.Abort()
+		_, err = ongoing.Write([]byte("audio!"))
+		tests.AssertNoError(t, err)
+
+		writeTranscode(t, cache, directory, "third", time.Now())
+
+		if _, err = os.Stat(path.Join(directory, "first")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the least recently used transcode to make room for the one being written, got %v", err)
+		}
+		assertDirectorySizeAtMost(t, directory, 12)
+	})
+
+	t.Run("it removes the temporary files left by transcodes that never ended", func(t *testing.T) {
+		directory := t.TempDir()
+		abandoned := path.Join(directory, "first-123.tmp")
+		ongoing := path.Join(directory, "second-456.tmp")
+		tests.AssertNoError(t, os.WriteFile(abandoned, []byte("audio"), 0o600))
+		tests.AssertNoError(t, os.WriteFile(ongoing, []byte("audio"), 0o600))
+		longAgo := time.Now().Add(-48 * time.Hour)
+		tests.AssertNoError(t, os.Chtimes(abandoned, longAgo, longAgo))
+
+		_, err := adapter.NewDiskTranscodeCache(directory, 10)
+		tests.AssertNoError(t, err)
+
+		if _, err = os.Stat(abandoned); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the abandoned temporary file to be removed, got %v", err)
+		}
+		if _, err = os.Stat(ongoing); err != nil {
+			t.Errorf("expected the recent temporary file to be kept, got %v", err)
+		}
+	})
+}
+
+// writeTranscode caches a transcode of 6 bytes under key in directory, last used at usedAt
+func writeTranscode(t *testing.T, cache adapter.TranscodeCache, directory string, key string, usedAt time.Time) {
+	t.Helper()
+	file, err := cache.Create(key)
+	tests.AssertNoError(t, err)
+	_, err = file.Write([]byte("audio!"))
+	tests.AssertNoError(t, err)
+	tests.AssertNoError(t, file.Commit())
+	tests.AssertNoError(t, os.Chtimes(path.Join(directory, key), usedAt, usedAt))
+}
+
+func assertDirectorySizeAtMost(t *testing.T, directory string, maximumSize int64) {
+	t.Helper()
+	entries, err := os.ReadDir(directory)
+	tests.AssertNoError(t, err)
+	var totalSize int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		tests.AssertNoError(t, err)
+		totalSize += info.Size()
+	}
+	if totalSize > maximumSize {
+		t.Errorf("expected the cache to take at most %d bytes, got %d bytes", maximumSize, totalSize)
+	}
+}
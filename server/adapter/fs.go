@@ -42,3 +42,16 @@ func (b *baseOSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
 	path := b.pathJoiner.Join(name)
 	return os.ReadDir(path)
 }
+
+// NewLibraryRoots opens the folders of the roots of the music library. rootPaths maps the name of each root to its folder.
+func NewLibraryRoots(rootPaths map[string]string) []music.LibraryRoot {
+	roots := make([]music.LibraryRoot, 0, len(rootPaths))
+	for name, rootPath := range rootPaths {
+		roots = append(roots, music.LibraryRoot{
+			Name:       name,
+			Path:       rootPath,
+			FileSystem: NewOSFileSystem(os.DirFS(rootPath), NewBasePathJoiner(rootPath)),
+		})
+	}
+	return roots
+}
@@ -0,0 +1,72 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size in bytes of the server's secret key
+const KeySize = 32
+
+// ReadOrCreateKey reads the secret key of the server from keyFile, where it is written in hexadecimal.
+// When the file does not exist, it generates a random key and writes it there, readable only by its owner.
+func ReadOrCreateKey(keyFile string) ([]byte, error) {
+	contents, err := os.ReadFile(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createKey(keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the key file %s: %w", keyFile, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("the key file %s does not contain a key of %d hexadecimal bytes", keyFile, KeySize)
+	}
+	return key, nil
+}
+
+func createKey(keyFile string) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate a key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create the directory of the key file %s: %w", keyFile, err)
+	}
+	// O_EXCL makes sure that a key written meanwhile is never overwritten
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the key file %s: %w", keyFile, err)
+	}
+	if _, err = file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not write the key file %s: %w", keyFile, err)
+	}
+	if err = file.Close(); err != nil {
+		return nil, fmt.Errorf("could not write the key file %s: %w", keyFile, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,58 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter_test
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestReadOrCreateKey(t *testing.T) {
+	t.Run("it generates a key readable only by its owner, then reads it back", func(t *testing.T) {
+		keyFile := path.Join(t.TempDir(), "secrets/mike.key")
+		created, err := adapter.ReadOrCreateKey(keyFile)
+		tests.AssertNoError(t, err)
+		if len(created) != adapter.KeySize {
+			t.Errorf("expected a key of %d bytes, got %d", adapter.KeySize, len(created))
+		}
+		info, err := os.Stat(keyFile)
+		tests.AssertNoError(t, err)
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("expected the key file to be readable only by its owner, got %v", info.Mode())
+		}
+
+		read, err := adapter.ReadOrCreateKey(keyFile)
+		tests.AssertNoError(t, err)
+		if !bytes.Equal(read, created) {
+			t.Error("expected the key to be read back from its file")
+		}
+	})
+
+	t.Run("given a key file that does not contain a key, it returns an error", func(t *testing.T) {
+		keyFile := path.Join(t.TempDir(), "mike.key")
+		tests.AssertNoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+
+		_, err := adapter.ReadOrCreateKey(keyFile)
+		tests.AssertError(t, err)
+	})
+}
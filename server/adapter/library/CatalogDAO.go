@@ -0,0 +1,243 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// Artists, albums and genres are kept in sync with the songs by triggers, so every one of them has songs
+const artistQuery = `SELECT artist.id, artist.name, COUNT(DISTINCT album.id), COUNT(song.id), COALESCE(MIN(song.cover_id), 0)
+	FROM artist
+	JOIN song ON song.artist = artist.name
+	LEFT JOIN album ON album.name = song.album AND album.artist = song.artist`
+
+const albumQuery = `SELECT album.id, album.name, COALESCE(artist.id, 0), album.artist, COUNT(song.id),
+		COALESCE(SUM(song.duration), 0), COALESCE(MIN(song.cover_id), 0)
+	FROM album
+	JOIN song ON song.album = album.name AND song.artist = album.artist
+	LEFT JOIN artist ON artist.name = album.artist`
+
+// The direction applies to the first column, the next ones order the albums and songs that are equal on it
+var (
+	albumOrders = map[music.AlbumSort]string{
+		music.AlbumsByName:   `album.name COLLATE NOCASE %s, album.artist COLLATE NOCASE, album.id`,
+		music.AlbumsByArtist: `album.artist COLLATE NOCASE %s, album.name COLLATE NOCASE, album.id`,
+	}
+	songOrders = map[music.SongSort]string{
+		music.SongsByTitle: `song.title COLLATE NOCASE %s, song.path`,
+		music.SongsByArtist: `song.artist COLLATE NOCASE %s, song.album COLLATE NOCASE, song.disk_number, song.track_number,
+			song.path`,
+		music.SongsByAlbum: `song.album COLLATE NOCASE %s, song.artist COLLATE NOCASE, song.disk_number, song.track_number,
+			song.path`,
+		music.SongsByDuration: `song.duration %s, song.path`,
+	}
+)
+
+// ListArtists returns all the artists, ordered by name regardless of case
+func (d *DAO) ListArtists(ctx context.Context) ([]music.LibraryArtist, error) {
+	rows, err := d.db.QueryContext(ctx, artistQuery+` GROUP BY artist.id ORDER BY artist.name COLLATE NOCASE, artist.id`)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the artists: %w", err)
+	}
+	defer rows.Close()
+	var artists []music.LibraryArtist
+	for rows.Next() {
+		artist, err := scanArtist(rows)
+		if err != nil {
+			return nil, err
+		}
+		artists = append(artists, *artist)
+	}
+	return artists, rows.Err()
+}
+
+// GetArtist returns the artist identified by artistID. It returns music.ErrArtistNotFound when there is no such artist.
+func (d *DAO) GetArtist(ctx context.Context, artistID uint) (*music.LibraryArtist, error) {
+	row := d.db.QueryRowContext(ctx, artistQuery+` WHERE artist.id = ? GROUP BY artist.id`, artistID)
+	artist, err := scanArtist(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrArtistNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the artist #%d: %w", artistID, err)
+	}
+	return artist, nil
+}
+
+// ListAlbums returns the page of albums selected by query and the total number of albums it selects
+func (d *DAO) ListAlbums(ctx context.Context, query music.AlbumQuery) ([]music.LibraryAlbum, uint, error) {
+	order, ok := albumOrders[query.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("Could not sort the albums by %q", query.Sort)
+	}
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if query.ArtistID != 0 {
+		conditions = append(conditions, `album.artist = (SELECT artist.name FROM artist WHERE artist.id = ?)`)
+		args = append(args, query.ArtistID)
+	}
+	if query.GenreID != 0 {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM song AS genre_song JOIN genre ON genre.name = genre_song.genre
+			WHERE genre.id = ? AND genre_song.album = album.name AND genre_song.artist = album.artist)`)
+		args = append(args, query.GenreID)
+	}
+	where := whereClause(conditions)
+
+	var total uint
+	if err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM album`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("Could not count the albums: %w", err)
+	}
+	pageQuery := albumQuery + where + ` GROUP BY album.id ORDER BY ` + fmt.Sprintf(order, sortDirection(query.Descending)) +
+		` LIMIT ? OFFSET ?`
+	rows, err := d.db.QueryContext(ctx, pageQuery, append(args, sqlLimit(query.Limit), query.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not retrieve the albums: %w", err)
+	}
+	defer rows.Close()
+	var albums []music.LibraryAlbum
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		albums = append(albums, *album)
+	}
+	return albums, total, rows.Err()
+}
+
+// GetAlbum returns the album identified by albumID. It returns music.ErrAlbumNotFound when there is no such album.
+func (d *DAO) GetAlbum(ctx context.Context, albumID uint) (*music.LibraryAlbum, error) {
+	row := d.db.QueryRowContext(ctx, albumQuery+` WHERE album.id = ? GROUP BY album.id`, albumID)
+	album, err := scanAlbum(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrAlbumNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the album #%d: %w", albumID, err)
+	}
+	return album, nil
+}
+
+// ListGenres returns all the genres, ordered by name regardless of case
+func (d *DAO) ListGenres(ctx context.Context) ([]music.Genre, error) {
+	query := `SELECT genre.id, genre.name, COUNT(DISTINCT album.id), COUNT(song.id) FROM genre
+		JOIN song ON song.genre = genre.name
+		LEFT JOIN album ON album.name = song.album AND album.artist = song.artist
+		GROUP BY genre.id
+		ORDER BY genre.name COLLATE NOCASE, genre.id`
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the genres: %w", err)
+	}
+	defer rows.Close()
+	var genres []music.Genre
+	for rows.Next() {
+		var genre music.Genre
+		if err = rows.Scan(&genre.ID, &genre.Name, &genre.AlbumCount, &genre.SongCount); err != nil {
+			return nil, fmt.Errorf("Could not read a genre: %w", err)
+		}
+		genres = append(genres, genre)
+	}
+	return genres, rows.Err()
+}
+
+// ListSongs returns the page of songs selected by query and the total number of songs it selects
+func (d *DAO) ListSongs(ctx context.Context, query music.SongQuery) ([]music.IndexedSong, uint, error) {
+	order, ok := songOrders[query.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("Could not sort the songs by %q", query.Sort)
+	}
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if query.ArtistID != 0 {
+		conditions = append(conditions, `song.artist = (SELECT artist.name FROM artist WHERE artist.id = ?)`)
+		args = append(args, query.ArtistID)
+	}
+	if query.AlbumID != 0 {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM album
+			WHERE album.id = ? AND album.name = song.album AND album.artist = song.artist)`)
+		args = append(args, query.AlbumID)
+	}
+	if query.GenreID != 0 {
+		conditions = append(conditions, `song.genre = (SELECT genre.name FROM genre WHERE genre.id = ?)`)
+		args = append(args, query.GenreID)
+	}
+	where := whereClause(conditions)
+
+	var total uint
+	if err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM song`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("Could not count the songs: %w", err)
+	}
+	clause := where + ` ORDER BY ` + fmt.Sprintf(order, sortDirection(query.Descending)) + ` LIMIT ? OFFSET ?`
+	songs, err := d.querySongs(ctx, clause, append(args, sqlLimit(query.Limit), query.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not retrieve the songs: %w", err)
+	}
+	return songs, total, nil
+}
+
+func scanArtist(row rowScanner) (*music.LibraryArtist, error) {
+	var artist music.LibraryArtist
+	err := row.Scan(&artist.ID, &artist.Name, &artist.AlbumCount, &artist.SongCount, &artist.CoverID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read an artist: %w", err)
+	}
+	return &artist, nil
+}
+
+func scanAlbum(row rowScanner) (*music.LibraryAlbum, error) {
+	var album music.LibraryAlbum
+	err := row.Scan(&album.ID, &album.Name, &album.ArtistID, &album.Artist, &album.SongCount, &album.Duration, &album.CoverID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read an album: %w", err)
+	}
+	return &album, nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+func sortDirection(descending bool) string {
+	if descending {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// sqlLimit returns -1, which means no limit for SQLite, when limit is zero
+func sqlLimit(limit uint) int64 {
+	if limit == 0 {
+		return -1
+	}
+	return int64(limit)
+}
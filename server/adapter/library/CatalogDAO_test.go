@@ -0,0 +1,210 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestCatalog(t *testing.T) {
+	ctx := context.Background()
+	nemo := newCatalogSong("Nemo", "Nightwish", "Once", "Symphonic Metal", 4, 272)
+	ghost := newCatalogSong("Ghost Love Score", "Nightwish", "Once", "", 10, 600)
+	amaranth := newCatalogSong("Amaranth", "Nightwish", "Dark Passion Play", "Symphonic Metal", 4, 231)
+	sleepingSun := newCatalogSong("Sleeping Sun", "Nightwish", "", "", 0, 245)
+	intro := newCatalogSong("Intro", "", "untitled", "Ambient", 1, 60)
+
+	t.Run("it lists the artists derived from the tags of the songs", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+
+		artists, err := dao.ListArtists(ctx)
+		tests.AssertNoError(t, err)
+		if len(artists) != 1 || artists[0].Name != "Nightwish" || artists[0].AlbumCount != 2 || artists[0].SongCount != 4 {
+			t.Fatalf("unexpected artists %+v", artists)
+		}
+		artist, err := dao.GetArtist(ctx, artists[0].ID)
+		tests.AssertNoError(t, err)
+		if *artist != artists[0] {
+			t.Errorf("expected the artist %+v, got %+v", artists[0], artist)
+		}
+	})
+
+	t.Run("given an unknown artist, it returns ErrArtistNotFound", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo)
+
+		_, err := dao.GetArtist(ctx, 404)
+		if !errors.Is(err, music.ErrArtistNotFound) {
+			t.Errorf("expected ErrArtistNotFound, got %v", err)
+		}
+	})
+
+	t.Run("it lists pages of albums, ordered by name regardless of case", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+
+		albums, total, err := dao.ListAlbums(ctx, music.AlbumQuery{Sort: music.AlbumsByName, Limit: 2, Offset: 1})
+		tests.AssertNoError(t, err)
+		if total != 3 || len(albums) != 2 || albums[0].Name != "Once" || albums[1].Name != "untitled" {
+			t.Fatalf("unexpected page of %d albums %+v", total, albums)
+		}
+		if albums[0].ArtistID == 0 || albums[0].SongCount != 2 || albums[0].Duration != 872 || albums[1].ArtistID != 0 {
+			t.Errorf("unexpected albums %+v", albums)
+		}
+		album, err := dao.GetAlbum(ctx, albums[0].ID)
+		tests.AssertNoError(t, err)
+		if *album != albums[0] {
+			t.Errorf("expected the album %+v, got %+v", albums[0], album)
+		}
+	})
+
+	t.Run("it lists the albums of an artist or of a genre", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+		artists, err := dao.ListArtists(ctx)
+		tests.AssertNoError(t, err)
+		genres, err := dao.ListGenres(ctx)
+		tests.AssertNoError(t, err)
+
+		albums, total, err := dao.ListAlbums(ctx, music.AlbumQuery{ArtistID: artists[0].ID, Sort: music.AlbumsByName, Descending: true})
+		tests.AssertNoError(t, err)
+		if total != 2 || len(albums) != 2 || albums[0].Name != "Once" || albums[1].Name != "Dark Passion Play" {
+			t.Errorf("unexpected albums of the artist %+v", albums)
+		}
+		albums, total, err = dao.ListAlbums(ctx, music.AlbumQuery{GenreID: genres[0].ID, Sort: music.AlbumsByArtist})
+		tests.AssertNoError(t, err)
+		if total != 1 || len(albums) != 1 || albums[0].Name != "untitled" {
+			t.Errorf("unexpected albums of the genre %+v", albums)
+		}
+	})
+
+	t.Run("given an unknown album, it returns ErrAlbumNotFound", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo)
+
+		_, err := dao.GetAlbum(ctx, 404)
+		if !errors.Is(err, music.ErrAlbumNotFound) {
+			t.Errorf("expected ErrAlbumNotFound, got %v", err)
+		}
+	})
+
+	t.Run("it lists the genres with their number of albums and songs", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+
+		genres, err := dao.ListGenres(ctx)
+		tests.AssertNoError(t, err)
+		if len(genres) != 2 || genres[0].Name != "Ambient" || genres[1].Name != "Symphonic Metal" {
+			t.Fatalf("unexpected genres %+v", genres)
+		}
+		if genres[1].AlbumCount != 2 || genres[1].SongCount != 2 {
+			t.Errorf("unexpected counts for the genre %+v", genres[1])
+		}
+	})
+
+	t.Run("it lists pages of sorted songs", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+
+		songs, total, err := dao.ListSongs(ctx, music.SongQuery{Sort: music.SongsByDuration, Descending: true, Limit: 2})
+		tests.AssertNoError(t, err)
+		if total != 5 || len(songs) != 2 || songs[0].Title != "Ghost Love Score" || songs[1].Title != "Nemo" {
+			t.Errorf("unexpected page of %d songs %+v", total, songs)
+		}
+		songs, total, err = dao.ListSongs(ctx, music.SongQuery{Sort: music.SongsByTitle})
+		tests.AssertNoError(t, err)
+		if total != 5 || len(songs) != 5 || songs[0].Title != "Amaranth" || songs[4].Title != "Sleeping Sun" {
+			t.Errorf("unexpected songs sorted by title %+v", songs)
+		}
+		if songs[0].Genre != "Symphonic Metal" {
+			t.Errorf("expected the songs to have their genre, got %+v", songs[0])
+		}
+	})
+
+	t.Run("it lists the songs of an album in the order of their tracks", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth, sleepingSun, intro)
+		albums, _, err := dao.ListAlbums(ctx, music.AlbumQuery{Sort: music.AlbumsByName})
+		tests.AssertNoError(t, err)
+
+		songs, total, err := dao.ListSongs(ctx, music.SongQuery{AlbumID: albums[1].ID, Sort: music.SongsByAlbum})
+		tests.AssertNoError(t, err)
+		if total != 2 || len(songs) != 2 || songs[0].Title != "Nemo" || songs[1].Title != "Ghost Love Score" {
+			t.Errorf("unexpected songs of the album %+v", songs)
+		}
+	})
+
+	t.Run("given an unknown sort, it returns an error", func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo)
+
+		_, _, err := dao.ListSongs(ctx, music.SongQuery{Sort: "size"})
+		tests.AssertError(t, err)
+		_, _, err = dao.ListAlbums(ctx, music.AlbumQuery{Sort: "year"})
+		tests.AssertError(t, err)
+	})
+
+	t.Run(`when the tags of songs change, albums without songs are removed
+		and the other ones keep their identifiers`, func(t *testing.T) {
+		dao := newCatalogDAO(t, nemo, ghost, amaranth)
+		before, _, err := dao.ListAlbums(ctx, music.AlbumQuery{Sort: music.AlbumsByName})
+		tests.AssertNoError(t, err)
+
+		live := amaranth
+		live.Album = "Showtime, Storytime"
+		saveCatalogSongs(t, dao, nemo, ghost, live)
+
+		after, _, err := dao.ListAlbums(ctx, music.AlbumQuery{Sort: music.AlbumsByName})
+		tests.AssertNoError(t, err)
+		if len(after) != 2 || after[0].ID != before[1].ID || after[1].Name != "Showtime, Storytime" {
+			t.Errorf("unexpected albums after the change of tags %+v", after)
+		}
+		if _, err = dao.GetAlbum(ctx, before[0].ID); !errors.Is(err, music.ErrAlbumNotFound) {
+			t.Errorf("expected the album without songs to be removed, got %v", err)
+		}
+	})
+}
+
+func newCatalogSong(title string, artist string, album string, genre string, track uint, duration uint) music.IndexedSong {
+	return music.IndexedSong{
+		Song: music.Song{
+			Title:       title,
+			Artist:      artist,
+			Album:       album,
+			Genre:       genre,
+			TrackNumber: track,
+			Duration:    duration,
+			Type:        "audio/mpeg",
+		},
+		Path: title + ".mp3",
+	}
+}
+
+func newCatalogDAO(t *testing.T, songs ...music.IndexedSong) *DAO {
+	t.Helper()
+	dao := NewDAO(tests.NewDatabase(t))
+	saveCatalogSongs(t, dao, songs...)
+	return dao
+}
+
+// saveCatalogSongs scans a library where all the songs are at the top
+func saveCatalogSongs(t *testing.T, dao *DAO, songs ...music.IndexedSong) {
+	t.Helper()
+	ctx := context.Background()
+	scanID, err := dao.BeginScan(ctx)
+	tests.AssertNoError(t, err)
+	tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, music.SubFolder{Name: ".", Path: "."}, songs))
+	tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+}
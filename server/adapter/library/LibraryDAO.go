@@ -0,0 +1,532 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package library stores the music library index and the playlists of users in the database.
+*/
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// DAO implements music.LibraryIndex, music.SearchIndex, music.CoverStore, music.LibraryPlaylistStore
+// and music.CatalogStore
+type DAO struct {
+	db *sql.DB
+}
+
+// NewDAO creates a new DAO
+func NewDAO(db *sql.DB) *DAO {
+	return &DAO{db}
+}
+
+// BeginScan records the start of a new scan and returns its identifier
+func (d *DAO) BeginScan(ctx context.Context) (int64, error) {
+	query := `INSERT INTO library_scan(started_at) VALUES (?)`
+	result, err := d.db.ExecContext(ctx, query, time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("Could not save the new library scan: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// SaveFolder saves the folder and its songs in a single transaction. Folders and songs
+// are matched by path so that they keep their identifiers from one scan to the next.
+// The parent folder must have been saved first.
+func (d *DAO) SaveFolder(ctx context.Context, scanID int64, folder music.SubFolder, songs []music.IndexedSong) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	var parentID sql.NullInt64
+	if folder.Path != "." {
+		parentQuery := `SELECT folder.id FROM folder WHERE folder.path = ?`
+		if err = tx.QueryRowContext(ctx, parentQuery, path.Dir(folder.Path)).Scan(&parentID); err != nil {
+			return fmt.Errorf("Could not retrieve the parent of folder %v: %w", folder.Path, err)
+		}
+	}
+	folderCoverID := nullableID(folder.CoverID)
+	folderQuery := `INSERT INTO folder(path, name, parent_id, cover_id, modification_time, scan_id) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET name = excluded.name, parent_id = excluded.parent_id, cover_id = excluded.cover_id,
+			modification_time = excluded.modification_time, scan_id = excluded.scan_id
+		RETURNING id`
+	var folderID int64
+	err = tx.QueryRowContext(ctx, folderQuery, folder.Path, folder.Name, parentID, folderCoverID,
+		unixNanoOrZero(folder.ModificationTime), scanID).Scan(&folderID)
+	if err != nil {
+		return fmt.Errorf("Could not save the folder %v: %w", folder.Path, err)
+	}
+
+	songQuery := `INSERT INTO song(folder_id, path, title, artist, album, genre, track_number, disk_number, duration, type,
+			modification_time, size, has_picture, cover_id, scan_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET folder_id = excluded.folder_id, title = excluded.title, artist = excluded.artist,
+			album = excluded.album, genre = excluded.genre, track_number = excluded.track_number, disk_number = excluded.disk_number,
+			duration = excluded.duration, type = excluded.type, modification_time = excluded.modification_time,
+			size = excluded.size, has_picture = excluded.has_picture, cover_id = excluded.cover_id, scan_id = excluded.scan_id`
+	statement, err := tx.PrepareContext(ctx, songQuery)
+	if err != nil {
+		return fmt.Errorf("Could not prepare the song query: %w", err)
+	}
+	defer statement.Close()
+	for _, song := range songs {
+		songCoverID := folderCoverID
+		if song.HasPicture {
+			embedded := music.Cover{Path: song.Path, Embedded: true, ModificationTime: song.ModificationTime, Size: song.Size}
+			coverID, err := saveCover(ctx, tx, scanID, embedded)
+			if err != nil {
+				return err
+			}
+			songCoverID = nullableID(coverID)
+		}
+		_, err = statement.ExecContext(
+			ctx,
+			folderID,
+			song.Path,
+			song.Title,
+			song.Artist,
+			song.Album,
+			song.Genre,
+			song.TrackNumber,
+			song.DiskNumber,
+			song.Duration,
+			song.Type,
+			song.ModificationTime.UnixNano(),
+			song.Size,
+			song.HasPicture,
+			songCoverID,
+			scanID,
+		)
+		if err != nil {
+			return fmt.Errorf("Could not save the song %v: %w", song.Path, err)
+		}
+	}
+	if !folderCoverID.Valid {
+		coverQuery := `UPDATE folder SET cover_id = (
+				SELECT song.cover_id FROM song WHERE song.folder_id = folder.id AND song.cover_id IS NOT NULL ORDER BY song.path LIMIT 1
+			) WHERE folder.id = ?`
+		if _, err = tx.ExecContext(ctx, coverQuery, folderID); err != nil {
+			return fmt.Errorf("Could not save the cover of folder %v: %w", folder.Path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveCover saves the cover image file and returns its identifier. Covers are matched by path so that
+// they keep their identifiers from one scan to the next.
+func (d *DAO) SaveCover(ctx context.Context, scanID int64, cover music.Cover) (uint, error) {
+	return saveCover(ctx, d.db, scanID, cover)
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func saveCover(ctx context.Context, db rowQueryer, scanID int64, cover music.Cover) (uint, error) {
+	query := `INSERT INTO cover(path, embedded, modification_time, size, scan_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET embedded = excluded.embedded, modification_time = excluded.modification_time,
+			size = excluded.size, scan_id = excluded.scan_id
+		RETURNING id`
+	var coverID uint
+	err := db.QueryRowContext(ctx, query, cover.Path, cover.Embedded, cover.ModificationTime.UnixNano(), cover.Size, scanID).
+		Scan(&coverID)
+	if err != nil {
+		return 0, fmt.Errorf("Could not save the cover %v: %w", cover.Path, err)
+	}
+	return coverID, nil
+}
+
+// GetCover returns the cover identified by coverID. It returns music.ErrCoverNotFound when there is no such cover.
+func (d *DAO) GetCover(ctx context.Context, coverID uint) (*music.Cover, error) {
+	query := `SELECT cover.id, cover.path, cover.embedded, cover.modification_time, cover.size FROM cover WHERE cover.id = ?`
+	var (
+		cover            music.Cover
+		modificationTime int64
+	)
+	err := d.db.QueryRowContext(ctx, query, coverID).
+		Scan(&cover.ID, &cover.Path, &cover.Embedded, &modificationTime, &cover.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrCoverNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the cover #%d: %w", coverID, err)
+	}
+	cover.ModificationTime = time.Unix(0, modificationTime)
+	return &cover, nil
+}
+
+// SavePlaylistFile saves the playlist file and replaces its entries in a single transaction.
+// Playlist files are matched by path so that they keep their identifiers from one scan to the next.
+func (d *DAO) SavePlaylistFile(ctx context.Context, scanID int64, playlist music.PlaylistFile) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	playlistQuery := `INSERT INTO library_playlist(path, name, scan_id) VALUES (?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET name = excluded.name, scan_id = excluded.scan_id
+		RETURNING id`
+	var playlistID int64
+	err = tx.QueryRowContext(ctx, playlistQuery, playlist.Path, playlist.Name, scanID).Scan(&playlistID)
+	if err != nil {
+		return fmt.Errorf("Could not save the playlist %v: %w", playlist.Path, err)
+	}
+	deleteQuery := `DELETE FROM library_playlist_entry WHERE library_playlist_entry.library_playlist_id = ?`
+	if _, err = tx.ExecContext(ctx, deleteQuery, playlistID); err != nil {
+		return fmt.Errorf("Could not remove the previous entries of playlist %v: %w", playlist.Path, err)
+	}
+	statement, err := tx.PrepareContext(
+		ctx,
+		`INSERT INTO library_playlist_entry(library_playlist_id, song_path, position) VALUES (?, ?, ?)`,
+	)
+	if err != nil {
+		return fmt.Errorf("Could not prepare the playlist entry query: %w", err)
+	}
+	defer statement.Close()
+	for position, songPath := range playlist.SongPaths {
+		if _, err = statement.ExecContext(ctx, playlistID, songPath, position); err != nil {
+			return fmt.Errorf("Could not save the entry %v of playlist %v: %w", songPath, playlist.Path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// inFolderCondition matches the rows whose path is the folder ?1 or is in the folder ?1 or in its sub-folders.
+// Paths in the folder start with its path followed by a slash. LIKE would need its wildcards escaped.
+const inFolderCondition = `(path = ?1 OR substr(path, 1, length(?1) + 1) = ?1 || '/')`
+
+// KeepFolder stamps the folders, songs, covers and playlist files of the folder at folderPath and of its
+// sub-folders with the scan, so that the end of the scan does not remove them.
+func (d *DAO) KeepFolder(ctx context.Context, scanID int64, folderPath string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	for _, table := range []string{"song", "folder", "cover", "library_playlist"} {
+		query := `UPDATE ` + table + ` SET scan_id = ?2 WHERE ` + inFolderCondition
+		if _, err = tx.ExecContext(ctx, query, folderPath, scanID); err != nil {
+			return fmt.Errorf("Could not keep the %s rows of %s: %w", table, folderPath, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// EndScan removes the folders, songs and playlist files that were not saved during the scan and records
+// the end of the scan.
+func (d *DAO) EndScan(ctx context.Context, scanID int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM song WHERE song.scan_id <> ?`, scanID); err != nil {
+		return fmt.Errorf("Could not remove the songs that were not found during the scan: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM folder WHERE folder.scan_id <> ?`, scanID); err != nil {
+		return fmt.Errorf("Could not remove the folders that were not found during the scan: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM cover WHERE cover.scan_id <> ?`, scanID); err != nil {
+		return fmt.Errorf("Could not remove the covers that were not found during the scan: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM library_playlist WHERE library_playlist.scan_id <> ?`, scanID); err != nil {
+		return fmt.Errorf("Could not remove the playlist files that were not found during the scan: %w", err)
+	}
+	query := `UPDATE library_scan SET finished_at = ? WHERE library_scan.id = ?`
+	if _, err = tx.ExecContext(ctx, query, time.Now().Unix(), scanID); err != nil {
+		return fmt.Errorf("Could not record the end of the library scan: %w", err)
+	}
+	return tx.Commit()
+}
+
+// EndFolderScan removes the folders, songs, covers and playlist files of the folder at folderPath and of its
+// sub-folders that were not saved during the scan. The rest of the index is left untouched and the scan does not
+// count as a scan of the whole library.
+func (d *DAO) EndFolderScan(ctx context.Context, scanID int64, folderPath string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	for _, table := range []string{"song", "folder", "cover", "library_playlist"} {
+		query := `DELETE FROM ` + table + ` WHERE scan_id <> ?2 AND ` + inFolderCondition
+		if _, err = tx.ExecContext(ctx, query, folderPath, scanID); err != nil {
+			return fmt.Errorf("Could not remove the %s rows of %s that were not found during the scan: %w", table, folderPath, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStatistics counts the folders, songs, covers and playlist files of the library index
+func (d *DAO) GetStatistics(ctx context.Context) (*music.LibraryStatistics, error) {
+	query := `SELECT
+		(SELECT COUNT(*) FROM folder),
+		(SELECT COUNT(*) FROM song),
+		(SELECT COUNT(*) FROM cover),
+		(SELECT COUNT(*) FROM library_playlist),
+		(SELECT COALESCE(SUM(song.duration), 0) FROM song),
+		(SELECT COALESCE(SUM(song.size), 0) FROM song),
+		(SELECT COALESCE(MAX(library_scan.finished_at), 0) FROM library_scan)`
+	var statistics music.LibraryStatistics
+	var lastScan int64
+	err := d.db.QueryRowContext(ctx, query).Scan(
+		&statistics.Folders,
+		&statistics.Songs,
+		&statistics.Covers,
+		&statistics.Playlists,
+		&statistics.Duration,
+		&statistics.Size,
+		&lastScan,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Could not compute the library statistics: %w", err)
+	}
+	if lastScan != 0 {
+		statistics.LastScan = time.Unix(lastScan, 0)
+	}
+	return &statistics, nil
+}
+
+// ListFolder returns the sub-folders and songs of the folder at folderPath, ordered by path.
+// It returns music.ErrFolderNotFound when the folder is not in the index.
+func (d *DAO) ListFolder(ctx context.Context, folderPath string) ([]music.SubFolder, []music.IndexedSong, error) {
+	var folderID int64
+	err := d.db.QueryRowContext(ctx, `SELECT folder.id FROM folder WHERE folder.path = ?`, folderPath).Scan(&folderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, music.ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not retrieve the folder %v: %w", folderPath, err)
+	}
+
+	subFolders, err := d.listSubFolders(ctx, folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	songs, err := d.querySongs(ctx, `WHERE song.folder_id = ? ORDER BY song.path`, folderID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not retrieve the songs of folder %v: %w", folderPath, err)
+	}
+	return subFolders, songs, nil
+}
+
+// GetSong returns the song identified by songID. It returns music.ErrSongNotFound when there is no such song.
+func (d *DAO) GetSong(ctx context.Context, songID uint) (*music.IndexedSong, error) {
+	row := d.db.QueryRowContext(ctx, `SELECT `+songColumns+` FROM song WHERE song.id = ?`, songID)
+	song, err := scanSong(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrSongNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the song #%d: %w", songID, err)
+	}
+	return song, nil
+}
+
+// ListLibraryPlaylists returns the playlist files of the library, ordered by path. Their song count
+// only includes the songs that are in the library index.
+func (d *DAO) ListLibraryPlaylists(ctx context.Context) ([]music.LibraryPlaylist, error) {
+	query := `SELECT library_playlist.id, library_playlist.name, library_playlist.path, COUNT(song.id) FROM library_playlist
+		LEFT JOIN library_playlist_entry ON library_playlist_entry.library_playlist_id = library_playlist.id
+		LEFT JOIN song ON song.path = library_playlist_entry.song_path
+		GROUP BY library_playlist.id
+		ORDER BY library_playlist.path`
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the playlist files: %w", err)
+	}
+	defer rows.Close()
+	var playlists []music.LibraryPlaylist
+	for rows.Next() {
+		var playlist music.LibraryPlaylist
+		if err = rows.Scan(&playlist.ID, &playlist.Name, &playlist.Path, &playlist.SongCount); err != nil {
+			return nil, fmt.Errorf("Could not read a playlist file: %w", err)
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+// GetLibraryPlaylist returns the playlist file and its entries, in order. Entries whose song
+// is not in the library index are left out.
+func (d *DAO) GetLibraryPlaylist(ctx context.Context, playlistID uint) (*music.Playlist, error) {
+	var playlist music.Playlist
+	query := `SELECT library_playlist.id, library_playlist.name FROM library_playlist WHERE library_playlist.id = ?`
+	err := d.db.QueryRowContext(ctx, query, playlistID).Scan(&playlist.ID, &playlist.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the playlist file #%d: %w", playlistID, err)
+	}
+
+	entriesQuery := `SELECT ` + songColumns + `, library_playlist_entry.id FROM library_playlist_entry
+		JOIN song ON song.path = library_playlist_entry.song_path
+		WHERE library_playlist_entry.library_playlist_id = ?
+		ORDER BY library_playlist_entry.position`
+	rows, err := d.db.QueryContext(ctx, entriesQuery, playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the entries of playlist file #%d: %w", playlistID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry music.PlaylistEntry
+		song, err := scanSong(&suffixedRowScanner{rows, []interface{}{&entry.ID}})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read an entry of playlist file #%d: %w", playlistID, err)
+		}
+		entry.Song = song.Song
+		playlist.Entries = append(playlist.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read the entries of playlist file #%d: %w", playlistID, err)
+	}
+	playlist.SongCount = uint(len(playlist.Entries))
+	return &playlist, nil
+}
+
+// maximumSearchCandidates bounds the number of songs FindSongs returns. Ranking happens in the domain.
+const maximumSearchCandidates = 1000
+
+// FindSongs returns the songs whose title, artist, album or folder name contain words starting with
+// every one of the terms.
+func (d *DAO) FindSongs(ctx context.Context, terms []string) ([]music.IndexedSong, error) {
+	var prefixes []string
+	for _, term := range terms {
+		term = strings.ReplaceAll(term, `"`, "")
+		if term != "" {
+			prefixes = append(prefixes, `"`+term+`*"`)
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	clause := `JOIN song_search ON song_search.docid = song.id WHERE song_search MATCH ? LIMIT ?`
+	songs, err := d.querySongs(ctx, clause, strings.Join(prefixes, " "), maximumSearchCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("Could not search for songs matching %v: %w", terms, err)
+	}
+	return songs, nil
+}
+
+func (d *DAO) listSubFolders(ctx context.Context, folderID int64) ([]music.SubFolder, error) {
+	query := `SELECT folder.name, folder.path, COALESCE(folder.cover_id, 0), folder.modification_time FROM folder
+		WHERE folder.parent_id = ? ORDER BY folder.path`
+	rows, err := d.db.QueryContext(ctx, query, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the sub-folders of folder #%d: %w", folderID, err)
+	}
+	defer rows.Close()
+	var subFolders []music.SubFolder
+	for rows.Next() {
+		var (
+			subFolder        music.SubFolder
+			modificationTime int64
+		)
+		if err = rows.Scan(&subFolder.Name, &subFolder.Path, &subFolder.CoverID, &modificationTime); err != nil {
+			return nil, fmt.Errorf("Could not read a sub-folder of folder #%d: %w", folderID, err)
+		}
+		if modificationTime != 0 {
+			subFolder.ModificationTime = time.Unix(0, modificationTime)
+		}
+		subFolders = append(subFolders, subFolder)
+	}
+	return subFolders, rows.Err()
+}
+
+const songColumns = `song.id, song.path, song.title, song.artist, song.album, song.genre, song.track_number,
+	song.disk_number, song.duration, song.type, song.modification_time, song.size, song.has_picture,
+	COALESCE(song.cover_id, 0)`
+
+// querySongs selects songs with the given JOIN / WHERE / ORDER BY clause
+func (d *DAO) querySongs(ctx context.Context, clause string, args ...interface{}) ([]music.IndexedSong, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT `+songColumns+` FROM song `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var songs []music.IndexedSong
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, *song)
+	}
+	return songs, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSong(row rowScanner) (*music.IndexedSong, error) {
+	var (
+		song             music.IndexedSong
+		modificationTime int64
+	)
+	err := row.Scan(
+		&song.ID,
+		&song.Path,
+		&song.Title,
+		&song.Artist,
+		&song.Album,
+		&song.Genre,
+		&song.TrackNumber,
+		&song.DiskNumber,
+		&song.Duration,
+		&song.Type,
+		&modificationTime,
+		&song.Size,
+		&song.HasPicture,
+		&song.CoverID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read a song: %w", err)
+	}
+	song.URI = path.Join(music.MusicPath, song.Path)
+	song.ModificationTime = time.Unix(0, modificationTime)
+	return &song, nil
+}
+
+// unixNanoOrZero stores the zero Time, which is out of the range of UnixNano, as zero
+func unixNanoOrZero(value time.Time) int64 {
+	if value.IsZero() {
+		return 0
+	}
+	return value.UnixNano()
+}
+
+// nullableID stores zero identifiers as NULL
+func nullableID(id uint) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,376 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestDAO(t *testing.T) {
+	ctx := context.Background()
+	modificationTime := time.Date(2021, time.May, 8, 12, 0, 0, 42, time.UTC)
+	top := music.SubFolder{Name: ".", Path: "."}
+	root := music.SubFolder{Name: "music", Path: "music"}
+	once := music.SubFolder{Name: "Once", Path: "music/Once"}
+	ghost := music.IndexedSong{
+		Song:             music.Song{Title: "Ghost Love Score", Artist: "Nightwish", TrackNumber: 10, Type: "audio/mpeg"},
+		Path:             "music/Once/ghost.mp3",
+		ModificationTime: modificationTime,
+		Size:             1024,
+	}
+
+	t.Run("it lists the folders and songs saved during a scan", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		scanID := saveLibrary(t, dao, root, once, ghost)
+
+		folders, _, err := dao.ListFolder(ctx, "music")
+		tests.AssertNoError(t, err)
+		if len(folders) != 1 || folders[0] != once {
+			t.Errorf("expected the music root to contain %v, got %v", once, folders)
+		}
+
+		_, songs, err := dao.ListFolder(ctx, "music/Once")
+		tests.AssertNoError(t, err)
+		if len(songs) != 1 {
+			t.Fatalf("expected folder Once to contain one song, got %v", songs)
+		}
+		got := songs[0]
+		if got.ID == 0 || got.Title != ghost.Title || got.URI != "/music/music/Once/ghost.mp3" || !got.ModificationTime.Equal(modificationTime) {
+			t.Errorf("saved song %+v does not match %+v", got, ghost)
+		}
+		tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+	})
+
+	t.Run("it keeps the modification time of the folders", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		modified := once
+		modified.ModificationTime = modificationTime
+		saveLibrary(t, dao, root, modified, ghost)
+
+		folders, _, err := dao.ListFolder(ctx, "music")
+		tests.AssertNoError(t, err)
+		if len(folders) != 1 || !folders[0].ModificationTime.Equal(modificationTime) {
+			t.Errorf("expected the folder Once to be modified at %v, got %v", modificationTime, folders)
+		}
+	})
+
+	t.Run("songs keep their identifier from one scan to the next", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		saveLibrary(t, dao, root, once, ghost)
+		_, before, _ := dao.ListFolder(ctx, "music/Once")
+
+		changed := ghost
+		changed.Title = "Ghost Love Score (Remastered)"
+		saveLibrary(t, dao, root, once, changed)
+		_, after, _ := dao.ListFolder(ctx, "music/Once")
+
+		if len(after) != 1 || after[0].ID != before[0].ID || after[0].Title != changed.Title {
+			t.Errorf("expected song #%d to be updated, got %v", before[0].ID, after)
+		}
+	})
+
+	t.Run("it retrieves a song by its identifier", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		saveLibrary(t, dao, root, once, ghost)
+		_, songs, _ := dao.ListFolder(ctx, "music/Once")
+
+		got, err := dao.GetSong(ctx, songs[0].ID)
+		tests.AssertNoError(t, err)
+		if got.Path != ghost.Path {
+			t.Errorf("expected song %s, got %s", ghost.Path, got.Path)
+		}
+
+		_, err = dao.GetSong(ctx, 404)
+		if !errors.Is(err, music.ErrSongNotFound) {
+			t.Errorf("expected ErrSongNotFound, got %v", err)
+		}
+	})
+
+	t.Run("it finds the songs with words starting with every term", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		saveLibrary(t, dao, root, once, ghost)
+
+		songs, err := dao.FindSongs(ctx, []string{"nightw", "lov"})
+		tests.AssertNoError(t, err)
+		if len(songs) != 1 || songs[0].Path != ghost.Path {
+			t.Errorf("expected to find %s, got %v", ghost.Path, songs)
+		}
+
+		songs, err = dao.FindSongs(ctx, []string{"once"})
+		tests.AssertNoError(t, err)
+		if len(songs) != 1 {
+			t.Errorf("expected to find the song by its folder name, got %v", songs)
+		}
+
+		songs, err = dao.FindSongs(ctx, []string{"ghost", "bebop"})
+		tests.AssertNoError(t, err)
+		if len(songs) != 0 {
+			t.Errorf("expected no song to match every term, got %v", songs)
+		}
+	})
+
+	t.Run("removed songs can no longer be found", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		saveLibrary(t, dao, root, once, ghost)
+
+		scanID, err := dao.BeginScan(ctx)
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, top, nil))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, root, nil))
+		tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+
+		songs, err := dao.FindSongs(ctx, []string{"ghost"})
+		tests.AssertNoError(t, err)
+		if len(songs) != 0 {
+			t.Errorf("expected the removed song not to be found, got %v", songs)
+		}
+	})
+
+	t.Run("folders and their songs share the folder's cover image file", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		scanID, err := dao.BeginScan(ctx)
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, top, nil))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, root, nil))
+		coverID, err := dao.SaveCover(ctx, scanID, music.Cover{Path: "music/Once/cover.jpg", ModificationTime: modificationTime, Size: 64})
+		tests.AssertNoError(t, err)
+		withCover := once
+		withCover.CoverID = coverID
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, withCover, []music.IndexedSong{ghost}))
+		tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+
+		folders, _, _ := dao.ListFolder(ctx, "music")
+		_, songs, _ := dao.ListFolder(ctx, "music/Once")
+		if len(folders) != 1 || folders[0].CoverID != coverID || len(songs) != 1 || songs[0].CoverID != coverID {
+			t.Errorf("expected the folder and its song to have cover #%d, got %+v and %+v", coverID, folders, songs)
+		}
+		cover, err := dao.GetCover(ctx, coverID)
+		tests.AssertNoError(t, err)
+		if cover.Path != "music/Once/cover.jpg" || cover.Embedded || cover.Size != 64 || !cover.ModificationTime.Equal(modificationTime) {
+			t.Errorf("unexpected cover %+v", cover)
+		}
+
+		_, err = dao.GetCover(ctx, 404)
+		if !errors.Is(err, music.ErrCoverNotFound) {
+			t.Errorf("expected ErrCoverNotFound, got %v", err)
+		}
+	})
+
+	t.Run("songs embedding a picture have their own cover and folders without cover image file use it", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		withPicture := ghost
+		withPicture.HasPicture = true
+		saveLibrary(t, dao, root, once, withPicture)
+
+		folders, _, _ := dao.ListFolder(ctx, "music")
+		_, songs, _ := dao.ListFolder(ctx, "music/Once")
+		if len(songs) != 1 || !songs[0].HasPicture || songs[0].CoverID == 0 || folders[0].CoverID != songs[0].CoverID {
+			t.Fatalf("expected the song's embedded cover to be the folder's cover, got %+v and %+v", folders, songs)
+		}
+		cover, err := dao.GetCover(ctx, songs[0].CoverID)
+		tests.AssertNoError(t, err)
+		if cover.Path != ghost.Path || !cover.Embedded {
+			t.Errorf("expected an embedded cover in %s, got %+v", ghost.Path, cover)
+		}
+
+		saveLibrary(t, dao, root, once, ghost)
+		_, err = dao.GetCover(ctx, cover.ID)
+		if !errors.Is(err, music.ErrCoverNotFound) {
+			t.Errorf("expected the cover to be removed once the song no longer embeds it, got %v", err)
+		}
+	})
+
+	t.Run("it lists the playlist files and their songs that are in the index", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		scanID := saveLibrary(t, dao, root, once, ghost)
+		playlistFile := music.PlaylistFile{
+			Path:      "music/Once/best.m3u8",
+			Name:      "Best of",
+			SongPaths: []string{"music/Once/missing.mp3", ghost.Path},
+		}
+		tests.AssertNoError(t, dao.SavePlaylistFile(ctx, scanID, playlistFile))
+
+		playlists, err := dao.ListLibraryPlaylists(ctx)
+		tests.AssertNoError(t, err)
+		if len(playlists) != 1 || playlists[0].Path != playlistFile.Path || playlists[0].SongCount != 1 {
+			t.Fatalf("unexpected playlist files %+v", playlists)
+		}
+
+		playlist, err := dao.GetLibraryPlaylist(ctx, playlists[0].ID)
+		tests.AssertNoError(t, err)
+		if playlist.Name != "Best of" || len(playlist.Entries) != 1 || playlist.Entries[0].Song.Title != ghost.Title {
+			t.Errorf("unexpected playlist file %+v", playlist)
+		}
+
+		_, err = dao.GetLibraryPlaylist(ctx, 404)
+		if !errors.Is(err, music.ErrPlaylistNotFound) {
+			t.Errorf("expected ErrPlaylistNotFound, got %v", err)
+		}
+	})
+
+	t.Run("saving a playlist file again replaces its entries and ending a scan removes the ones it did not save", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		scanID := saveLibrary(t, dao, root, once, ghost)
+		playlistFile := music.PlaylistFile{Path: "music/best.m3u8", Name: "Best of", SongPaths: []string{ghost.Path, ghost.Path}}
+		tests.AssertNoError(t, dao.SavePlaylistFile(ctx, scanID, playlistFile))
+		playlistFile.SongPaths = []string{ghost.Path}
+		tests.AssertNoError(t, dao.SavePlaylistFile(ctx, scanID, playlistFile))
+
+		playlists, _ := dao.ListLibraryPlaylists(ctx)
+		if len(playlists) != 1 || playlists[0].SongCount != 1 {
+			t.Errorf("expected the entries to be replaced, got %+v", playlists)
+		}
+
+		saveLibrary(t, dao, root, once, ghost)
+		playlists, _ = dao.ListLibraryPlaylists(ctx)
+		if len(playlists) != 0 {
+			t.Errorf("expected the playlist file to be removed, got %+v", playlists)
+		}
+	})
+
+	t.Run("it counts the contents of the library index", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		statistics, err := dao.GetStatistics(ctx)
+		tests.AssertNoError(t, err)
+		if !statistics.LastScan.IsZero() {
+			t.Errorf("expected no finished scan, got %v", statistics.LastScan)
+		}
+
+		saveLibrary(t, dao, root, once, ghost)
+		statistics, err = dao.GetStatistics(ctx)
+		tests.AssertNoError(t, err)
+		if statistics.Folders != 3 || statistics.Songs != 1 || statistics.Size != ghost.Size || statistics.LastScan.IsZero() {
+			t.Errorf("unexpected statistics %+v", statistics)
+		}
+	})
+
+	t.Run("ending a scan removes the folders and songs it did not save", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		saveLibrary(t, dao, root, once, ghost)
+
+		scanID, err := dao.BeginScan(ctx)
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, top, nil))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, root, nil))
+		tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+
+		_, _, err = dao.ListFolder(ctx, "music/Once")
+		if !errors.Is(err, music.ErrFolderNotFound) {
+			t.Errorf("expected ErrFolderNotFound, got %v", err)
+		}
+	})
+
+	t.Run("ending the scan of a folder only removes what it did not save in this folder", func(t *testing.T) {
+		dao := NewDAO(tests.NewDatabase(t))
+		// "music/Once 2" starts like "music/Once" but is not in it
+		sibling := music.SubFolder{Name: "Once 2", Path: "music/Once 2"}
+		scanID, err := dao.BeginScan(ctx)
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, top, nil))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, root, nil))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, once, []music.IndexedSong{ghost}))
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, sibling, []music.IndexedSong{{
+			Song: ghost.Song, Path: "music/Once 2/ghost.mp3", ModificationTime: modificationTime, Size: 1024,
+		}}))
+		tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+
+		scanID, err = dao.BeginScan(ctx)
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, once, nil))
+		tests.AssertNoError(t, dao.EndFolderScan(ctx, scanID, "music/Once"))
+
+		_, songs, err := dao.ListFolder(ctx, "music/Once")
+		tests.AssertNoError(t, err)
+		if len(songs) != 0 {
+			t.Errorf("expected the removed song to be removed from the index, got %v", songs)
+		}
+		_, songs, _ = dao.ListFolder(ctx, "music/Once 2")
+		if len(songs) != 1 {
+			t.Errorf("expected the songs outside of the scanned folder to be kept, got %v", songs)
+		}
+	})
+	t.Run("a scan keeps the songs and playlists of the folders it cannot read", func(t *testing.T) {
+		db := tests.NewDatabase(t)
+		dao := NewDAO(db)
+		playlistDAO := NewPlaylistDAO(db)
+		library := fstest.MapFS{
+			"music/Once/ghost.mp3":   {},
+			"music/Once/best.m3u8":   {Data: []byte("ghost.mp3\n")},
+			"music/Century/nemo.mp3": {},
+		}
+		_, err := music.NewScanner(library, dao).Scan(ctx)
+		tests.AssertNoError(t, err)
+		_, songs, err := dao.ListFolder(ctx, "music/Once")
+		tests.AssertNoError(t, err)
+		created, err := playlistDAO.CreatePlaylist(ctx, 1, "Road trip")
+		tests.AssertNoError(t, err)
+		tests.AssertNoError(t, playlistDAO.AppendSongs(ctx, 1, created.ID, []uint{songs[0].ID}))
+
+		report, err := music.NewScanner(&libraryWithUnreadableFolder{library, "music/Once"}, dao).Scan(ctx)
+
+		tests.AssertNoError(t, err)
+		if len(report.UnreadableFolders) != 1 {
+			t.Errorf("expected the unreadable folder to be reported, got %v", report.UnreadableFolders)
+		}
+		_, kept, err := dao.ListFolder(ctx, "music/Once")
+		tests.AssertNoError(t, err)
+		if len(kept) != 1 || kept[0].ID != songs[0].ID {
+			t.Errorf("expected the songs of the unreadable folder to be kept, got %v", kept)
+		}
+		playlists, _ := dao.ListLibraryPlaylists(ctx)
+		if len(playlists) != 1 || playlists[0].SongCount != 1 {
+			t.Errorf("expected the playlist files of the unreadable folder to be kept, got %+v", playlists)
+		}
+		playlist, err := playlistDAO.GetPlaylist(ctx, 1, created.ID)
+		tests.AssertNoError(t, err)
+		assertPlaylistSongs(t, playlist, songs[0].ID)
+	})
+}
+
+func saveLibrary(t *testing.T, dao *DAO, root music.SubFolder, folder music.SubFolder, song music.IndexedSong) int64 {
+	t.Helper()
+	ctx := context.Background()
+	scanID, err := dao.BeginScan(ctx)
+	tests.AssertNoError(t, err)
+	tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, music.SubFolder{Name: ".", Path: "."}, nil))
+	tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, root, nil))
+	tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, folder, []music.IndexedSong{song}))
+	tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+	return scanID
+}
+
+// libraryWithUnreadableFolder returns an error when reading the given folder
+type libraryWithUnreadableFolder struct {
+	fstest.MapFS
+	unreadable string
+}
+
+func (l *libraryWithUnreadableFolder) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == l.unreadable {
+		return nil, fs.ErrPermission
+	}
+	return l.MapFS.ReadDir(name)
+}
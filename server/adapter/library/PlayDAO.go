@@ -0,0 +1,170 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// PlayDAO implements music.PlayStore
+type PlayDAO struct {
+	db *sql.DB
+}
+
+// NewPlayDAO creates a new PlayDAO
+func NewPlayDAO(db *sql.DB) *PlayDAO {
+	return &PlayDAO{db}
+}
+
+// RecordPlay saves a play of the song by the user
+func (d *PlayDAO) RecordPlay(ctx context.Context, userID uint, songID uint, playedAt time.Time) (*music.Play, error) {
+	query := insertPlayQuery + `WHERE song.id = ?`
+	playID, err := d.insertPlay(ctx, query, userID, playedAt, songID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not record the play of song #%d: %w", songID, err)
+	}
+	play := &music.Play{ID: playID, PlayedAt: time.Unix(playedAt.Unix(), 0)}
+	row := d.db.QueryRowContext(ctx, `SELECT `+songColumns+` FROM song WHERE song.id = ?`, songID)
+	song, err := scanSong(row)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the played song #%d: %w", songID, err)
+	}
+	play.Song = song.Song
+	return play, nil
+}
+
+// RecordPlayOfPath saves a play of the song at songPath by the user
+func (d *PlayDAO) RecordPlayOfPath(ctx context.Context, userID uint, songPath string, playedAt time.Time) error {
+	query := insertPlayQuery + `WHERE song.path = ?`
+	if _, err := d.insertPlay(ctx, query, userID, playedAt, songPath); err != nil {
+		return fmt.Errorf("Could not record the play of song %s: %w", songPath, err)
+	}
+	return nil
+}
+
+// insertPlayQuery copies the path and the tags of the song, so that its plays can find it again
+// when the scanner removes it and adds it back, for example after its file was moved
+const insertPlayQuery = `INSERT INTO play(user_id, song_id, song_path, song_title, song_artist, song_album, played_at)
+	SELECT ?, song.id, song.path, song.title, song.artist, song.album, ? FROM song `
+
+// insertPlay runs the INSERT … SELECT query of a play. It returns ErrSongNotFound when the SELECT finds no song.
+func (d *PlayDAO) insertPlay(ctx context.Context, query string, userID uint, playedAt time.Time, song interface{}) (uint, error) {
+	result, err := d.db.ExecContext(ctx, query, userID, playedAt.Unix(), song)
+	if err != nil {
+		return 0, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if inserted == 0 {
+		return 0, music.ErrSongNotFound
+	}
+	playID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return uint(playID), nil
+}
+
+// playedSongColumns selects the song of a play like songColumns. When the song is no longer in the library,
+// they fall back to the path and the tags kept by the play.
+const playedSongColumns = `COALESCE(song.id, 0), COALESCE(song.path, play.song_path),
+	COALESCE(song.title, play.song_title), COALESCE(song.artist, play.song_artist),
+	COALESCE(song.album, play.song_album), COALESCE(song.genre, ''), COALESCE(song.track_number, 0),
+	COALESCE(song.disk_number, 0), COALESCE(song.duration, 0), COALESCE(song.type, ''),
+	COALESCE(song.modification_time, 0), COALESCE(song.size, 0), COALESCE(song.has_picture, 0),
+	COALESCE(song.cover_id, 0)`
+
+// scanPlayedSong reads the columns of playedSongColumns. The songs that are no longer in the library
+// have no URI.
+func scanPlayedSong(row rowScanner) (*music.IndexedSong, error) {
+	song, err := scanSong(row)
+	if err != nil {
+		return nil, err
+	}
+	if song.ID == 0 {
+		song.URI = ""
+	}
+	return song, nil
+}
+
+// ListRecentPlays returns the last plays of the user, most recent first
+func (d *PlayDAO) ListRecentPlays(ctx context.Context, userID uint, limit uint) ([]music.Play, error) {
+	query := `SELECT ` + playedSongColumns + `, play.id, play.played_at FROM play
+		LEFT JOIN song ON song.id = play.song_id
+		WHERE play.user_id = ?
+		ORDER BY play.played_at DESC, play.id DESC
+		LIMIT ?`
+	rows, err := d.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the plays of user #%d: %w", userID, err)
+	}
+	defer rows.Close()
+	var plays []music.Play
+	for rows.Next() {
+		var (
+			play     music.Play
+			playedAt int64
+		)
+		song, err := scanPlayedSong(&suffixedRowScanner{rows, []interface{}{&play.ID, &playedAt}})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read a play of user #%d: %w", userID, err)
+		}
+		play.Song = song.Song
+		play.PlayedAt = time.Unix(playedAt, 0)
+		plays = append(plays, play)
+	}
+	return plays, rows.Err()
+}
+
+// ListPlayCounts returns the songs the user listened to, most played first. The plays of the songs that are
+// no longer in the library are counted by path.
+func (d *PlayDAO) ListPlayCounts(ctx context.Context, userID uint, limit uint) ([]music.SongPlayCount, error) {
+	query := `SELECT ` + playedSongColumns + `, COUNT(play.id), MAX(play.played_at) FROM play
+		LEFT JOIN song ON song.id = play.song_id
+		WHERE play.user_id = ?
+		GROUP BY song.id, CASE WHEN song.id IS NULL THEN play.song_path END
+		ORDER BY COUNT(play.id) DESC, MAX(play.played_at) DESC, song.id, play.song_path
+		LIMIT ?`
+	rows, err := d.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the play counts of user #%d: %w", userID, err)
+	}
+	defer rows.Close()
+	var counts []music.SongPlayCount
+	for rows.Next() {
+		var (
+			count        music.SongPlayCount
+			lastPlayedAt int64
+		)
+		song, err := scanPlayedSong(&suffixedRowScanner{rows, []interface{}{&count.PlayCount, &lastPlayedAt}})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read a play count of user #%d: %w", userID, err)
+		}
+		count.Song = song.Song
+		count.LastPlayedAt = time.Unix(lastPlayedAt, 0)
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,181 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestPlayDAO(t *testing.T) {
+	ctx := context.Background()
+	const userID, otherUserID = 1, 2
+	monday := time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC)
+
+	t.Run("it records plays and lists the most recent first", func(t *testing.T) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+
+		play, err := dao.RecordPlay(ctx, userID, songIDs[0], monday)
+		tests.AssertNoError(t, err)
+		if play.ID == 0 || play.Song.Title != "Nemo" || !play.PlayedAt.Equal(monday) {
+			t.Errorf("unexpected play %+v", play)
+		}
+		tests.AssertNoError(t, dao.RecordPlayOfPath(ctx, userID, "ghost.mp3", monday.Add(time.Hour)))
+		_, err = dao.RecordPlay(ctx, otherUserID, songIDs[0], monday.Add(2*time.Hour))
+		tests.AssertNoError(t, err)
+
+		plays, err := dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 2 || plays[0].Song.ID != songIDs[1] || plays[1].ID != play.ID {
+			t.Errorf("expected the two plays of the user, most recent first, got %+v", plays)
+		}
+		plays, _ = dao.ListRecentPlays(ctx, userID, 1)
+		if len(plays) != 1 {
+			t.Errorf("expected the plays to be limited, got %+v", plays)
+		}
+	})
+
+	t.Run("it counts the plays of each song, most played first", func(t *testing.T) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+		for _, songID := range []uint{songIDs[0], songIDs[1], songIDs[1]} {
+			_, err := dao.RecordPlay(ctx, userID, songID, monday)
+			tests.AssertNoError(t, err)
+		}
+		_, err := dao.RecordPlay(ctx, otherUserID, songIDs[0], monday.Add(time.Hour))
+		tests.AssertNoError(t, err)
+
+		counts, err := dao.ListPlayCounts(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(counts) != 2 || counts[0].Song.ID != songIDs[1] || counts[0].PlayCount != 2 || counts[1].PlayCount != 1 {
+			t.Fatalf("unexpected play counts %+v", counts)
+		}
+		if !counts[1].LastPlayedAt.Equal(monday) {
+			t.Errorf("expected the plays of other users to be ignored, got %v", counts[1].LastPlayedAt)
+		}
+	})
+
+	t.Run("when the scanner moves a song, its plays follow it", func(t *testing.T) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+		_, err := dao.RecordPlay(ctx, userID, songIDs[0], monday)
+		tests.AssertNoError(t, err)
+
+		moved := music.Song{Title: "Nemo", Artist: "Nightwish", Album: "Once", Type: "audio/mpeg"}
+		rescan(t, NewDAO(playlistDAO.db), music.IndexedSong{Song: moved, Path: "moved.mp3"})
+
+		plays, err := dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 1 || plays[0].Song.ID == songIDs[0] || plays[0].Song.Title != "Nemo" {
+			t.Errorf("expected the play to follow the moved song, got %+v", plays)
+		}
+	})
+
+	t.Run("plays of songs without artist or album only follow them by path", func(t *testing.T) {
+		playlistDAO, _ := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+		libraryDAO := NewDAO(playlistDAO.db)
+		// Untagged songs are named after their file
+		untagged := music.Song{Title: "01.mp3", Type: "audio/mpeg"}
+		rescan(t, libraryDAO, music.IndexedSong{Song: untagged, Path: "Once/01.mp3"}, music.IndexedSong{Song: untagged, Path: "Century/01.mp3"})
+		tests.AssertNoError(t, dao.RecordPlayOfPath(ctx, userID, "Once/01.mp3", monday))
+
+		rescan(t, libraryDAO, music.IndexedSong{Song: untagged, Path: "Century/01.mp3"})
+		rescan(t, libraryDAO, music.IndexedSong{Song: untagged, Path: "Century/01.mp3"}, music.IndexedSong{Song: untagged, Path: "Oceanborn/01.mp3"})
+		plays, err := dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 1 || plays[0].Song.ID != 0 {
+			t.Errorf("expected the play not to go to another untagged song, got %+v", plays)
+		}
+
+		rescan(t, libraryDAO, music.IndexedSong{Song: untagged, Path: "Once/01.mp3"})
+		plays, err = dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 1 || plays[0].Song.ID == 0 {
+			t.Errorf("expected the play to come back with the song at the same path, got %+v", plays)
+		}
+	})
+
+	t.Run("when the scanner removes a song, its plays are kept with its tags until it comes back", func(t *testing.T) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+		libraryDAO := NewDAO(playlistDAO.db)
+		_, err := dao.RecordPlay(ctx, userID, songIDs[0], monday)
+		tests.AssertNoError(t, err)
+
+		rescan(t, libraryDAO)
+		plays, err := dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 1 || plays[0].Song.ID != 0 || plays[0].Song.URI != "" || plays[0].Song.Title != "Nemo" || plays[0].Song.Artist != "Nightwish" {
+			t.Errorf("expected the play of the removed song with the tags it had, got %+v", plays)
+		}
+
+		rescan(t, libraryDAO, music.IndexedSong{Song: music.Song{Title: "Nemo (remastered)", Type: "audio/mpeg"}, Path: "nemo.mp3"})
+		plays, err = dao.ListRecentPlays(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(plays) != 1 || plays[0].Song.ID == 0 || plays[0].Song.Title != "Nemo (remastered)" {
+			t.Errorf("expected the play to come back with the song at the same path, got %+v", plays)
+		}
+	})
+
+	t.Run("it counts the plays of the removed songs by path", func(t *testing.T) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+		for _, songID := range []uint{songIDs[0], songIDs[1], songIDs[1]} {
+			_, err := dao.RecordPlay(ctx, userID, songID, monday)
+			tests.AssertNoError(t, err)
+		}
+
+		rescan(t, NewDAO(playlistDAO.db))
+		counts, err := dao.ListPlayCounts(ctx, userID, 10)
+		tests.AssertNoError(t, err)
+		if len(counts) != 2 || counts[0].Song.Title != "Ghost Love Score" || counts[0].PlayCount != 2 || counts[1].PlayCount != 1 {
+			t.Errorf("expected the removed songs to be counted apart, got %+v", counts)
+		}
+	})
+
+	t.Run("given a song that is not in the library, it returns ErrSongNotFound", func(t *testing.T) {
+		playlistDAO, _ := newPlaylistDAOWithSongs(t)
+		dao := NewPlayDAO(playlistDAO.db)
+
+		_, err := dao.RecordPlay(ctx, userID, 404, monday)
+		if !errors.Is(err, music.ErrSongNotFound) {
+			t.Errorf("expected ErrSongNotFound, got %v", err)
+		}
+		err = dao.RecordPlayOfPath(ctx, userID, "missing.mp3", monday)
+		if !errors.Is(err, music.ErrSongNotFound) {
+			t.Errorf("expected ErrSongNotFound, got %v", err)
+		}
+	})
+}
+
+// rescan replaces the songs of the library with songs
+func rescan(t *testing.T, dao *DAO, songs ...music.IndexedSong) {
+	t.Helper()
+	ctx := context.Background()
+	scanID, err := dao.BeginScan(ctx)
+	tests.AssertNoError(t, err)
+	tests.AssertNoError(t, dao.SaveFolder(ctx, scanID, music.SubFolder{Name: ".", Path: "."}, songs))
+	tests.AssertNoError(t, dao.EndScan(ctx, scanID))
+}
@@ -0,0 +1,249 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// PlaylistDAO implements music.PlaylistStore
+type PlaylistDAO struct {
+	db *sql.DB
+}
+
+// NewPlaylistDAO creates a new PlaylistDAO
+func NewPlaylistDAO(db *sql.DB) *PlaylistDAO {
+	return &PlaylistDAO{db}
+}
+
+// ListPlaylists returns the playlists of the owner, ordered by name
+func (d *PlaylistDAO) ListPlaylists(ctx context.Context, ownerID uint) ([]music.PlaylistSummary, error) {
+	query := `SELECT playlist.id, playlist.name, COUNT(playlist_entry.id) FROM playlist
+		LEFT JOIN playlist_entry ON playlist_entry.playlist_id = playlist.id
+		WHERE playlist.user_id = ?
+		GROUP BY playlist.id
+		ORDER BY playlist.name COLLATE NOCASE, playlist.id`
+	rows, err := d.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the playlists of user #%d: %w", ownerID, err)
+	}
+	defer rows.Close()
+	var playlists []music.PlaylistSummary
+	for rows.Next() {
+		var playlist music.PlaylistSummary
+		if err = rows.Scan(&playlist.ID, &playlist.Name, &playlist.SongCount); err != nil {
+			return nil, fmt.Errorf("Could not read a playlist of user #%d: %w", ownerID, err)
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+// CreatePlaylist saves a new empty playlist
+func (d *PlaylistDAO) CreatePlaylist(ctx context.Context, ownerID uint, name string) (*music.PlaylistSummary, error) {
+	result, err := d.db.ExecContext(ctx, `INSERT INTO playlist(user_id, name) VALUES (?, ?)`, ownerID, name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the new playlist %v: %w", name, err)
+	}
+	playlistID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the identifier of the new playlist %v: %w", name, err)
+	}
+	return &music.PlaylistSummary{ID: uint(playlistID), Name: name}, nil
+}
+
+// GetPlaylist returns the playlist and its entries, in order
+func (d *PlaylistDAO) GetPlaylist(ctx context.Context, ownerID uint, playlistID uint) (*music.Playlist, error) {
+	var playlist music.Playlist
+	query := `SELECT playlist.id, playlist.name FROM playlist WHERE playlist.id = ? AND playlist.user_id = ?`
+	err := d.db.QueryRowContext(ctx, query, playlistID, ownerID).Scan(&playlist.ID, &playlist.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the playlist #%d: %w", playlistID, err)
+	}
+
+	entriesQuery := `SELECT ` + songColumns + `, playlist_entry.id FROM playlist_entry
+		JOIN song ON song.id = playlist_entry.song_id
+		WHERE playlist_entry.playlist_id = ?
+		ORDER BY playlist_entry.position, playlist_entry.id`
+	rows, err := d.db.QueryContext(ctx, entriesQuery, playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the entries of playlist #%d: %w", playlistID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry music.PlaylistEntry
+		song, err := scanSong(&suffixedRowScanner{rows, []interface{}{&entry.ID}})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read an entry of playlist #%d: %w", playlistID, err)
+		}
+		entry.Song = song.Song
+		playlist.Entries = append(playlist.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read the entries of playlist #%d: %w", playlistID, err)
+	}
+	playlist.SongCount = uint(len(playlist.Entries))
+	return &playlist, nil
+}
+
+// RenamePlaylist changes the name of the playlist
+func (d *PlaylistDAO) RenamePlaylist(ctx context.Context, ownerID uint, playlistID uint, name string) error {
+	return d.edit(ctx, ownerID, playlistID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE playlist SET name = ? WHERE playlist.id = ?`, name, playlistID)
+		if err != nil {
+			return fmt.Errorf("Could not rename the playlist #%d: %w", playlistID, err)
+		}
+		return nil
+	})
+}
+
+// AppendSongs adds the songs at the end of the playlist, in the given order
+func (d *PlaylistDAO) AppendSongs(ctx context.Context, ownerID uint, playlistID uint, songIDs []uint) error {
+	return d.edit(ctx, ownerID, playlistID, func(tx *sql.Tx) error {
+		var lastPosition int64
+		positionQuery := `SELECT COALESCE(MAX(playlist_entry.position), -1) FROM playlist_entry WHERE playlist_entry.playlist_id = ?`
+		if err := tx.QueryRowContext(ctx, positionQuery, playlistID).Scan(&lastPosition); err != nil {
+			return fmt.Errorf("Could not retrieve the last position of playlist #%d: %w", playlistID, err)
+		}
+		// Inserting from the song table adds nothing when the song is not in the library index
+		statement, err := tx.PrepareContext(
+			ctx,
+			`INSERT INTO playlist_entry(playlist_id, song_id, position) SELECT ?, song.id, ? FROM song WHERE song.id = ?`,
+		)
+		if err != nil {
+			return fmt.Errorf("Could not prepare the playlist entry query: %w", err)
+		}
+		defer statement.Close()
+		for index, songID := range songIDs {
+			result, err := statement.ExecContext(ctx, playlistID, lastPosition+int64(index)+1, songID)
+			if err != nil {
+				return fmt.Errorf("Could not append the song #%d to playlist #%d: %w", songID, playlistID, err)
+			}
+			if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+				return fmt.Errorf("Could not append the song #%d to playlist #%d: %w", songID, playlistID, music.ErrSongNotFound)
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveEntry removes the entry from the playlist. The other entries keep their order.
+func (d *PlaylistDAO) RemoveEntry(ctx context.Context, ownerID uint, playlistID uint, entryID uint) error {
+	return d.edit(ctx, ownerID, playlistID, func(tx *sql.Tx) error {
+		query := `DELETE FROM playlist_entry WHERE playlist_entry.id = ? AND playlist_entry.playlist_id = ?`
+		result, err := tx.ExecContext(ctx, query, entryID, playlistID)
+		if err != nil {
+			return fmt.Errorf("Could not remove the entry #%d from playlist #%d: %w", entryID, playlistID, err)
+		}
+		if removed, err := result.RowsAffected(); err != nil || removed == 0 {
+			return music.ErrPlaylistEntryNotFound
+		}
+		return nil
+	})
+}
+
+// ReorderEntries gives every entry of the playlist its position in entryIDs
+func (d *PlaylistDAO) ReorderEntries(ctx context.Context, ownerID uint, playlistID uint, entryIDs []uint) error {
+	return d.edit(ctx, ownerID, playlistID, func(tx *sql.Tx) error {
+		var entryCount int
+		countQuery := `SELECT COUNT(*) FROM playlist_entry WHERE playlist_entry.playlist_id = ?`
+		if err := tx.QueryRowContext(ctx, countQuery, playlistID).Scan(&entryCount); err != nil {
+			return fmt.Errorf("Could not count the entries of playlist #%d: %w", playlistID, err)
+		}
+		if entryCount != len(entryIDs) {
+			return music.ErrStalePlaylistOrder
+		}
+		statement, err := tx.PrepareContext(
+			ctx,
+			`UPDATE playlist_entry SET position = ? WHERE playlist_entry.id = ? AND playlist_entry.playlist_id = ?`,
+		)
+		if err != nil {
+			return fmt.Errorf("Could not prepare the playlist order query: %w", err)
+		}
+		defer statement.Close()
+		seen := make(map[uint]bool, len(entryIDs))
+		for position, entryID := range entryIDs {
+			if seen[entryID] {
+				return music.ErrStalePlaylistOrder
+			}
+			seen[entryID] = true
+			result, err := statement.ExecContext(ctx, position, entryID, playlistID)
+			if err != nil {
+				return fmt.Errorf("Could not move the entry #%d of playlist #%d: %w", entryID, playlistID, err)
+			}
+			if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+				return music.ErrStalePlaylistOrder
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePlaylist removes the playlist and its entries
+func (d *PlaylistDAO) DeletePlaylist(ctx context.Context, ownerID uint, playlistID uint) error {
+	result, err := d.db.ExecContext(ctx, `DELETE FROM playlist WHERE playlist.id = ? AND playlist.user_id = ?`, playlistID, ownerID)
+	if err != nil {
+		return fmt.Errorf("Could not delete the playlist #%d: %w", playlistID, err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return music.ErrPlaylistNotFound
+	}
+	return nil
+}
+
+// edit runs change in a transaction after checking that the owner owns the playlist. Transactions take the
+// database write lock when they begin (see database.ConnectionOptions): concurrent edits of the playlist wait
+// for each other and always see the entries left by the previous edit.
+func (d *PlaylistDAO) edit(ctx context.Context, ownerID uint, playlistID uint, change func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	var owned bool
+	query := `SELECT EXISTS (SELECT 1 FROM playlist WHERE playlist.id = ? AND playlist.user_id = ?)`
+	if err = tx.QueryRowContext(ctx, query, playlistID, ownerID).Scan(&owned); err != nil {
+		return fmt.Errorf("Could not check the owner of the playlist #%d: %w", playlistID, err)
+	}
+	if !owned {
+		return music.ErrPlaylistNotFound
+	}
+	if err = change(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// suffixedRowScanner scans extra columns selected after the song columns
+type suffixedRowScanner struct {
+	rowScanner
+	suffix []interface{}
+}
+
+func (s *suffixedRowScanner) Scan(dest ...interface{}) error {
+	return s.rowScanner.Scan(append(dest, s.suffix...)...)
+}
@@ -0,0 +1,232 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestPlaylistDAO(t *testing.T) {
+	ctx := context.Background()
+	const ownerID, otherUserID = 1, 2
+
+	t.Run("it lists the playlists of their owner only", func(t *testing.T) {
+		dao, _ := newPlaylistDAOWithSongs(t)
+		_, err := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+		tests.AssertNoError(t, err)
+		_, err = dao.CreatePlaylist(ctx, otherUserID, "Someone else's")
+		tests.AssertNoError(t, err)
+
+		playlists, err := dao.ListPlaylists(ctx, ownerID)
+		tests.AssertNoError(t, err)
+		if len(playlists) != 1 || playlists[0].Name != "Road trip" {
+			t.Errorf("expected only the owner's playlist, got %v", playlists)
+		}
+	})
+
+	t.Run("it appends, reorders and removes entries", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, err := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+		tests.AssertNoError(t, err)
+
+		tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs))
+		tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs[:1]))
+		playlist, err := dao.GetPlaylist(ctx, ownerID, created.ID)
+		tests.AssertNoError(t, err)
+		assertPlaylistSongs(t, playlist, songIDs[0], songIDs[1], songIDs[0])
+
+		entries := playlist.Entries
+		order := []uint{entries[2].ID, entries[0].ID, entries[1].ID}
+		tests.AssertNoError(t, dao.ReorderEntries(ctx, ownerID, created.ID, order))
+		tests.AssertNoError(t, dao.RemoveEntry(ctx, ownerID, created.ID, entries[0].ID))
+		playlist, err = dao.GetPlaylist(ctx, ownerID, created.ID)
+		tests.AssertNoError(t, err)
+		assertPlaylistSongs(t, playlist, songIDs[0], songIDs[1])
+		if playlist.Entries[0].ID != entries[2].ID {
+			t.Errorf("expected entries to keep their identifier, got %v", playlist.Entries)
+		}
+	})
+
+	t.Run("given songs that are not in the library, it appends none of them", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+
+		err := dao.AppendSongs(ctx, ownerID, created.ID, []uint{songIDs[0], 404})
+		if !errors.Is(err, music.ErrSongNotFound) {
+			t.Errorf("expected ErrSongNotFound, got %v", err)
+		}
+		playlist, _ := dao.GetPlaylist(ctx, ownerID, created.ID)
+		assertPlaylistSongs(t, playlist)
+	})
+
+	t.Run("given entries that do not match the playlist, it refuses to reorder it", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+		tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs))
+		playlist, _ := dao.GetPlaylist(ctx, ownerID, created.ID)
+		first := playlist.Entries[0].ID
+
+		for _, order := range [][]uint{{first}, {first, first}, {first, 404}} {
+			err := dao.ReorderEntries(ctx, ownerID, created.ID, order)
+			if !errors.Is(err, music.ErrStalePlaylistOrder) {
+				t.Errorf("expected ErrStalePlaylistOrder for %v, got %v", order, err)
+			}
+		}
+		playlist, _ = dao.GetPlaylist(ctx, ownerID, created.ID)
+		assertPlaylistSongs(t, playlist, songIDs[0], songIDs[1])
+	})
+
+	t.Run("concurrent appends keep every song, in a stable order", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs))
+			}()
+		}
+		wg.Wait()
+
+		playlist, err := dao.GetPlaylist(ctx, ownerID, created.ID)
+		tests.AssertNoError(t, err)
+		if playlist.SongCount != 20 {
+			t.Fatalf("expected 20 entries, got %d", playlist.SongCount)
+		}
+		for index, entry := range playlist.Entries {
+			if entry.Song.ID != songIDs[index%2] {
+				t.Fatalf("expected appended songs not to interleave, got %v", playlist.Entries)
+			}
+		}
+	})
+
+	t.Run("the playlist can be read while it is edited", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs[:1]))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := dao.GetPlaylist(ctx, ownerID, created.ID)
+				tests.AssertNoError(t, err)
+			}
+		}()
+		wg.Wait()
+
+		playlist, err := dao.GetPlaylist(ctx, ownerID, created.ID)
+		tests.AssertNoError(t, err)
+		if playlist.SongCount != 10 {
+			t.Errorf("expected 10 entries, got %d", playlist.SongCount)
+		}
+	})
+
+	t.Run("other users cannot see, edit or delete the playlist", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+
+		_, err := dao.GetPlaylist(ctx, otherUserID, created.ID)
+		assertPlaylistNotFound(t, err)
+		assertPlaylistNotFound(t, dao.RenamePlaylist(ctx, otherUserID, created.ID, "Mine now"))
+		assertPlaylistNotFound(t, dao.AppendSongs(ctx, otherUserID, created.ID, songIDs))
+		assertPlaylistNotFound(t, dao.DeletePlaylist(ctx, otherUserID, created.ID))
+	})
+
+	t.Run("it renames and deletes the playlist", func(t *testing.T) {
+		dao, _ := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+
+		tests.AssertNoError(t, dao.RenamePlaylist(ctx, ownerID, created.ID, "Commute"))
+		playlist, _ := dao.GetPlaylist(ctx, ownerID, created.ID)
+		if playlist.Name != "Commute" {
+			t.Errorf("expected the playlist to be renamed, got %s", playlist.Name)
+		}
+		tests.AssertNoError(t, dao.DeletePlaylist(ctx, ownerID, created.ID))
+		_, err := dao.GetPlaylist(ctx, ownerID, created.ID)
+		assertPlaylistNotFound(t, err)
+	})
+
+	t.Run("songs removed from the library are removed from the playlists", func(t *testing.T) {
+		dao, songIDs := newPlaylistDAOWithSongs(t)
+		created, _ := dao.CreatePlaylist(ctx, ownerID, "Road trip")
+		tests.AssertNoError(t, dao.AppendSongs(ctx, ownerID, created.ID, songIDs))
+
+		libraryDAO := NewDAO(dao.db)
+		scanID, _ := libraryDAO.BeginScan(ctx)
+		tests.AssertNoError(t, libraryDAO.SaveFolder(ctx, scanID, music.SubFolder{Name: ".", Path: "."}, nil))
+		tests.AssertNoError(t, libraryDAO.EndScan(ctx, scanID))
+
+		playlist, _ := dao.GetPlaylist(ctx, ownerID, created.ID)
+		assertPlaylistSongs(t, playlist)
+	})
+}
+
+// newPlaylistDAOWithSongs creates a PlaylistDAO on a database whose library contains two songs
+func newPlaylistDAOWithSongs(t *testing.T) (*PlaylistDAO, []uint) {
+	t.Helper()
+	ctx := context.Background()
+	db := tests.NewDatabase(t)
+	libraryDAO := NewDAO(db)
+	songs := []music.IndexedSong{
+		{Song: music.Song{Title: "Nemo", Artist: "Nightwish", Album: "Once", Type: "audio/mpeg"}, Path: "nemo.mp3"},
+		{Song: music.Song{Title: "Ghost Love Score", Artist: "Nightwish", Album: "Once", Type: "audio/mpeg"}, Path: "ghost.mp3"},
+	}
+	scanID, err := libraryDAO.BeginScan(ctx)
+	tests.AssertNoError(t, err)
+	tests.AssertNoError(t, libraryDAO.SaveFolder(ctx, scanID, music.SubFolder{Name: ".", Path: "."}, songs))
+	tests.AssertNoError(t, libraryDAO.EndScan(ctx, scanID))
+
+	_, saved, err := libraryDAO.ListFolder(ctx, ".")
+	tests.AssertNoError(t, err)
+	// ListFolder orders by path
+	return NewPlaylistDAO(db), []uint{saved[1].ID, saved[0].ID}
+}
+
+func assertPlaylistSongs(t *testing.T, playlist *music.Playlist, wantSongIDs ...uint) {
+	t.Helper()
+	if len(playlist.Entries) != len(wantSongIDs) {
+		t.Fatalf("expected %d entries, got %v", len(wantSongIDs), playlist.Entries)
+	}
+	for index, entry := range playlist.Entries {
+		if entry.Song.ID != wantSongIDs[index] {
+			t.Errorf("expected song #%d at position %d, got #%d", wantSongIDs[index], index, entry.Song.ID)
+		}
+	}
+}
+
+func assertPlaylistNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, music.ErrPlaylistNotFound) {
+		t.Errorf("expected ErrPlaylistNotFound, got %v", err)
+	}
+}
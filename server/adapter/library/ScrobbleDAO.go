@@ -0,0 +1,164 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// ScrobbleDAO implements music.ScrobbleStore
+type ScrobbleDAO struct {
+	db *sql.DB
+}
+
+// NewScrobbleDAO creates a new ScrobbleDAO
+func NewScrobbleDAO(db *sql.DB) *ScrobbleDAO {
+	return &ScrobbleDAO{db}
+}
+
+// GetScrobblingToken returns the token of the user. It is empty when the user does not scrobble.
+func (d *ScrobbleDAO) GetScrobblingToken(ctx context.Context, userID uint) (string, error) {
+	var token string
+	row := d.db.QueryRowContext(ctx, `SELECT token FROM scrobbling_token WHERE scrobbling_token.user_id = ?`, userID)
+	err := row.Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not retrieve the scrobbling token of user #%d: %w", userID, err)
+	}
+	return token, nil
+}
+
+// SaveScrobblingToken replaces the token of the user. An empty token removes it and the user's queued plays.
+// Queued plays that failed with the previous token are submitted again as soon as possible.
+func (d *ScrobbleDAO) SaveScrobblingToken(ctx context.Context, userID uint, token string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	if token == "" {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM scrobbling_token WHERE scrobbling_token.user_id = ?`, userID); err != nil {
+			return fmt.Errorf("Could not delete the scrobbling token of user #%d: %w", userID, err)
+		}
+		query := `DELETE FROM scrobble WHERE scrobble.play_id IN (SELECT play.id FROM play WHERE play.user_id = ?)`
+		if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("Could not empty the scrobbling queue of user #%d: %w", userID, err)
+		}
+		return tx.Commit()
+	}
+	query := `INSERT INTO scrobbling_token(user_id, token) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token = excluded.token`
+	if _, err = tx.ExecContext(ctx, query, userID, token); err != nil {
+		return fmt.Errorf("Could not save the scrobbling token of user #%d: %w", userID, err)
+	}
+	query = `UPDATE scrobble SET attempts = 0, retry_at = 0
+		WHERE scrobble.play_id IN (SELECT play.id FROM play WHERE play.user_id = ?)`
+	if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("Could not reschedule the scrobbling queue of user #%d: %w", userID, err)
+	}
+	return tx.Commit()
+}
+
+// ListPendingScrobbles returns the queued plays that can be submitted at now, oldest first
+func (d *ScrobbleDAO) ListPendingScrobbles(ctx context.Context, now time.Time, limit uint) ([]music.PendingScrobble, error) {
+	query := `SELECT ` + songColumns + `, scrobble.id, scrobble.attempts, play.user_id, play.played_at, scrobbling_token.token
+		FROM scrobble
+		JOIN play ON play.id = scrobble.play_id
+		JOIN song ON song.id = play.song_id
+		JOIN scrobbling_token ON scrobbling_token.user_id = play.user_id
+		WHERE scrobble.retry_at <= ?
+		ORDER BY play.played_at, scrobble.id
+		LIMIT ?`
+	rows, err := d.db.QueryContext(ctx, query, now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the pending scrobbles: %w", err)
+	}
+	defer rows.Close()
+	var pending []music.PendingScrobble
+	for rows.Next() {
+		var (
+			scrobble music.PendingScrobble
+			playedAt int64
+		)
+		song, err := scanSong(&suffixedRowScanner{
+			rows,
+			[]interface{}{&scrobble.ID, &scrobble.Attempts, &scrobble.UserID, &playedAt, &scrobble.Token},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read a pending scrobble: %w", err)
+		}
+		scrobble.Listen = music.Listen{Song: song.Song, ListenedAt: time.Unix(playedAt, 0)}
+		pending = append(pending, scrobble)
+	}
+	return pending, rows.Err()
+}
+
+// DeleteScrobbles removes submitted plays from the queue
+func (d *ScrobbleDAO) DeleteScrobbles(ctx context.Context, scrobbleIDs []uint) error {
+	return d.updateScrobbles(ctx, scrobbleIDs, `DELETE FROM scrobble WHERE scrobble.id = ?`)
+}
+
+// PostponeScrobbles counts a failed submission of the plays and keeps them in the queue until retryAt
+func (d *ScrobbleDAO) PostponeScrobbles(ctx context.Context, scrobbleIDs []uint, retryAt time.Time) error {
+	query := `UPDATE scrobble SET attempts = attempts + 1, retry_at = ? WHERE scrobble.id = ?`
+	return d.updateScrobbles(ctx, scrobbleIDs, query, retryAt.Unix())
+}
+
+// updateScrobbles runs the query once per scrobble in a single transaction. The identifier of the scrobble
+// is the last parameter of the query.
+func (d *ScrobbleDAO) updateScrobbles(ctx context.Context, scrobbleIDs []uint, query string, args ...interface{}) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	statement, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("Could not prepare the scrobble query: %w", err)
+	}
+	defer statement.Close()
+	for _, scrobbleID := range scrobbleIDs {
+		if _, err = statement.ExecContext(ctx, append(args, scrobbleID)...); err != nil {
+			return fmt.Errorf("Could not update the scrobble #%d: %w", scrobbleID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSongOfPath returns the song at songPath in the music library
+func (d *ScrobbleDAO) GetSongOfPath(ctx context.Context, songPath string) (*music.Song, error) {
+	row := d.db.QueryRowContext(ctx, `SELECT `+songColumns+` FROM song WHERE song.path = ?`, songPath)
+	song, err := scanSong(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, music.ErrSongNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the song %s: %w", songPath, err)
+	}
+	return &song.Song, nil
+}
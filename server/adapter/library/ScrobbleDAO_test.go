@@ -0,0 +1,119 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package library
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestScrobbleDAO(t *testing.T) {
+	ctx := context.Background()
+	const userID, otherUserID = 1, 2
+	monday := time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC)
+
+	newDAOs := func(t *testing.T) (*ScrobbleDAO, *PlayDAO, []uint) {
+		playlistDAO, songIDs := newPlaylistDAOWithSongs(t)
+		return NewScrobbleDAO(playlistDAO.db), NewPlayDAO(playlistDAO.db), songIDs
+	}
+
+	t.Run("it saves, replaces and removes the token of a user", func(t *testing.T) {
+		dao, _, _ := newDAOs(t)
+
+		token, err := dao.GetScrobblingToken(ctx, userID)
+		tests.AssertNoError(t, err)
+		if token != "" {
+			t.Errorf("expected no token, got %q", token)
+		}
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, "first"))
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, "second"))
+		if token, _ = dao.GetScrobblingToken(ctx, userID); token != "second" {
+			t.Errorf("expected the token to be replaced, got %q", token)
+		}
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, ""))
+		if token, _ = dao.GetScrobblingToken(ctx, userID); token != "" {
+			t.Errorf("expected the token to be removed, got %q", token)
+		}
+	})
+
+	t.Run("it queues the plays of users who have a token, oldest first", func(t *testing.T) {
+		dao, playDAO, songIDs := newDAOs(t)
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, "token"))
+		tests.AssertNoError(t, playDAO.RecordPlayOfPath(ctx, userID, "ghost.mp3", monday.Add(time.Hour)))
+		_, err := playDAO.RecordPlay(ctx, userID, songIDs[0], monday)
+		tests.AssertNoError(t, err)
+		_, err = playDAO.RecordPlay(ctx, otherUserID, songIDs[0], monday)
+		tests.AssertNoError(t, err)
+
+		pending, err := dao.ListPendingScrobbles(ctx, monday, 10)
+		tests.AssertNoError(t, err)
+		if len(pending) != 2 || pending[0].Listen.Song.Title != "Nemo" || pending[1].Listen.Song.ID != songIDs[1] {
+			t.Fatalf("expected the two plays of the user, oldest first, got %+v", pending)
+		}
+		if pending[0].UserID != userID || pending[0].Token != "token" || !pending[0].Listen.ListenedAt.Equal(monday) {
+			t.Errorf("unexpected pending scrobble %+v", pending[0])
+		}
+	})
+
+	t.Run("it postpones and deletes scrobbles", func(t *testing.T) {
+		dao, playDAO, songIDs := newDAOs(t)
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, "token"))
+		for _, songID := range songIDs {
+			_, err := playDAO.RecordPlay(ctx, userID, songID, monday)
+			tests.AssertNoError(t, err)
+		}
+		pending, _ := dao.ListPendingScrobbles(ctx, monday, 10)
+
+		tests.AssertNoError(t, dao.PostponeScrobbles(ctx, []uint{pending[0].ID}, monday.Add(time.Hour)))
+		tests.AssertNoError(t, dao.DeleteScrobbles(ctx, []uint{pending[1].ID}))
+
+		if pending, _ = dao.ListPendingScrobbles(ctx, monday, 10); len(pending) != 0 {
+			t.Errorf("expected the postponed scrobble to wait, got %+v", pending)
+		}
+		pending, _ = dao.ListPendingScrobbles(ctx, monday.Add(time.Hour), 10)
+		if len(pending) != 1 || pending[0].Attempts != 1 {
+			t.Fatalf("expected the postponed scrobble after its retry time, got %+v", pending)
+		}
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, "new token"))
+		if pending, _ = dao.ListPendingScrobbles(ctx, monday, 10); len(pending) != 1 || pending[0].Attempts != 0 {
+			t.Errorf("expected a new token to reschedule the queue, got %+v", pending)
+		}
+		tests.AssertNoError(t, dao.SaveScrobblingToken(ctx, userID, ""))
+		if pending, _ = dao.ListPendingScrobbles(ctx, monday, 10); len(pending) != 0 {
+			t.Errorf("expected removing the token to empty the queue, got %+v", pending)
+		}
+	})
+
+	t.Run("it finds songs by path", func(t *testing.T) {
+		dao, _, _ := newDAOs(t)
+
+		song, err := dao.GetSongOfPath(ctx, "nemo.mp3")
+		tests.AssertNoError(t, err)
+		if song.Title != "Nemo" {
+			t.Errorf("unexpected song %+v", song)
+		}
+		if _, err = dao.GetSongOfPath(ctx, "missing.mp3"); !errors.Is(err, music.ErrSongNotFound) {
+			t.Errorf("expected ErrSongNotFound, got %v", err)
+		}
+	})
+}
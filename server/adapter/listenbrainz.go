@@ -0,0 +1,157 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	// listenBrainzTimeout bounds every request to the ListenBrainz server
+	listenBrainzTimeout = 30 * time.Second
+	// maximumListenBrainzErrorOutput bounds how much of an error response is kept to explain failures
+	maximumListenBrainzErrorOutput = 1024
+)
+
+// NewListenBrainzScrobbler creates a new music.Scrobbler submitting listens with the ListenBrainz API found at baseURL.
+// For example "https://api.listenbrainz.org". Any server implementing the same protocol can be used.
+func NewListenBrainzScrobbler(baseURL string) music.Scrobbler {
+	return &listenBrainzScrobbler{
+		submitURL: strings.TrimSuffix(baseURL, "/") + "/1/submit-listens",
+		client:    &http.Client{Timeout: listenBrainzTimeout},
+	}
+}
+
+// listenBrainzScrobbler implements music.Scrobbler
+type listenBrainzScrobbler struct {
+	submitURL string
+	client    *http.Client
+}
+
+// listenBrainzSubmission is the body of the requests to submit-listens
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"` // "single", "import" or "playing_now"
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                 `json:"listened_at,omitempty"` // Unix time, omitted for "playing_now"
+	TrackMetadata listenBrainzTrackInfo `json:"track_metadata"`
+}
+
+type listenBrainzTrackInfo struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info"`
+}
+
+func (l *listenBrainzScrobbler) NowPlaying(ctx context.Context, token string, song music.Song) error {
+	if !isSubmittable(song) {
+		return nil
+	}
+	return l.submit(ctx, token, listenBrainzSubmission{
+		ListenType: "playing_now",
+		Payload:    []listenBrainzListen{{TrackMetadata: toListenBrainzTrackInfo(song)}},
+	})
+}
+
+// Submit sends the listens at once. Listens of songs without artist tag are dropped, ListenBrainz refuses them.
+func (l *listenBrainzScrobbler) Submit(ctx context.Context, token string, listens []music.Listen) error {
+	payload := make([]listenBrainzListen, 0, len(listens))
+	for _, listen := range listens {
+		if !isSubmittable(listen.Song) {
+			continue
+		}
+		payload = append(payload, listenBrainzListen{
+			ListenedAt:    listen.ListenedAt.Unix(),
+			TrackMetadata: toListenBrainzTrackInfo(listen.Song),
+		})
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	listenType := "import"
+	if len(payload) == 1 {
+		listenType = "single"
+	}
+	return l.submit(ctx, token, listenBrainzSubmission{ListenType: listenType, Payload: payload})
+}
+
+func (l *listenBrainzScrobbler) submit(ctx context.Context, token string, submission listenBrainzSubmission) error {
+	body, err := json.Marshal(submission)
+	if err != nil {
+		return fmt.Errorf("could not encode the %s listens to JSON: %w", submission.ListenType, err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, l.submitURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create the request to %s: %w", l.submitURL, err)
+	}
+	request.Header.Set("Authorization", "Token "+token)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := l.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %v", music.ErrScrobblingUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, maximumListenBrainzErrorOutput))
+	explanation := fmt.Sprintf("%s answered %s: %s", l.submitURL, response.Status, strings.TrimSpace(string(message)))
+	switch {
+	case response.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s", music.ErrScrobblingTokenRejected, explanation)
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s", music.ErrScrobblingUnavailable, explanation)
+	default:
+		return fmt.Errorf("could not submit the listens, %s", explanation)
+	}
+}
+
+func isSubmittable(song music.Song) bool {
+	return song.Artist != "" && song.Title != ""
+}
+
+func toListenBrainzTrackInfo(song music.Song) listenBrainzTrackInfo {
+	info := map[string]interface{}{"submission_client": "mike-sierra-sierra"}
+	if song.Duration != 0 {
+		info["duration_ms"] = song.Duration * 1000
+	}
+	if song.TrackNumber != 0 {
+		info["tracknumber"] = song.TrackNumber
+	}
+	if song.DiskNumber != 0 {
+		info["discnumber"] = song.DiskNumber
+	}
+	return listenBrainzTrackInfo{
+		ArtistName:     song.Artist,
+		TrackName:      song.Title,
+		ReleaseName:    song.Album,
+		AdditionalInfo: info,
+	}
+}
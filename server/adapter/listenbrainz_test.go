@@ -0,0 +1,137 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestListenBrainzScrobbler(t *testing.T) {
+	ctx := context.Background()
+	ghost := music.Song{Title: "Ghost Love Score", Artist: "Nightwish", Album: "Once", TrackNumber: 10, Duration: 600}
+	monday := time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC)
+
+	type submission struct {
+		authorization string
+		body          map[string]interface{}
+	}
+	newServer := func(t *testing.T, status int) (*httptest.Server, *[]submission) {
+		var submissions []submission
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodPost || request.URL.Path != "/1/submit-listens" {
+				t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+			}
+			var body map[string]interface{}
+			if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+				t.Errorf("could not decode the submission: %v", err)
+			}
+			submissions = append(submissions, submission{request.Header.Get("Authorization"), body})
+			writer.WriteHeader(status)
+			writer.Write([]byte(`{"status": "ok"}`)) //nolint:errcheck // The test fails if the body is missing
+		}))
+		t.Cleanup(server.Close)
+		return server, &submissions
+	}
+
+	t.Run("it submits a single listen with the token of the user", func(t *testing.T) {
+		server, submissions := newServer(t, http.StatusOK)
+		scrobbler := adapter.NewListenBrainzScrobbler(server.URL + "/")
+
+		err := scrobbler.Submit(ctx, "secret", []music.Listen{{Song: ghost, ListenedAt: monday}})
+		tests.AssertNoError(t, err)
+
+		if len(*submissions) != 1 {
+			t.Fatalf("expected one submission, got %+v", *submissions)
+		}
+		got := (*submissions)[0]
+		payload := got.body["payload"].([]interface{})[0].(map[string]interface{})
+		metadata := payload["track_metadata"].(map[string]interface{})
+		if got.authorization != "Token secret" || got.body["listen_type"] != "single" ||
+			payload["listened_at"] != float64(monday.Unix()) || metadata["artist_name"] != "Nightwish" ||
+			metadata["track_name"] != "Ghost Love Score" || metadata["release_name"] != "Once" {
+			t.Errorf("unexpected submission %+v", got)
+		}
+	})
+
+	t.Run("it imports several listens and drops the songs without artist", func(t *testing.T) {
+		server, submissions := newServer(t, http.StatusOK)
+		scrobbler := adapter.NewListenBrainzScrobbler(server.URL)
+		listens := []music.Listen{
+			{Song: ghost, ListenedAt: monday},
+			{Song: music.Song{Title: "untagged.mp3"}, ListenedAt: monday},
+			{Song: ghost, ListenedAt: monday.Add(10 * time.Minute)},
+		}
+
+		tests.AssertNoError(t, scrobbler.Submit(ctx, "secret", listens))
+
+		got := (*submissions)[0].body
+		if got["listen_type"] != "import" || len(got["payload"].([]interface{})) != 2 {
+			t.Errorf("unexpected submission %+v", got)
+		}
+	})
+
+	t.Run("it notifies the song being played", func(t *testing.T) {
+		server, submissions := newServer(t, http.StatusOK)
+		scrobbler := adapter.NewListenBrainzScrobbler(server.URL)
+
+		tests.AssertNoError(t, scrobbler.NowPlaying(ctx, "secret", ghost))
+
+		got := (*submissions)[0].body
+		payload := got["payload"].([]interface{})[0].(map[string]interface{})
+		if _, ok := payload["listened_at"]; got["listen_type"] != "playing_now" || ok {
+			t.Errorf("unexpected submission %+v", got)
+		}
+	})
+
+	for status, want := range map[int]error{
+		http.StatusUnauthorized:       music.ErrScrobblingTokenRejected,
+		http.StatusTooManyRequests:    music.ErrScrobblingUnavailable,
+		http.StatusServiceUnavailable: music.ErrScrobblingUnavailable,
+	} {
+		t.Run("when the server answers "+http.StatusText(status)+", it returns "+want.Error(), func(t *testing.T) {
+			server, _ := newServer(t, status)
+			scrobbler := adapter.NewListenBrainzScrobbler(server.URL)
+
+			err := scrobbler.Submit(ctx, "secret", []music.Listen{{Song: ghost, ListenedAt: monday}})
+			if !errors.Is(err, want) {
+				t.Errorf("expected %v, got %v", want, err)
+			}
+		})
+	}
+
+	t.Run("when the server cannot be reached, it returns ErrScrobblingUnavailable", func(t *testing.T) {
+		server, _ := newServer(t, http.StatusOK)
+		server.Close()
+		scrobbler := adapter.NewListenBrainzScrobbler(server.URL)
+
+		err := scrobbler.Submit(ctx, "secret", []music.Listen{{Song: ghost, ListenedAt: monday}})
+		if !errors.Is(err, music.ErrScrobblingUnavailable) {
+			t.Errorf("expected ErrScrobblingUnavailable, got %v", err)
+		}
+	})
+}
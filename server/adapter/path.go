@@ -23,7 +23,10 @@ on the domain.
 */
 package adapter
 
-import "path"
+import (
+	"path"
+	"strings"
+)
 
 // PathJoiner joins the given relative path to its base path
 type PathJoiner interface {
@@ -49,3 +52,33 @@ func (b *basePathJoiner) Join(relativePath string) string {
 	}
 	return path.Join(b.basePath, relativePath)
 }
+
+// NewRootsPathJoiner creates a new PathJoiner for the paths of the music library, whose first
+// folder is the name of a library root. rootPaths maps the name of each root to its base path.
+func NewRootsPathJoiner(rootPaths map[string]string) PathJoiner {
+	joiners := make(map[string]PathJoiner, len(rootPaths))
+	for name, basePath := range rootPaths {
+		joiners[name] = NewBasePathJoiner(basePath)
+	}
+	return &rootsPathJoiner{joiners}
+}
+
+// rootsPathJoiner implements PathJoiner. It joins "lossless/album/song.flac" to the
+// base path of the root named "lossless". Paths outside of a known root are joined to nothing,
+// Join returns an empty string for them.
+type rootsPathJoiner struct {
+	joiners map[string]PathJoiner
+}
+
+// Join joins the given relative path to the base path of its root
+func (r *rootsPathJoiner) Join(relativePath string) string {
+	parts := strings.SplitN(path.Clean(strings.TrimPrefix(relativePath, "/")), "/", 2)
+	joiner, ok := r.joiners[parts[0]]
+	if !ok {
+		return ""
+	}
+	if len(parts) == 1 {
+		return joiner.Join(".")
+	}
+	return joiner.Join(parts[1])
+}
@@ -44,6 +44,30 @@ func TestBasePathJoiner(t *testing.T) {
 	})
 }
 
+func TestRootsPathJoiner(t *testing.T) {
+	joiner := adapter.NewRootsPathJoiner(map[string]string{"lossless": "/mnt/lossless", "podcasts": "/srv/podcasts"})
+
+	t.Run("it joins paths to the base path of their root", func(t *testing.T) {
+		assertPathEquals(t, joiner.Join("lossless/album/song.flac"), "/mnt/lossless/album/song.flac")
+		assertPathEquals(t, joiner.Join("podcasts/episode.mp3"), "/srv/podcasts/episode.mp3")
+	})
+
+	t.Run("it joins the name of a root to its base path", func(t *testing.T) {
+		assertPathEquals(t, joiner.Join("lossless"), "/mnt/lossless")
+	})
+
+	t.Run("it does not allow ascending from a root to another", func(t *testing.T) {
+		assertPathEquals(t, joiner.Join("lossless/../podcasts/episode.mp3"), "/srv/podcasts/episode.mp3")
+		assertPathEquals(t, joiner.Join("lossless/album/../../../etc/passwd"), "")
+	})
+
+	t.Run("it returns an empty path outside of the roots", func(t *testing.T) {
+		assertPathEquals(t, joiner.Join("unknown/song.mp3"), "")
+		assertPathEquals(t, joiner.Join("."), "")
+		assertPathEquals(t, joiner.Join("../song.mp3"), "")
+	})
+}
+
 func assertPathEquals(t *testing.T, got, want string) {
 	t.Helper()
 	if got != want {
@@ -0,0 +1,307 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	defaultCatalogLimit = 100
+	maximumCatalogLimit = 500
+)
+
+// LibraryArtist represents the songs sharing the same artist tag. It is output by the REST API.
+type LibraryArtist struct {
+	ID         uint   `json:"id"`         // ID is the artist's identifier in the library index. E.g. "7"
+	Name       string `json:"name"`       // Name of the artist. E.g. "Yoko Kanno"
+	AlbumCount uint   `json:"albumCount"` // Number of albums of the artist. E.g. "3"
+	SongCount  uint   `json:"songCount"`  // Number of songs of the artist, including the songs without album. E.g. "42"
+	CoverURI   string `json:"coverUri"`   // URI to the cover art of one of the artist's songs. Empty when none has cover art.
+}
+
+func fromLibraryArtist(source music.LibraryArtist) LibraryArtist {
+	return LibraryArtist{source.ID, source.Name, source.AlbumCount, source.SongCount, coverURI(source.CoverID)}
+}
+
+// ArtistAlbums represents an artist and its albums, ordered by name. It is output by the REST API.
+type ArtistAlbums struct {
+	LibraryArtist
+	Albums []LibraryAlbum `json:"albums"`
+}
+
+// LibraryAlbum represents the songs sharing the same album and artist tags. It is output by the REST API.
+type LibraryAlbum struct {
+	ID        uint   `json:"id"`        // ID is the album's identifier in the library index. E.g. "12"
+	Name      string `json:"name"`      // Name of the album. E.g. "Cowboy Bebop Original Soundtrack"
+	ArtistID  uint   `json:"artistId"`  // ArtistID is the identifier of the album's artist. Zero when the songs have no artist tag.
+	Artist    string `json:"artist"`    // Name of the album's artist. E.g. "Yoko Kanno"
+	SongCount uint   `json:"songCount"` // Number of songs of the album. E.g. "12"
+	Duration  uint   `json:"duration"`  // Total duration of the songs in seconds. E.g. "2914"
+	CoverURI  string `json:"coverUri"`  // URI to the cover art of one of the album's songs. Empty when none has cover art.
+}
+
+func fromLibraryAlbum(source music.LibraryAlbum) LibraryAlbum {
+	return LibraryAlbum{
+		ID:        source.ID,
+		Name:      source.Name,
+		ArtistID:  source.ArtistID,
+		Artist:    source.Artist,
+		SongCount: source.SongCount,
+		Duration:  source.Duration,
+		CoverURI:  coverURI(source.CoverID),
+	}
+}
+
+// AlbumSongs represents an album and its songs, ordered by disk and track number. It is output by the REST API.
+type AlbumSongs struct {
+	LibraryAlbum
+	Songs []Song `json:"songs"`
+}
+
+// AlbumPage represents one page of albums. Total counts all the albums, not only this page. It is output by the REST API.
+type AlbumPage struct {
+	Albums []LibraryAlbum `json:"albums"`
+	Total  uint           `json:"total"`
+}
+
+// SongPage represents one page of songs. Total counts all the songs, not only this page. It is output by the REST API.
+type SongPage struct {
+	Songs []Song `json:"songs"`
+	Total uint   `json:"total"`
+}
+
+// Genre represents the songs sharing the same genre tag. It is output by the REST API.
+type Genre struct {
+	ID         uint   `json:"id"`         // ID is the genre's identifier in the library index. E.g. "5"
+	Name       string `json:"name"`       // Name of the genre. E.g. "Jazz"
+	AlbumCount uint   `json:"albumCount"` // Number of albums with songs of the genre. E.g. "3"
+	SongCount  uint   `json:"songCount"`  // Number of songs of the genre. E.g. "42"
+}
+
+type getArtistsHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getArtistsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	artists, err := h.catalogStore.ListArtists(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the artists: %w", err)
+	}
+	response := make([]LibraryArtist, 0, len(artists))
+	for _, artist := range artists {
+		response = append(response, fromLibraryArtist(artist))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getArtistHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getArtistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	artistID, err := parseIDVar(request, "artistId")
+	if err != nil {
+		return err
+	}
+	artist, err := h.catalogStore.GetArtist(request.Context(), artistID)
+	if errors.Is(err, music.ErrArtistNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the artist #%d: %w", artistID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("error while retrieving the artist #%d: %w", artistID, err)
+	}
+	albums, _, err := h.catalogStore.ListAlbums(request.Context(), music.AlbumQuery{ArtistID: artistID, Sort: music.AlbumsByName})
+	if err != nil {
+		return fmt.Errorf("error while retrieving the albums of artist #%d: %w", artistID, err)
+	}
+	response := ArtistAlbums{fromLibraryArtist(*artist), make([]LibraryAlbum, 0, len(albums))}
+	for _, album := range albums {
+		response.Albums = append(response.Albums, fromLibraryAlbum(album))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getAlbumsHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getAlbumsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	values := request.URL.Query()
+	query := music.AlbumQuery{Sort: music.AlbumSort(values.Get("sort"))}
+	switch query.Sort {
+	case "":
+		query.Sort = music.AlbumsByName
+	case music.AlbumsByName, music.AlbumsByArtist:
+	default:
+		return server.NewBadRequestError(fmt.Errorf("unknown album sort %q", query.Sort), "Sort must be name or artist")
+	}
+	var err error
+	if query.ArtistID, err = parseFilterID(values, "artistId"); err != nil {
+		return err
+	}
+	if query.GenreID, err = parseFilterID(values, "genreId"); err != nil {
+		return err
+	}
+	if query.Descending, err = parseDescending(values); err != nil {
+		return err
+	}
+	if query.Limit, query.Offset, err = parseCatalogPage(values); err != nil {
+		return err
+	}
+
+	albums, total, err := h.catalogStore.ListAlbums(request.Context(), query)
+	if err != nil {
+		return fmt.Errorf("error while retrieving the albums: %w", err)
+	}
+	response := AlbumPage{make([]LibraryAlbum, 0, len(albums)), total}
+	for _, album := range albums {
+		response.Albums = append(response.Albums, fromLibraryAlbum(album))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getAlbumHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getAlbumHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	albumID, err := parseIDVar(request, "albumId")
+	if err != nil {
+		return err
+	}
+	album, err := h.catalogStore.GetAlbum(request.Context(), albumID)
+	if errors.Is(err, music.ErrAlbumNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the album #%d: %w", albumID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("error while retrieving the album #%d: %w", albumID, err)
+	}
+	songs, _, err := h.catalogStore.ListSongs(request.Context(), music.SongQuery{AlbumID: albumID, Sort: music.SongsByAlbum})
+	if err != nil {
+		return fmt.Errorf("error while retrieving the songs of album #%d: %w", albumID, err)
+	}
+	response := AlbumSongs{fromLibraryAlbum(*album), make([]Song, 0, len(songs))}
+	for _, song := range songs {
+		response.Songs = append(response.Songs, fromSong(song.Song))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getGenresHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getGenresHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	genres, err := h.catalogStore.ListGenres(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the genres: %w", err)
+	}
+	response := make([]Genre, 0, len(genres))
+	for _, genre := range genres {
+		response = append(response, Genre{genre.ID, genre.Name, genre.AlbumCount, genre.SongCount})
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getSongsHandler struct {
+	catalogStore music.CatalogStore
+}
+
+func (h *getSongsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	values := request.URL.Query()
+	query := music.SongQuery{Sort: music.SongSort(values.Get("sort"))}
+	switch query.Sort {
+	case "":
+		query.Sort = music.SongsByTitle
+	case music.SongsByTitle, music.SongsByArtist, music.SongsByAlbum, music.SongsByDuration:
+	default:
+		return server.NewBadRequestError(
+			fmt.Errorf("unknown song sort %q", query.Sort),
+			"Sort must be title, artist, album or duration",
+		)
+	}
+	var err error
+	if query.ArtistID, err = parseFilterID(values, "artistId"); err != nil {
+		return err
+	}
+	if query.AlbumID, err = parseFilterID(values, "albumId"); err != nil {
+		return err
+	}
+	if query.GenreID, err = parseFilterID(values, "genreId"); err != nil {
+		return err
+	}
+	if query.Descending, err = parseDescending(values); err != nil {
+		return err
+	}
+	if query.Limit, query.Offset, err = parseCatalogPage(values); err != nil {
+		return err
+	}
+
+	songs, total, err := h.catalogStore.ListSongs(request.Context(), query)
+	if err != nil {
+		return fmt.Errorf("error while retrieving the songs: %w", err)
+	}
+	response := SongPage{make([]Song, 0, len(songs)), total}
+	for _, song := range songs {
+		response.Songs = append(response.Songs, fromSong(song.Song))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+// parseFilterID parses an optional identifier query parameter. It returns zero when the parameter is missing.
+func parseFilterID(values url.Values, name string) (uint, error) {
+	id, err := parseUintParameter(values.Get(name), 0)
+	if err != nil {
+		return 0, server.NewBadRequestError(err, fmt.Sprintf("%s must be a positive integer", name))
+	}
+	return id, nil
+}
+
+// parseDescending parses the order query parameter, which is "asc" (the default) or "desc"
+func parseDescending(values url.Values) (bool, error) {
+	switch order := values.Get("order"); order {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	default:
+		return false, server.NewBadRequestError(fmt.Errorf("unknown order %q", order), "Order must be asc or desc")
+	}
+}
+
+func parseCatalogPage(values url.Values) (uint, uint, error) {
+	limit, err := parseUintParameter(values.Get("limit"), defaultCatalogLimit)
+	if err == nil && (limit == 0 || limit > maximumCatalogLimit) {
+		err = fmt.Errorf("limit %d is out of bounds", limit)
+	}
+	if err != nil {
+		return 0, 0, server.NewBadRequestError(err, fmt.Sprintf("Limit must be an integer between 1 and %d", maximumCatalogLimit))
+	}
+	offset, err := parseUintParameter(values.Get("offset"), 0)
+	if err != nil {
+		return 0, 0, server.NewBadRequestError(err, "Offset must be a positive integer")
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,281 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestArtists(t *testing.T) {
+	t.Run("it will return the JSON representation of the artists", func(t *testing.T) {
+		handler := &getArtistsHandler{&stubCatalogStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/artists"))
+		tests.AssertNoError(t, err)
+
+		var got []LibraryArtist
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into LibraryArtists, '%v'", response.Body, err)
+		}
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if len(got) != 1 || got[0].Name != "Nightwish" || got[0].CoverURI != "/api/covers/5" {
+			t.Errorf("unexpected artists %+v", got)
+		}
+	})
+
+	t.Run("when the artists cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getArtistsHandler{&stubCatalogStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/artists"))
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given an artist ID, it will return the artist and its albums", func(t *testing.T) {
+		store := &stubCatalogStore{}
+		handler := &getArtistHandler{store}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodGet, "", map[string]string{"artistId": "7"}))
+		tests.AssertNoError(t, err)
+
+		var got ArtistAlbums
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into ArtistAlbums, '%v'", response.Body, err)
+		}
+		if got.Name != "Nightwish" || len(got.Albums) != 1 || got.Albums[0].Name != "Once" {
+			t.Errorf("unexpected artist %+v", got)
+		}
+		if store.albumQuery.ArtistID != 7 || store.albumQuery.Limit != 0 {
+			t.Errorf("expected all the albums of the artist to be listed, got the query %+v", store.albumQuery)
+		}
+	})
+
+	t.Run("given an unknown artist ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &getArtistHandler{&stubCatalogStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodGet, "", map[string]string{"artistId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+func TestAlbums(t *testing.T) {
+	t.Run("it will return a page of albums selected by the query parameters", func(t *testing.T) {
+		store := &stubCatalogStore{}
+		handler := &getAlbumsHandler{store}
+		response := httptest.NewRecorder()
+
+		request := tests.NewGetRequest(t, "/api/albums?genreId=5&sort=artist&order=desc&limit=10&offset=20")
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got AlbumPage
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into an AlbumPage, '%v'", response.Body, err)
+		}
+		if got.Total != 21 || len(got.Albums) != 1 || got.Albums[0].ArtistID != 7 {
+			t.Errorf("unexpected page of albums %+v", got)
+		}
+		want := music.AlbumQuery{GenreID: 5, Sort: music.AlbumsByArtist, Descending: true, Limit: 10, Offset: 20}
+		if store.albumQuery != want {
+			t.Errorf("expected the query %+v, got %+v", want, store.albumQuery)
+		}
+	})
+
+	t.Run("given no query parameters, it will return the first albums ordered by name", func(t *testing.T) {
+		store := &stubCatalogStore{}
+		handler := &getAlbumsHandler{store}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/albums"))
+		tests.AssertNoError(t, err)
+
+		want := music.AlbumQuery{Sort: music.AlbumsByName, Limit: defaultCatalogLimit}
+		if store.albumQuery != want {
+			t.Errorf("expected the query %+v, got %+v", want, store.albumQuery)
+		}
+	})
+
+	for _, query := range []string{"sort=year", "order=random", "limit=0", "limit=501", "offset=-1", "genreId=rock"} {
+		t.Run("given "+query+", it will return a Bad Request error", func(t *testing.T) {
+			handler := &getAlbumsHandler{&stubCatalogStore{}}
+
+			err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/albums?"+query))
+			assertHTTPErrorCode(t, err, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("given an album ID, it will return the album and its songs in the order of their tracks", func(t *testing.T) {
+		store := &stubCatalogStore{}
+		handler := &getAlbumHandler{store}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodGet, "", map[string]string{"albumId": "12"}))
+		tests.AssertNoError(t, err)
+
+		var got AlbumSongs
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into AlbumSongs, '%v'", response.Body, err)
+		}
+		if got.Name != "Once" || len(got.Songs) != 1 || got.Songs[0].Genre != "Symphonic Metal" {
+			t.Errorf("unexpected album %+v", got)
+		}
+		if store.songQuery.AlbumID != 12 || store.songQuery.Sort != music.SongsByAlbum || store.songQuery.Limit != 0 {
+			t.Errorf("expected all the songs of the album in the order of their tracks, got the query %+v", store.songQuery)
+		}
+	})
+
+	t.Run("given an unknown album ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &getAlbumHandler{&stubCatalogStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodGet, "", map[string]string{"albumId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+func TestGenres(t *testing.T) {
+	t.Run("it will return the JSON representation of the genres", func(t *testing.T) {
+		handler := &getGenresHandler{&stubCatalogStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/genres"))
+		tests.AssertNoError(t, err)
+
+		var got []Genre
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into Genres, '%v'", response.Body, err)
+		}
+		if len(got) != 1 || got[0].Name != "Symphonic Metal" || got[0].SongCount != 2 {
+			t.Errorf("unexpected genres %+v", got)
+		}
+	})
+
+	t.Run("when the genres cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getGenresHandler{&stubCatalogStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/genres"))
+		tests.AssertError(t, err)
+	})
+}
+
+func TestSongs(t *testing.T) {
+	t.Run("it will return a page of songs selected by the query parameters", func(t *testing.T) {
+		store := &stubCatalogStore{}
+		handler := &getSongsHandler{store}
+		response := httptest.NewRecorder()
+
+		request := tests.NewGetRequest(t, "/api/songs?artistId=7&sort=duration&order=desc&limit=1")
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got SongPage
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into a SongPage, '%v'", response.Body, err)
+		}
+		if got.Total != 2 || len(got.Songs) != 1 || got.Songs[0].Title != "Nemo" {
+			t.Errorf("unexpected page of songs %+v", got)
+		}
+		want := music.SongQuery{ArtistID: 7, Sort: music.SongsByDuration, Descending: true, Limit: 1}
+		if store.songQuery != want {
+			t.Errorf("expected the query %+v, got %+v", want, store.songQuery)
+		}
+	})
+
+	t.Run("given an unknown sort, it will return a Bad Request error", func(t *testing.T) {
+		handler := &getSongsHandler{&stubCatalogStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/songs?sort=size"))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("when the songs cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getSongsHandler{&stubCatalogStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/songs"))
+		tests.AssertError(t, err)
+	})
+}
+
+// stubCatalogStore knows the artist #7, its album #12 and the genre #5. It records the last queries.
+type stubCatalogStore struct {
+	shouldError bool
+	albumQuery  music.AlbumQuery
+	songQuery   music.SongQuery
+}
+
+var (
+	stubArtist = music.LibraryArtist{ID: 7, Name: "Nightwish", AlbumCount: 1, SongCount: 2, CoverID: 5}
+	stubAlbum  = music.LibraryAlbum{ID: 12, Name: "Once", ArtistID: 7, Artist: "Nightwish", SongCount: 2, CoverID: 5}
+	stubSong   = music.IndexedSong{
+		Song: music.Song{ID: 1, Title: "Nemo", Artist: "Nightwish", Album: "Once", Genre: "Symphonic Metal"},
+		Path: "music/Nightwish/Once/nemo.mp3",
+	}
+)
+
+func (s *stubCatalogStore) ListArtists(_ context.Context) ([]music.LibraryArtist, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return []music.LibraryArtist{stubArtist}, nil
+}
+
+func (s *stubCatalogStore) GetArtist(_ context.Context, artistID uint) (*music.LibraryArtist, error) {
+	if artistID != stubArtist.ID {
+		return nil, music.ErrArtistNotFound
+	}
+	artist := stubArtist
+	return &artist, nil
+}
+
+func (s *stubCatalogStore) ListAlbums(_ context.Context, query music.AlbumQuery) ([]music.LibraryAlbum, uint, error) {
+	s.albumQuery = query
+	if s.shouldError {
+		return nil, 0, errors.New("This error should be expected in tests")
+	}
+	return []music.LibraryAlbum{stubAlbum}, query.Offset + 1, nil
+}
+
+func (s *stubCatalogStore) GetAlbum(_ context.Context, albumID uint) (*music.LibraryAlbum, error) {
+	if albumID != stubAlbum.ID {
+		return nil, music.ErrAlbumNotFound
+	}
+	album := stubAlbum
+	return &album, nil
+}
+
+func (s *stubCatalogStore) ListGenres(_ context.Context) ([]music.Genre, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return []music.Genre{{ID: 5, Name: "Symphonic Metal", AlbumCount: 1, SongCount: 2}}, nil
+}
+
+func (s *stubCatalogStore) ListSongs(_ context.Context, query music.SongQuery) ([]music.IndexedSong, uint, error) {
+	s.songQuery = query
+	if s.shouldError {
+		return nil, 0, errors.New("This error should be expected in tests")
+	}
+	return []music.IndexedSong{stubSong}, 2, nil
+}
@@ -0,0 +1,74 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// maximumCoverSize bounds the "size" query parameter. Larger sizes are served at the largest cached size anyway.
+const maximumCoverSize = 4096
+
+// coverURI returns the URI of the cover identified by coverID, or an empty string when coverID is zero (no cover)
+func coverURI(coverID uint) string {
+	if coverID == 0 {
+		return ""
+	}
+	return "/api/covers/" + strconv.FormatUint(uint64(coverID), 10)
+}
+
+type coverHandler struct {
+	loader music.CoverLoader
+}
+
+func (h *coverHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	coverID, err := parseIDVar(request, "coverId")
+	if err != nil {
+		return err
+	}
+	size, err := parseUintParameter(request.URL.Query().Get("size"), 0)
+	if err == nil && size > maximumCoverSize {
+		err = fmt.Errorf("size %d is larger than %d", size, maximumCoverSize)
+	}
+	if err != nil {
+		return server.NewBadRequestError(err, fmt.Sprintf("Size must be an integer between 0 and %d", maximumCoverSize))
+	}
+	picture, err := h.loader.LoadCover(request.Context(), coverID, size)
+	if errors.Is(err, music.ErrCoverNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the cover #%d: %w", coverID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("error while loading the cover #%d: %w", coverID, err)
+	}
+
+	writer.Header().Set("Content-Type", picture.MIMEType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(picture.Data)))
+	// Cover images only change when the library is scanned again, browsers can keep them for a while
+	writer.Header().Set("Cache-Control", "private, max-age=86400")
+	_, err = writer.Write(picture.Data)
+	if err != nil {
+		return fmt.Errorf("could not write the cover #%d: %w", coverID, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetCover(t *testing.T) {
+	t.Run("given a cover ID, it will return the cover image", func(t *testing.T) {
+		loader := newValidCoverLoader()
+		handler := &coverHandler{loader}
+		request := newGetRequestWithCoverID(t, "5", "")
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, "image/png")
+		if response.Body.String() != "picture" {
+			t.Errorf("unexpected cover image %q", response.Body.String())
+		}
+		if response.Header().Get("Cache-Control") == "" {
+			t.Error("expected a Cache-Control header")
+		}
+		if loader.coverID != 5 || loader.size != 0 {
+			t.Errorf("expected to load the original cover #5, got cover #%d at size %d", loader.coverID, loader.size)
+		}
+	})
+
+	t.Run("given a size, it will load the cover at that size", func(t *testing.T) {
+		loader := newValidCoverLoader()
+		handler := &coverHandler{loader}
+		request := newGetRequestWithCoverID(t, "5", "300")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		tests.AssertNoError(t, err)
+
+		if loader.size != 300 {
+			t.Errorf("expected to load the cover at size 300, got %d", loader.size)
+		}
+	})
+
+	for _, size := range []string{"-12", "big", "100000"} {
+		t.Run("given an invalid size "+size+", it will return a Bad Request error", func(t *testing.T) {
+			handler := &coverHandler{newValidCoverLoader()}
+			request := newGetRequestWithCoverID(t, "5", size)
+
+			err := handler.ServeHTTP(httptest.NewRecorder(), request)
+			assertHTTPErrorCode(t, err, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("given an unknown cover ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &coverHandler{newValidCoverLoader()}
+		request := newGetRequestWithCoverID(t, "404", "")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+
+	t.Run("when the cover cannot be loaded, it will return an error", func(t *testing.T) {
+		handler := &coverHandler{&stubCoverLoader{shouldError: true}}
+		request := newGetRequestWithCoverID(t, "5", "")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		tests.AssertError(t, err)
+	})
+}
+
+func newGetRequestWithCoverID(t *testing.T, coverID string, size string) *http.Request {
+	t.Helper()
+	target := "/api/covers/" + coverID
+	if size != "" {
+		target += "?size=" + size
+	}
+	request := tests.NewGetRequest(t, target)
+	return mux.SetURLVars(request, map[string]string{"coverId": coverID})
+}
+
+func newValidCoverLoader() *stubCoverLoader {
+	return &stubCoverLoader{}
+}
+
+// stubCoverLoader knows a single cover #5
+type stubCoverLoader struct {
+	coverID     uint
+	size        uint
+	shouldError bool
+}
+
+func (s *stubCoverLoader) LoadCover(_ context.Context, coverID uint, size uint) (*music.Picture, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	s.coverID, s.size = coverID, size
+	if coverID != 5 {
+		return nil, music.ErrCoverNotFound
+	}
+	return &music.Picture{MIMEType: "image/png", Data: []byte("picture")}, nil
+}
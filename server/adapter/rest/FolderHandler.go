@@ -18,11 +18,13 @@
 package rest
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
 	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
 )
 
@@ -35,33 +37,49 @@ type FolderContents struct {
 // SubFolder represents a music folder that is a child of a Folder. We do not expose its items
 // yet, another HTTP request is needed to expose them.
 type SubFolder struct {
-	Name string `json:"name"` // Basename of the folder
-	Path string `json:"path"` // Path from the root music folder. For example "Yoko%20Kanno"
+	Name     string `json:"name"`     // Basename of the folder
+	Path     string `json:"path"`     // Path from the root music folder. For example "Yoko%20Kanno"
+	CoverURI string `json:"coverUri"` // URI to the folder's cover art. Empty when the folder has no cover art.
 }
 
 func fromSubFolder(source music.SubFolder) SubFolder {
 	return SubFolder{
-		Name: source.Name,
-		Path: source.Path,
+		Name:     source.Name,
+		Path:     source.Path,
+		CoverURI: coverURI(source.CoverID),
 	}
 }
 
 // Song represents a music file. It is distinguished by media type (audio/mp3, audio/flac, etc.)
 // Most of its fields mirror tags such as ID3 tags for MP3. It is output by the REST API.
 type Song struct {
-	Title string `json:"title"` // Title is the song's title. E.g. "Know Your Enemy"
-	// TrackNumber uint   `json:"trackNumber"` // TrackNumber is the track number of the song. E.g. "3"
-	// DiskNumber  uint   `json:"diskNumber"`  // DiskNumber is the disk number of the song. E.g. "1"
-	// Artist      string `json:"artist"`      // Artist is the name of the main artist. E.g. "Yoko Kanno"
-	// Duration    uint   `json:"duration"`    // Duration is the duration of the song in seconds. E.g. "165"
-	URI string `json:"uri"` // URI to access this song on this server. E.g. "/music/Yoko%20Kanno/1-03%20-Know%20Your%20Enemy.flac"
-	// Type        string `json:"type"`        // MIME type of the song E.g. "audio/flac"
+	ID          uint   `json:"id"`          // ID is the song's identifier in the library index. E.g. "42"
+	Title       string `json:"title"`       // Title is the song's title. E.g. "Know Your Enemy"
+	TrackNumber uint   `json:"trackNumber"` // TrackNumber is the track number of the song. E.g. "3"
+	DiskNumber  uint   `json:"diskNumber"`  // DiskNumber is the disk number of the song. E.g. "1"
+	Artist      string `json:"artist"`      // Artist is the name of the main artist. E.g. "Yoko Kanno"
+	Album       string `json:"album"`       // Album is the name of the album. E.g. "Cowboy Bebop Original Soundtrack"
+	Genre       string `json:"genre"`       // Genre is the genre of the song. E.g. "Jazz"
+	Duration    uint   `json:"duration"`    // Duration is the duration of the song in seconds. E.g. "165"
+	URI         string `json:"uri"`         // URI to access this song on this server. E.g. "/music/Yoko%20Kanno/1-03%20-Know%20Your%20Enemy.flac"
+	Type        string `json:"type"`        // MIME type of the song E.g. "audio/flac"
+	Format      string `json:"format"`      // Format is the short name of the song's format. E.g. "flac"
 }
 
 func fromSong(source music.Song) Song {
+	format, _ := music.AudioFormatOfType(source.Type)
 	return Song{
-		Title: source.Title,
-		URI:   source.URI,
+		ID:          source.ID,
+		Title:       source.Title,
+		TrackNumber: source.TrackNumber,
+		DiskNumber:  source.DiskNumber,
+		Artist:      source.Artist,
+		Album:       source.Album,
+		Genre:       source.Genre,
+		Duration:    source.Duration,
+		URI:         source.URI,
+		Type:        source.Type,
+		Format:      format.Name,
 	}
 }
 
@@ -77,16 +95,22 @@ func mapIntoRepresentations(contentFolders []music.SubFolder, contentSongs []mus
 	return FolderContents{folders, songs}
 }
 
+// maximumFolderLimit bounds the number of sub-folders and songs of a page of a folder
+const maximumFolderLimit = 1000
+
 // Folder represents a music folder. It can be any folder in the filesystem hierarchy
 // such as an album, an artist folder containing many albums, a genre folder containing
-// many artists, etc. It is output by the REST API.
+// many artists, etc. Totals count all the sub-folders and songs of the selected types, not only
+// this page. It is output by the REST API.
 type Folder struct {
-	Folders []SubFolder `json:"folders"`
-	Songs   []Song      `json:"songs"`
+	Folders      []SubFolder `json:"folders"`
+	Songs        []Song      `json:"songs"`
+	TotalFolders uint        `json:"totalFolders"`
+	TotalSongs   uint        `json:"totalSongs"`
 }
 
 type folderHandler struct {
-	explorer music.MusicLibraryExplorer
+	browser music.FolderBrowser
 }
 
 func (h *folderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
@@ -95,18 +119,56 @@ func (h *folderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 	if folderPath == "" {
 		folderPath = "." // List the contents of the root music library folder
 	}
-	folders, songs, err := h.explorer.ListContents(folderPath)
+	query, err := parseFolderQuery(request.URL.Query())
 	if err != nil {
-		return fmt.Errorf("error while retrieving the contents of the folder at path %v: %w", folderPath, err)
+		return err
+	}
+	page, err := h.browser.BrowseFolder(request.Context(), folderPath, query)
+	if errors.Is(err, music.ErrFolderNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the folder at path %v: %w", folderPath, err))
 	}
-	contents := mapIntoRepresentations(folders, songs)
-	response := Folder{Folders: contents.Folders, Songs: contents.Songs}
-	err = json.NewEncoder(writer).Encode(response)
 	if err != nil {
-		return fmt.Errorf("could not encode the folder %v to JSON: %w", response, err)
+		return fmt.Errorf("error while retrieving the contents of the folder at path %v: %w", folderPath, err)
 	}
+	contents := mapIntoRepresentations(page.Folders, page.Songs)
+	response := Folder{
+		Folders:      contents.Folders,
+		Songs:        contents.Songs,
+		TotalFolders: page.TotalFolders,
+		TotalSongs:   page.TotalSongs,
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
 
-	writer.Header().Set("Content-Type", jsonMediaType)
-	writer.WriteHeader(http.StatusOK)
-	return nil
+// parseFolderQuery parses the sort, type, order, limit and offset query parameters.
+// Without limit, all the contents of the folder are listed.
+func parseFolderQuery(values url.Values) (music.FolderQuery, error) {
+	query := music.FolderQuery{Sort: music.FolderSort(values.Get("sort")), Type: music.FolderEntryType(values.Get("type"))}
+	switch query.Sort {
+	case "":
+		query.Sort = music.FolderEntriesByName
+	case music.FolderEntriesByName, music.FolderEntriesByModificationTime, music.FolderEntriesByTrack:
+	default:
+		return query, server.NewBadRequestError(fmt.Errorf("unknown folder sort %q", query.Sort), "Sort must be name, mtime or track")
+	}
+	switch query.Type {
+	case music.FolderEntriesOfAllTypes, music.FolderEntriesOfFolders, music.FolderEntriesOfSongs:
+	default:
+		return query, server.NewBadRequestError(fmt.Errorf("unknown folder entry type %q", query.Type), "Type must be folders or songs")
+	}
+	var err error
+	if query.Descending, err = parseDescending(values); err != nil {
+		return query, err
+	}
+	query.Limit, err = parseUintParameter(values.Get("limit"), 0)
+	if err == nil && values.Get("limit") != "" && (query.Limit == 0 || query.Limit > maximumFolderLimit) {
+		err = fmt.Errorf("limit %d is out of bounds", query.Limit)
+	}
+	if err != nil {
+		return query, server.NewBadRequestError(err, fmt.Sprintf("Limit must be an integer between 1 and %d", maximumFolderLimit))
+	}
+	if query.Offset, err = parseUintParameter(values.Get("offset"), 0); err != nil {
+		return query, server.NewBadRequestError(err, "Offset must be a positive integer")
+	}
+	return query, nil
 }
@@ -18,6 +18,7 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -35,8 +36,7 @@ func TestGetFolder(t *testing.T) {
 
 	t.Run(`given no path, it will return the JSON representation of the contents of the root music library folder`, func(t *testing.T) {
 		request := tests.NewGetRequest(t, "/api/folders/")
-		explorer := newValidLibraryExplorer(t)
-		handler := &folderHandler{explorer}
+		handler := &folderHandler{newValidFolderBrowser(t)}
 
 		err := handler.ServeHTTP(response, request)
 		tests.AssertNoError(t, err)
@@ -52,8 +52,7 @@ func TestGetFolder(t *testing.T) {
 
 	t.Run(`given a path, it will return the JSON representation of the folder's contents at that path`, func(t *testing.T) {
 		request := newGetRequestWithPathVar(t, "Sub Folder")
-		explorer := newValidLibraryExplorer(t)
-		handler := &folderHandler{explorer}
+		handler := &folderHandler{newValidFolderBrowser(t)}
 
 		err := handler.ServeHTTP(response, request)
 		tests.AssertNoError(t, err)
@@ -65,12 +64,14 @@ func TestGetFolder(t *testing.T) {
 		}
 		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
 		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if got.Folders[0].CoverURI != "/api/covers/3" || got.Folders[1].CoverURI != "" {
+			t.Errorf("unexpected folder cover URIs %+v", got.Folders)
+		}
 	})
 
 	t.Run(`when folders or songs are nil slices, it will return an empty JSON array instead of null`, func(t *testing.T) {
 		request := newGetRequestWithPathVar(t, "Sub Folder")
-		explorer := newLibraryExplorerReturnsEmpty(t)
-		handler := &folderHandler{explorer}
+		handler := &folderHandler{newFolderBrowserReturnsEmpty(t)}
 
 		err := handler.ServeHTTP(response, request)
 		tests.AssertNoError(t, err)
@@ -88,10 +89,69 @@ func TestGetFolder(t *testing.T) {
 		}
 	})
 
+	t.Run("given query parameters, it will return the page of the folder's contents they select", func(t *testing.T) {
+		request := newGetRequestWithPathVar(t, "Sub Folder")
+		request.URL.RawQuery = "sort=track&type=songs&order=desc&limit=2&offset=10"
+		browser := newValidFolderBrowser(t)
+		handler := &folderHandler{browser}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got Folder
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("Unable to parse response from server %q into Folder, %v", response.Body, err)
+		}
+		if got.TotalFolders != 2 || got.TotalSongs != 12 {
+			t.Errorf("unexpected totals %+v", got)
+		}
+		want := music.FolderQuery{
+			Sort:       music.FolderEntriesByTrack,
+			Type:       music.FolderEntriesOfSongs,
+			Descending: true,
+			Limit:      2,
+			Offset:     10,
+		}
+		if browser.query != want {
+			t.Errorf("expected the query %+v, got %+v", want, browser.query)
+		}
+	})
+
+	t.Run("given no query parameters, it will list all the contents of the folder by name", func(t *testing.T) {
+		browser := newValidFolderBrowser(t)
+		handler := &folderHandler{browser}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newGetRequestWithPathVar(t, "Sub Folder"))
+		tests.AssertNoError(t, err)
+
+		if want := (music.FolderQuery{Sort: music.FolderEntriesByName}); browser.query != want {
+			t.Errorf("expected the query %+v, got %+v", want, browser.query)
+		}
+	})
+
+	for _, query := range []string{"sort=size", "type=playlists", "order=random", "limit=0", "limit=1001", "offset=first"} {
+		t.Run("given "+query+", it will return a Bad Request error", func(t *testing.T) {
+			request := newGetRequestWithPathVar(t, "Sub Folder")
+			request.URL.RawQuery = query
+			handler := &folderHandler{newValidFolderBrowser(t)}
+
+			err := handler.ServeHTTP(httptest.NewRecorder(), request)
+			assertHTTPErrorCode(t, err, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("given a folder that is not in the library, it will return a Not Found error", func(t *testing.T) {
+		request := newGetRequestWithPathVar(t, "unknown")
+		handler := &folderHandler{newValidFolderBrowser(t)}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+
 	t.Run("it will return an error if the given folder cannot be read", func(t *testing.T) {
 		request := newGetRequestWithPathVar(t, "unknown")
-		explorer := newLibraryExplorerWithError(t)
-		handler := &folderHandler{explorer}
+		handler := &folderHandler{newFolderBrowserWithError(t)}
 
 		err := handler.ServeHTTP(response, request)
 		tests.AssertError(t, err)
@@ -102,46 +162,49 @@ func newGetRequestWithPathVar(t *testing.T, pathName string) *http.Request {
 	request := tests.NewGetRequest(t, "/api/folders/"+url.PathEscape(pathName))
 	vars := make(map[string]string)
 	vars["path"] = pathName
-	mux.SetURLVars(request, vars)
-	return request
+	return mux.SetURLVars(request, vars)
 }
 
-func newValidLibraryExplorer(t *testing.T) music.MusicLibraryExplorer {
+func newValidFolderBrowser(t *testing.T) *folderBrowserStub {
 	t.Helper()
-	return &libraryExplorerStub{true, false}
+	return &folderBrowserStub{isValid: true}
 }
 
-func newLibraryExplorerReturnsEmpty(t *testing.T) music.MusicLibraryExplorer {
+func newFolderBrowserReturnsEmpty(t *testing.T) *folderBrowserStub {
 	t.Helper()
-	return &libraryExplorerStub{true, true}
+	return &folderBrowserStub{isValid: true, returnsNil: true}
 }
 
-func newLibraryExplorerWithError(t *testing.T) music.MusicLibraryExplorer {
+func newFolderBrowserWithError(t *testing.T) *folderBrowserStub {
 	t.Helper()
-	return &libraryExplorerStub{false, false}
+	return &folderBrowserStub{}
 }
 
-type libraryExplorerStub struct {
+// folderBrowserStub knows every folder but "unknown". It records the last query.
+type folderBrowserStub struct {
 	isValid    bool
 	returnsNil bool
+	query      music.FolderQuery
 }
 
-func (e *libraryExplorerStub) ListContents(pathName string) ([]music.SubFolder, []music.Song, error) {
+func (e *folderBrowserStub) BrowseFolder(_ context.Context, folderPath string, query music.FolderQuery) (*music.FolderPage, error) {
+	e.query = query
 	if !e.isValid {
-		return nil, nil, errors.New("This error should be expected in tests")
+		return nil, errors.New("This error should be expected in tests")
+	}
+	if folderPath == "unknown" {
+		return nil, music.ErrFolderNotFound
 	}
 	if e.returnsNil {
-		folders := []music.SubFolder{}
-		songs := []music.Song{}
-		return folders, songs, nil
+		return &music.FolderPage{}, nil
 	}
 	folders := []music.SubFolder{
-		{Name: "satisfied", Path: "Sub Folder/satisfied"},
+		{Name: "satisfied", Path: "Sub Folder/satisfied", CoverID: 3},
 		{Name: "indicate", Path: "Sub Folder/indicate"},
 	}
 	songs := []music.Song{
 		{Title: "Medicine Worry", URI: "/music/Sub Folder/Medicine Worry.mp3"},
 		{Title: "He Wall", URI: "/music/Sub Folder/He Wall.ogg"},
 	}
-	return folders, songs, nil
+	return &music.FolderPage{Folders: folders, Songs: songs, TotalFolders: 2, TotalSongs: 12}, nil
 }
@@ -0,0 +1,84 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// LibraryPlaylist represents a playlist file found in the music library, such as an M3U8 or XSPF file.
+// It is output by the REST API.
+type LibraryPlaylist struct {
+	PlaylistSummary
+	Path string `json:"path"` // Path from the root music folder. E.g. "Yoko%20Kanno/Best%20of.m3u8"
+}
+
+type getLibraryPlaylistsHandler struct {
+	libraryPlaylistStore music.LibraryPlaylistStore
+}
+
+func (h *getLibraryPlaylistsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	playlists, err := h.libraryPlaylistStore.ListLibraryPlaylists(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the playlist files: %w", err)
+	}
+	response := make([]LibraryPlaylist, 0)
+	for _, playlist := range playlists {
+		response = append(response, LibraryPlaylist{fromPlaylistSummary(playlist.PlaylistSummary), playlist.Path})
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getLibraryPlaylistHandler struct {
+	libraryPlaylistStore music.LibraryPlaylistStore
+}
+
+func (h *getLibraryPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	playlist, err := h.libraryPlaylistStore.GetLibraryPlaylist(request.Context(), playlistID)
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	return writeJSON(writer, http.StatusOK, fromPlaylist(playlist))
+}
+
+type exportLibraryPlaylistHandler struct {
+	libraryPlaylistStore music.LibraryPlaylistStore
+}
+
+func (h *exportLibraryPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	exporter, err := playlistExporterFor(request)
+	if err != nil {
+		return err
+	}
+	playlist, err := h.libraryPlaylistStore.GetLibraryPlaylist(request.Context(), playlistID)
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	return exporter.export(writer, playlist)
+}
@@ -0,0 +1,148 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestLibraryPlaylists(t *testing.T) {
+	t.Run("it will return the JSON representation of the playlist files of the library", func(t *testing.T) {
+		handler := &getLibraryPlaylistsHandler{&stubLibraryPlaylistStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/library-playlists"))
+		tests.AssertNoError(t, err)
+
+		var got []LibraryPlaylist
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into LibraryPlaylists, '%v'", response.Body, err)
+		}
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if len(got) != 1 || got[0].Path != "Nightwish/best.m3u8" || got[0].SongCount != 1 {
+			t.Errorf("unexpected playlist files %+v", got)
+		}
+	})
+
+	t.Run("when the playlist files cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getLibraryPlaylistsHandler{&stubLibraryPlaylistStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/library-playlists"))
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given a playlist ID, it will return the JSON representation of the playlist file", func(t *testing.T) {
+		handler := &getLibraryPlaylistHandler{&stubLibraryPlaylistStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "3"}))
+		tests.AssertNoError(t, err)
+
+		var got Playlist
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into a Playlist, '%v'", response.Body, err)
+		}
+		if got.Name != "Best of" || len(got.Entries) != 1 {
+			t.Errorf("unexpected playlist %+v", got)
+		}
+	})
+
+	t.Run("given an unknown playlist ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &getLibraryPlaylistHandler{&stubLibraryPlaylistStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+func TestExportPlaylist(t *testing.T) {
+	t.Run("given no format, it will export the playlist as an M3U8 file", func(t *testing.T) {
+		handler := &exportPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertContentTypeHeaderEquals(t, response, "audio/x-mpegurl; charset=utf-8")
+		if got := response.Header().Get("Content-Disposition"); got != `attachment; filename="Road trip.m3u8"` {
+			t.Errorf("unexpected Content-Disposition %s", got)
+		}
+	})
+
+	t.Run("given the xspf format, it will export the playlist file as an XSPF file", func(t *testing.T) {
+		handler := &exportLibraryPlaylistHandler{&stubLibraryPlaylistStore{}}
+		response := httptest.NewRecorder()
+		request := newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "3"})
+		request.URL.RawQuery = "format=xspf"
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		tests.AssertContentTypeHeaderEquals(t, response, "application/xspf+xml; charset=utf-8")
+	})
+
+	t.Run("given an unknown format, it will return a Bad Request error", func(t *testing.T) {
+		handler := &exportPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+		request := newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "12"})
+		request.URL.RawQuery = "format=pls"
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given an unknown playlist ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &exportPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+// stubLibraryPlaylistStore knows a single playlist file #3
+type stubLibraryPlaylistStore struct {
+	shouldError bool
+}
+
+func (s *stubLibraryPlaylistStore) ListLibraryPlaylists(_ context.Context) ([]music.LibraryPlaylist, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return []music.LibraryPlaylist{
+		{PlaylistSummary: music.PlaylistSummary{ID: 3, Name: "Best of", SongCount: 1}, Path: "Nightwish/best.m3u8"},
+	}, nil
+}
+
+func (s *stubLibraryPlaylistStore) GetLibraryPlaylist(_ context.Context, playlistID uint) (*music.Playlist, error) {
+	if playlistID != 3 {
+		return nil, music.ErrPlaylistNotFound
+	}
+	return &music.Playlist{
+		PlaylistSummary: music.PlaylistSummary{ID: 3, Name: "Best of", SongCount: 1},
+		Entries:         []music.PlaylistEntry{{ID: 1, Song: music.Song{ID: 1, Title: "Nemo", URI: "/music/Nightwish/nemo.mp3"}}},
+	}, nil
+}
@@ -0,0 +1,157 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	defaultPlaysLimit = 50
+	maximumPlaysLimit = 500
+)
+
+// Play represents a song listened to by the current user. It is output by the REST API.
+type Play struct {
+	ID       uint      `json:"id"`       // ID is the play's identifier. E.g. "42"
+	PlayedAt time.Time `json:"playedAt"` // When the user started listening to the song. E.g. "2021-03-14T15:09:26Z"
+	Song     Song      `json:"song"`
+}
+
+func fromPlay(source music.Play) Play {
+	return Play{source.ID, source.PlayedAt.UTC(), fromSong(source.Song)}
+}
+
+// SongPlayCount represents how many times the current user listened to a song. It is output by the REST API.
+type SongPlayCount struct {
+	Song         Song      `json:"song"`
+	PlayCount    uint      `json:"playCount"`    // Number of times the user listened to the song. E.g. "7"
+	LastPlayedAt time.Time `json:"lastPlayedAt"` // When the user last started listening to the song
+}
+
+// PlayForm is the JSON body of requests recording a play. PlayedAt defaults to the time of the request.
+type PlayForm struct {
+	SongID   uint       `json:"songId"`
+	PlayedAt *time.Time `json:"playedAt"`
+}
+
+type postPlayHandler struct {
+	playStore music.PlayStore
+	userStore user.Store
+}
+
+func (h *postPlayHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	form := new(PlayForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	now := time.Now()
+	playedAt := now
+	if form.PlayedAt != nil {
+		playedAt = *form.PlayedAt
+	}
+	if playedAt.After(now) {
+		return server.NewBadRequestError(
+			fmt.Errorf("play time %v is in the future", playedAt),
+			"playedAt must not be in the future",
+		)
+	}
+	play, err := h.playStore.RecordPlay(request.Context(), userID, form.SongID, playedAt)
+	if errors.Is(err, music.ErrSongNotFound) {
+		return server.NewBadRequestError(
+			fmt.Errorf("could not find the song #%d: %w", form.SongID, err),
+			fmt.Sprintf("Song #%d could not be found", form.SongID),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error while recording a play of the song #%d: %w", form.SongID, err)
+	}
+	return writeJSON(writer, http.StatusCreated, fromPlay(*play))
+}
+
+type getPlaysHandler struct {
+	playStore music.PlayStore
+	userStore user.Store
+}
+
+func (h *getPlaysHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	limit, err := parsePlaysLimit(request)
+	if err != nil {
+		return err
+	}
+	plays, err := h.playStore.ListRecentPlays(request.Context(), userID, limit)
+	if err != nil {
+		return fmt.Errorf("error while listing the recent plays: %w", err)
+	}
+	response := make([]Play, 0, len(plays))
+	for _, play := range plays {
+		response = append(response, fromPlay(play))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type getPlayCountsHandler struct {
+	playStore music.PlayStore
+	userStore user.Store
+}
+
+func (h *getPlayCountsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	limit, err := parsePlaysLimit(request)
+	if err != nil {
+		return err
+	}
+	counts, err := h.playStore.ListPlayCounts(request.Context(), userID, limit)
+	if err != nil {
+		return fmt.Errorf("error while listing the play counts: %w", err)
+	}
+	response := make([]SongPlayCount, 0, len(counts))
+	for _, count := range counts {
+		response = append(response, SongPlayCount{fromSong(count.Song), count.PlayCount, count.LastPlayedAt.UTC()})
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+func parsePlaysLimit(request *http.Request) (uint, error) {
+	limit, err := parseUintParameter(request.URL.Query().Get("limit"), defaultPlaysLimit)
+	if err == nil && (limit == 0 || limit > maximumPlaysLimit) {
+		err = fmt.Errorf("limit %d is out of bounds", limit)
+	}
+	if err != nil {
+		return 0, server.NewBadRequestError(err, fmt.Sprintf("Limit must be an integer between 1 and %d", maximumPlaysLimit))
+	}
+	return limit, nil
+}
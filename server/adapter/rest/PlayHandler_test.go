@@ -0,0 +1,155 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestPlays(t *testing.T) {
+	t.Run("given a song, it will record a play of the current user and return it", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := &postPlayHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+		body := `{"songId": 1, "playedAt": "2021-03-14T15:09:26Z"}`
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, body, nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		var got Play
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into a Play, '%v'", response.Body, err)
+		}
+		want := time.Date(2021, time.March, 14, 15, 9, 26, 0, time.UTC)
+		if got.ID != 42 || got.Song.ID != 1 || !got.PlayedAt.Equal(want) || store.userID != 27 {
+			t.Errorf("unexpected play %+v for user #%d", got, store.userID)
+		}
+	})
+
+	t.Run("without a play time, it will record the play at the time of the request", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := &postPlayHandler{store, &stubUserStore{}}
+		before := time.Now()
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"songId": 1}`, nil))
+		tests.AssertNoError(t, err)
+
+		if store.playedAt.Before(before) || store.playedAt.After(time.Now()) {
+			t.Errorf("expected the play to be recorded now, got %v", store.playedAt)
+		}
+	})
+
+	t.Run("given a song that is not in the library, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlayHandler{&stubPlayStore{}, &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"songId": 404}`, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given a play time in the future, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlayHandler{&stubPlayStore{}, &stubUserStore{}}
+		body := `{"songId": 1, "playedAt": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, body, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("it will return the recent plays of the current user", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := &getPlaysHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/plays?limit=10"))
+		tests.AssertNoError(t, err)
+
+		var got []Play
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into Plays, '%v'", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		if len(got) != 1 || got[0].Song.Title != "Medicine Worry" || store.userID != 27 || store.limit != 10 {
+			t.Errorf("unexpected plays %+v for user #%d and limit %d", got, store.userID, store.limit)
+		}
+	})
+
+	t.Run("it will return the play counts of the current user", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := &getPlayCountsHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/play-counts"))
+		tests.AssertNoError(t, err)
+
+		var got []SongPlayCount
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body from server %q into SongPlayCounts, '%v'", response.Body, err)
+		}
+		if len(got) != 1 || got[0].PlayCount != 3 || store.limit != defaultPlaysLimit {
+			t.Errorf("unexpected play counts %+v for limit %d", got, store.limit)
+		}
+	})
+
+	t.Run("given a limit out of bounds, it will return a Bad Request error", func(t *testing.T) {
+		handler := &getPlaysHandler{&stubPlayStore{}, &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/plays?limit=0"))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+}
+
+// stubPlayStore knows the song #1 and records the arguments it is called with
+type stubPlayStore struct {
+	userID   uint
+	playedAt time.Time
+	limit    uint
+}
+
+var stubPlayedSong = music.Song{ID: 1, Title: "Medicine Worry", URI: "/music/Sub Folder/Medicine Worry.mp3", Type: "audio/mpeg"}
+
+func (s *stubPlayStore) RecordPlay(_ context.Context, userID uint, songID uint, playedAt time.Time) (*music.Play, error) {
+	s.userID, s.playedAt = userID, playedAt
+	if songID != stubPlayedSong.ID {
+		return nil, music.ErrSongNotFound
+	}
+	return &music.Play{ID: 42, Song: stubPlayedSong, PlayedAt: playedAt}, nil
+}
+
+func (s *stubPlayStore) RecordPlayOfPath(_ context.Context, userID uint, _ string, playedAt time.Time) error {
+	s.userID, s.playedAt = userID, playedAt
+	return nil
+}
+
+func (s *stubPlayStore) ListRecentPlays(_ context.Context, userID uint, limit uint) ([]music.Play, error) {
+	s.userID, s.limit = userID, limit
+	return []music.Play{{ID: 42, Song: stubPlayedSong, PlayedAt: time.Unix(1615734566, 0)}}, nil
+}
+
+func (s *stubPlayStore) ListPlayCounts(_ context.Context, userID uint, limit uint) ([]music.SongPlayCount, error) {
+	s.userID, s.limit = userID, limit
+	return []music.SongPlayCount{{Song: stubPlayedSong, PlayCount: 3, LastPlayedAt: time.Unix(1615734566, 0)}}, nil
+}
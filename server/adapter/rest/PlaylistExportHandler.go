@@ -0,0 +1,97 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// playlistExporter writes a playlist for download in one playlist file format
+type playlistExporter struct {
+	extension string
+	mediaType string
+	write     func(writer io.Writer, playlist *music.Playlist) error
+}
+
+var playlistExporters = map[string]playlistExporter{
+	"m3u8": {".m3u8", "audio/x-mpegurl; charset=utf-8", music.WriteM3U8},
+	"xspf": {".xspf", "application/xspf+xml; charset=utf-8", music.WriteXSPF},
+}
+
+// playlistExporterFor returns the exporter matching the "format" query parameter. It defaults to M3U8.
+func playlistExporterFor(request *http.Request) (*playlistExporter, error) {
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = "m3u8"
+	}
+	exporter, ok := playlistExporters[strings.ToLower(format)]
+	if !ok {
+		return nil, server.NewBadRequestError(
+			fmt.Errorf("unknown playlist format %q", format),
+			"Format must be either m3u8 or xspf",
+		)
+	}
+	return &exporter, nil
+}
+
+func (p *playlistExporter) export(writer http.ResponseWriter, playlist *music.Playlist) error {
+	// Write to a buffer first so that errors can still be reported with an error status
+	var buffer bytes.Buffer
+	if err := p.write(&buffer, playlist); err != nil {
+		return fmt.Errorf("could not export the playlist #%d: %w", playlist.ID, err)
+	}
+	fileName := strings.NewReplacer("/", "_", `\`, "_").Replace(playlist.Name) + p.extension
+	writer.Header().Set("Content-Type", p.mediaType)
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	_, err := buffer.WriteTo(writer)
+	return err
+}
+
+type exportPlaylistHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *exportPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	exporter, err := playlistExporterFor(request)
+	if err != nil {
+		return err
+	}
+	playlist, err := h.playlistStore.GetPlaylist(request.Context(), ownerID, playlistID)
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	return exporter.export(writer, playlist)
+}
@@ -0,0 +1,345 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	maximumPlaylistNameLength = 255
+	maximumRequestBodySize    = 1 << 20
+)
+
+// PlaylistSummary represents a playlist without its songs. It is output by the REST API.
+type PlaylistSummary struct {
+	ID        uint   `json:"id"`        // ID is the playlist's identifier. E.g. "12"
+	Name      string `json:"name"`      // Name of the playlist. E.g. "Road trip"
+	SongCount uint   `json:"songCount"` // Number of songs in the playlist. E.g. "42"
+}
+
+func fromPlaylistSummary(source music.PlaylistSummary) PlaylistSummary {
+	return PlaylistSummary{
+		ID:        source.ID,
+		Name:      source.Name,
+		SongCount: source.SongCount,
+	}
+}
+
+// Playlist represents an ordered list of songs owned by the current user. It is output by the REST API.
+type Playlist struct {
+	PlaylistSummary
+	Entries []PlaylistEntry `json:"entries"`
+}
+
+// PlaylistEntry represents a song in a playlist. It is output by the REST API.
+type PlaylistEntry struct {
+	ID   uint `json:"id"` // ID is the entry's identifier. It does not change when the playlist is reordered. E.g. "7"
+	Song Song `json:"song"`
+}
+
+func fromPlaylist(source *music.Playlist) Playlist {
+	entries := make([]PlaylistEntry, 0) // Init slice at zero, otherwise nil slice results in "null" JSON instead of []
+	for _, entry := range source.Entries {
+		entries = append(entries, PlaylistEntry{ID: entry.ID, Song: fromSong(entry.Song)})
+	}
+	return Playlist{fromPlaylistSummary(source.PlaylistSummary), entries}
+}
+
+// PlaylistForm is the JSON body of requests creating or renaming a playlist
+type PlaylistForm struct {
+	Name string `json:"name"`
+}
+
+// AppendSongsForm is the JSON body of requests appending songs to a playlist
+type AppendSongsForm struct {
+	SongIDs []uint `json:"songIds"`
+}
+
+// ReorderEntriesForm is the JSON body of requests reordering a playlist. It must list every entry of the playlist.
+type ReorderEntriesForm struct {
+	EntryIDs []uint `json:"entryIds"`
+}
+
+type getPlaylistsHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *getPlaylistsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlists, err := h.playlistStore.ListPlaylists(request.Context(), ownerID)
+	if err != nil {
+		return fmt.Errorf("error while retrieving the playlists: %w", err)
+	}
+	response := make([]PlaylistSummary, 0)
+	for _, playlist := range playlists {
+		response = append(response, fromPlaylistSummary(playlist))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type postPlaylistHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *postPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	name, err := decodePlaylistName(writer, request)
+	if err != nil {
+		return err
+	}
+	playlist, err := h.playlistStore.CreatePlaylist(request.Context(), ownerID, name)
+	if err != nil {
+		return fmt.Errorf("error while creating the playlist: %w", err)
+	}
+	writer.Header().Set("Location", fmt.Sprintf("/api/playlists/%d", playlist.ID))
+	return writeJSON(writer, http.StatusCreated, fromPlaylistSummary(*playlist))
+}
+
+type getPlaylistHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *getPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	playlist, err := h.playlistStore.GetPlaylist(request.Context(), ownerID, playlistID)
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	return writeJSON(writer, http.StatusOK, fromPlaylist(playlist))
+}
+
+type patchPlaylistHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *patchPlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	name, err := decodePlaylistName(writer, request)
+	if err != nil {
+		return err
+	}
+	if err = h.playlistStore.RenamePlaylist(request.Context(), ownerID, playlistID, name); err != nil {
+		return playlistError(err, playlistID)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type deletePlaylistHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *deletePlaylistHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	if err = h.playlistStore.DeletePlaylist(request.Context(), ownerID, playlistID); err != nil {
+		return playlistError(err, playlistID)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type postPlaylistEntriesHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *postPlaylistEntriesHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	form := new(AppendSongsForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	if len(form.SongIDs) == 0 {
+		return server.NewBadRequestError(errors.New("no song to append"), "songIds must contain at least one song ID")
+	}
+	err = h.playlistStore.AppendSongs(request.Context(), ownerID, playlistID, form.SongIDs)
+	if errors.Is(err, music.ErrSongNotFound) {
+		return server.NewBadRequestError(err, "Every song ID must belong to a song of the library")
+	}
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type putPlaylistEntriesHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *putPlaylistEntriesHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	form := new(ReorderEntriesForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	err = h.playlistStore.ReorderEntries(request.Context(), ownerID, playlistID, form.EntryIDs)
+	if errors.Is(err, music.ErrStalePlaylistOrder) {
+		return server.NewConflictError(err, "The playlist has changed, entryIds must list its current entries")
+	}
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type deletePlaylistEntryHandler struct {
+	playlistStore music.PlaylistStore
+	userStore     user.Store
+}
+
+func (h *deletePlaylistEntryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	playlistID, err := parseIDVar(request, "playlistId")
+	if err != nil {
+		return err
+	}
+	entryID, err := parseIDVar(request, "entryId")
+	if err != nil {
+		return err
+	}
+	err = h.playlistStore.RemoveEntry(request.Context(), ownerID, playlistID, entryID)
+	if errors.Is(err, music.ErrPlaylistEntryNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the entry #%d of playlist #%d: %w", entryID, playlistID, err))
+	}
+	if err != nil {
+		return playlistError(err, playlistID)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// currentUserID returns the identifier of the user authenticated by the request's session
+func currentUserID(request *http.Request, userStore user.Store) (uint, error) {
+	currentUser, err := userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve the current user: %w", err)
+	}
+	return currentUser.ID, nil
+}
+
+// parseIDVar parses the identifier in the route variable named name
+func parseIDVar(request *http.Request, name string) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(request)[name], 10, 32)
+	if err != nil {
+		return 0, server.NewBadRequestError(err, fmt.Sprintf("%s must be a positive integer", name))
+	}
+	return uint(id), nil
+}
+
+func decodeJSONBody(writer http.ResponseWriter, request *http.Request, form interface{}) error {
+	body := http.MaxBytesReader(writer, request.Body, maximumRequestBodySize)
+	if err := json.NewDecoder(body).Decode(form); err != nil {
+		return server.NewBadRequestError(err, "Could not decode the JSON request body")
+	}
+	return nil
+}
+
+func decodePlaylistName(writer http.ResponseWriter, request *http.Request) (string, error) {
+	form := new(PlaylistForm)
+	if err := decodeJSONBody(writer, request, form); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(form.Name)
+	if name == "" || utf8.RuneCountInString(name) > maximumPlaylistNameLength {
+		return "", server.NewBadRequestError(
+			fmt.Errorf("invalid playlist name %q", form.Name),
+			fmt.Sprintf("Playlist name must contain between 1 and %d characters", maximumPlaylistNameLength),
+		)
+	}
+	return name, nil
+}
+
+func playlistError(err error, playlistID uint) error {
+	if errors.Is(err, music.ErrPlaylistNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the playlist #%d: %w", playlistID, err))
+	}
+	return fmt.Errorf("error while editing the playlist #%d: %w", playlistID, err)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, response interface{}) error {
+	writer.Header().Set("Content-Type", jsonMediaType)
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		return fmt.Errorf("could not encode %v to JSON: %w", response, err)
+	}
+	return nil
+}
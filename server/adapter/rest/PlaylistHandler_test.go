@@ -0,0 +1,336 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestPlaylists(t *testing.T) {
+	t.Run("it will return the JSON representation of the current user's playlists", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &getPlaylistsHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/playlists"))
+		tests.AssertNoError(t, err)
+
+		var got []PlaylistSummary
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into PlaylistSummaries, '%v'", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if len(got) != 1 || got[0].Name != "Road trip" || store.ownerID != 27 {
+			t.Errorf("unexpected playlists %+v for owner #%d", got, store.ownerID)
+		}
+	})
+
+	t.Run("given a name, it will create a playlist and return its location", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &postPlaylistHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, `{"name": "  Road trip "}`, nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		tests.AssertLocationHeaderEquals(t, response, "/api/playlists/12")
+		if store.name != "Road trip" {
+			t.Errorf("expected the name to be trimmed, got %q", store.name)
+		}
+	})
+
+	t.Run("given a blank name, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"name": " "}`, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given a body that is not JSON, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `name=Road trip`, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("when the current user cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getPlaylistsHandler{newValidPlaylistStore(), &stubUserStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/playlists"))
+		tests.AssertError(t, err)
+	})
+}
+
+func TestPlaylist(t *testing.T) {
+	t.Run("given a playlist ID, it will return the JSON representation of the playlist and its entries", func(t *testing.T) {
+		handler := &getPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		var got Playlist
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into a Playlist, '%v'", response.Body, err)
+		}
+		if got.ID != 12 || len(got.Entries) != 1 || got.Entries[0].Song.Title != "Medicine Worry" {
+			t.Errorf("unexpected playlist %+v", got)
+		}
+	})
+
+	t.Run("given an unknown playlist ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &getPlaylistHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodGet, "", map[string]string{"playlistId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given a new name, it will rename the playlist", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &patchPlaylistHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPatch, `{"name": "Commute"}`, map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if store.name != "Commute" {
+			t.Errorf("expected the playlist to be renamed, got %q", store.name)
+		}
+	})
+
+	t.Run("it will delete the playlist", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &deletePlaylistHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodDelete, "", map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if !store.hasDeleted {
+			t.Errorf("expected the playlist to be deleted")
+		}
+	})
+
+	t.Run("when the playlist cannot be deleted, it will return an error", func(t *testing.T) {
+		handler := &deletePlaylistHandler{&stubPlaylistStore{shouldError: true}, &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodDelete, "", map[string]string{"playlistId": "12"}))
+		tests.AssertError(t, err)
+	})
+}
+
+func TestPlaylistEntries(t *testing.T) {
+	t.Run("given song IDs, it will append them to the playlist", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &postPlaylistEntriesHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, `{"songIds": [1, 2]}`, map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if len(store.ids) != 2 {
+			t.Errorf("expected two songs to be appended, got %v", store.ids)
+		}
+	})
+
+	t.Run("given a song ID that is not in the library, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlaylistEntriesHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"songIds": [404]}`, map[string]string{"playlistId": "12"}))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given no song ID, it will return a Bad Request error", func(t *testing.T) {
+		handler := &postPlaylistEntriesHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"songIds": []}`, map[string]string{"playlistId": "12"}))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given every entry ID, it will reorder the playlist", func(t *testing.T) {
+		store := newValidPlaylistStore()
+		handler := &putPlaylistEntriesHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPut, `{"entryIds": [7]}`, map[string]string{"playlistId": "12"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+	})
+
+	t.Run("given entry IDs that do not match the playlist anymore, it will return a Conflict error", func(t *testing.T) {
+		handler := &putPlaylistEntriesHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPut, `{"entryIds": [7, 8]}`, map[string]string{"playlistId": "12"}))
+		assertHTTPErrorCode(t, err, http.StatusConflict)
+	})
+
+	t.Run("it will remove the entry from the playlist", func(t *testing.T) {
+		handler := &deletePlaylistEntryHandler{newValidPlaylistStore(), &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodDelete, "", map[string]string{"playlistId": "12", "entryId": "7"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+	})
+
+	t.Run("given an unknown entry ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &deletePlaylistEntryHandler{newValidPlaylistStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodDelete, "", map[string]string{"playlistId": "12", "entryId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+func newJSONRequest(t *testing.T, method string, body string, vars map[string]string) *http.Request {
+	t.Helper()
+	request := httptest.NewRequest(method, "/api/playlists", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if vars != nil {
+		request = mux.SetURLVars(request, vars)
+	}
+	return request
+}
+
+func newValidPlaylistStore() *stubPlaylistStore {
+	return &stubPlaylistStore{}
+}
+
+// stubPlaylistStore knows a single playlist #12 with a single entry #7. The library contains the songs #1 and #2.
+type stubPlaylistStore struct {
+	ownerID     uint
+	name        string
+	ids         []uint
+	hasDeleted  bool
+	shouldError bool
+}
+
+func (s *stubPlaylistStore) ListPlaylists(_ context.Context, ownerID uint) ([]music.PlaylistSummary, error) {
+	s.ownerID = ownerID
+	return []music.PlaylistSummary{{ID: 12, Name: "Road trip", SongCount: 1}}, nil
+}
+
+func (s *stubPlaylistStore) CreatePlaylist(_ context.Context, ownerID uint, name string) (*music.PlaylistSummary, error) {
+	s.ownerID = ownerID
+	s.name = name
+	return &music.PlaylistSummary{ID: 12, Name: name}, nil
+}
+
+func (s *stubPlaylistStore) GetPlaylist(_ context.Context, _ uint, playlistID uint) (*music.Playlist, error) {
+	if playlistID != 12 {
+		return nil, music.ErrPlaylistNotFound
+	}
+	return &music.Playlist{
+		PlaylistSummary: music.PlaylistSummary{ID: 12, Name: "Road trip", SongCount: 1},
+		Entries:         []music.PlaylistEntry{{ID: 7, Song: music.Song{ID: 1, Title: "Medicine Worry"}}},
+	}, nil
+}
+
+func (s *stubPlaylistStore) RenamePlaylist(_ context.Context, _ uint, _ uint, name string) error {
+	s.name = name
+	return nil
+}
+
+func (s *stubPlaylistStore) AppendSongs(_ context.Context, _ uint, _ uint, songIDs []uint) error {
+	for _, songID := range songIDs {
+		if songID != 1 && songID != 2 {
+			return music.ErrSongNotFound
+		}
+	}
+	s.ids = songIDs
+	return nil
+}
+
+func (s *stubPlaylistStore) RemoveEntry(_ context.Context, _ uint, _ uint, entryID uint) error {
+	if entryID != 7 {
+		return music.ErrPlaylistEntryNotFound
+	}
+	return nil
+}
+
+func (s *stubPlaylistStore) ReorderEntries(_ context.Context, _ uint, _ uint, entryIDs []uint) error {
+	if len(entryIDs) != 1 || entryIDs[0] != 7 {
+		return music.ErrStalePlaylistOrder
+	}
+	return nil
+}
+
+func (s *stubPlaylistStore) DeletePlaylist(_ context.Context, _ uint, _ uint) error {
+	if s.shouldError {
+		return errors.New("This error should be expected in tests")
+	}
+	s.hasDeleted = true
+	return nil
+}
+
+// stubUserStore is always signed in as the user #27
+type stubUserStore struct {
+	shouldError      bool
+	subsonicPassword []byte // Encrypted Subsonic password
+	role             user.Role
+}
+
+func (s *stubUserStore) GetUserMatchingEmail(_ context.Context, _ string) (*user.PossibleMatch, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) GetUserMatchingSession(_ context.Context) (*user.Current, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return &user.Current{ID: 27, Email: "testuser@example.com", Username: "Test User", Role: s.role}, nil
+}
+
+func (s *stubUserStore) HasAdministrator(_ context.Context) (bool, error) {
+	return false, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) SaveFirstAdministrator(_ context.Context, _ *user.Registration) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) GetSubsonicCredentials(_ context.Context, _ string) (*user.SubsonicCredentials, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) SaveSubsonicPassword(_ context.Context, userID uint, encryptedPassword []byte) error {
+	if s.shouldError || userID != 27 {
+		return errors.New("This error should be expected in tests")
+	}
+	s.subsonicPassword = encryptedPassword
+	return nil
+}
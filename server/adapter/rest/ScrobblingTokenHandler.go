@@ -0,0 +1,106 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// maximumScrobblingTokenLength bounds the length of scrobbling tokens. ListenBrainz tokens are 36 characters long.
+const maximumScrobblingTokenLength = 255
+
+// ScrobblingStatus tells whether the current user forwards their plays to the scrobbling service. It is output by the REST API.
+type ScrobblingStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ScrobblingTokenForm is the JSON body of requests saving the scrobbling token of the current user
+type ScrobblingTokenForm struct {
+	Token string `json:"token"` // Token given by the scrobbling service. E.g. the "User token" of ListenBrainz
+}
+
+type getScrobblingTokenHandler struct {
+	scrobbleStore music.ScrobbleStore
+	userStore     user.Store
+}
+
+// ServeHTTP never outputs the token, it only tells whether there is one
+func (h *getScrobblingTokenHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	token, err := h.scrobbleStore.GetScrobblingToken(request.Context(), userID)
+	if err != nil {
+		return fmt.Errorf("error while retrieving the scrobbling token: %w", err)
+	}
+	return writeJSON(writer, http.StatusOK, ScrobblingStatus{Enabled: token != ""})
+}
+
+// putScrobblingTokenHandler saves the token of the current user. Their next plays are forwarded to the scrobbling service.
+type putScrobblingTokenHandler struct {
+	scrobbleStore music.ScrobbleStore
+	userStore     user.Store
+}
+
+func (h *putScrobblingTokenHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	form := new(ScrobblingTokenForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	token := strings.TrimSpace(form.Token)
+	if token == "" || len(token) > maximumScrobblingTokenLength {
+		return server.NewBadRequestError(
+			fmt.Errorf("invalid scrobbling token of length %d", len(token)),
+			fmt.Sprintf("Token must contain between 1 and %d characters", maximumScrobblingTokenLength),
+		)
+	}
+	if err = h.scrobbleStore.SaveScrobblingToken(request.Context(), userID, token); err != nil {
+		return fmt.Errorf("error while saving the scrobbling token: %w", err)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// deleteScrobblingTokenHandler stops forwarding the plays of the current user. Plays not submitted yet are dropped.
+type deleteScrobblingTokenHandler struct {
+	scrobbleStore music.ScrobbleStore
+	userStore     user.Store
+}
+
+func (h *deleteScrobblingTokenHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	if err = h.scrobbleStore.SaveScrobblingToken(request.Context(), userID, ""); err != nil {
+		return fmt.Errorf("error while removing the scrobbling token: %w", err)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestScrobblingToken(t *testing.T) {
+	t.Run("it saves the trimmed scrobbling token of the current user", func(t *testing.T) {
+		store := &stubScrobbleStore{}
+		handler := &putScrobblingTokenHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPut, `{"token": " secret "}`, nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if store.tokens[27] != "secret" {
+			t.Errorf("expected the token to be saved for user #27, got %v", store.tokens)
+		}
+	})
+
+	t.Run("it tells whether the current user has a token without showing it", func(t *testing.T) {
+		store := &stubScrobbleStore{tokens: map[uint]string{27: "secret"}}
+		handler := &getScrobblingTokenHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/scrobbling-token"))
+		tests.AssertNoError(t, err)
+
+		var got ScrobblingStatus
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into ScrobblingStatus, %v", response.Body, err)
+		}
+		if !got.Enabled || strings.Contains(response.Body.String(), "secret") {
+			t.Errorf("unexpected scrobbling status %q", response.Body.String())
+		}
+	})
+
+	t.Run("it removes the scrobbling token of the current user", func(t *testing.T) {
+		store := &stubScrobbleStore{tokens: map[uint]string{27: "secret"}}
+		handler := &deleteScrobblingTokenHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodDelete, "", nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if store.tokens[27] != "" {
+			t.Errorf("expected the token to be removed, got %v", store.tokens)
+		}
+	})
+
+	t.Run("given a blank token, it returns a Bad Request error", func(t *testing.T) {
+		handler := &putScrobblingTokenHandler{&stubScrobbleStore{}, &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPut, `{"token": "  "}`, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+}
+
+// stubScrobbleStore only knows the tokens of users
+type stubScrobbleStore struct {
+	tokens map[uint]string
+}
+
+func (s *stubScrobbleStore) GetScrobblingToken(_ context.Context, userID uint) (string, error) {
+	return s.tokens[userID], nil
+}
+
+func (s *stubScrobbleStore) SaveScrobblingToken(_ context.Context, userID uint, token string) error {
+	if s.tokens == nil {
+		s.tokens = make(map[uint]string)
+	}
+	s.tokens[userID] = token
+	return nil
+}
+
+func (s *stubScrobbleStore) ListPendingScrobbles(_ context.Context, _ time.Time, _ uint) ([]music.PendingScrobble, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubScrobbleStore) DeleteScrobbles(_ context.Context, _ []uint) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubScrobbleStore) PostponeScrobbles(_ context.Context, _ []uint, _ time.Time) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubScrobbleStore) GetSongOfPath(_ context.Context, _ string) (*music.Song, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
@@ -0,0 +1,124 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+const (
+	defaultSearchLimit = 20
+	maximumSearchLimit = 100
+)
+
+// SearchResults represents one page of the songs, albums and artists matching a search, best matches first.
+// Totals count all the matches, not only this page. It is output by the REST API.
+type SearchResults struct {
+	Songs        []Song   `json:"songs"`
+	Albums       []Album  `json:"albums"`
+	Artists      []Artist `json:"artists"`
+	TotalSongs   uint     `json:"totalSongs"`
+	TotalAlbums  uint     `json:"totalAlbums"`
+	TotalArtists uint     `json:"totalArtists"`
+}
+
+// Album represents a group of songs sharing the same album and artist tags. It is output by the REST API.
+type Album struct {
+	Name      string `json:"name"`      // Name of the album. E.g. "Cowboy Bebop Original Soundtrack"
+	Artist    string `json:"artist"`    // Name of the album's artist. E.g. "Yoko Kanno"
+	SongCount uint   `json:"songCount"` // Number of songs of the album matching the search. E.g. "12"
+}
+
+// Artist represents a group of songs sharing the same artist tag. It is output by the REST API.
+type Artist struct {
+	Name      string `json:"name"`      // Name of the artist. E.g. "Yoko Kanno"
+	SongCount uint   `json:"songCount"` // Number of songs of the artist matching the search. E.g. "42"
+}
+
+func fromSearchResults(source *music.SearchResults) SearchResults {
+	results := SearchResults{
+		Songs:        make([]Song, 0), // Init slices at zero, otherwise nil slice results in "null" JSON instead of []
+		Albums:       make([]Album, 0),
+		Artists:      make([]Artist, 0),
+		TotalSongs:   source.TotalSongs,
+		TotalAlbums:  source.TotalAlbums,
+		TotalArtists: source.TotalArtists,
+	}
+	for _, song := range source.Songs {
+		results.Songs = append(results.Songs, fromSong(song))
+	}
+	for _, album := range source.Albums {
+		results.Albums = append(results.Albums, Album{album.Name, album.Artist, album.SongCount})
+	}
+	for _, artist := range source.Artists {
+		results.Artists = append(results.Artists, Artist{artist.Name, artist.SongCount})
+	}
+	return results
+}
+
+type searchHandler struct {
+	searcher music.Searcher
+}
+
+func (h *searchHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	values := request.URL.Query()
+	limit, err := parseUintParameter(values.Get("limit"), defaultSearchLimit)
+	if err == nil && (limit == 0 || limit > maximumSearchLimit) {
+		err = fmt.Errorf("limit %d is out of bounds", limit)
+	}
+	if err != nil {
+		return server.NewBadRequestError(err, fmt.Sprintf("Limit must be an integer between 1 and %d", maximumSearchLimit))
+	}
+	offset, err := parseUintParameter(values.Get("offset"), 0)
+	if err != nil {
+		return server.NewBadRequestError(err, "Offset must be a positive integer")
+	}
+
+	query := music.SearchQuery{Terms: values.Get("q"), Limit: limit, Offset: offset}
+	results, err := h.searcher.Search(request.Context(), query)
+	if errors.Is(err, music.ErrEmptySearch) {
+		return server.NewBadRequestError(err, "Search query q must contain at least one word")
+	}
+	if err != nil {
+		return fmt.Errorf("error while searching the library for %q: %w", query.Terms, err)
+	}
+
+	response := fromSearchResults(results)
+	writer.Header().Set("Content-Type", jsonMediaType)
+	err = json.NewEncoder(writer).Encode(response)
+	if err != nil {
+		return fmt.Errorf("could not encode the search results %v to JSON: %w", response, err)
+	}
+	return nil
+}
+
+// parseUintParameter parses a query parameter. It returns defaultValue when the parameter is missing.
+func parseUintParameter(value string, defaultValue uint) (uint, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	return uint(parsed), err
+}
@@ -0,0 +1,123 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSearch(t *testing.T) {
+	t.Run("given a query, it will return the JSON representation of the search results", func(t *testing.T) {
+		searcher := &stubSearcher{}
+		handler := &searchHandler{searcher}
+		request := tests.NewGetRequest(t, "/api/search?q=worry&limit=5&offset=10")
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got SearchResults
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into SearchResults, '%v'", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if len(got.Songs) != 1 || got.Songs[0].Title != "Medicine Worry" || got.TotalSongs != 11 {
+			t.Errorf("unexpected search results %+v", got)
+		}
+		if got.Albums == nil || got.Artists == nil {
+			t.Errorf("expected empty albums and artists to be encoded as empty arrays, got %+v", got)
+		}
+		want := music.SearchQuery{Terms: "worry", Limit: 5, Offset: 10}
+		if searcher.query != want {
+			t.Errorf("expected query %+v, got %+v", want, searcher.query)
+		}
+	})
+
+	t.Run("given no limit, it will return the default number of results", func(t *testing.T) {
+		searcher := &stubSearcher{}
+		handler := &searchHandler{searcher}
+		request := tests.NewGetRequest(t, "/api/search?q=worry")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		tests.AssertNoError(t, err)
+		if searcher.query.Limit != defaultSearchLimit || searcher.query.Offset != 0 {
+			t.Errorf("expected the default limit and offset, got %+v", searcher.query)
+		}
+	})
+
+	t.Run("given a query without any word, it will return a Bad Request error", func(t *testing.T) {
+		handler := &searchHandler{&stubSearcher{}}
+		request := tests.NewGetRequest(t, "/api/search?q=%20")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given a limit above the maximum, it will return a Bad Request error", func(t *testing.T) {
+		handler := &searchHandler{&stubSearcher{}}
+		request := tests.NewGetRequest(t, "/api/search?q=worry&limit=1000")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("given an offset that is not a number, it will return a Bad Request error", func(t *testing.T) {
+		handler := &searchHandler{&stubSearcher{}}
+		request := tests.NewGetRequest(t, "/api/search?q=worry&offset=first")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("when the search fails, it will return an error", func(t *testing.T) {
+		handler := &searchHandler{&stubSearcher{shouldError: true}}
+		request := tests.NewGetRequest(t, "/api/search?q=worry")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		tests.AssertError(t, err)
+	})
+}
+
+type stubSearcher struct {
+	query       music.SearchQuery
+	shouldError bool
+}
+
+func (s *stubSearcher) Search(_ context.Context, query music.SearchQuery) (*music.SearchResults, error) {
+	s.query = query
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	if query.Terms == " " {
+		return nil, music.ErrEmptySearch
+	}
+	return &music.SearchResults{
+		Songs:      []music.Song{{ID: 1, Title: "Medicine Worry", Type: "audio/mpeg"}},
+		TotalSongs: 11,
+	}, nil
+}
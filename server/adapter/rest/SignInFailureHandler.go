@@ -0,0 +1,86 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+)
+
+const (
+	defaultSignInFailuresLimit = 50
+	maximumSignInFailuresLimit = 500
+)
+
+// SignInFailure represents a failed sign-in attempt, as seen by administrators. It is output by the REST API.
+type SignInFailure struct {
+	ID          uint      `json:"id"`          // ID is the failure's identifier. E.g. "42"
+	AttemptedAt time.Time `json:"attemptedAt"` // Date of the attempt
+	IP          string    `json:"ip"`          // IP address of the client. E.g. "192.0.2.1"
+	Account     string    `json:"account"`     // Email typed in the sign-in form, it may not match any user
+	// Reason is either "unknown-account", "wrong-password", "invalid-code" or "locked-out"
+	Reason string `json:"reason"`
+}
+
+// SignInFailurePage represents a page of the audit log of the failed sign-in attempts, most recent first.
+// It is output by the REST API.
+type SignInFailurePage struct {
+	Failures []SignInFailure `json:"failures"`
+	Total    uint            `json:"total"` // Total number of failures in the audit log. E.g. "128"
+}
+
+type getSignInFailuresHandler struct {
+	throttleStore user.SignInThrottleStore
+}
+
+func (h *getSignInFailuresHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	values := request.URL.Query()
+	limit, err := parseUintParameter(values.Get("limit"), defaultSignInFailuresLimit)
+	if err == nil && (limit == 0 || limit > maximumSignInFailuresLimit) {
+		err = fmt.Errorf("limit %d is out of bounds", limit)
+	}
+	if err != nil {
+		return server.NewBadRequestError(
+			err,
+			fmt.Sprintf("Limit must be an integer between 1 and %d", maximumSignInFailuresLimit),
+		)
+	}
+	offset, err := parseUintParameter(values.Get("offset"), 0)
+	if err != nil {
+		return server.NewBadRequestError(err, "Offset must be a positive integer")
+	}
+	failures, total, err := h.throttleStore.ListSignInFailures(request.Context(), limit, offset)
+	if err != nil {
+		return fmt.Errorf("error while listing the sign-in failures: %w", err)
+	}
+	response := SignInFailurePage{Failures: make([]SignInFailure, 0, len(failures)), Total: total}
+	for _, failure := range failures {
+		response.Failures = append(response.Failures, SignInFailure{
+			ID:          failure.ID,
+			AttemptedAt: failure.AttemptedAt.UTC(),
+			IP:          failure.IP,
+			Account:     failure.Account,
+			Reason:      string(failure.Reason),
+		})
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
@@ -0,0 +1,117 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetSignInFailures(t *testing.T) {
+	t.Run("it will return the requested page of the audit log and its total", func(t *testing.T) {
+		store := &stubSignInThrottleStore{}
+		handler := &getSignInFailuresHandler{store}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/sign-in-failures?limit=1&offset=2"))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		var got SignInFailurePage
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into a SignInFailurePage, %v", response.Body, err)
+		}
+		if got.Total != 3 || len(got.Failures) != 1 || got.Failures[0].Reason != "wrong-password" ||
+			got.Failures[0].IP != "192.0.2.1" {
+			t.Errorf("unexpected sign-in failures %+v", got)
+		}
+		if store.limit != 1 || store.offset != 2 {
+			t.Errorf("expected limit 1 and offset 2, got %d and %d", store.limit, store.offset)
+		}
+	})
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=ten", "offset=-1"} {
+		t.Run("given "+query+", it will return Bad Request", func(t *testing.T) {
+			handler := &getSignInFailuresHandler{&stubSignInThrottleStore{}}
+
+			err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/sign-in-failures?"+query))
+			assertHTTPErrorCode(t, err, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("when the audit log cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &getSignInFailuresHandler{&stubSignInThrottleStore{shouldError: true}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/sign-in-failures"))
+		tests.AssertError(t, err)
+	})
+}
+
+type stubSignInThrottleStore struct {
+	shouldError bool
+	limit       uint
+	offset      uint
+}
+
+func (s *stubSignInThrottleStore) LockedUntil(_ context.Context, _ []string) (time.Time, error) {
+	return time.Time{}, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) CountSignInFailure(_ context.Context, _ string, _ time.Time, _ time.Time) (uint, error) {
+	return 0, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) LockSignIn(_ context.Context, _ string, _ time.Time) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) ResetSignInFailures(_ context.Context, _ []string) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) SaveSignInFailure(_ context.Context, _ *user.SignInFailure) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) ListSignInFailures(
+	_ context.Context,
+	limit uint,
+	offset uint,
+) ([]user.SignInFailure, uint, error) {
+	if s.shouldError {
+		return nil, 0, errors.New("error while listing the sign-in failures")
+	}
+	s.limit, s.offset = limit, offset
+	failure := user.SignInFailure{
+		ID:          1,
+		AttemptedAt: time.Date(2021, time.June, 12, 20, 0, 0, 0, time.UTC),
+		IP:          "192.0.2.1",
+		Account:     "mike@example.com",
+		Reason:      user.FailureWrongPassword,
+	}
+	return []user.SignInFailure{failure}, 3, nil
+}
@@ -0,0 +1,76 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// SongDetails represents a single song with everything known about it. It is output by the REST API.
+type SongDetails struct {
+	Song
+	CoverURI   string `json:"coverUri"`   // URI to the song's cover art. Empty when the song has no cover art.
+	FolderPath string `json:"folderPath"` // Path of the folder containing the song. Empty for the root music folder. E.g. "Yoko%20Kanno"
+}
+
+func fromIndexedSong(source *music.IndexedSong) SongDetails {
+	folderPath := source.FolderPath()
+	if folderPath == "." {
+		folderPath = ""
+	}
+	return SongDetails{
+		Song:       fromSong(source.Song),
+		CoverURI:   coverURI(source.CoverID),
+		FolderPath: folderPath,
+	}
+}
+
+type songHandler struct {
+	songStore music.SongStore
+}
+
+func (s *songHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	vars := mux.Vars(request)
+	songID, err := strconv.ParseUint(vars["songId"], 10, 32)
+	if err != nil {
+		return server.NewBadRequestError(err, "Song ID must be a positive integer")
+	}
+	song, err := s.songStore.GetSong(request.Context(), uint(songID))
+	if errors.Is(err, music.ErrSongNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the song #%d: %w", songID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("error while retrieving the song #%d: %w", songID, err)
+	}
+
+	response := fromIndexedSong(song)
+	writer.Header().Set("Content-Type", jsonMediaType)
+	err = json.NewEncoder(writer).Encode(response)
+	if err != nil {
+		return fmt.Errorf("could not encode the song %v to JSON: %w", response, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetSong(t *testing.T) {
+	t.Run("given a song ID, it will return the JSON representation of the song", func(t *testing.T) {
+		handler := &songHandler{newValidSongStore(t)}
+		request := newGetRequestWithSongID(t, "1")
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got SongDetails
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into a SongDetails, '%v'", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+		if got.ID != 1 || got.Title != "Medicine Worry" || got.Type != "audio/mpeg" || got.Format != "mp3" || got.FolderPath != "Sub Folder" || got.CoverURI != "/api/covers/5" {
+			t.Errorf("unexpected song representation %+v", got)
+		}
+	})
+
+	t.Run("given a song at the root of the library, its folder path will be empty", func(t *testing.T) {
+		handler := &songHandler{newValidSongStore(t)}
+		request := newGetRequestWithSongID(t, "2")
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, request)
+		tests.AssertNoError(t, err)
+
+		var got SongDetails
+		err = json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("could not decode the response body from server %q into a SongDetails, '%v'", response.Body, err)
+		}
+		if got.FolderPath != "" {
+			t.Errorf("expected an empty folder path, got %s", got.FolderPath)
+		}
+		if got.CoverURI != "" {
+			t.Errorf("expected an empty cover URI for a song without cover art, got %s", got.CoverURI)
+		}
+	})
+
+	t.Run("given an unknown song ID, it will return a Not Found error", func(t *testing.T) {
+		handler := &songHandler{newValidSongStore(t)}
+		request := newGetRequestWithSongID(t, "404")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given a song ID that is not a number, it will return a Bad Request error", func(t *testing.T) {
+		handler := &songHandler{newValidSongStore(t)}
+		request := newGetRequestWithSongID(t, "not-a-number")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("when the song cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &songHandler{&stubSongStore{shouldError: true}}
+		request := newGetRequestWithSongID(t, "1")
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), request)
+		tests.AssertError(t, err)
+	})
+}
+
+func newGetRequestWithSongID(t *testing.T, songID string) *http.Request {
+	t.Helper()
+	request := tests.NewGetRequest(t, "/api/songs/"+songID)
+	return mux.SetURLVars(request, map[string]string{"songId": songID})
+}
+
+func assertHTTPErrorCode(t *testing.T, err error, want int) {
+	t.Helper()
+	var httpErr *server.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected an HTTPError, got %v", err)
+	}
+	tests.AssertStatusEquals(t, httpErr.Code, want)
+}
+
+func newValidSongStore(t *testing.T) music.SongStore {
+	t.Helper()
+	return &stubSongStore{songs: map[uint]music.IndexedSong{
+		1: {
+			Song: music.Song{ID: 1, Title: "Medicine Worry", URI: "/music/Sub Folder/Medicine Worry.mp3", Type: "audio/mpeg", CoverID: 5},
+			Path: "Sub Folder/Medicine Worry.mp3",
+		},
+		2: {
+			Song: music.Song{ID: 2, Title: "He Wall", URI: "/music/He Wall.ogg", Type: "audio/ogg"},
+			Path: "He Wall.ogg",
+		},
+	}}
+}
+
+type stubSongStore struct {
+	songs       map[uint]music.IndexedSong
+	shouldError bool
+}
+
+func (s *stubSongStore) GetSong(_ context.Context, songID uint) (*music.IndexedSong, error) {
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	song, ok := s.songs[songID]
+	if !ok {
+		return nil, music.ErrSongNotFound
+	}
+	return &song, nil
+}
@@ -0,0 +1,80 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+)
+
+// subsonicPasswordBytes is the number of random bytes of generated Subsonic passwords
+const subsonicPasswordBytes = 12
+
+// SubsonicCredentials represents what users must type in Subsonic API clients. It is output by the REST API.
+type SubsonicCredentials struct {
+	Username string `json:"username"` // Username of the current user
+	Password string `json:"password"` // Generated password for the Subsonic API. E.g. "3f9a0c5e1b7d2a4c6e8f0a1b"
+}
+
+// postSubsonicPasswordHandler generates a new password for the Subsonic API. It replaces the previous one.
+// The password is saved encrypted with cipher.
+type postSubsonicPasswordHandler struct {
+	userStore user.Store
+	cipher    *user.SecretCipher
+}
+
+func (h *postSubsonicPasswordHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	currentUser, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("could not retrieve the current user: %w", err)
+	}
+	randomBytes := make([]byte, subsonicPasswordBytes)
+	if _, err = rand.Read(randomBytes); err != nil {
+		return fmt.Errorf("could not generate a Subsonic password: %w", err)
+	}
+	password := hex.EncodeToString(randomBytes)
+	encryptedPassword, err := h.cipher.Encrypt([]byte(password))
+	if err != nil {
+		return fmt.Errorf("could not encrypt the Subsonic password: %w", err)
+	}
+	if err = h.userStore.SaveSubsonicPassword(request.Context(), currentUser.ID, encryptedPassword); err != nil {
+		return fmt.Errorf("error while saving the Subsonic password: %w", err)
+	}
+	return writeJSON(writer, http.StatusCreated, SubsonicCredentials{Username: currentUser.Username, Password: password})
+}
+
+// deleteSubsonicPasswordHandler revokes the password for the Subsonic API
+type deleteSubsonicPasswordHandler struct {
+	userStore user.Store
+}
+
+func (h *deleteSubsonicPasswordHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	ownerID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	if err = h.userStore.SaveSubsonicPassword(request.Context(), ownerID, nil); err != nil {
+		return fmt.Errorf("error while revoking the Subsonic password: %w", err)
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSubsonicPassword(t *testing.T) {
+	t.Run("it generates a new Subsonic password for the current user and saves it encrypted", func(t *testing.T) {
+		userStore := &stubUserStore{subsonicPassword: []byte("previous")}
+		cipher := newTestCipher(t)
+		handler := &postSubsonicPasswordHandler{userStore, cipher}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, "", nil))
+		tests.AssertNoError(t, err)
+
+		var got SubsonicCredentials
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into SubsonicCredentials, %v", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		saved, err := cipher.Decrypt(userStore.subsonicPassword)
+		tests.AssertNoError(t, err)
+		if got.Username != "Test User" || len(got.Password) != 24 || got.Password != string(saved) {
+			t.Errorf("unexpected Subsonic credentials %+v, saved password %q", got, saved)
+		}
+	})
+
+	t.Run("it revokes the Subsonic password of the current user", func(t *testing.T) {
+		userStore := &stubUserStore{subsonicPassword: []byte("previous")}
+		handler := &deleteSubsonicPasswordHandler{userStore}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodDelete, "", nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if userStore.subsonicPassword != nil {
+			t.Errorf("expected the Subsonic password to be revoked, got %q", userStore.subsonicPassword)
+		}
+	})
+
+	t.Run("when the password cannot be saved, it returns an error", func(t *testing.T) {
+		handler := &postSubsonicPasswordHandler{&stubUserStore{shouldError: true}, newTestCipher(t)}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, "", nil))
+		tests.AssertError(t, err)
+	})
+}
+
+func newTestCipher(t *testing.T) *user.SecretCipher {
+	t.Helper()
+	cipher, err := user.NewSecretCipher(make([]byte, 32))
+	tests.AssertNoError(t, err)
+	return cipher
+}
@@ -0,0 +1,295 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/swithek/sessionup"
+)
+
+// User represents a user account, as seen by administrators. It is output by the REST API.
+type User struct {
+	ID       uint   `json:"id"`       // ID is the user's identifier. E.g. "3"
+	Email    string `json:"email"`    // Email the user signs in with. E.g. "mike@example.com"
+	Username string `json:"username"` // Username displayed in the app and used by Subsonic clients. E.g. "Mike"
+	Role     string `json:"role"`     // Role of the user, either "admin" or "listener"
+	Disabled bool   `json:"disabled"` // Disabled users cannot sign in
+}
+
+func fromAccount(source user.Account) User {
+	return User{
+		ID:       source.ID,
+		Email:    source.Email,
+		Username: source.Username,
+		Role:     string(source.Role),
+		Disabled: source.Disabled,
+	}
+}
+
+// Invitation represents a link that lets someone register their own account. It is output by the REST API.
+type Invitation struct {
+	URI       string    `json:"uri"`       // URI of the registration page. E.g. "/invitations/9b1c54a3e0f24d7a8f5e6b2c1d0a9e8f"
+	Role      string    `json:"role"`      // Role of the account registered with the invitation
+	ExpiresAt time.Time `json:"expiresAt"` // The invitation cannot be used after this date
+}
+
+// PasswordReset represents a link that lets a user choose a new password. It is output by the REST API.
+type PasswordReset struct {
+	URI       string    `json:"uri"`       // URI of the password page. E.g. "/password-reset/9b1c54a3e0f24d7a8f5e6b2c1d0a9e8f"
+	ExpiresAt time.Time `json:"expiresAt"` // The link cannot be used after this date
+}
+
+// UserForm is the JSON body of requests creating a user. Role defaults to "listener".
+type UserForm struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// UserUpdateForm is the JSON body of requests editing a user. Missing fields are left unchanged.
+type UserUpdateForm struct {
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// InvitationForm is the JSON body of requests inviting a user. Role defaults to "listener".
+type InvitationForm struct {
+	Role string `json:"role"`
+}
+
+// administratorsOnly forbids the requests of users who are not administrators
+type administratorsOnly struct {
+	userStore user.Store
+	next      server.ErroringHandler
+}
+
+func (h *administratorsOnly) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	currentUser, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("could not retrieve the current user: %w", err)
+	}
+	if !currentUser.IsAdministrator() {
+		return server.NewForbiddenError(fmt.Errorf("user #%d is not an administrator", currentUser.ID))
+	}
+	return h.next.ServeHTTP(writer, request)
+}
+
+type getUsersHandler struct {
+	accountStore user.AccountStore
+}
+
+func (h *getUsersHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	accounts, err := h.accountStore.ListUsers(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the users: %w", err)
+	}
+	response := make([]User, 0)
+	for _, account := range accounts {
+		response = append(response, fromAccount(account))
+	}
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+type postUserHandler struct {
+	accountStore user.AccountStore
+}
+
+func (h *postUserHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	form := new(UserForm)
+	if err := decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	form.Email, form.Username = strings.TrimSpace(form.Email), strings.TrimSpace(form.Username)
+	if form.Email == "" || form.Username == "" || form.Password == "" {
+		return server.NewBadRequestError(errors.New("missing user fields"), "email, username and password are required")
+	}
+	role, err := parseRole(form.Role)
+	if err != nil {
+		return err
+	}
+	registration, err := user.NewRegistration(
+		&user.RegistrationForm{Email: form.Email, Password: form.Password, Username: form.Username},
+		role,
+	)
+	if err != nil {
+		return err
+	}
+	account, err := h.accountStore.SaveUser(request.Context(), registration)
+	if errors.Is(err, user.ErrUserAlreadyExists) {
+		return server.NewConflictError(err, "A user with the same email or username already exists")
+	}
+	if err != nil {
+		return fmt.Errorf("error while creating the user: %w", err)
+	}
+	writer.Header().Set("Location", fmt.Sprintf("/api/users/%d", account.ID))
+	return writeJSON(writer, http.StatusCreated, fromAccount(*account))
+}
+
+type patchUserHandler struct {
+	accountStore   user.AccountStore
+	sessionManager *sessionup.Manager
+}
+
+func (h *patchUserHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := parseIDVar(request, "userId")
+	if err != nil {
+		return err
+	}
+	form := new(UserUpdateForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	account, err := h.accountStore.GetUser(request.Context(), userID)
+	if err != nil {
+		return userError(err, userID)
+	}
+	if form.Role != nil {
+		if account.Role, err = parseRole(*form.Role); err != nil {
+			return err
+		}
+	}
+	if form.Disabled != nil {
+		account.Disabled = *form.Disabled
+	}
+	if err = h.accountStore.UpdateUser(request.Context(), userID, account.Role, account.Disabled); err != nil {
+		return userError(err, userID)
+	}
+	if account.Disabled {
+		if err = revokeSessions(request, h.sessionManager, userID); err != nil {
+			return err
+		}
+	}
+	return writeJSON(writer, http.StatusOK, fromAccount(*account))
+}
+
+type deleteUserHandler struct {
+	accountStore   user.AccountStore
+	sessionManager *sessionup.Manager
+}
+
+func (h *deleteUserHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := parseIDVar(request, "userId")
+	if err != nil {
+		return err
+	}
+	if err = h.accountStore.DeleteUser(request.Context(), userID); err != nil {
+		return userError(err, userID)
+	}
+	if err = revokeSessions(request, h.sessionManager, userID); err != nil {
+		return err
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type postInvitationHandler struct {
+	accountStore user.AccountStore
+	userStore    user.Store
+}
+
+func (h *postInvitationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	currentUserID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	form := new(InvitationForm)
+	if err = decodeJSONBody(writer, request, form); err != nil {
+		return err
+	}
+	role, err := parseRole(form.Role)
+	if err != nil {
+		return err
+	}
+	invitation, err := h.accountStore.CreateInvitation(request.Context(), role, currentUserID)
+	if err != nil {
+		return fmt.Errorf("error while creating the invitation: %w", err)
+	}
+	return writeJSON(writer, http.StatusCreated, Invitation{
+		URI:       user.InvitationURI(invitation.Token),
+		Role:      string(invitation.Role),
+		ExpiresAt: invitation.ExpiresAt,
+	})
+}
+
+// postPasswordResetHandler creates a password reset link for the user. It replaces their previous link.
+type postPasswordResetHandler struct {
+	accountStore user.AccountStore
+	userStore    user.Store
+}
+
+func (h *postPasswordResetHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	userID, err := parseIDVar(request, "userId")
+	if err != nil {
+		return err
+	}
+	currentUserID, err := currentUserID(request, h.userStore)
+	if err != nil {
+		return err
+	}
+	reset, err := h.accountStore.CreatePasswordReset(request.Context(), userID, currentUserID)
+	if err != nil {
+		return userError(err, userID)
+	}
+	return writeJSON(writer, http.StatusCreated, PasswordReset{
+		URI:       user.PasswordResetURI(reset.Token),
+		ExpiresAt: reset.ExpiresAt,
+	})
+}
+
+// parseRole returns the role named name. An empty name is the listener role.
+func parseRole(name string) (user.Role, error) {
+	if name == "" {
+		return user.RoleListener, nil
+	}
+	role := user.Role(name)
+	if !role.IsValid() {
+		return "", server.NewBadRequestError(
+			fmt.Errorf("invalid role %q", name),
+			fmt.Sprintf("role must be either %q or %q", user.RoleAdministrator, user.RoleListener),
+		)
+	}
+	return role, nil
+}
+
+// revokeSessions signs the user out of all their devices
+func revokeSessions(request *http.Request, sessionManager *sessionup.Manager, userID uint) error {
+	err := sessionManager.RevokeByUserKey(request.Context(), strconv.FormatUint(uint64(userID), 10))
+	if err != nil {
+		return fmt.Errorf("could not revoke the sessions of user #%d: %w", userID, err)
+	}
+	return nil
+}
+
+func userError(err error, userID uint) error {
+	if errors.Is(err, user.ErrUserNotFound) {
+		return server.NewNotFoundError(fmt.Errorf("could not find the user #%d: %w", userID, err))
+	}
+	if errors.Is(err, user.ErrLastAdministrator) {
+		return server.NewConflictError(err, "There must be at least one active administrator")
+	}
+	return fmt.Errorf("error while editing the user #%d: %w", userID, err)
+}
@@ -0,0 +1,338 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestAdministratorsOnly(t *testing.T) {
+	t.Run("when the current user is not an administrator, it will return Forbidden", func(t *testing.T) {
+		handler := &administratorsOnly{&stubUserStore{role: user.RoleListener}, &getUsersHandler{newValidAccountStore()}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/users"))
+		assertHTTPErrorCode(t, err, http.StatusForbidden)
+	})
+
+	t.Run("when the current user is an administrator, it will call the next handler", func(t *testing.T) {
+		handler := &administratorsOnly{&stubUserStore{role: user.RoleAdministrator}, &getUsersHandler{newValidAccountStore()}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, tests.NewGetRequest(t, "/api/users"))
+		tests.AssertNoError(t, err)
+
+		var got []User
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into Users, %v", response.Body, err)
+		}
+		if len(got) != 2 || got[0].Username != "Listener" || got[1].Role != "admin" {
+			t.Errorf("unexpected users %+v", got)
+		}
+	})
+
+	t.Run("when the current user cannot be retrieved, it will return an error", func(t *testing.T) {
+		handler := &administratorsOnly{&stubUserStore{shouldError: true}, &getUsersHandler{newValidAccountStore()}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/api/users"))
+		tests.AssertError(t, err)
+	})
+}
+
+func TestPostUserHandler(t *testing.T) {
+	t.Run("it will create the user and return its location", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &postUserHandler{store}
+		response := httptest.NewRecorder()
+
+		body := `{"email": "new@example.com", "username": "New", "password": "welcome0", "role": "admin"}`
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, body, nil))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		tests.AssertLocationHeaderEquals(t, response, "/api/users/28")
+		saved := store.accounts[28]
+		if saved.Username != "New" || saved.Role != user.RoleAdministrator {
+			t.Errorf("unexpected saved user %+v", saved)
+		}
+	})
+
+	t.Run("when no role is given, it will create a listener", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &postUserHandler{store}
+
+		body := `{"email": "new@example.com", "username": "New", "password": "welcome0"}`
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, body, nil))
+		tests.AssertNoError(t, err)
+
+		if store.accounts[28].Role != user.RoleListener {
+			t.Errorf("expected a listener, got %+v", store.accounts[28])
+		}
+	})
+
+	testCases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no email", `{"username": "New", "password": "welcome0"}`, http.StatusBadRequest},
+		{"a blank username", `{"email": "new@example.com", "username": " ", "password": "welcome0"}`, http.StatusBadRequest},
+		{"an unknown role", `{"email": "new@example.com", "username": "New", "password": "welcome0", "role": "root"}`, http.StatusBadRequest},
+		{"a password longer than 64 characters", `{"email": "new@example.com", "username": "New", "password": "` + strings.Repeat("a", 65) + `"}`, http.StatusBadRequest},
+		{"an email that is already used", `{"email": "listener@example.com", "username": "New", "password": "welcome0"}`, http.StatusConflict},
+	}
+	for _, testCase := range testCases {
+		t.Run("given "+testCase.name+", it will return an error", func(t *testing.T) {
+			handler := &postUserHandler{newValidAccountStore()}
+
+			err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, testCase.body, nil))
+			assertHTTPErrorCode(t, err, testCase.want)
+		})
+	}
+}
+
+func TestPatchUserHandler(t *testing.T) {
+	t.Run("it will disable the user and revoke their sessions", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &patchUserHandler{store, tests.NewValidSessionManager(t)}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPatch, `{"disabled": true}`, map[string]string{"userId": "3"}))
+		tests.AssertNoError(t, err)
+
+		var got User
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into User, %v", response.Body, err)
+		}
+		if !got.Disabled || got.Role != "listener" || !store.accounts[3].Disabled {
+			t.Errorf("expected the listener to be disabled, got %+v", got)
+		}
+	})
+
+	t.Run("it will change the role of the user", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &patchUserHandler{store, tests.NewValidSessionManager(t)}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPatch, `{"role": "admin"}`, map[string]string{"userId": "3"}))
+		tests.AssertNoError(t, err)
+
+		if store.accounts[3].Role != user.RoleAdministrator || store.accounts[3].Disabled {
+			t.Errorf("expected the listener to become an administrator, got %+v", store.accounts[3])
+		}
+	})
+
+	testCases := []struct {
+		name   string
+		body   string
+		userID string
+		want   int
+	}{
+		{"an unknown user", `{"disabled": true}`, "404", http.StatusNotFound},
+		{"an unknown role", `{"role": "root"}`, "3", http.StatusBadRequest},
+		{"the last administrator", `{"role": "listener"}`, "27", http.StatusConflict},
+	}
+	for _, testCase := range testCases {
+		t.Run("given "+testCase.name+", it will return an error", func(t *testing.T) {
+			handler := &patchUserHandler{newValidAccountStore(), tests.NewValidSessionManager(t)}
+
+			request := newJSONRequest(t, http.MethodPatch, testCase.body, map[string]string{"userId": testCase.userID})
+			err := handler.ServeHTTP(httptest.NewRecorder(), request)
+			assertHTTPErrorCode(t, err, testCase.want)
+		})
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	t.Run("it will delete the user", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &deleteUserHandler{store, tests.NewValidSessionManager(t)}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodDelete, "", map[string]string{"userId": "3"}))
+		tests.AssertNoError(t, err)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+		if _, ok := store.accounts[3]; ok {
+			t.Error("expected the user to be deleted")
+		}
+	})
+
+	t.Run("given the last administrator, it will return Conflict", func(t *testing.T) {
+		handler := &deleteUserHandler{newValidAccountStore(), tests.NewValidSessionManager(t)}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodDelete, "", map[string]string{"userId": "27"}))
+		assertHTTPErrorCode(t, err, http.StatusConflict)
+	})
+}
+
+func TestPostInvitationHandler(t *testing.T) {
+	t.Run("it will create an invitation and return its link", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &postInvitationHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, `{"role": "listener"}`, nil))
+		tests.AssertNoError(t, err)
+
+		var got Invitation
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into Invitation, %v", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		if got.URI != "/invitations/0123456789abcdef" || got.Role != "listener" || store.invitedBy != 27 {
+			t.Errorf("unexpected invitation %+v", got)
+		}
+	})
+
+	t.Run("given an unknown role, it will return Bad Request", func(t *testing.T) {
+		handler := &postInvitationHandler{newValidAccountStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, `{"role": "root"}`, nil))
+		assertHTTPErrorCode(t, err, http.StatusBadRequest)
+	})
+}
+
+func TestPostPasswordResetHandler(t *testing.T) {
+	t.Run("it will create a password reset and return its link", func(t *testing.T) {
+		store := newValidAccountStore()
+		handler := &postPasswordResetHandler{store, &stubUserStore{}}
+		response := httptest.NewRecorder()
+
+		err := handler.ServeHTTP(response, newJSONRequest(t, http.MethodPost, "", map[string]string{"userId": "3"}))
+		tests.AssertNoError(t, err)
+
+		var got PasswordReset
+		if err = json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the response body %q into PasswordReset, %v", response.Body, err)
+		}
+		tests.AssertStatusEquals(t, response.Code, http.StatusCreated)
+		if got.URI != "/password-reset/0123456789abcdef" || store.resetBy != 27 {
+			t.Errorf("unexpected password reset %+v", got)
+		}
+	})
+
+	t.Run("given an unknown user, it will return Not Found", func(t *testing.T) {
+		handler := &postPasswordResetHandler{newValidAccountStore(), &stubUserStore{}}
+
+		err := handler.ServeHTTP(httptest.NewRecorder(), newJSONRequest(t, http.MethodPost, "", map[string]string{"userId": "404"}))
+		assertHTTPErrorCode(t, err, http.StatusNotFound)
+	})
+}
+
+func newValidAccountStore() *stubAccountStore {
+	return &stubAccountStore{accounts: map[uint]user.Account{
+		3:  {ID: 3, Email: "listener@example.com", Username: "Listener", Role: user.RoleListener},
+		27: {ID: 27, Email: "testuser@example.com", Username: "Test User", Role: user.RoleAdministrator},
+	}}
+}
+
+// stubAccountStore knows the listener #3 and the administrator #27, who is the last administrator
+type stubAccountStore struct {
+	accounts  map[uint]user.Account
+	invitedBy uint
+	resetBy   uint
+}
+
+func (s *stubAccountStore) ListUsers(_ context.Context) ([]user.Account, error) {
+	return []user.Account{s.accounts[3], s.accounts[27]}, nil
+}
+
+func (s *stubAccountStore) GetUser(_ context.Context, userID uint) (*user.Account, error) {
+	account, ok := s.accounts[userID]
+	if !ok {
+		return nil, user.ErrUserNotFound
+	}
+	return &account, nil
+}
+
+func (s *stubAccountStore) SaveUser(_ context.Context, registration *user.Registration) (*user.Account, error) {
+	for _, account := range s.accounts {
+		if account.Email == registration.Email || account.Username == registration.Username {
+			return nil, user.ErrUserAlreadyExists
+		}
+	}
+	account := user.Account{ID: 28, Email: registration.Email, Username: registration.Username, Role: registration.Role}
+	s.accounts[account.ID] = account
+	return &account, nil
+}
+
+func (s *stubAccountStore) UpdateUser(_ context.Context, userID uint, role user.Role, disabled bool) error {
+	account, ok := s.accounts[userID]
+	if !ok {
+		return user.ErrUserNotFound
+	}
+	if userID == 27 && (role != user.RoleAdministrator || disabled) {
+		return user.ErrLastAdministrator
+	}
+	account.Role, account.Disabled = role, disabled
+	s.accounts[userID] = account
+	return nil
+}
+
+func (s *stubAccountStore) DeleteUser(_ context.Context, userID uint) error {
+	if _, ok := s.accounts[userID]; !ok {
+		return user.ErrUserNotFound
+	}
+	if userID == 27 {
+		return user.ErrLastAdministrator
+	}
+	delete(s.accounts, userID)
+	return nil
+}
+
+func (s *stubAccountStore) UpdatePassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) CreateInvitation(_ context.Context, role user.Role, createdBy uint) (*user.Invitation, error) {
+	s.invitedBy = createdBy
+	return &user.Invitation{Token: "0123456789abcdef", Role: role, ExpiresAt: time.Now().Add(user.InvitationLifetime)}, nil
+}
+
+func (s *stubAccountStore) GetInvitation(_ context.Context, _ string) (*user.Invitation, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubAccountStore) AcceptInvitation(_ context.Context, _ string, _ *user.Registration) (*user.Account, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubAccountStore) CreatePasswordReset(_ context.Context, userID uint, createdBy uint) (*user.PasswordReset, error) {
+	if _, ok := s.accounts[userID]; !ok {
+		return nil, user.ErrUserNotFound
+	}
+	s.resetBy = createdBy
+	return &user.PasswordReset{Token: "0123456789abcdef", UserID: userID, ExpiresAt: time.Now().Add(user.PasswordResetLifetime)}, nil
+}
+
+func (s *stubAccountStore) GetPasswordReset(_ context.Context, _ string) (*user.PasswordReset, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubAccountStore) ResetPassword(_ context.Context, _ string, _ []byte) (*user.PasswordReset, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
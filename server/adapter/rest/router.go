@@ -21,40 +21,114 @@ Package rest implements the REST API.
 package rest
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
 	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
 	"github.com/swithek/sessionup"
 )
 
 // Register registers a gorilla/mux Subrouter for the REST API on the given router
-func Register(router *mux.Router, sessionManager *sessionup.Manager, explorer music.MusicLibraryExplorer) {
-	songHandler := &songHandler{}
-	folderHandler := &folderHandler{explorer}
+func Register(
+	router *mux.Router,
+	sessionManager *sessionup.Manager,
+	folderBrowser music.FolderBrowser,
+	songStore music.SongStore,
+	searcher music.Searcher,
+	catalogStore music.CatalogStore,
+	playlistStore music.PlaylistStore,
+	libraryPlaylistStore music.LibraryPlaylistStore,
+	coverLoader music.CoverLoader,
+	playStore music.PlayStore,
+	scrobbleStore music.ScrobbleStore,
+	userStore user.Store,
+	accountStore user.AccountStore,
+	throttleStore user.SignInThrottleStore,
+	secretCipher *user.SecretCipher,
+) {
+	songHandler := &songHandler{songStore}
+	searchHandler := &searchHandler{searcher}
+	folderHandler := &folderHandler{folderBrowser}
+	coverHandler := &coverHandler{coverLoader}
 
 	apiRouter := router.PathPrefix("/api/").Subrouter()
 	// All requests to the REST API must be authenticated
 	apiRouter.Use(sessionManager.Auth)
-	apiRouter.Handle("/songs/{songId}", server.WrapErrors(songHandler))
+	apiRouter.Handle("/songs/{songId:[0-9]+}", server.WrapErrors(songHandler))
 	apiRouter.Handle("/folders/{path:.*}", server.WrapErrors(folderHandler))
-}
+	apiRouter.Handle("/search", server.WrapErrors(searchHandler))
+	apiRouter.Handle("/songs", server.WrapErrors(&getSongsHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/artists", server.WrapErrors(&getArtistsHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/artists/{artistId:[0-9]+}", server.WrapErrors(&getArtistHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/albums", server.WrapErrors(&getAlbumsHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/albums/{albumId:[0-9]+}", server.WrapErrors(&getAlbumHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/genres", server.WrapErrors(&getGenresHandler{catalogStore})).Methods(http.MethodGet)
+	apiRouter.Handle("/covers/{coverId:[0-9]+}", server.WrapErrors(coverHandler)).Methods(http.MethodGet)
 
-const jsonMediaType = "application/json; charset=utf-8"
+	apiRouter.Handle("/playlists", server.WrapErrors(&getPlaylistsHandler{playlistStore, userStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/playlists", server.WrapErrors(&postPlaylistHandler{playlistStore, userStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}", server.WrapErrors(&getPlaylistHandler{playlistStore, userStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}", server.WrapErrors(&patchPlaylistHandler{playlistStore, userStore})).
+		Methods(http.MethodPatch)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}", server.WrapErrors(&deletePlaylistHandler{playlistStore, userStore})).
+		Methods(http.MethodDelete)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}/export", server.WrapErrors(&exportPlaylistHandler{playlistStore, userStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}/entries", server.WrapErrors(&postPlaylistEntriesHandler{playlistStore, userStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}/entries", server.WrapErrors(&putPlaylistEntriesHandler{playlistStore, userStore})).
+		Methods(http.MethodPut)
+	apiRouter.Handle("/playlists/{playlistId:[0-9]+}/entries/{entryId:[0-9]+}", server.WrapErrors(&deletePlaylistEntryHandler{playlistStore, userStore})).
+		Methods(http.MethodDelete)
+
+	apiRouter.Handle("/plays", server.WrapErrors(&getPlaysHandler{playStore, userStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/plays", server.WrapErrors(&postPlayHandler{playStore, userStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/play-counts", server.WrapErrors(&getPlayCountsHandler{playStore, userStore})).
+		Methods(http.MethodGet)
 
-type songHandler struct{}
+	apiRouter.Handle("/scrobbling-token", server.WrapErrors(&getScrobblingTokenHandler{scrobbleStore, userStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/scrobbling-token", server.WrapErrors(&putScrobblingTokenHandler{scrobbleStore, userStore})).
+		Methods(http.MethodPut)
+	apiRouter.Handle("/scrobbling-token", server.WrapErrors(&deleteScrobblingTokenHandler{scrobbleStore, userStore})).
+		Methods(http.MethodDelete)
 
-func (s *songHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
-	response := &Song{Title: "Hello World"}
-	err := json.NewEncoder(writer).Encode(response)
-	if err != nil {
-		return fmt.Errorf("could not encode the song %v to JSON: %w", response, err)
+	apiRouter.Handle("/subsonic-password", server.WrapErrors(&postSubsonicPasswordHandler{userStore, secretCipher})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/subsonic-password", server.WrapErrors(&deleteSubsonicPasswordHandler{userStore})).
+		Methods(http.MethodDelete)
+
+	adminOnly := func(next server.ErroringHandler) http.Handler {
+		return server.WrapErrors(&administratorsOnly{userStore, next})
 	}
+	apiRouter.Handle("/users", adminOnly(&getUsersHandler{accountStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/users", adminOnly(&postUserHandler{accountStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/users/{userId:[0-9]+}", adminOnly(&patchUserHandler{accountStore, sessionManager})).
+		Methods(http.MethodPatch)
+	apiRouter.Handle("/users/{userId:[0-9]+}", adminOnly(&deleteUserHandler{accountStore, sessionManager})).
+		Methods(http.MethodDelete)
+	apiRouter.Handle("/users/{userId:[0-9]+}/password-reset", adminOnly(&postPasswordResetHandler{accountStore, userStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/invitations", adminOnly(&postInvitationHandler{accountStore, userStore})).
+		Methods(http.MethodPost)
+	apiRouter.Handle("/sign-in-failures", adminOnly(&getSignInFailuresHandler{throttleStore})).
+		Methods(http.MethodGet)
 
-	writer.Header().Set("Content-Type", jsonMediaType)
-	writer.WriteHeader(http.StatusOK)
-	return nil
+	apiRouter.Handle("/library-playlists", server.WrapErrors(&getLibraryPlaylistsHandler{libraryPlaylistStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/library-playlists/{playlistId:[0-9]+}", server.WrapErrors(&getLibraryPlaylistHandler{libraryPlaylistStore})).
+		Methods(http.MethodGet)
+	apiRouter.Handle("/library-playlists/{playlistId:[0-9]+}/export", server.WrapErrors(&exportLibraryPlaylistHandler{libraryPlaylistStore})).
+		Methods(http.MethodGet)
 }
+
+const jsonMediaType = "application/json; charset=utf-8"
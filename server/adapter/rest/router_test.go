@@ -18,7 +18,6 @@
 package rest
 
 import (
-	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -30,8 +29,10 @@ import (
 func TestRouter(t *testing.T) {
 	router := mux.NewRouter()
 	sessionManager := tests.NewValidSessionManager(t)
-	explorer := newValidLibraryExplorer(t)
-	Register(router, sessionManager, explorer)
+	browser := newValidFolderBrowser(t)
+	songStore := newValidSongStore(t)
+	searcher := &stubSearcher{}
+	Register(router, sessionManager, browser, songStore, searcher, &stubCatalogStore{}, newValidPlaylistStore(), &stubLibraryPlaylistStore{}, newValidCoverLoader(), &stubPlayStore{}, &stubScrobbleStore{}, &stubUserStore{}, newValidAccountStore(), &stubSignInThrottleStore{}, newTestCipher(t))
 
 	t.Run("/api/folders/path is handled by FolderHandler", func(t *testing.T) {
 		request := tests.NewAuthenticatedGetRequest(t, "/api/folders/path")
@@ -48,25 +49,68 @@ func TestRouter(t *testing.T) {
 
 		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
 	})
-}
 
-func TestGetSong(t *testing.T) {
-	handler := &songHandler{}
-	t.Run("given a song ID, it will return JSON containing Hello World", func(t *testing.T) {
-		request := tests.NewGetRequest(t, "/api/songs/15")
+	t.Run("/api/search is handled by SearchHandler", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/search?q=worry")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("/api/songs is handled by the songs handler of the catalog", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/songs?sort=album")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("/api/albums/12 is handled by the album handler of the catalog", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/albums/12")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("/api/covers/5 is handled by CoverHandler", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/covers/5")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("/api/playlists/12 is handled by PlaylistHandler", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/playlists/12")
 		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
 
-		err := handler.ServeHTTP(response, request)
-		tests.AssertNoError(t, err)
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("/api/plays is handled by PlayHandler", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/plays")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
 
-		var got Song
-		err = json.NewDecoder(response.Body).Decode(&got)
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
 
-		if err != nil {
-			t.Fatalf("could not decode the response body from server %q into a Song, '%v'", response.Body, err)
-		}
+	t.Run("/api/library-playlists is handled by LibraryPlaylistHandler", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/library-playlists")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
 
 		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
-		tests.AssertContentTypeHeaderEquals(t, response, jsonMediaType)
+	})
+
+	t.Run("/api/users is only allowed to administrators", func(t *testing.T) {
+		request := tests.NewAuthenticatedGetRequest(t, "/api/users")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
 	})
 }
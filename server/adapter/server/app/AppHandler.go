@@ -53,6 +53,7 @@ func (h *appHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request
 		StylesheetURI:   styleSheetURI,
 		AppURI:          scriptURI,
 		HeaderPresenter: headerPresenter,
+		IsAdministrator: currentUser.IsAdministrator(),
 	}
 	err = h.templateExecutor.Load(writer, presenter, "app.html", "sidebar.html")
 	if err != nil {
@@ -79,6 +80,7 @@ type appPresenter struct {
 	StylesheetURI   string // Public URI path to the stylesheet
 	AppURI          string // Public URI path to the javascript app
 	HeaderPresenter *headerPresenter
+	IsAdministrator bool // Whether the current user can manage the other users
 }
 
 type headerPresenter struct {
@@ -147,6 +147,10 @@ func (s *stubDAOForApp) GetUserMatchingEmail(_ context.Context, _ string) (*user
 	return nil, errors.New("This method should not have been called in tests")
 }
 
+func (s *stubDAOForApp) HasAdministrator(_ context.Context) (bool, error) {
+	return false, errors.New("This method should not have been called in tests")
+}
+
 func (s *stubDAOForApp) SaveFirstAdministrator(_ context.Context, _ *user.Registration) error {
 	return errors.New("This method should not have been called in tests")
 }
@@ -171,3 +175,11 @@ func TestGenerateGravatarHash(t *testing.T) {
 		}
 	})
 }
+
+func (s *stubDAOForApp) GetSubsonicCredentials(_ context.Context, _ string) (*user.SubsonicCredentials, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubDAOForApp) SaveSubsonicPassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method should not have been called in tests")
+}
@@ -36,9 +36,11 @@ func Register(
 	assetsResolver adapter.AssetsResolver,
 	userStore user.Store,
 	sessionManager *sessionup.Manager,
+	csrf *server.CSRFProtection,
 ) {
-	appHandler := sessionManager.Auth(
+	// The app page holds the sign-out form
+	appHandler := sessionManager.Auth(csrf.Middleware(
 		server.WrapErrors(&appHandler{templateExecutor, assetsResolver, userStore}),
-	)
+	))
 	router.PathPrefix("/app").Handler(appHandler)
 }
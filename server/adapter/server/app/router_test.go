@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
 	"github.com/hyzual/mike-sierra-sierra/tests"
 )
 
@@ -32,7 +33,7 @@ func TestRouter(t *testing.T) {
 	assetsResolver := newValidAssetsResolver()
 	userStore := newValidUserStore()
 	sessionManager := tests.NewValidSessionManager(t)
-	Register(router, templateExecutor, assetsResolver, userStore, sessionManager)
+	Register(router, templateExecutor, assetsResolver, userStore, sessionManager, server.NewCSRFProtection(make([]byte, 32)))
 
 	t.Run("/app/suffix is handled by AppHandler", func(t *testing.T) {
 		request := tests.NewAuthenticatedGetRequest(t, "/app/suffix")
@@ -40,5 +41,6 @@ func TestRouter(t *testing.T) {
 		router.ServeHTTP(response, request)
 
 		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertCSRFCookieSet(t, response)
 	})
 }
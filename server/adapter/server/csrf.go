@@ -0,0 +1,148 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// CSRFCookieName is the cookie holding the secret that anti-CSRF tokens are derived from
+	CSRFCookieName = "csrf"
+	// CSRFFieldName is the hidden form field where forms send their anti-CSRF token
+	CSRFFieldName = "csrf-token"
+	// CSRFHeaderName is the header where scripts can send their anti-CSRF token instead of a form field
+	CSRFHeaderName  = "X-CSRF-Token"
+	csrfSecretBytes = 32
+)
+
+// CSRFProtection protects forms against cross-site request forgery with signed double-submit cookies.
+// Each browser gets a random secret in a cookie, and forms must send back a token derived from it
+// with the secret key of the server. Other sites can neither read the cookie nor compute the token.
+type CSRFProtection struct {
+	key []byte
+}
+
+// NewCSRFProtection creates a new CSRFProtection signing the tokens with a key derived from the given
+// secret key, so that the secret key can also be used for other purposes
+func NewCSRFProtection(key []byte) *CSRFProtection {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("csrf")) //nolint:errcheck // Writing to a hash never fails
+	return &CSRFProtection{mac.Sum(nil)}
+}
+
+// NewToken creates a new secret cookie and the token that must be sent with it
+func (p *CSRFProtection) NewToken() (*http.Cookie, string, error) {
+	secret := make([]byte, csrfSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("could not generate an anti-CSRF secret: %w", err)
+	}
+	cookie := &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    hex.EncodeToString(secret),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie, p.token(secret), nil
+}
+
+// token signs the secret of the cookie
+func (p *CSRFProtection) token(secret []byte) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(secret) //nolint:errcheck // Writing to a hash never fails
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware rejects the POST, PUT, PATCH and DELETE requests without a valid anti-CSRF token
+// with 403 Forbidden. It gives the token of the request to the templates: see adapter.CSRFTokenWriter.
+// The token field is removed from the parsed form, so that handlers only decode their own fields.
+func (p *CSRFProtection) Middleware(next http.Handler) http.Handler {
+	return WrapErrors(&csrfHandler{p, next})
+}
+
+type csrfHandler struct {
+	protection *CSRFProtection
+	next       http.Handler
+}
+
+func (h *csrfHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	secret, err := readCSRFSecret(request)
+	if isSafeMethod(request.Method) {
+		if err != nil {
+			var cookie *http.Cookie
+			cookie, _, err = h.protection.NewToken()
+			if err != nil {
+				return err
+			}
+			http.SetCookie(writer, cookie)
+			secret, _ = hex.DecodeString(cookie.Value)
+		}
+		h.next.ServeHTTP(&csrfTokenWriter{writer, h.protection.token(secret)}, request)
+		return nil
+	}
+	if err != nil {
+		return NewForbiddenError(err)
+	}
+	token := h.protection.token(secret)
+	submitted := request.Header.Get(CSRFHeaderName)
+	if submitted == "" {
+		submitted = request.PostFormValue(CSRFFieldName)
+		request.PostForm.Del(CSRFFieldName)
+		request.Form.Del(CSRFFieldName)
+	}
+	if !hmac.Equal([]byte(submitted), []byte(token)) {
+		return NewForbiddenError(errors.New("the anti-CSRF token is missing or invalid"))
+	}
+	h.next.ServeHTTP(&csrfTokenWriter{writer, token}, request)
+	return nil
+}
+
+// readCSRFSecret returns the secret of the cookie of the request
+func readCSRFSecret(request *http.Request) ([]byte, error) {
+	cookie, err := request.Cookie(CSRFCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("the anti-CSRF cookie is missing: %w", err)
+	}
+	secret, err := hex.DecodeString(cookie.Value)
+	if err != nil || len(secret) != csrfSecretBytes {
+		return nil, errors.New("the anti-CSRF cookie is invalid")
+	}
+	return secret, nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// csrfTokenWriter implements adapter.CSRFTokenWriter
+type csrfTokenWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+func (w *csrfTokenWriter) CSRFToken() string {
+	return w.token
+}
@@ -0,0 +1,139 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	protection := server.NewCSRFProtection(make([]byte, 32))
+	form := url.Values{"email": {"mike@example.com"}}
+
+	t.Run("given a GET request without cookie, it will set the cookie and give the token to the handler", func(t *testing.T) {
+		next := &formRecorder{}
+		response := httptest.NewRecorder()
+
+		protection.Middleware(next).ServeHTTP(response, tests.NewGetRequest(t, "/sign-in"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertCSRFCookieSet(t, response)
+		if next.token == "" {
+			t.Error("expected the writer to give an anti-CSRF token")
+		}
+	})
+
+	t.Run("given a POST request with a valid token, it will call the next handler without the token field", func(t *testing.T) {
+		next := &formRecorder{}
+		response := httptest.NewRecorder()
+
+		protection.Middleware(next).ServeHTTP(response, tests.NewPostFormRequest(t, protection, "/sign-in", form))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		if !next.called || next.form.Get("email") != "mike@example.com" || next.form.Get(server.CSRFFieldName) != "" {
+			t.Errorf("expected the next handler to get the form without its token, got %v", next.form)
+		}
+	})
+
+	t.Run("given a POST request with the token in a header, it will call the next handler", func(t *testing.T) {
+		cookie, token, err := protection.NewToken()
+		tests.AssertNoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/sign-out", nil)
+		request.AddCookie(cookie)
+		request.Header.Set(server.CSRFHeaderName, token)
+		next := &formRecorder{}
+		response := httptest.NewRecorder()
+
+		protection.Middleware(next).ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("given a POST request without token, it will return Forbidden", func(t *testing.T) {
+		cookie, _, err := protection.NewToken()
+		tests.AssertNoError(t, err)
+		request := tests.NewPostFormRequest(t, nil, "/sign-in", form)
+		request.AddCookie(cookie)
+		next := &formRecorder{}
+		response := httptest.NewRecorder()
+
+		protection.Middleware(next).ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+		if next.called {
+			t.Error("did not expect the next handler to be called")
+		}
+	})
+
+	t.Run("given a POST request without cookie, it will return Forbidden", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		protection.Middleware(&formRecorder{}).ServeHTTP(response, tests.NewPostFormRequest(t, nil, "/sign-in", form))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("given a token signed with another key, it will return Forbidden", func(t *testing.T) {
+		other := server.NewCSRFProtection([]byte("another key of thirty-two bytes!"))
+		response := httptest.NewRecorder()
+
+		protection.Middleware(&formRecorder{}).ServeHTTP(response, tests.NewPostFormRequest(t, other, "/sign-in", form))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("given the token of another cookie, it will return Forbidden", func(t *testing.T) {
+		cookie, _, err := protection.NewToken()
+		tests.AssertNoError(t, err)
+		_, otherToken, err := protection.NewToken()
+		tests.AssertNoError(t, err)
+		request := tests.NewPostFormRequest(t, nil, "/sign-in", url.Values{server.CSRFFieldName: {otherToken}})
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+
+		protection.Middleware(&formRecorder{}).ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+}
+
+// formRecorder records the form and the anti-CSRF token it is called with
+type formRecorder struct {
+	called bool
+	form   url.Values
+	token  string
+}
+
+func (h *formRecorder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	h.called = true
+	if err := request.ParseForm(); err == nil {
+		h.form = request.PostForm
+	}
+	if tokenWriter, ok := writer.(adapter.CSRFTokenWriter); ok {
+		h.token = tokenWriter.CSRFToken()
+	}
+	_, _ = io.WriteString(writer, "ok")
+}
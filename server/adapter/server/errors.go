@@ -67,6 +67,22 @@ func NewForbiddenError(err error) *HTTPError {
 	return &HTTPError{http.StatusForbidden, "Forbidden", err}
 }
 
+// NewNotFoundError creates a new HTTPError that will be converted to a 404 Not Found error
+func NewNotFoundError(err error) *HTTPError {
+	return &HTTPError{http.StatusNotFound, "Not Found", err}
+}
+
+// NewConflictError creates a new HTTPError that will be converted to a 409 Conflict error for end-users
+func NewConflictError(err error, message string) *HTTPError {
+	return &HTTPError{http.StatusConflict, message, err}
+}
+
+// NewTooManyRequestsError creates a new HTTPError that will be converted to a 429 Too Many Requests error
+// for end-users. See SetRetryAfter to tell them when to try again.
+func NewTooManyRequestsError(err error, message string) *HTTPError {
+	return &HTTPError{http.StatusTooManyRequests, message, err}
+}
+
 func (h *HTTPError) Unwrap() error {
 	return h.err
 }
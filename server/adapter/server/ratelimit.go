@@ -0,0 +1,124 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the requests of each client IP address with a token bucket: a client can send
+// burst requests at once, then one more request each interval. It is safe for concurrent use.
+type RateLimiter struct {
+	burst    float64
+	interval time.Duration
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	buckets  map[string]*tokenBucket
+	prunedAt time.Time
+}
+
+// tokenBucket holds the requests a client can still send, as of updatedAt
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewRateLimiter creates a new RateLimiter
+func NewRateLimiter(burst uint, interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		burst:    float64(burst),
+		interval: interval,
+		now:      time.Now,
+		buckets:  make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket of the client. When the bucket is empty, it returns false
+// and how long the client must wait for the next token.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.prune(now)
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+float64(now.Sub(bucket.updatedAt))/float64(l.interval))
+	bucket.updatedAt = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(l.interval))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune forgets the clients whose bucket is full again, once in a while, so that the buckets
+// of all the clients ever seen are not kept
+func (l *RateLimiter) prune(now time.Time) {
+	refill := time.Duration(l.burst * float64(l.interval))
+	if now.Sub(l.prunedAt) < refill {
+		return
+	}
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.updatedAt) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+	l.prunedAt = now
+}
+
+// Middleware rejects the requests of clients who sent too many of them with 429 Too Many Requests
+// and a Retry-After header. It can be given to mux.Router.Use or wrap the handler of a single route.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		allowed, wait := l.Allow(ClientIP(request))
+		if !allowed {
+			SetRetryAfter(writer, wait)
+			http.Error(writer, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// SetRetryAfter tells clients how long to wait before sending their request again, in whole seconds
+func SetRetryAfter(writer http.ResponseWriter, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	writer.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// ClientIP returns the IP address of the client of the request, without its port.
+// Behind a reverse proxy, it is the address of the proxy.
+func ClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,81 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("it allows a burst of requests per client, then tells how long to wait", func(t *testing.T) {
+		limiter := server.NewRateLimiter(2, time.Hour)
+		for i := 0; i < 2; i++ {
+			if allowed, _ := limiter.Allow("192.0.2.1"); !allowed {
+				t.Fatalf("expected request %d of the burst to be allowed", i+1)
+			}
+		}
+		allowed, wait := limiter.Allow("192.0.2.1")
+		if allowed || wait <= 0 || wait > time.Hour {
+			t.Errorf("expected the request to be refused for at most an hour, got %v and %v", allowed, wait)
+		}
+		if allowed, _ = limiter.Allow("192.0.2.2"); !allowed {
+			t.Error("expected the requests of another client to be allowed")
+		}
+	})
+
+	t.Run("it allows requests again once the interval has passed", func(t *testing.T) {
+		limiter := server.NewRateLimiter(1, 10*time.Millisecond)
+		limiter.Allow("192.0.2.1")
+		time.Sleep(50 * time.Millisecond)
+		if allowed, _ := limiter.Allow("192.0.2.1"); !allowed {
+			t.Error("expected the request to be allowed after the interval")
+		}
+	})
+
+	t.Run("its middleware refuses requests with Too Many Requests and a Retry-After header", func(t *testing.T) {
+		limiter := server.NewRateLimiter(1, time.Minute)
+		handler := limiter.Middleware(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writer.WriteHeader(http.StatusNoContent)
+		}))
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/sign-in", nil))
+		tests.AssertStatusEquals(t, response.Code, http.StatusNoContent)
+
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/sign-in", nil))
+		tests.AssertStatusEquals(t, response.Code, http.StatusTooManyRequests)
+		if got := response.Header().Get("Retry-After"); got != "60" {
+			t.Errorf("expected to retry after 60 seconds, got %q", got)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "[2001:db8::1]:54321"
+	if got := server.ClientIP(request); got != "2001:db8::1" {
+		t.Errorf("expected the IP address without its port, got %s", got)
+	}
+}
@@ -26,17 +26,25 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
 	"github.com/swithek/sessionup"
 )
 
 // Register registers routes for the assets and music routes on the given gorilla/mux router.
+// transcoder can be nil, music files are then always served as they are.
 func Register(
 	router *mux.Router,
 	sessionManager *sessionup.Manager,
 	assetsLoader adapter.PathJoiner,
+	musicLibrary music.MusicLibraryFileSystem,
 	musicLoader adapter.PathJoiner,
+	transcoder music.Transcoder,
+	transcodeCache adapter.TranscodeCache,
+	playStore music.PlayStore,
+	nowPlaying music.NowPlayingNotifier,
 ) {
-	musicHandler := sessionManager.Auth(http.StripPrefix("/music/", &musicHandler{musicLoader}))
+	streamHandler := NewStreamHandler(musicLibrary, musicLoader, transcoder, transcodeCache, playStore, nowPlaying)
+	musicHandler := sessionManager.Auth(http.StripPrefix("/music/", streamHandler))
 	assetsHandler := &assetsHandler{assetsLoader}
 
 	router.HandleFunc("/", rootHandler)
@@ -62,14 +70,6 @@ func (a *assetsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 	http.ServeFile(writer, request, a.assetsLoader.Join(cleanedPath))
 }
 
-type musicHandler struct {
-	pathJoiner adapter.PathJoiner
-}
-
-func (m *musicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	http.ServeFile(writer, request, m.pathJoiner.Join(request.URL.Path))
-}
-
 // HandleUnauthorized redirects to /sign-in when users are not authenticated.
 // It is used by sessionup's Auth middleware.
 func HandleUnauthorized(_ error) http.Handler {
@@ -23,6 +23,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	"github.com/gorilla/mux"
 	"github.com/hyzual/mike-sierra-sierra/tests"
@@ -33,7 +34,7 @@ func TestRouter(t *testing.T) {
 	sessionManager := tests.NewValidSessionManager(t)
 	assetsLoader := &stubPathJoiner{filename: ""}
 	musicLoader := &stubPathJoiner{filename: ""}
-	Register(router, sessionManager, assetsLoader, musicLoader)
+	Register(router, sessionManager, assetsLoader, fstest.MapFS{}, musicLoader, nil, nil, nil, nil)
 
 	t.Run("/unknown returns 404", func(t *testing.T) {
 		request := tests.NewGetRequest(t, "/unknown")
@@ -78,22 +79,6 @@ func TestGetAssets(t *testing.T) {
 	})
 }
 
-func TestGetMusic(t *testing.T) {
-	tempFile, removeTempFile := createTempFile(t)
-	defer removeTempFile()
-	musicLoader := &stubPathJoiner{tempFile.Name()}
-	handler := &musicHandler{musicLoader}
-
-	t.Run("returns OK for a path leading to a music file", func(t *testing.T) {
-		request := tests.NewGetRequest(t, "/music/album/amazing-song.mp3")
-		response := httptest.NewRecorder()
-
-		handler.ServeHTTP(response, request)
-
-		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
-	})
-}
-
 func TestUnauthorized(t *testing.T) {
 	handler := HandleUnauthorized(errors.New("Error"))
 	request := tests.NewGetRequest(t, "/app")
@@ -0,0 +1,198 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/swithek/sessionup"
+)
+
+// NewStreamHandler creates a new handler streaming the songs of the music library. Request paths are the paths
+// of the songs in musicLibrary, for example "music/Nightwish/Once/ghost.mp3". Only songs can be streamed,
+// other files of the library are forbidden. musicLoader gives the path of the song files to the transcoder.
+// transcoder can be nil, songs are then always served as they are. It runs one transcode per CPU at most,
+// further transcodes are refused with 503 Service Unavailable. When the listener streams enough of a song,
+// the play is saved in playStore. nowPlaying is told about the songs listeners start; it can be nil.
+func NewStreamHandler(
+	musicLibrary music.MusicLibraryFileSystem,
+	musicLoader adapter.PathJoiner,
+	transcoder music.Transcoder,
+	transcodeCache adapter.TranscodeCache,
+	playStore music.PlayStore,
+	nowPlaying music.NowPlayingNotifier,
+) http.Handler {
+	transcodeSlots := make(chan struct{}, runtime.NumCPU())
+	return &streamHandler{musicLibrary, musicLoader, transcoder, transcodeCache, playStore, nowPlaying, transcodeSlots}
+}
+
+type streamHandler struct {
+	musicLibrary music.MusicLibraryFileSystem
+	pathJoiner   adapter.PathJoiner
+	transcoder   music.Transcoder
+	cache        adapter.TranscodeCache
+	playStore    music.PlayStore
+	nowPlaying   music.NowPlayingNotifier
+	// transcodeSlots holds a value for each transcode running, up to its capacity
+	transcodeSlots chan struct{}
+}
+
+// ServeHTTP streams the song as it is, unless a "format" query parameter asks to transcode it.
+// Songs are served with an ETag and support conditional and Range requests.
+func (s *streamHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	songPath := strings.TrimPrefix(request.URL.Path, "/")
+	song, err := music.OpenSongFile(s.musicLibrary, songPath)
+	if errors.Is(err, music.ErrSongNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if errors.Is(err, music.ErrNotASong) {
+		http.Error(writer, "Only songs can be streamed", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("could not open the song %s: %v", songPath, err)
+		http.Error(writer, "Could not open the song", http.StatusInternalServerError)
+		return
+	}
+	defer song.Close()
+
+	if s.transcoder != nil && request.URL.Query().Get("format") != "" {
+		s.serveTranscoded(writer, request, song)
+		return
+	}
+	content, ok := song.File.(io.ReadSeeker)
+	if !ok {
+		log.Printf("could not stream the song %s: the file cannot seek", songPath)
+		http.Error(writer, "Could not open the song", http.StatusInternalServerError)
+		return
+	}
+	etag := songETag(song)
+	if play := s.startPlay(writer, request, song.Path, etag); play != nil {
+		writer = play
+		defer play.finish(song.Size)
+	}
+	writer.Header().Set("Content-Type", song.Type)
+	writer.Header().Set("ETag", etag)
+	http.ServeContent(writer, request, "", song.ModificationTime, content)
+}
+
+// songETag changes whenever the song file changes
+func songETag(song *music.SongFile) string {
+	return fmt.Sprintf(`"%x-%x"`, song.ModificationTime.UnixNano(), song.Size)
+}
+
+type contextKey int
+
+const listenerKey contextKey = iota
+
+// WithListener tells the stream handler which user listens to the songs, for requests that are not
+// authenticated by a session. For example requests of the Subsonic API.
+func WithListener(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, listenerKey, userID)
+}
+
+// listenerID returns the identifier of the user listening to the songs
+func listenerID(request *http.Request) (uint, bool) {
+	if userID, ok := request.Context().Value(listenerKey).(uint); ok {
+		return userID, true
+	}
+	session, ok := sessionup.FromContext(request.Context())
+	if !ok {
+		return 0, false
+	}
+	userID, err := strconv.ParseUint(session.UserKey, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// startPlay logs the requests that start playing a song. Requests for the rest of a song, HEAD requests
+// and requests for a song the client already has do not start a play. When the listener is known, it returns
+// a writer counting the bytes sent to the listener, otherwise it returns nil.
+func (s *streamHandler) startPlay(writer http.ResponseWriter, request *http.Request, songPath string, etag string) *playback {
+	if request.Method != http.MethodGet || request.Header.Get("If-None-Match") == etag {
+		return nil
+	}
+	if byteRange := request.Header.Get("Range"); byteRange != "" && !strings.HasPrefix(byteRange, "bytes=0-") {
+		return nil
+	}
+	userID, ok := listenerID(request)
+	if !ok {
+		log.Printf("%s plays %s", request.RemoteAddr, songPath)
+		return nil
+	}
+	log.Printf("user #%d plays %s", userID, songPath)
+	if s.nowPlaying != nil {
+		// The notification must not delay the song
+		go s.notifyNowPlaying(userID, songPath)
+	}
+	if s.playStore == nil {
+		return nil
+	}
+	return &playback{ResponseWriter: writer, store: s.playStore, userID: userID, songPath: songPath, startedAt: time.Now()}
+}
+
+func (s *streamHandler) notifyNowPlaying(userID uint, songPath string) {
+	if err := s.nowPlaying.NowPlaying(context.Background(), userID, songPath); err != nil {
+		log.Printf("could not notify that user #%d plays %s: %v", userID, songPath, err)
+	}
+}
+
+// playback counts the bytes of a song sent to a listener, to save the play once enough of the song was sent
+type playback struct {
+	http.ResponseWriter
+	store     music.PlayStore
+	userID    uint
+	songPath  string
+	startedAt time.Time
+	written   int64
+}
+
+func (p *playback) Write(data []byte) (int, error) {
+	written, err := p.ResponseWriter.Write(data)
+	p.written += int64(written)
+	return written, err
+}
+
+// finish saves the play when enough of the song of size bytes was sent
+func (p *playback) finish(size int64) {
+	if music.IsPlayed(p.written, size) {
+		p.record()
+	}
+}
+
+// record saves the play. The request may be over, the play is saved even when the client is gone.
+func (p *playback) record() {
+	err := p.store.RecordPlayOfPath(context.Background(), p.userID, p.songPath, p.startedAt)
+	if err != nil {
+		log.Printf("could not record the play of %s by user #%d: %v", p.songPath, p.userID, err)
+	}
+}
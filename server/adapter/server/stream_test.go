@@ -0,0 +1,202 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestStreamSongs(t *testing.T) {
+	musicLibrary := fstest.MapFS{
+		"music/album/amazing-song.flac": {Data: []byte("original"), ModTime: time.Date(2021, time.May, 8, 12, 0, 0, 0, time.UTC)},
+		"music/album/cover.jpg":         {Data: []byte("jpeg")},
+	}
+	handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, nil, nil)
+
+	t.Run("it streams the song with its audio MIME type and an ETag", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/music/album/amazing-song.flac"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, "audio/flac")
+		if response.Body.String() != "original" || response.Header().Get("ETag") == "" {
+			t.Errorf("expected the song with an ETag, got %q and headers %v", response.Body.String(), response.Header())
+		}
+	})
+
+	t.Run("given the ETag of the song, it returns Not Modified", func(t *testing.T) {
+		first := httptest.NewRecorder()
+		handler.ServeHTTP(first, tests.NewGetRequest(t, "/music/album/amazing-song.flac"))
+		request := tests.NewGetRequest(t, "/music/album/amazing-song.flac")
+		request.Header.Set("If-None-Match", first.Header().Get("ETag"))
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNotModified)
+	})
+
+	t.Run("given a byte range, it streams this part of the song", func(t *testing.T) {
+		request := tests.NewGetRequest(t, "/music/album/amazing-song.flac")
+		request.Header.Set("Range", "bytes=2-5")
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusPartialContent)
+		if response.Body.String() != "igin" || response.Header().Get("Content-Range") != "bytes 2-5/8" {
+			t.Errorf("expected bytes 2 to 5 of the song, got %q and headers %v", response.Body.String(), response.Header())
+		}
+	})
+
+	t.Run("given a file that is not a song, it returns Forbidden", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/music/album/cover.jpg"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	for name, target := range map[string]string{
+		"a missing song":                 "/music/album/missing.flac",
+		"a folder":                       "/music/album",
+		"a path outside of the library":  "/music/../../etc/passwd.mp3",
+		"a path with an empty component": "//music/album/amazing-song.flac",
+	} {
+		t.Run("given "+name+", it returns Not Found", func(t *testing.T) {
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, tests.NewGetRequest(t, target))
+
+			tests.AssertStatusEquals(t, response.Code, http.StatusNotFound)
+		})
+	}
+}
+
+func TestStreamRecordsPlays(t *testing.T) {
+	musicLibrary := fstest.MapFS{"music/album/amazing-song.flac": {Data: []byte("original")}}
+	newListenerRequest := func(t *testing.T) *http.Request {
+		request := tests.NewGetRequest(t, "/music/album/amazing-song.flac")
+		return request.WithContext(WithListener(request.Context(), 27))
+	}
+
+	t.Run("when the listener streams the whole song, it records a play", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, store, nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), newListenerRequest(t))
+
+		if len(store.songPaths) != 1 || store.songPaths[0] != "music/album/amazing-song.flac" || store.userID != 27 {
+			t.Errorf("expected a play of the song by user #27, got %v by user #%d", store.songPaths, store.userID)
+		}
+	})
+
+	t.Run("when the listener streams less than the threshold, it does not record a play", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, store, nil)
+		request := newListenerRequest(t)
+		request.Header.Set("Range", "bytes=0-2")
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		if len(store.songPaths) != 0 {
+			t.Errorf("expected no play, got %v", store.songPaths)
+		}
+	})
+
+	t.Run("when the request continues a song, it does not record another play", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, store, nil)
+		request := newListenerRequest(t)
+		request.Header.Set("Range", "bytes=2-")
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		if len(store.songPaths) != 0 {
+			t.Errorf("expected no play, got %v", store.songPaths)
+		}
+	})
+
+	t.Run("when the listener is unknown, it does not record a play", func(t *testing.T) {
+		store := &stubPlayStore{}
+		handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, store, nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), tests.NewGetRequest(t, "/music/album/amazing-song.flac"))
+
+		if len(store.songPaths) != 0 {
+			t.Errorf("expected no play, got %v", store.songPaths)
+		}
+	})
+}
+
+func TestStreamNotifiesNowPlaying(t *testing.T) {
+	musicLibrary := fstest.MapFS{"music/album/amazing-song.flac": {Data: []byte("original")}}
+	notifier := &stubNowPlayingNotifier{notified: make(chan string, 1)}
+	handler := NewStreamHandler(musicLibrary, &stubPathJoiner{}, nil, nil, nil, notifier)
+	request := tests.NewGetRequest(t, "/music/album/amazing-song.flac")
+
+	handler.ServeHTTP(httptest.NewRecorder(), request.WithContext(WithListener(request.Context(), 27)))
+
+	select {
+	case songPath := <-notifier.notified:
+		if songPath != "music/album/amazing-song.flac" {
+			t.Errorf("unexpected song %s", songPath)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the song to be notified")
+	}
+}
+
+type stubNowPlayingNotifier struct {
+	notified chan string
+}
+
+func (s *stubNowPlayingNotifier) NowPlaying(_ context.Context, _ uint, songPath string) error {
+	s.notified <- songPath
+	return nil
+}
+
+// stubPlayStore records the paths of the songs played
+type stubPlayStore struct {
+	userID    uint
+	songPaths []string
+}
+
+func (s *stubPlayStore) RecordPlay(_ context.Context, _ uint, _ uint, _ time.Time) (*music.Play, error) {
+	return nil, music.ErrSongNotFound
+}
+
+func (s *stubPlayStore) RecordPlayOfPath(_ context.Context, userID uint, songPath string, _ time.Time) error {
+	s.userID = userID
+	s.songPaths = append(s.songPaths, songPath)
+	return nil
+}
+
+func (s *stubPlayStore) ListRecentPlays(_ context.Context, _ uint, _ uint) ([]music.Play, error) {
+	return nil, nil
+}
+
+func (s *stubPlayStore) ListPlayCounts(_ context.Context, _ uint, _ uint) ([]music.SongPlayCount, error) {
+	return nil, nil
+}
@@ -0,0 +1,169 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// serveTranscoded serves the song transcoded following the "format" and "bitrate" query parameters.
+// Finished transcodes are cached, they are served with an ETag and support for Range requests.
+// Transcodes in progress are streamed as ffmpeg produces them. When all the transcode slots are taken,
+// it responds with 503 Service Unavailable: each transcode keeps a CPU busy.
+func (s *streamHandler) serveTranscoded(writer http.ResponseWriter, request *http.Request, song *music.SongFile) {
+	profile, err := parseTranscodingProfile(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filePath := s.pathJoiner.Join(song.Path)
+	if filePath == "" {
+		http.NotFound(writer, request)
+		return
+	}
+	key := transcodeCacheKey(filePath, song, profile)
+	etag := `"` + key + `"`
+	writer.Header().Set("Content-Type", profile.Format.MIMEType)
+
+	cached, err := s.cache.Open(key)
+	if err == nil {
+		defer cached.Close()
+		if play := s.startPlay(writer, request, song.Path, etag); play != nil {
+			writer = play
+			if info, statErr := cached.Stat(); statErr == nil {
+				defer play.finish(info.Size())
+			}
+		}
+		writer.Header().Set("ETag", etag)
+		http.ServeContent(writer, request, "", song.ModificationTime, cached)
+		return
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("could not read the cached transcode of %s: %v", filePath, err)
+	}
+
+	select {
+	case s.transcodeSlots <- struct{}{}:
+		defer func() { <-s.transcodeSlots }()
+	default:
+		writer.Header().Set("Retry-After", "10")
+		http.Error(writer, "Too many songs are being transcoded, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+	play := s.startPlay(writer, request, song.Path, etag)
+	pending, err := s.cache.Create(key)
+	if err != nil {
+		log.Printf("could not cache the transcode of %s: %v", filePath, err)
+	}
+	output := &transcodeWriter{client: writer, pending: pending}
+	// The length of the transcode is not known before it ends
+	writer.Header().Set("Accept-Ranges", "none")
+	err = s.transcoder.Transcode(request.Context(), filePath, profile, output)
+	if err != nil {
+		output.abort()
+		if output.written == 0 {
+			http.Error(writer, "Could not transcode the music file", http.StatusInternalServerError)
+		}
+		log.Printf("could not transcode %s to %s: %v", filePath, profile, err)
+		return
+	}
+	if err = output.commit(); err != nil {
+		log.Printf("could not cache the transcode of %s: %v", filePath, err)
+	}
+	// The whole transcode was sent
+	if play != nil {
+		play.record()
+	}
+}
+
+func parseTranscodingProfile(query url.Values) (music.TranscodingProfile, error) {
+	var bitrate uint64
+	if value := query.Get("bitrate"); value != "" {
+		var err error
+		bitrate, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return music.TranscodingProfile{}, errors.New("Bitrate must be a positive integer in kbit/s")
+		}
+	}
+	profile, err := music.NewTranscodingProfile(query.Get("format"), uint(bitrate))
+	if err != nil {
+		return music.TranscodingProfile{}, fmt.Errorf("Invalid transcoding parameters: %w", err)
+	}
+	return profile, nil
+}
+
+// transcodeCacheKey changes whenever the music file changes
+func transcodeCacheKey(filePath string, song *music.SongFile, profile music.TranscodingProfile) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s\x00%d\x00%d\x00%s",
+		filePath,
+		song.ModificationTime.UnixNano(),
+		song.Size,
+		profile,
+	)))
+	return hex.EncodeToString(hash[:])
+}
+
+// transcodeWriter streams the transcode to the client and copies it to the cache. When the cache
+// cannot be written, it gives up caching but keeps streaming.
+type transcodeWriter struct {
+	client  io.Writer
+	pending *adapter.PendingFile // nil when the transcode is not cached
+	written int64
+}
+
+func (t *transcodeWriter) Write(data []byte) (int, error) {
+	written, err := t.client.Write(data)
+	t.written += int64(written)
+	if err != nil {
+		return written, err
+	}
+	if t.pending != nil {
+		if _, cacheErr := t.pending.Write(data); cacheErr != nil {
+			log.Printf("could not cache the transcode %s: %v", t.pending.Name(), cacheErr)
+			t.abort()
+		}
+	}
+	return written, nil
+}
+
+func (t *transcodeWriter) commit() error {
+	if t.pending == nil {
+		return nil
+	}
+	return t.pending.Commit()
+}
+
+func (t *transcodeWriter) abort() {
+	if t.pending != nil {
+		t.pending.Abort()
+		t.pending = nil
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetTranscodedMusic(t *testing.T) {
+	musicFile := filepath.Join(t.TempDir(), "amazing-song.flac")
+	if err := os.WriteFile(musicFile, []byte("original"), 0o600); err != nil {
+		t.Fatalf("could not create the music file %v", err)
+	}
+
+	t.Run("without format, it serves the music file as it is", func(t *testing.T) {
+		transcoder := &stubTranscoder{output: "transcoded"}
+		handler := newTranscodingMusicHandler(t, musicFile, transcoder)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		if response.Body.String() != "original" || transcoder.calls != 0 {
+			t.Errorf("expected the original music file, got %q", response.Body.String())
+		}
+	})
+
+	t.Run("given a format, it streams the transcoded music file and caches it", func(t *testing.T) {
+		transcoder := &stubTranscoder{output: "transcoded"}
+		handler := newTranscodingMusicHandler(t, musicFile, transcoder)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac?format=opus&bitrate=96"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, "audio/ogg")
+		if response.Body.String() != "transcoded" || transcoder.profile.Bitrate != 96 {
+			t.Errorf("expected the music file transcoded at 96 kbit/s, got %q at %d kbit/s", response.Body.String(), transcoder.profile.Bitrate)
+		}
+
+		request := tests.NewGetRequest(t, "/amazing-song.flac?format=opus&bitrate=96")
+		request.Header.Set("Range", "bytes=5-")
+		cachedResponse := httptest.NewRecorder()
+		handler.ServeHTTP(cachedResponse, request)
+
+		tests.AssertStatusEquals(t, cachedResponse.Code, http.StatusPartialContent)
+		tests.AssertContentTypeHeaderEquals(t, cachedResponse, "audio/ogg")
+		if cachedResponse.Body.String() != "coded" || transcoder.calls != 1 {
+			t.Errorf("expected a range of the cached transcode, got %q after %d transcodes", cachedResponse.Body.String(), transcoder.calls)
+		}
+		if cachedResponse.Header().Get("ETag") == "" {
+			t.Error("expected the cached transcode to have an ETag")
+		}
+	})
+
+	for _, query := range []string{"format=wav", "format=opus&bitrate=high", "format=mp3&bitrate=1000"} {
+		t.Run("given invalid transcoding parameters "+query+", it returns Bad Request", func(t *testing.T) {
+			handler := newTranscodingMusicHandler(t, musicFile, &stubTranscoder{})
+			response := httptest.NewRecorder()
+
+			handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac?"+query))
+
+			tests.AssertStatusEquals(t, response.Code, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("given a path that does not lead to a file, it returns Not Found", func(t *testing.T) {
+		handler := newTranscodingMusicHandler(t, musicFile, &stubTranscoder{})
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/missing.flac?format=opus"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("when all the transcode slots are taken, it responds with 503 Service Unavailable", func(t *testing.T) {
+		transcoder := &stubTranscoder{output: "transcoded"}
+		handler := newTranscodingMusicHandler(t, musicFile, transcoder)
+		handler.transcodeSlots <- struct{}{}
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac?format=mp3"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusServiceUnavailable)
+		if transcoder.calls != 0 || response.Header().Get("Retry-After") == "" {
+			t.Errorf("expected the client to retry later, got %d transcodes and headers %v", transcoder.calls, response.Header())
+		}
+	})
+
+	t.Run("when the transcoding fails before any output, it returns an error and caches nothing", func(t *testing.T) {
+		transcoder := &stubTranscoder{shouldError: true}
+		handler := newTranscodingMusicHandler(t, musicFile, transcoder)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac?format=mp3"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusInternalServerError)
+		assertTranscodeCacheIsEmpty(t, handler)
+	})
+
+	t.Run("when the transcoding is interrupted, it caches nothing", func(t *testing.T) {
+		transcoder := &stubTranscoder{output: "partial", shouldError: true}
+		handler := newTranscodingMusicHandler(t, musicFile, transcoder)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, "/amazing-song.flac?format=mp3"))
+
+		if response.Body.String() != "partial" {
+			t.Errorf("expected the partial transcode to be streamed, got %q", response.Body.String())
+		}
+		assertTranscodeCacheIsEmpty(t, handler)
+	})
+}
+
+func newTranscodingMusicHandler(t *testing.T, musicFile string, transcoder music.Transcoder) *streamHandler {
+	t.Helper()
+	cache, err := adapter.NewDiskTranscodeCache(t.TempDir(), 1<<20)
+	tests.AssertNoError(t, err)
+	musicLibrary := os.DirFS(filepath.Dir(musicFile)).(fs.ReadDirFS)
+	return &streamHandler{musicLibrary, &stubPathJoiner{musicFile}, transcoder, cache, nil, nil, make(chan struct{}, 1)}
+}
+
+func assertTranscodeCacheIsEmpty(t *testing.T, handler *streamHandler) {
+	t.Helper()
+	file, err := handler.cache.Create("probe")
+	tests.AssertNoError(t, err)
+	file.Abort()
+	entries, _ := os.ReadDir(filepath.Dir(file.Name()))
+	if len(entries) != 0 {
+		t.Errorf("expected the transcode cache to be empty, got %v", entries)
+	}
+}
+
+type stubTranscoder struct {
+	output      string
+	shouldError bool
+	calls       int
+	profile     music.TranscodingProfile
+}
+
+func (s *stubTranscoder) Transcode(_ context.Context, _ string, profile music.TranscodingProfile, output io.Writer) error {
+	s.calls++
+	s.profile = profile
+	if s.output != "" {
+		if _, err := io.WriteString(output, s.output); err != nil {
+			return err
+		}
+	}
+	if s.shouldError {
+		return errors.New("This error should be expected in tests")
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// InvitationLifetime is how long invitation links can be used after they are created
+	InvitationLifetime   = 7 * 24 * time.Hour
+	invitationTokenBytes = 16
+	// PasswordResetLifetime is how long password reset links can be used after they are created
+	PasswordResetLifetime = 24 * time.Hour
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches the given identifier
+	ErrUserNotFound = errors.New("the user could not be found")
+	// ErrUserAlreadyExists is returned when another user has the same email or username
+	ErrUserAlreadyExists = errors.New("a user with the same email or username already exists")
+	// ErrLastAdministrator is returned when a change would leave no active administrator
+	ErrLastAdministrator = errors.New("the last active administrator cannot be demoted, disabled or deleted")
+	// ErrInvitationNotFound is returned when an invitation token does not match or has expired
+	ErrInvitationNotFound = errors.New("the invitation could not be found or has expired")
+	// ErrPasswordResetNotFound is returned when a password reset token does not match or has expired
+	ErrPasswordResetNotFound = errors.New("the password reset could not be found or has expired")
+)
+
+// AccountStore handles database operations related to the management of user accounts by administrators
+type AccountStore interface {
+	ListUsers(ctx context.Context) ([]Account, error)
+	// GetUser returns ErrUserNotFound when there is no such user
+	GetUser(ctx context.Context, userID uint) (*Account, error)
+	// SaveUser creates the account of the registration.
+	// It returns ErrUserAlreadyExists when another user has the same email or username.
+	SaveUser(ctx context.Context, registration *Registration) (*Account, error)
+	// UpdateUser changes the role of the user and enables or disables them. Disabled users cannot sign in.
+	// It returns ErrUserNotFound when there is no such user and ErrLastAdministrator when
+	// no active administrator would be left.
+	UpdateUser(ctx context.Context, userID uint, role Role, disabled bool) error
+	// DeleteUser deletes the user and their playlists. It returns ErrUserNotFound when there is
+	// no such user and ErrLastAdministrator when no active administrator would be left.
+	DeleteUser(ctx context.Context, userID uint) error
+	// UpdatePassword replaces the password hash of the user. It returns ErrUserNotFound when there is no such user.
+	UpdatePassword(ctx context.Context, userID uint, passwordHash []byte) error
+	// CreateInvitation creates a single-use invitation to register an account with the given role.
+	// It expires after InvitationLifetime.
+	CreateInvitation(ctx context.Context, role Role, createdBy uint) (*Invitation, error)
+	// GetInvitation returns ErrInvitationNotFound when the token does not match or has expired
+	GetInvitation(ctx context.Context, token string) (*Invitation, error)
+	// AcceptInvitation creates the account of the registration with the role of the invitation and
+	// removes the invitation. The role of the registration is ignored. It returns ErrInvitationNotFound
+	// when the token does not match or has expired, and ErrUserAlreadyExists like SaveUser.
+	AcceptInvitation(ctx context.Context, token string, registration *Registration) (*Account, error)
+	// CreatePasswordReset creates a single-use link to let the user choose a new password. It replaces
+	// the previous links of the user and expires after PasswordResetLifetime. It returns ErrUserNotFound
+	// when there is no such user.
+	CreatePasswordReset(ctx context.Context, userID uint, createdBy uint) (*PasswordReset, error)
+	// GetPasswordReset returns ErrPasswordResetNotFound when the token does not match or has expired
+	GetPasswordReset(ctx context.Context, token string) (*PasswordReset, error)
+	// ResetPassword replaces the password hash of the user of the link and removes the link.
+	// It returns ErrPasswordResetNotFound when the token does not match or has expired.
+	ResetPassword(ctx context.Context, token string, passwordHash []byte) (*PasswordReset, error)
+}
+
+// Account represents a user as seen by administrators
+type Account struct {
+	ID       uint
+	Email    string
+	Username string
+	Role     Role
+	Disabled bool // Disabled users cannot sign in nor use the Subsonic API
+}
+
+// Invitation lets someone register an account without an administrator typing their password.
+// Only a hash of the token is saved, the token itself is only known when the invitation is created.
+type Invitation struct {
+	Token     string // Secret part of the invitation link. For example "9b1c54a3e0f24d7a8f5e6b2c1d0a9e8f"
+	Role      Role   // Role of the account registered with the invitation
+	ExpiresAt time.Time
+}
+
+// PasswordReset lets a user choose a new password without knowing the current one.
+// Only a hash of the token is saved, the token itself is only known when the link is created.
+type PasswordReset struct {
+	Token     string // Secret part of the password reset link. For example "9b1c54a3e0f24d7a8f5e6b2c1d0a9e8f"
+	UserID    uint
+	ExpiresAt time.Time
+}
+
+// AccountDAO implements AccountStore
+type AccountDAO struct {
+	db *sql.DB
+}
+
+// NewAccountDAO creates a new AccountDAO
+func NewAccountDAO(db *sql.DB) *AccountDAO {
+	return &AccountDAO{db}
+}
+
+// ListUsers returns all users, ordered by username
+func (d *AccountDAO) ListUsers(ctx context.Context) ([]Account, error) {
+	query := `SELECT user.id, user.email, user.username, user.role, user.disabled FROM user
+		ORDER BY user.username COLLATE NOCASE, user.id`
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the users: %w", err)
+	}
+	defer rows.Close()
+	var accounts []Account
+	for rows.Next() {
+		var account Account
+		if err = rows.Scan(&account.ID, &account.Email, &account.Username, &account.Role, &account.Disabled); err != nil {
+			return nil, fmt.Errorf("Could not read a user: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// GetUser returns the user identified by userID
+func (d *AccountDAO) GetUser(ctx context.Context, userID uint) (*Account, error) {
+	query := `SELECT user.id, user.email, user.username, user.role, user.disabled FROM user WHERE user.id = ?`
+	var account Account
+	err := d.db.QueryRowContext(ctx, query, userID).
+		Scan(&account.ID, &account.Email, &account.Username, &account.Role, &account.Disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the user #%d: %w", userID, err)
+	}
+	return &account, nil
+}
+
+// SaveUser creates the account of the registration
+func (d *AccountDAO) SaveUser(ctx context.Context, registration *Registration) (*Account, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+	account, err := insertUser(ctx, tx, registration)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Could not commit the new user %v: %w", registration.Username, err)
+	}
+	return account, nil
+}
+
+func insertUser(ctx context.Context, tx *sql.Tx, registration *Registration) (*Account, error) {
+	var exists bool
+	existsQuery := `SELECT EXISTS(SELECT 1 FROM user WHERE user.email = ? OR user.username = ?)`
+	if err := tx.QueryRowContext(ctx, existsQuery, registration.Email, registration.Username).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("Could not check whether the user %v exists: %w", registration.Username, err)
+	}
+	if exists {
+		return nil, ErrUserAlreadyExists
+	}
+	query := `INSERT INTO user(email, password, username, role) VALUES (?, ?, ?, ?)`
+	result, err := tx.ExecContext(
+		ctx,
+		query,
+		registration.Email,
+		registration.PasswordHash,
+		registration.Username,
+		registration.Role,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the new user %v: %w", registration.Username, err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the identifier of the new user %v: %w", registration.Username, err)
+	}
+	return &Account{
+		ID:       uint(userID),
+		Email:    registration.Email,
+		Username: registration.Username,
+		Role:     registration.Role,
+	}, nil
+}
+
+// UpdateUser changes the role of the user and enables or disables them
+func (d *AccountDAO) UpdateUser(ctx context.Context, userID uint, role Role, disabled bool) error {
+	return d.edit(ctx, userID, func(tx *sql.Tx) error {
+		query := `UPDATE user SET role = ?, disabled = ? WHERE user.id = ?`
+		if _, err := tx.ExecContext(ctx, query, role, disabled, userID); err != nil {
+			return fmt.Errorf("Could not update the user #%d: %w", userID, err)
+		}
+		return nil
+	})
+}
+
+// DeleteUser deletes the user. Triggers delete their playlists and invitations.
+func (d *AccountDAO) DeleteUser(ctx context.Context, userID uint) error {
+	return d.edit(ctx, userID, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user WHERE user.id = ?`, userID); err != nil {
+			return fmt.Errorf("Could not delete the user #%d: %w", userID, err)
+		}
+		return nil
+	})
+}
+
+// UpdatePassword replaces the password hash of the user
+func (d *AccountDAO) UpdatePassword(ctx context.Context, userID uint, passwordHash []byte) error {
+	query := `UPDATE user SET password = ? WHERE user.id = ?`
+	result, err := d.db.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("Could not update the password of user #%d: %w", userID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Could not check whether the password of user #%d was updated: %w", userID, err)
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// edit runs the change in a transaction. It rolls back changes that leave no active administrator.
+func (d *AccountDAO) edit(ctx context.Context, userID uint, change func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM user WHERE user.id = ?)`, userID).Scan(&exists); err != nil {
+		return fmt.Errorf("Could not check whether the user #%d exists: %w", userID, err)
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	if err = change(tx); err != nil {
+		return err
+	}
+	var administrators uint
+	query := `SELECT COUNT(*) FROM user WHERE user.role = ? AND user.disabled = 0`
+	if err = tx.QueryRowContext(ctx, query, RoleAdministrator).Scan(&administrators); err != nil {
+		return fmt.Errorf("Could not count the active administrators: %w", err)
+	}
+	if administrators == 0 {
+		return ErrLastAdministrator
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Could not commit the changes to user #%d: %w", userID, err)
+	}
+	return nil
+}
+
+// CreateInvitation creates a single-use invitation to register an account with the given role
+func (d *AccountDAO) CreateInvitation(ctx context.Context, role Role, createdBy uint) (*Invitation, error) {
+	randomBytes := make([]byte, invitationTokenBytes)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, fmt.Errorf("Could not generate an invitation token: %w", err)
+	}
+	invitation := &Invitation{
+		Token:     hex.EncodeToString(randomBytes),
+		Role:      role,
+		ExpiresAt: time.Now().Add(InvitationLifetime),
+	}
+	query := `INSERT INTO invitation(token_hash, role, created_by, expires_at) VALUES (?, ?, ?, ?)`
+	_, err := d.db.ExecContext(ctx, query, hashToken(invitation.Token), role, createdBy, invitation.ExpiresAt.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+// GetInvitation returns the invitation matching the token
+func (d *AccountDAO) GetInvitation(ctx context.Context, token string) (*Invitation, error) {
+	return getInvitation(ctx, d.db, token)
+}
+
+// AcceptInvitation creates the account of the registration with the role of the invitation
+func (d *AccountDAO) AcceptInvitation(ctx context.Context, token string, registration *Registration) (*Account, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	invitation, err := getInvitation(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+	invited := *registration
+	invited.Role = invitation.Role
+	account, err := insertUser(ctx, tx, &invited)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM invitation WHERE token_hash = ?`, hashToken(token)); err != nil {
+		return nil, fmt.Errorf("Could not remove the accepted invitation: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Could not commit the new user %v: %w", registration.Username, err)
+	}
+	return account, nil
+}
+
+// CreatePasswordReset creates a single-use link to let the user choose a new password
+func (d *AccountDAO) CreatePasswordReset(ctx context.Context, userID uint, createdBy uint) (*PasswordReset, error) {
+	randomBytes := make([]byte, invitationTokenBytes)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, fmt.Errorf("Could not generate a password reset token: %w", err)
+	}
+	reset := &PasswordReset{
+		Token:     hex.EncodeToString(randomBytes),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(PasswordResetLifetime),
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM user WHERE user.id = ?)`, userID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("Could not check whether the user #%d exists: %w", userID, err)
+	}
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	query := `DELETE FROM password_reset WHERE password_reset.user_id = ? OR password_reset.expires_at <= ?`
+	if _, err = tx.ExecContext(ctx, query, userID, time.Now().Unix()); err != nil {
+		return nil, fmt.Errorf("Could not remove the previous password resets of user #%d: %w", userID, err)
+	}
+	query = `INSERT INTO password_reset(token_hash, user_id, created_by, expires_at) VALUES (?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query, hashToken(reset.Token), userID, createdBy, reset.ExpiresAt.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the password reset of user #%d: %w", userID, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Could not commit the password reset of user #%d: %w", userID, err)
+	}
+	return reset, nil
+}
+
+// GetPasswordReset returns the password reset matching the token
+func (d *AccountDAO) GetPasswordReset(ctx context.Context, token string) (*PasswordReset, error) {
+	return getPasswordReset(ctx, d.db, token)
+}
+
+// ResetPassword replaces the password hash of the user of the link
+func (d *AccountDAO) ResetPassword(ctx context.Context, token string, passwordHash []byte) (*PasswordReset, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	reset, err := getPasswordReset(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE user SET password = ? WHERE user.id = ?`, passwordHash, reset.UserID); err != nil {
+		return nil, fmt.Errorf("Could not update the password of user #%d: %w", reset.UserID, err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM password_reset WHERE token_hash = ?`, hashToken(token)); err != nil {
+		return nil, fmt.Errorf("Could not remove the used password reset: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Could not commit the new password of user #%d: %w", reset.UserID, err)
+	}
+	return reset, nil
+}
+
+// rowQueryer is implemented by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getInvitation(ctx context.Context, db rowQueryer, token string) (*Invitation, error) {
+	query := `SELECT invitation.role, invitation.expires_at FROM invitation
+		WHERE invitation.token_hash = ? AND invitation.expires_at > ?`
+	invitation := &Invitation{Token: token}
+	var expiresAt int64
+	err := db.QueryRowContext(ctx, query, hashToken(token), time.Now().Unix()).Scan(&invitation.Role, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the invitation: %w", err)
+	}
+	invitation.ExpiresAt = time.Unix(expiresAt, 0)
+	return invitation, nil
+}
+
+func getPasswordReset(ctx context.Context, db rowQueryer, token string) (*PasswordReset, error) {
+	query := `SELECT password_reset.user_id, password_reset.expires_at FROM password_reset
+		WHERE password_reset.token_hash = ? AND password_reset.expires_at > ?`
+	reset := &PasswordReset{Token: token}
+	var expiresAt int64
+	err := db.QueryRowContext(ctx, query, hashToken(token), time.Now().Unix()).Scan(&reset.UserID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPasswordResetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the password reset: %w", err)
+	}
+	reset.ExpiresAt = time.Unix(expiresAt, 0)
+	return reset, nil
+}
+
+// hashToken hashes secret tokens before saving them, so that a leaked database does not leak working links
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
@@ -0,0 +1,311 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestFirstAdministrator(t *testing.T) {
+	ctx := context.Background()
+	db := tests.NewDatabase(t)
+	dao := NewDAO(db)
+
+	hasAdministrator, err := dao.HasAdministrator(ctx)
+	tests.AssertNoError(t, err)
+	if hasAdministrator {
+		t.Fatal("expected no administrator in a new database")
+	}
+
+	tests.AssertNoError(t, dao.SaveFirstAdministrator(ctx, newTestRegistration("admin", RoleListener)))
+	hasAdministrator, err = dao.HasAdministrator(ctx)
+	tests.AssertNoError(t, err)
+	if !hasAdministrator {
+		t.Error("expected the first user to be an administrator")
+	}
+
+	err = dao.SaveFirstAdministrator(ctx, newTestRegistration("other", RoleAdministrator))
+	if !errors.Is(err, ErrAdministratorExists) {
+		t.Errorf("expected ErrAdministratorExists, got %v", err)
+	}
+}
+
+func TestAccountDAO(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it saves and lists users", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		listener, err := dao.SaveUser(ctx, newTestRegistration("Bob", RoleListener))
+		tests.AssertNoError(t, err)
+
+		accounts, err := dao.ListUsers(ctx)
+		tests.AssertNoError(t, err)
+		if len(accounts) != 2 || accounts[1] != *listener {
+			t.Errorf("expected the administrator and the listener, got %v", accounts)
+		}
+	})
+
+	t.Run("given an email or username that is already used, it refuses to save the user", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		sameEmail := newTestRegistration("Bob", RoleListener)
+		sameEmail.Email = "admin@example.com"
+
+		for _, registration := range []*Registration{sameEmail, newTestRegistration("admin", RoleListener)} {
+			_, err := dao.SaveUser(ctx, registration)
+			if !errors.Is(err, ErrUserAlreadyExists) {
+				t.Errorf("expected ErrUserAlreadyExists, got %v", err)
+			}
+		}
+	})
+
+	t.Run("disabled users cannot sign in", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		listener, _ := dao.SaveUser(ctx, newTestRegistration("Bob", RoleListener))
+
+		tests.AssertNoError(t, dao.UpdateUser(ctx, listener.ID, RoleListener, true))
+		_, err := NewDAO(db).GetUserMatchingEmail(ctx, listener.Email)
+		tests.AssertError(t, err)
+		_, err = NewDAO(db).GetSubsonicCredentials(ctx, listener.Username)
+		tests.AssertError(t, err)
+	})
+
+	t.Run("it saves and revokes the encrypted Subsonic password", func(t *testing.T) {
+		_, db := newAccountDAOWithAdministrator(t)
+		dao := NewDAO(db)
+		const administratorID = 1
+
+		tests.AssertNoError(t, dao.SaveSubsonicPassword(ctx, administratorID, []byte{0, 42}))
+		credentials, err := dao.GetSubsonicCredentials(ctx, "admin")
+		tests.AssertNoError(t, err)
+		if !bytes.Equal(credentials.EncryptedSubsonicPassword, []byte{0, 42}) {
+			t.Errorf("expected the encrypted password to be saved as is, got %v", credentials.EncryptedSubsonicPassword)
+		}
+
+		tests.AssertNoError(t, dao.SaveSubsonicPassword(ctx, administratorID, nil))
+		credentials, err = dao.GetSubsonicCredentials(ctx, "admin")
+		tests.AssertNoError(t, err)
+		if credentials.EncryptedSubsonicPassword != nil {
+			t.Errorf("expected the password to be revoked, got %v", credentials.EncryptedSubsonicPassword)
+		}
+	})
+
+	t.Run("it refuses to demote, disable or delete the last active administrator", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		const administratorID = 1
+
+		changes := map[string]error{
+			"demote":  dao.UpdateUser(ctx, administratorID, RoleListener, false),
+			"disable": dao.UpdateUser(ctx, administratorID, RoleAdministrator, true),
+			"delete":  dao.DeleteUser(ctx, administratorID),
+		}
+		for change, err := range changes {
+			if !errors.Is(err, ErrLastAdministrator) {
+				t.Errorf("expected ErrLastAdministrator when trying to %s, got %v", change, err)
+			}
+		}
+		administrator, err := dao.GetUser(ctx, administratorID)
+		tests.AssertNoError(t, err)
+		if administrator.Role != RoleAdministrator || administrator.Disabled {
+			t.Errorf("expected the administrator to be unchanged, got %v", administrator)
+		}
+	})
+
+	t.Run("it deletes the user and their playlists", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		listener, _ := dao.SaveUser(ctx, newTestRegistration("Bob", RoleListener))
+		_, err := db.Exec(`INSERT INTO playlist(user_id, name) VALUES (?, 'Road trip')`, listener.ID)
+		tests.AssertNoError(t, err)
+
+		tests.AssertNoError(t, dao.DeleteUser(ctx, listener.ID))
+		if _, err = dao.GetUser(ctx, listener.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+		var playlists int
+		tests.AssertNoError(t, db.QueryRow(`SELECT COUNT(*) FROM playlist`).Scan(&playlists))
+		if playlists != 0 {
+			t.Errorf("expected the playlists of the user to be deleted, got %d", playlists)
+		}
+	})
+
+	t.Run("it replaces the password of the user", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+
+		tests.AssertNoError(t, dao.UpdatePassword(ctx, 1, []byte("new-hash")))
+		match, err := NewDAO(db).GetUserMatchingEmail(ctx, "admin@example.com")
+		tests.AssertNoError(t, err)
+		if string(match.PasswordHash) != "new-hash" {
+			t.Errorf("expected the password hash to be replaced, got %s", match.PasswordHash)
+		}
+	})
+
+	t.Run("given an unknown user, it returns ErrUserNotFound", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+
+		if err := dao.UpdatePassword(ctx, 404, []byte("hash")); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+
+		if err := dao.UpdateUser(ctx, 404, RoleListener, false); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+		if err := dao.DeleteUser(ctx, 404); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
+
+func TestInvitations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("an invitation registers a user with its role, once", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		invitation, err := dao.CreateInvitation(ctx, RoleAdministrator, 1)
+		tests.AssertNoError(t, err)
+
+		found, err := dao.GetInvitation(ctx, invitation.Token)
+		tests.AssertNoError(t, err)
+		if found.Role != RoleAdministrator {
+			t.Errorf("unexpected invitation %v", found)
+		}
+
+		account, err := dao.AcceptInvitation(ctx, invitation.Token, newTestRegistration("Bob", RoleListener))
+		tests.AssertNoError(t, err)
+		if account.Role != RoleAdministrator {
+			t.Errorf("expected the invited user to have the role of the invitation, got %v", account)
+		}
+		_, err = dao.AcceptInvitation(ctx, invitation.Token, newTestRegistration("Carol", RoleListener))
+		if !errors.Is(err, ErrInvitationNotFound) {
+			t.Errorf("expected ErrInvitationNotFound, got %v", err)
+		}
+	})
+
+	t.Run("when the username is already used, the invitation can still be used", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		invitation, _ := dao.CreateInvitation(ctx, RoleListener, 1)
+
+		_, err := dao.AcceptInvitation(ctx, invitation.Token, newTestRegistration("admin", RoleListener))
+		if !errors.Is(err, ErrUserAlreadyExists) {
+			t.Errorf("expected ErrUserAlreadyExists, got %v", err)
+		}
+		_, err = dao.GetInvitation(ctx, invitation.Token)
+		tests.AssertNoError(t, err)
+	})
+
+	t.Run("expired or unknown invitations are not found", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		invitation, _ := dao.CreateInvitation(ctx, RoleListener, 1)
+		_, err := db.Exec(`UPDATE invitation SET expires_at = 0`)
+		tests.AssertNoError(t, err)
+
+		for _, token := range []string{invitation.Token, "unknown"} {
+			if _, err = dao.GetInvitation(ctx, token); !errors.Is(err, ErrInvitationNotFound) {
+				t.Errorf("expected ErrInvitationNotFound, got %v", err)
+			}
+		}
+	})
+}
+
+func TestPasswordResets(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a password reset replaces the password of its user, once", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		reset, err := dao.CreatePasswordReset(ctx, 1, 1)
+		tests.AssertNoError(t, err)
+
+		found, err := dao.GetPasswordReset(ctx, reset.Token)
+		tests.AssertNoError(t, err)
+		if found.UserID != 1 {
+			t.Errorf("unexpected password reset %v", found)
+		}
+
+		used, err := dao.ResetPassword(ctx, reset.Token, []byte("new hash"))
+		tests.AssertNoError(t, err)
+		if used.UserID != 1 {
+			t.Errorf("unexpected password reset %v", used)
+		}
+		var passwordHash []byte
+		tests.AssertNoError(t, db.QueryRow(`SELECT password FROM user WHERE id = 1`).Scan(&passwordHash))
+		if string(passwordHash) != "new hash" {
+			t.Errorf("expected the password to be replaced, got %q", passwordHash)
+		}
+		_, err = dao.ResetPassword(ctx, reset.Token, []byte("other hash"))
+		if !errors.Is(err, ErrPasswordResetNotFound) {
+			t.Errorf("expected ErrPasswordResetNotFound, got %v", err)
+		}
+	})
+
+	t.Run("a new password reset replaces the previous one of the user", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		previous, err := dao.CreatePasswordReset(ctx, 1, 1)
+		tests.AssertNoError(t, err)
+
+		_, err = dao.CreatePasswordReset(ctx, 1, 1)
+		tests.AssertNoError(t, err)
+
+		if _, err = dao.GetPasswordReset(ctx, previous.Token); !errors.Is(err, ErrPasswordResetNotFound) {
+			t.Errorf("expected ErrPasswordResetNotFound, got %v", err)
+		}
+	})
+
+	t.Run("given an unknown user, it will return ErrUserNotFound", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+
+		if _, err := dao.CreatePasswordReset(ctx, 404, 1); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("expired or unknown password resets are not found", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		reset, _ := dao.CreatePasswordReset(ctx, 1, 1)
+		_, err := db.Exec(`UPDATE password_reset SET expires_at = 0`)
+		tests.AssertNoError(t, err)
+
+		for _, token := range []string{reset.Token, "unknown"} {
+			if _, err = dao.GetPasswordReset(ctx, token); !errors.Is(err, ErrPasswordResetNotFound) {
+				t.Errorf("expected ErrPasswordResetNotFound, got %v", err)
+			}
+		}
+	})
+}
+
+// newAccountDAOWithAdministrator returns a DAO whose database contains the administrator #1 named "admin"
+func newAccountDAOWithAdministrator(t *testing.T) (*AccountDAO, *sql.DB) {
+	t.Helper()
+	db := tests.NewDatabase(t)
+	if err := NewDAO(db).SaveFirstAdministrator(context.Background(), newTestRegistration("admin", RoleAdministrator)); err != nil {
+		t.Fatalf("could not save the administrator: %v", err)
+	}
+	return NewAccountDAO(db), db
+}
+
+func newTestRegistration(username string, role Role) *Registration {
+	return &Registration{
+		Email:        username + "@example.com",
+		PasswordHash: testPasswordHash,
+		Username:     username,
+		Role:         role,
+	}
+}
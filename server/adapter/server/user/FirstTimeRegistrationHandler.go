@@ -18,6 +18,7 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -35,22 +36,36 @@ const (
 	bcryptWork            = 12
 )
 
-// NewFirstTimeRegistrationGetHandler creates a new handler for GET /first-time-registration
+// ErrPasswordTooLong is returned when a password is longer than 64 characters
+var ErrPasswordTooLong = errors.New("password is too long")
+
+// NewFirstTimeRegistrationGetHandler creates a new handler for GET /first-time-registration.
+// Once an administrator exists, it redirects to /sign-in.
 func NewFirstTimeRegistrationGetHandler(
 	te adapter.TemplateExecutor,
 	ar adapter.AssetsResolver,
+	us Store,
 ) http.Handler {
 	return server.WrapErrors(
-		&getFirstTimeRegistrationHandler{te, ar},
+		&getFirstTimeRegistrationHandler{te, ar, us},
 	)
 }
 
 type getFirstTimeRegistrationHandler struct {
 	templateExecutor adapter.TemplateExecutor
 	assetsResolver   adapter.AssetsResolver
+	userStore        Store
 }
 
 func (h *getFirstTimeRegistrationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	hasAdministrator, err := h.userStore.HasAdministrator(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while checking whether an administrator exists: %w", err)
+	}
+	if hasAdministrator {
+		http.Redirect(writer, request, "/sign-in", http.StatusFound)
+		return nil
+	}
 	styleSheetURI, err := h.assetsResolver.GetAssetURI("style.css")
 	if err != nil {
 		return fmt.Errorf("could not resolve asset %s: %w", "style.css", err)
@@ -67,7 +82,8 @@ type firstTimeRegistrationPresenter struct {
 	StylesheetURI string // Public URI path to the stylesheet
 }
 
-// NewFirstTimeRegistrationPostHandler creates a new handler for POST /first-time-registration
+// NewFirstTimeRegistrationPostHandler creates a new handler for POST /first-time-registration.
+// Once an administrator exists, it is forbidden.
 func NewFirstTimeRegistrationPostHandler(
 	us Store,
 	de *schema.Decoder,
@@ -84,7 +100,14 @@ type postFirstTimeRegistrationHandler struct {
 }
 
 func (h *postFirstTimeRegistrationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
-	err := request.ParseForm()
+	hasAdministrator, err := h.userStore.HasAdministrator(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while checking whether an administrator exists: %w", err)
+	}
+	if hasAdministrator {
+		return server.NewForbiddenError(ErrAdministratorExists)
+	}
+	err = request.ParseForm()
 	if err != nil {
 		return server.NewBadRequestError(err, "Could not parse the first-time registration form")
 	}
@@ -93,22 +116,15 @@ func (h *postFirstTimeRegistrationHandler) ServeHTTP(writer http.ResponseWriter,
 	if err != nil {
 		return server.NewBadRequestError(err, "Could not decode the first-time registration form into its representation")
 	}
-	if len([]rune(form.Password)) > maximumPasswordLength {
-		return server.NewBadRequestError(err, "Password cannot be longer than 64 characters")
-	}
-
-	passwordhash, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcryptWork)
+	registration, err := NewRegistration(form, RoleAdministrator)
 	if err != nil {
-		return fmt.Errorf("error while hashing the password: %w", err)
-	}
-
-	registration := &Registration{
-		Email:        form.Email,
-		PasswordHash: passwordhash,
-		Username:     form.Username,
+		return err
 	}
 
 	err = h.userStore.SaveFirstAdministrator(request.Context(), registration)
+	if errors.Is(err, ErrAdministratorExists) {
+		return server.NewForbiddenError(err)
+	}
 	if err != nil {
 		return fmt.Errorf("error while saving the first administrator account: %w", err)
 	}
@@ -124,3 +140,33 @@ type RegistrationForm struct {
 	Password string `schema:"password,required"`
 	Username string `schema:"username,required"`
 }
+
+// NewRegistration checks the password of the form and hashes it
+func NewRegistration(form *RegistrationForm, role Role) (*Registration, error) {
+	passwordHash, err := HashPassword(form.Password)
+	if errors.Is(err, ErrPasswordTooLong) {
+		return nil, server.NewBadRequestError(err, "Password cannot be longer than 64 characters")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Registration{
+		Email:        form.Email,
+		PasswordHash: passwordHash,
+		Username:     form.Username,
+		Role:         role,
+	}, nil
+}
+
+// HashPassword hashes the password with bcrypt. It returns ErrPasswordTooLong when the password
+// is longer than 64 characters.
+func HashPassword(password string) ([]byte, error) {
+	if len([]rune(password)) > maximumPasswordLength {
+		return nil, ErrPasswordTooLong
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptWork)
+	if err != nil {
+		return nil, fmt.Errorf("error while hashing the password: %w", err)
+	}
+	return passwordHash, nil
+}
@@ -36,7 +36,7 @@ func TestGetFirstTimeRegistrationHandler(t *testing.T) {
 	t.Run("when it cannot resolve assets, it will return a 500 error", func(t *testing.T) {
 		assetsResolver := &stubAssetsResolver{true, ""}
 		templateExecutor := newTemplateExecutorWithValidTemplate()
-		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver)
+		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver, &stubDAOForRegistration{})
 
 		response := httptest.NewRecorder()
 		handler.ServeHTTP(response, request)
@@ -47,7 +47,7 @@ func TestGetFirstTimeRegistrationHandler(t *testing.T) {
 	t.Run("when it cannot load the template, it will return a 500 error", func(t *testing.T) {
 		assetsResolver := &stubAssetsResolver{false, "style.css"}
 		templateExecutor := newTemplateExecutorWithInvalidTemplate()
-		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver)
+		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver, &stubDAOForRegistration{})
 
 		response := httptest.NewRecorder()
 		handler.ServeHTTP(response, request)
@@ -58,13 +58,29 @@ func TestGetFirstTimeRegistrationHandler(t *testing.T) {
 	t.Run("it will execute the template with its assets", func(t *testing.T) {
 		assetsResolver := &stubAssetsResolver{false, "style.css"}
 		templateExecutor := newTemplateExecutorWithValidTemplate()
-		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver)
+		handler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver, &stubDAOForRegistration{})
 
 		response := httptest.NewRecorder()
 		handler.ServeHTTP(response, request)
 
 		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
 	})
+
+	t.Run("when an administrator already exists, it will redirect to /sign-in", func(t *testing.T) {
+		assetsResolver := &stubAssetsResolver{false, "style.css"}
+		templateExecutor := newTemplateExecutorWithValidTemplate()
+		handler := NewFirstTimeRegistrationGetHandler(
+			templateExecutor,
+			assetsResolver,
+			&stubDAOForRegistration{hasAdministrator: true},
+		)
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/sign-in")
+	})
 }
 
 func TestPostFirstTimeRegistrationHandler(t *testing.T) {
@@ -130,6 +146,26 @@ func TestPostFirstTimeRegistrationHandler(t *testing.T) {
 		tests.AssertStatusEquals(t, response.Code, http.StatusInternalServerError)
 	})
 
+	t.Run("when an administrator already exists, it will return Forbidden", func(t *testing.T) {
+		request := newValidPostFirstRegistrationRequest()
+		response := httptest.NewRecorder()
+		handler := NewFirstTimeRegistrationPostHandler(&stubDAOForRegistration{hasAdministrator: true}, schema.NewDecoder())
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("when an administrator is registered concurrently, it will return Forbidden", func(t *testing.T) {
+		request := newValidPostFirstRegistrationRequest()
+		response := httptest.NewRecorder()
+		handler := NewFirstTimeRegistrationPostHandler(&stubDAOForRegistration{saveError: ErrAdministratorExists}, schema.NewDecoder())
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
 	t.Run("when successful, POST /first-time-registration will redirect to /sign-in", func(t *testing.T) {
 		request := newValidPostFirstRegistrationRequest()
 		response := httptest.NewRecorder()
@@ -143,13 +179,13 @@ func TestPostFirstTimeRegistrationHandler(t *testing.T) {
 }
 
 func newFirstTimeRegistrationHandler() http.Handler {
-	dao := &stubDAOForRegistration{false}
+	dao := &stubDAOForRegistration{}
 	decoder := schema.NewDecoder()
 	return NewFirstTimeRegistrationPostHandler(dao, decoder)
 }
 
 func newFirstTimeRegistrationHandlerWithDBError() http.Handler {
-	dao := &stubDAOForRegistration{true}
+	dao := &stubDAOForRegistration{saveError: errors.New("Could not register first administrator")}
 	decoder := schema.NewDecoder()
 	return NewFirstTimeRegistrationPostHandler(dao, decoder)
 }
@@ -165,14 +201,19 @@ func newValidPostFirstRegistrationRequest() *http.Request {
 }
 
 type stubDAOForRegistration struct {
-	hasError bool
+	hasAdministrator bool
+	saveError        error
+}
+
+func (s *stubDAOForRegistration) HasAdministrator(_ context.Context) (bool, error) {
+	return s.hasAdministrator, nil
 }
 
 func (s *stubDAOForRegistration) SaveFirstAdministrator(_ context.Context, registration *Registration) error {
-	if s.hasError {
-		return errors.New("Could not register first administrator")
+	if registration.Role != RoleAdministrator {
+		return errors.New("Expected the first user to be an administrator")
 	}
-	return nil
+	return s.saveError
 }
 
 func (s *stubDAOForRegistration) GetUserMatchingEmail(_ context.Context, _ string) (*PossibleMatch, error) {
@@ -182,3 +223,11 @@ func (s *stubDAOForRegistration) GetUserMatchingEmail(_ context.Context, _ strin
 func (s *stubDAOForRegistration) GetUserMatchingSession(_ context.Context) (*Current, error) {
 	return nil, errors.New("This method should not have been called in tests")
 }
+
+func (s *stubDAOForRegistration) GetSubsonicCredentials(_ context.Context, _ string) (*SubsonicCredentials, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubDAOForRegistration) SaveSubsonicPassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method should not have been called in tests")
+}
@@ -0,0 +1,124 @@
+/*
+ *   Copyright (C) 2020  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+)
+
+// InvitationURI returns the URI of the page where invited users register their account
+func InvitationURI(token string) string {
+	return "/invitations/" + token
+}
+
+// NewInvitationGetHandler creates a new handler for GET /invitations/{token}
+func NewInvitationGetHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	as AccountStore,
+) http.Handler {
+	return server.WrapErrors(
+		&getInvitationHandler{te, ar, as},
+	)
+}
+
+type getInvitationHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+	accountStore     AccountStore
+}
+
+func (h *getInvitationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	token := mux.Vars(request)["token"]
+	_, err := h.accountStore.GetInvitation(request.Context(), token)
+	if errors.Is(err, ErrInvitationNotFound) {
+		return server.NewNotFoundError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error while retrieving the invitation: %w", err)
+	}
+	styleSheetURI, err := h.assetsResolver.GetAssetURI("style.css")
+	if err != nil {
+		return fmt.Errorf("could not resolve asset %s: %w", "style.css", err)
+	}
+	presenter := &invitationPresenter{StylesheetURI: styleSheetURI, FormURI: InvitationURI(token)}
+	err = h.templateExecutor.Load(writer, presenter, "invitation.html")
+	if err != nil {
+		return fmt.Errorf("could not load template %s: %w", "invitation.html", err)
+	}
+	return nil
+}
+
+type invitationPresenter struct {
+	StylesheetURI string // Public URI path to the stylesheet
+	FormURI       string // URI path where the registration form is posted
+}
+
+// NewInvitationPostHandler creates a new handler for POST /invitations/{token}
+func NewInvitationPostHandler(
+	as AccountStore,
+	de *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postInvitationHandler{as, de},
+	)
+}
+
+// postInvitationHandler registers the account of an invited user
+type postInvitationHandler struct {
+	accountStore AccountStore
+	decoder      *schema.Decoder
+}
+
+func (h *postInvitationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the registration form")
+	}
+	form := new(RegistrationForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the registration form into its representation")
+	}
+	// The role comes from the invitation
+	registration, err := NewRegistration(form, RoleListener)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.accountStore.AcceptInvitation(request.Context(), mux.Vars(request)["token"], registration)
+	if errors.Is(err, ErrInvitationNotFound) {
+		return server.NewNotFoundError(err)
+	}
+	if errors.Is(err, ErrUserAlreadyExists) {
+		return server.NewConflictError(err, "A user with the same email or username already exists")
+	}
+	if err != nil {
+		return fmt.Errorf("error while registering the invited user: %w", err)
+	}
+
+	http.Redirect(writer, request, "/sign-in", http.StatusFound)
+	return nil
+}
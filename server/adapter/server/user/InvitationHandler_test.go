@@ -0,0 +1,162 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetInvitationHandler(t *testing.T) {
+	t.Run("it will execute the template with the form URI", func(t *testing.T) {
+		handler := NewInvitationGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"}, &stubAccountStore{})
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, newInvitationRequest(t, http.MethodGet, validInvitationToken, ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("when the invitation is unknown or has expired, it will return Not Found", func(t *testing.T) {
+		handler := NewInvitationGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"}, &stubAccountStore{})
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, newInvitationRequest(t, http.MethodGet, "0000", ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestPostInvitationHandler(t *testing.T) {
+	const validForm = "email=bob@example.com&password=welcome0&username=Bob"
+
+	t.Run("when successful, it will register the user and redirect to /sign-in", func(t *testing.T) {
+		store := &stubAccountStore{}
+		handler := NewInvitationPostHandler(store, schema.NewDecoder())
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, newInvitationRequest(t, http.MethodPost, validInvitationToken, validForm))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/sign-in")
+		if store.registered == nil || store.registered.Username != "Bob" {
+			t.Errorf("expected Bob to be registered, got %v", store.registered)
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		token string
+		form  string
+		want  int
+	}{
+		{"an unknown invitation", "0000", validForm, http.StatusNotFound},
+		{"a form without password", validInvitationToken, "email=bob@example.com&username=Bob", http.StatusBadRequest},
+		{"a username that is already used", validInvitationToken, "email=bob@example.com&password=welcome0&username=admin", http.StatusConflict},
+	}
+	for _, testCase := range testCases {
+		t.Run("given "+testCase.name+", it will return an error", func(t *testing.T) {
+			handler := NewInvitationPostHandler(&stubAccountStore{}, schema.NewDecoder())
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, newInvitationRequest(t, http.MethodPost, testCase.token, testCase.form))
+
+			tests.AssertStatusEquals(t, response.Code, testCase.want)
+		})
+	}
+}
+
+const validInvitationToken = "0123456789abcdef"
+
+func newInvitationRequest(t *testing.T, method string, token string, form string) *http.Request {
+	t.Helper()
+	request := httptest.NewRequest(method, InvitationURI(token), strings.NewReader(form))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return mux.SetURLVars(request, map[string]string{"token": token})
+}
+
+// stubAccountStore knows a single invitation and a single user named "admin"
+type stubAccountStore struct {
+	registered *Registration
+}
+
+func (s *stubAccountStore) GetInvitation(_ context.Context, token string) (*Invitation, error) {
+	if token != validInvitationToken {
+		return nil, ErrInvitationNotFound
+	}
+	return &Invitation{Token: token, Role: RoleListener}, nil
+}
+
+func (s *stubAccountStore) AcceptInvitation(ctx context.Context, token string, registration *Registration) (*Account, error) {
+	if _, err := s.GetInvitation(ctx, token); err != nil {
+		return nil, err
+	}
+	if registration.Username == "admin" {
+		return nil, ErrUserAlreadyExists
+	}
+	s.registered = registration
+	return &Account{ID: 2, Email: registration.Email, Username: registration.Username, Role: RoleListener}, nil
+}
+
+func (s *stubAccountStore) ListUsers(_ context.Context) ([]Account, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) GetUser(_ context.Context, _ uint) (*Account, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) SaveUser(_ context.Context, _ *Registration) (*Account, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) UpdateUser(_ context.Context, _ uint, _ Role, _ bool) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) DeleteUser(_ context.Context, _ uint) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) UpdatePassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) CreateInvitation(_ context.Context, _ Role, _ uint) (*Invitation, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) CreatePasswordReset(_ context.Context, _ uint, _ uint) (*PasswordReset, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) GetPasswordReset(_ context.Context, _ string) (*PasswordReset, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubAccountStore) ResetPassword(_ context.Context, _ string, _ []byte) (*PasswordReset, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
@@ -0,0 +1,244 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/swithek/sessionup"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordURI is the page where signed-in users change their password
+const PasswordURI = "/account/password"
+
+// PasswordResetURI returns the URI of the page where users choose a new password without knowing the current one
+func PasswordResetURI(token string) string {
+	return "/password-reset/" + token
+}
+
+type passwordPresenter struct {
+	StylesheetURI          string // Public URI path to the stylesheet
+	FormURI                string // URI path where the password form is posted
+	RequireCurrentPassword bool   // RequireCurrentPassword is false for password reset links
+}
+
+func loadPasswordTemplate(
+	writer http.ResponseWriter,
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	presenter *passwordPresenter,
+) error {
+	styleSheetURI, err := ar.GetAssetURI("style.css")
+	if err != nil {
+		return fmt.Errorf("could not resolve asset %s: %w", "style.css", err)
+	}
+	presenter.StylesheetURI = styleSheetURI
+	err = te.Load(writer, presenter, "password.html")
+	if err != nil {
+		return fmt.Errorf("could not load template %s: %w", "password.html", err)
+	}
+	return nil
+}
+
+// hashNewPassword hashes the password chosen by users, see HashPassword
+func hashNewPassword(password string) ([]byte, error) {
+	passwordHash, err := HashPassword(password)
+	if errors.Is(err, ErrPasswordTooLong) {
+		return nil, server.NewBadRequestError(err, "Password cannot be longer than 64 characters")
+	}
+	return passwordHash, err
+}
+
+// NewPasswordGetHandler creates a new handler for GET /account/password
+func NewPasswordGetHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+) http.Handler {
+	return server.WrapErrors(
+		&getPasswordHandler{te, ar},
+	)
+}
+
+type getPasswordHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+}
+
+func (h *getPasswordHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) error {
+	presenter := &passwordPresenter{FormURI: PasswordURI, RequireCurrentPassword: true}
+	return loadPasswordTemplate(writer, h.templateExecutor, h.assetsResolver, presenter)
+}
+
+// NewPasswordPostHandler creates a new handler for POST /account/password.
+// Once the password is changed, the other sessions of the user are signed out.
+func NewPasswordPostHandler(
+	us Store,
+	as AccountStore,
+	sessionManager *sessionup.Manager,
+	de *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postPasswordHandler{us, as, sessionManager, de},
+	)
+}
+
+// postPasswordHandler changes the password of users who give their current one, so that someone
+// using a session left open cannot change it
+type postPasswordHandler struct {
+	userStore      Store
+	accountStore   AccountStore
+	sessionManager *sessionup.Manager
+	decoder        *schema.Decoder
+}
+
+func (h *postPasswordHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the password form")
+	}
+	form := new(PasswordChangeForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the password form into its representation")
+	}
+	current, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the current user: %w", err)
+	}
+	possibleUser, err := h.userStore.GetUserMatchingEmail(request.Context(), current.Email)
+	if err != nil {
+		return fmt.Errorf("error while retrieving the password of the current user: %w", err)
+	}
+	err = bcrypt.CompareHashAndPassword(possibleUser.PasswordHash, []byte(form.CurrentPassword))
+	if err != nil {
+		return server.NewForbiddenError(errors.New("The current password is wrong"))
+	}
+	passwordHash, err := hashNewPassword(form.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	err = h.accountStore.UpdatePassword(request.Context(), current.ID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("error while changing the password: %w", err)
+	}
+	err = h.sessionManager.RevokeOther(request.Context())
+	if err != nil {
+		return fmt.Errorf("could not revoke the other sessions of user #%d: %w", current.ID, err)
+	}
+	http.Redirect(writer, request, "/app", http.StatusFound)
+	return nil
+}
+
+// NewPasswordResetGetHandler creates a new handler for GET /password-reset/{token}
+func NewPasswordResetGetHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	as AccountStore,
+) http.Handler {
+	return server.WrapErrors(
+		&getPasswordResetHandler{te, ar, as},
+	)
+}
+
+type getPasswordResetHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+	accountStore     AccountStore
+}
+
+func (h *getPasswordResetHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	token := mux.Vars(request)["token"]
+	_, err := h.accountStore.GetPasswordReset(request.Context(), token)
+	if errors.Is(err, ErrPasswordResetNotFound) {
+		return server.NewNotFoundError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error while retrieving the password reset: %w", err)
+	}
+	presenter := &passwordPresenter{FormURI: PasswordResetURI(token)}
+	return loadPasswordTemplate(writer, h.templateExecutor, h.assetsResolver, presenter)
+}
+
+// NewPasswordResetPostHandler creates a new handler for POST /password-reset/{token}.
+// Once the password is reset, all the sessions of the user are signed out.
+func NewPasswordResetPostHandler(
+	as AccountStore,
+	sessionManager *sessionup.Manager,
+	de *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postPasswordResetHandler{as, sessionManager, de},
+	)
+}
+
+// postPasswordResetHandler replaces the password of the user of a password reset link
+type postPasswordResetHandler struct {
+	accountStore   AccountStore
+	sessionManager *sessionup.Manager
+	decoder        *schema.Decoder
+}
+
+func (h *postPasswordResetHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the password form")
+	}
+	form := new(PasswordResetForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the password form into its representation")
+	}
+	passwordHash, err := hashNewPassword(form.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	reset, err := h.accountStore.ResetPassword(request.Context(), mux.Vars(request)["token"], passwordHash)
+	if errors.Is(err, ErrPasswordResetNotFound) {
+		return server.NewNotFoundError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error while resetting the password: %w", err)
+	}
+	err = h.sessionManager.RevokeByUserKey(request.Context(), strconv.FormatUint(uint64(reset.UserID), 10))
+	if err != nil {
+		return fmt.Errorf("could not revoke the sessions of user #%d: %w", reset.UserID, err)
+	}
+	http.Redirect(writer, request, "/sign-in", http.StatusFound)
+	return nil
+}
+
+// PasswordChangeForm represents the passwords provided by signed-in users to change their password
+type PasswordChangeForm struct {
+	CurrentPassword string `schema:"current-password,required"`
+	NewPassword     string `schema:"new-password,required"`
+}
+
+// PasswordResetForm represents the new password provided by users with a password reset link
+type PasswordResetForm struct {
+	NewPassword string `schema:"new-password,required"`
+}
@@ -0,0 +1,204 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+	"github.com/swithek/sessionup"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPostPasswordHandler(t *testing.T) {
+	t.Run("when the current password is wrong, it will return Forbidden", func(t *testing.T) {
+		handler, _, sessionStore := newTestPasswordPostHandler(t)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostPasswordRequest(PasswordURI, "wrong_password", "new password"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+		if sessionStore.revokedUserKey != "" {
+			t.Error("did not expect the sessions to be revoked")
+		}
+	})
+
+	t.Run("when the new password is too long, it will return Bad Request", func(t *testing.T) {
+		handler, _, _ := newTestPasswordPostHandler(t)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostPasswordRequest(PasswordURI, "welcome0", strings.Repeat("a", 65)))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("when no new password is provided, it will return Bad Request", func(t *testing.T) {
+		handler, _, _ := newTestPasswordPostHandler(t)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostPasswordRequest(PasswordURI, "welcome0", ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("when successful, it will change the password and revoke the other sessions", func(t *testing.T) {
+		handler, db, sessionStore := newTestPasswordPostHandler(t)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostPasswordRequest(PasswordURI, "welcome0", "new password"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/app")
+		assertPasswordEquals(t, db, "new password")
+		if sessionStore.revokedUserKey != "1" || len(sessionStore.keptIDs) != 1 || sessionStore.keptIDs[0] != "current" {
+			t.Errorf("expected the other sessions of user #1 to be revoked, got %q except %v",
+				sessionStore.revokedUserKey, sessionStore.keptIDs)
+		}
+	})
+}
+
+func TestGetPasswordResetHandler(t *testing.T) {
+	t.Run("when the password reset does not exist, it will return Not Found", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		handler := NewPasswordResetGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"}, dao)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPasswordResetRequest(http.MethodGet, "0123456789abcdef", nil))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("it will execute the template", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		reset, err := dao.CreatePasswordReset(context.Background(), 1, 1)
+		tests.AssertNoError(t, err)
+		handler := NewPasswordResetGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"}, dao)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPasswordResetRequest(http.MethodGet, reset.Token, nil))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+}
+
+func TestPostPasswordResetHandler(t *testing.T) {
+	t.Run("when the password reset does not exist, it will return Not Found", func(t *testing.T) {
+		dao, _ := newAccountDAOWithAdministrator(t)
+		handler := NewPasswordResetPostHandler(dao, tests.NewValidSessionManager(t), schema.NewDecoder())
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPasswordResetRequest(http.MethodPost, "0123456789abcdef", url.Values{"new-password": {"new password"}}))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("when successful, it will reset the password and revoke all the sessions of the user", func(t *testing.T) {
+		dao, db := newAccountDAOWithAdministrator(t)
+		reset, err := dao.CreatePasswordReset(context.Background(), 1, 1)
+		tests.AssertNoError(t, err)
+		sessionStore := &recordingSessionStore{}
+		handler := NewPasswordResetPostHandler(dao, sessionup.NewManager(sessionStore), schema.NewDecoder())
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPasswordResetRequest(http.MethodPost, reset.Token, url.Values{"new-password": {"new password"}}))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/sign-in")
+		assertPasswordEquals(t, db, "new password")
+		if sessionStore.revokedUserKey != "1" || len(sessionStore.keptIDs) != 0 {
+			t.Errorf("expected all the sessions of user #1 to be revoked, got %q except %v",
+				sessionStore.revokedUserKey, sessionStore.keptIDs)
+		}
+	})
+}
+
+// newTestPasswordPostHandler returns a handler for the administrator #1, whose password is "welcome0"
+func newTestPasswordPostHandler(t *testing.T) (http.Handler, *sql.DB, *recordingSessionStore) {
+	t.Helper()
+	accountDAO, db := newAccountDAOWithAdministrator(t)
+	sessionStore := &recordingSessionStore{}
+	handler := NewPasswordPostHandler(NewDAO(db), accountDAO, sessionup.NewManager(sessionStore), schema.NewDecoder())
+	return handler, db, sessionStore
+}
+
+// newPostPasswordRequest posts the password form in the session "current" of the administrator #1.
+// Empty passwords are left out of the form.
+func newPostPasswordRequest(uri string, currentPassword string, newPassword string) *http.Request {
+	form := url.Values{}
+	if currentPassword != "" {
+		form.Set("current-password", currentPassword)
+	}
+	if newPassword != "" {
+		form.Set("new-password", newPassword)
+	}
+	request := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := sessionup.NewContext(request.Context(), sessionup.Session{ID: "current", UserKey: "1"})
+	return request.WithContext(ctx)
+}
+
+func newPasswordResetRequest(method string, token string, form url.Values) *http.Request {
+	request := httptest.NewRequest(method, PasswordResetURI(token), strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return mux.SetURLVars(request, map[string]string{"token": token})
+}
+
+func assertPasswordEquals(t *testing.T, db *sql.DB, want string) {
+	t.Helper()
+	var passwordHash []byte
+	tests.AssertNoError(t, db.QueryRow(`SELECT password FROM user WHERE id = 1`).Scan(&passwordHash))
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(want)); err != nil {
+		t.Errorf("expected the password of user #1 to be %q: %v", want, err)
+	}
+}
+
+// recordingSessionStore records the sessions that are revoked
+type recordingSessionStore struct {
+	revokedUserKey string
+	keptIDs        []string
+}
+
+func (s *recordingSessionStore) Create(_ context.Context, _ sessionup.Session) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *recordingSessionStore) FetchByID(_ context.Context, _ string) (sessionup.Session, bool, error) {
+	return sessionup.Session{}, false, errors.New("This method should not have been called in tests")
+}
+
+func (s *recordingSessionStore) FetchByUserKey(_ context.Context, _ string) ([]sessionup.Session, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *recordingSessionStore) DeleteByID(_ context.Context, _ string) error {
+	return errors.New("This method should not have been called in tests")
+}
+
+func (s *recordingSessionStore) DeleteByUserKey(_ context.Context, key string, expID ...string) error {
+	s.revokedUserKey, s.keptIDs = key, expID
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// SecretCipher encrypts the secrets saved in the database, such as the TOTP secrets, with the server's key.
+// A copy of the database alone does not reveal them.
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher creates a new SecretCipher using AES-256-GCM. The key must be 32 bytes long.
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the key must be 32 bytes long, got %d bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the cipher: %w", err)
+	}
+	return &SecretCipher{aead}, nil
+}
+
+// Encrypt returns the random nonce followed by the encrypted secret
+func (c *SecretCipher) Encrypt(secret []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate a nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Decrypt returns the secret encrypted by Encrypt. It returns an error when the secret was encrypted
+// with another key or has been tampered with.
+func (c *SecretCipher) Decrypt(encrypted []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, errors.New("the encrypted secret is too short")
+	}
+	secret, err := c.aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the secret: %w", err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,65 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSecretCipher(t *testing.T) {
+	cipher, err := NewSecretCipher(bytes.Repeat([]byte{1}, 32))
+	tests.AssertNoError(t, err)
+
+	t.Run("it decrypts the secrets it encrypted", func(t *testing.T) {
+		encrypted, err := cipher.Encrypt([]byte("secret"))
+		tests.AssertNoError(t, err)
+		if bytes.Contains(encrypted, []byte("secret")) {
+			t.Error("expected the secret to be encrypted")
+		}
+		decrypted, err := cipher.Decrypt(encrypted)
+		tests.AssertNoError(t, err)
+		if string(decrypted) != "secret" {
+			t.Errorf("expected the secret, got %q", decrypted)
+		}
+	})
+
+	t.Run("it refuses secrets encrypted with another key or tampered with", func(t *testing.T) {
+		other, err := NewSecretCipher(bytes.Repeat([]byte{2}, 32))
+		tests.AssertNoError(t, err)
+		encrypted, err := other.Encrypt([]byte("secret"))
+		tests.AssertNoError(t, err)
+		_, err = cipher.Decrypt(encrypted)
+		tests.AssertError(t, err)
+
+		encrypted, err = cipher.Encrypt([]byte("secret"))
+		tests.AssertNoError(t, err)
+		encrypted[len(encrypted)-1] ^= 1
+		_, err = cipher.Decrypt(encrypted)
+		tests.AssertError(t, err)
+		_, err = cipher.Decrypt([]byte("short"))
+		tests.AssertError(t, err)
+	})
+
+	t.Run("it refuses keys that are not 32 bytes long", func(t *testing.T) {
+		_, err := NewSecretCipher([]byte("short"))
+		tests.AssertError(t, err)
+	})
+}
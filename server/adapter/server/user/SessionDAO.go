@@ -0,0 +1,85 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SessionsTable is the name of the table where the sessions store saves the sessions
+const SessionsTable = "sessions"
+
+// ActiveSession represents a session that has not expired yet
+type ActiveSession struct {
+	ID        string
+	UserID    string // Key of the user in the sessions store, it is their identifier
+	Username  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	IP        string
+	OS        string
+	Browser   string
+}
+
+// SessionDAO reads the sessions of all users. Sessions are created and revoked through the sessions store.
+type SessionDAO struct {
+	db *sql.DB
+}
+
+// NewSessionDAO creates a new SessionDAO
+func NewSessionDAO(db *sql.DB) *SessionDAO {
+	return &SessionDAO{db}
+}
+
+// ListSessions returns the sessions that have not expired yet, most recent first.
+// The sessions table must have been created by the sessions store.
+func (d *SessionDAO) ListSessions(ctx context.Context) ([]ActiveSession, error) {
+	query := `SELECT sessions.id, sessions.user_key, COALESCE(user.username, ''), sessions.created_at, sessions.expires_at,
+		COALESCE(sessions.ip, ''), COALESCE(sessions.agent_os, ''), COALESCE(sessions.agent_browser, '')
+		FROM ` + SessionsTable + ` AS sessions
+		LEFT JOIN user ON CAST(user.id AS TEXT) = sessions.user_key
+		WHERE sessions.expires_at > datetime('now', 'localtime')
+		ORDER BY sessions.created_at DESC`
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the sessions: %w", err)
+	}
+	defer rows.Close()
+	var sessions []ActiveSession
+	for rows.Next() {
+		var session ActiveSession
+		err = rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.Username,
+			&session.CreatedAt,
+			&session.ExpiresAt,
+			&session.IP,
+			&session.OS,
+			&session.Browser,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read a session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,51 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+	sqlitestore "github.com/hyzual/sessionup-sqlitestore"
+	"github.com/swithek/sessionup"
+)
+
+func TestListSessions(t *testing.T) {
+	ctx := context.Background()
+	_, db := newAccountDAOWithAdministrator(t)
+	store, err := sqlitestore.New(db, SessionsTable, 0)
+	if err != nil {
+		t.Fatalf("could not create the sessions store: %v", err)
+	}
+	now := time.Now()
+	sessions := []sessionup.Session{
+		{ID: "active", UserKey: "1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "expired", UserKey: "1", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+	for _, session := range sessions {
+		tests.AssertNoError(t, store.Create(ctx, session))
+	}
+
+	got, err := NewSessionDAO(db).ListSessions(ctx)
+	tests.AssertNoError(t, err)
+	if len(got) != 1 || got[0].ID != "active" || got[0].Username != "admin" || got[0].UserID != "1" {
+		t.Errorf("expected only the active session of the administrator, got %v", got)
+	}
+}
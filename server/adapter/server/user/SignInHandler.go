@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/schema"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter"
@@ -64,18 +65,24 @@ type signInPresenter struct {
 
 type postSignInHandler struct {
 	userStore      Store
+	twoFactor      *TwoFactorAuthenticator
+	throttle       *SignInThrottle
 	sessionManager *sessionup.Manager
 	decoder        *schema.Decoder
 }
 
-// NewSignInPostHandler creates a new handler for POST /sign-in
+// NewSignInPostHandler creates a new handler for POST /sign-in. Users who enabled two-factor
+// authentication are sent to TwoFactorSignInURI instead of being signed in.
+// Failed attempts are recorded by throttle, which locks out the IP address or the account after too many of them.
 func NewSignInPostHandler(
 	userStore Store,
+	twoFactor *TwoFactorAuthenticator,
+	throttle *SignInThrottle,
 	sessionManager *sessionup.Manager,
 	decoder *schema.Decoder,
 ) http.Handler {
 	return server.WrapErrors(
-		&postSignInHandler{userStore, sessionManager, decoder},
+		&postSignInHandler{userStore, twoFactor, throttle, sessionManager, decoder},
 	)
 }
 
@@ -91,15 +98,40 @@ func (h *postSignInHandler) ServeHTTP(writer http.ResponseWriter, request *http.
 		return server.NewBadRequestError(err, "Could not decode the sign-in form into its representation")
 	}
 
+	ip := server.ClientIP(request)
+	err = checkSignInLockout(request, writer, h.throttle, ip, signInForm.Email)
+	if err != nil {
+		return err
+	}
+
 	possibleUser, err := h.userStore.GetUserMatchingEmail(request.Context(), signInForm.Email)
 	if err != nil {
-		return server.NewForbiddenError(errors.New("Invalid credentials"))
+		return signInFailed(request, h.throttle, ip, signInForm.Email, FailureUnknownAccount)
 	}
 	err = bcrypt.CompareHashAndPassword(possibleUser.PasswordHash, []byte(signInForm.Password))
 	if err != nil {
-		return server.NewForbiddenError(errors.New("Invalid credentials"))
+		return signInFailed(request, h.throttle, ip, signInForm.Email, FailureWrongPassword)
 	}
 
+	hasTwoFactor, err := h.twoFactor.IsEnabled(request.Context(), possibleUser.ID)
+	if err != nil {
+		return fmt.Errorf("could not check the two-factor authentication of the user: %w", err)
+	}
+	if hasTwoFactor {
+		// The session is only initialised once the user gives their code
+		challenge, err := h.twoFactor.StartSignIn(request.Context(), possibleUser.ID)
+		if err != nil {
+			return fmt.Errorf("could not start the two-factor sign-in: %w", err)
+		}
+		http.SetCookie(writer, newSignInChallengeCookie(challenge))
+		http.Redirect(writer, request, TwoFactorSignInURI, http.StatusFound)
+		return nil
+	}
+
+	err = h.throttle.RecordSuccess(request.Context(), signInForm.Email)
+	if err != nil {
+		return fmt.Errorf("could not reset the sign-in failures: %w", err)
+	}
 	stringUserID := strconv.FormatUint(uint64(possibleUser.ID), 10)
 	err = h.sessionManager.Init(writer, request, stringUserID)
 	if err != nil {
@@ -110,6 +142,49 @@ func (h *postSignInHandler) ServeHTTP(writer http.ResponseWriter, request *http.
 	return nil
 }
 
+// checkSignInLockout answers 429 Too Many Requests when the IP address or the account is locked out.
+// The attempt is still added to the audit log.
+func checkSignInLockout(
+	request *http.Request,
+	writer http.ResponseWriter,
+	throttle *SignInThrottle,
+	ip string,
+	account string,
+) error {
+	lockedUntil, err := throttle.LockedUntil(request.Context(), ip, account)
+	if err != nil {
+		return fmt.Errorf("could not check the sign-in lockout: %w", err)
+	}
+	if lockedUntil.IsZero() {
+		return nil
+	}
+	err = throttle.RecordFailure(request.Context(), ip, account, FailureLockedOut)
+	if err != nil {
+		return fmt.Errorf("could not record the sign-in failure: %w", err)
+	}
+	server.SetRetryAfter(writer, time.Until(lockedUntil))
+	return server.NewTooManyRequestsError(
+		errors.New("Too many failed sign-in attempts"),
+		"Too many failed sign-in attempts, please try again later",
+	)
+}
+
+// signInFailed records the failure and returns the error shown to users. It does not tell which of
+// the credentials was wrong.
+func signInFailed(
+	request *http.Request,
+	throttle *SignInThrottle,
+	ip string,
+	account string,
+	reason SignInFailureReason,
+) error {
+	err := throttle.RecordFailure(request.Context(), ip, account, reason)
+	if err != nil {
+		return fmt.Errorf("could not record the sign-in failure: %w", err)
+	}
+	return server.NewForbiddenError(errors.New("Invalid credentials"))
+}
+
 // SignInForm represents the credentials provided by users to sign in
 type SignInForm struct {
 	Email    string `schema:"email,required"`
@@ -25,6 +25,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/schema"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter"
@@ -140,6 +141,82 @@ func TestPostSigninHandler(t *testing.T) {
 		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
 		tests.AssertLocationHeaderEquals(t, response, "/app")
 	})
+
+	t.Run(`when the user enabled two-factor authentication, it will not initialize the session
+		and will redirect to the code page with a sign-in challenge`, func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		handler := NewSignInPostHandler(
+			&stubDAOForSignIn{true},
+			authenticator,
+			newTestSignInThrottle(t),
+			tests.NewValidSessionManager(t),
+			schema.NewDecoder(),
+		)
+		request := newValidPostSigninRequest()
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, TwoFactorSignInURI)
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != signInChallengeCookie || cookies[0].Value == "" {
+			t.Errorf("expected only a sign-in challenge cookie, got %v", cookies)
+		}
+	})
+}
+
+func TestPostSigninHandlerThrottling(t *testing.T) {
+	t.Run("after too many wrong passwords, it will lock the account out with Too Many Requests", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		handler := NewSignInPostHandler(
+			&stubDAOForSignIn{false},
+			newTestTwoFactorAuthenticator(t),
+			throttle,
+			tests.NewValidSessionManager(t),
+			schema.NewDecoder(),
+		)
+		for i := 0; i < freeAccountFailures; i++ {
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, newValidPostSigninRequest())
+			tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+		}
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, newValidPostSigninRequest())
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, newValidPostSigninRequest())
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusTooManyRequests)
+		if response.Header().Get("Retry-After") == "" {
+			t.Error("expected a Retry-After header")
+		}
+		failures, total, err := throttle.store.ListSignInFailures(context.Background(), 1, 0)
+		tests.AssertNoError(t, err)
+		if total != freeAccountFailures+2 || failures[0].Reason != FailureLockedOut {
+			t.Errorf("expected the attempt during the lockout to be logged, got %d failures: %v", total, failures)
+		}
+	})
+
+	t.Run("when the account is locked out, it will not sign in even with the right password", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		_, err := throttle.store.CountSignInFailure(context.Background(), accountSubject("mike@example.com"), time.Now(), time.Now())
+		tests.AssertNoError(t, err)
+		err = throttle.store.LockSignIn(context.Background(), accountSubject("mike@example.com"), time.Now().Add(time.Hour))
+		tests.AssertNoError(t, err)
+		handler := NewSignInPostHandler(
+			&stubDAOForSignIn{true},
+			newTestTwoFactorAuthenticator(t),
+			throttle,
+			tests.NewValidSessionManager(t),
+			schema.NewDecoder(),
+		)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newValidPostSigninRequest())
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusTooManyRequests)
+	})
 }
 
 func newPostSigninRequest(body io.Reader) *http.Request {
@@ -157,7 +234,7 @@ func newSignInHandlerInvalidCredentials(t *testing.T) http.Handler {
 	dao := &stubDAOForSignIn{false}
 	sessionManager := tests.NewValidSessionManager(t)
 	decoder := schema.NewDecoder()
-	return NewSignInPostHandler(dao, sessionManager, decoder)
+	return NewSignInPostHandler(dao, newTestTwoFactorAuthenticator(t), newTestSignInThrottle(t), sessionManager, decoder)
 }
 
 func newSignInHandlerBadSession(t *testing.T) http.Handler {
@@ -166,7 +243,7 @@ func newSignInHandlerBadSession(t *testing.T) http.Handler {
 	sessionStore := tests.NewStoreWithErrorOnCreate(t)
 	sessionManager := sessionup.NewManager(sessionStore)
 	decoder := schema.NewDecoder()
-	return NewSignInPostHandler(dao, sessionManager, decoder)
+	return NewSignInPostHandler(dao, newTestTwoFactorAuthenticator(t), newTestSignInThrottle(t), sessionManager, decoder)
 }
 
 func newValidSignInHandler(t *testing.T) http.Handler {
@@ -174,7 +251,7 @@ func newValidSignInHandler(t *testing.T) http.Handler {
 	dao := &stubDAOForSignIn{true}
 	sessionManager := tests.NewValidSessionManager(t)
 	decoder := schema.NewDecoder()
-	return NewSignInPostHandler(dao, sessionManager, decoder)
+	return NewSignInPostHandler(dao, newTestTwoFactorAuthenticator(t), newTestSignInThrottle(t), sessionManager, decoder)
 }
 
 type stubDAOForSignIn struct {
@@ -191,6 +268,10 @@ func (s *stubDAOForSignIn) GetUserMatchingEmail(_ context.Context, _ string) (*P
 	return nil, errors.New("Credentials do not match")
 }
 
+func (s *stubDAOForSignIn) HasAdministrator(_ context.Context) (bool, error) {
+	return false, errors.New("This method should not have been called in tests")
+}
+
 func (s *stubDAOForSignIn) SaveFirstAdministrator(_ context.Context, _ *Registration) error {
 	return errors.New("This method should not have been called in tests")
 }
@@ -229,3 +310,11 @@ func (s *stubAssetsResolver) GetAssetURI(baseName string) (string, error) {
 	}
 	return s.filename, nil
 }
+
+func (s *stubDAOForSignIn) GetSubsonicCredentials(_ context.Context, _ string) (*SubsonicCredentials, error) {
+	return nil, errors.New("This method should not have been called in tests")
+}
+
+func (s *stubDAOForSignIn) SaveSubsonicPassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method should not have been called in tests")
+}
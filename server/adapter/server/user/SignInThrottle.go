@@ -0,0 +1,134 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// freeAccountFailures is how many failed sign-in attempts in a row an account gets before it is locked out
+	freeAccountFailures = 5
+	// freeIPFailures is how many failed sign-in attempts in a row an IP address gets before it is locked out.
+	// It is higher than for accounts as several users may share an address.
+	freeIPFailures = 20
+	// firstLockout is how long the first lockout lasts. Each further failure doubles it.
+	firstLockout = time.Minute
+	// maxLockout caps the lockouts
+	maxLockout = time.Hour
+	// failureMemory is how long failures in a row are remembered after the last one
+	failureMemory = 24 * time.Hour
+)
+
+// SignInThrottle protects the sign-in against brute-force attacks. It counts the failed attempts of
+// each IP address and of each account and locks them out for longer and longer. It also keeps an
+// audit log of the failed attempts.
+type SignInThrottle struct {
+	store SignInThrottleStore
+	now   func() time.Time
+}
+
+// NewSignInThrottle creates a new SignInThrottle
+func NewSignInThrottle(store SignInThrottleStore) *SignInThrottle {
+	return &SignInThrottle{store, time.Now}
+}
+
+// LockedUntil returns the end of the lockout of the IP address or of the account.
+// It is the zero Time when neither of them is locked out.
+func (t *SignInThrottle) LockedUntil(ctx context.Context, ip string, account string) (time.Time, error) {
+	lockedUntil, err := t.store.LockedUntil(ctx, []string{ipSubject(ip), accountSubject(account)})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lockedUntil.After(t.now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, nil
+}
+
+// RecordFailure adds the failed attempt to the audit log. Unless the reason is FailureLockedOut,
+// it also counts the failure and locks the IP address or the account out after too many of them.
+func (t *SignInThrottle) RecordFailure(
+	ctx context.Context,
+	ip string,
+	account string,
+	reason SignInFailureReason,
+) error {
+	now := t.now()
+	failure := &SignInFailure{AttemptedAt: now, IP: ip, Account: account, Reason: reason}
+	if err := t.store.SaveSignInFailure(ctx, failure); err != nil {
+		return err
+	}
+	if reason == FailureLockedOut {
+		// Attempts during a lockout are not counted, otherwise the lockout would never end for users
+		// who keep trying
+		return nil
+	}
+	if err := t.countFailure(ctx, ipSubject(ip), freeIPFailures, now); err != nil {
+		return err
+	}
+	return t.countFailure(ctx, accountSubject(account), freeAccountFailures, now)
+}
+
+// RecordSuccess forgets the failures of the account once its user is signed in. The failures of the IP
+// address are kept, otherwise an attacker could sign in with their own account to keep guessing.
+func (t *SignInThrottle) RecordSuccess(ctx context.Context, account string) error {
+	return t.store.ResetSignInFailures(ctx, []string{accountSubject(account)})
+}
+
+func (t *SignInThrottle) countFailure(ctx context.Context, subject string, freeFailures uint, now time.Time) error {
+	failures, err := t.store.CountSignInFailure(ctx, subject, now, now.Add(-failureMemory))
+	if err != nil {
+		return err
+	}
+	lockout := lockoutAfter(failures, freeFailures)
+	if lockout == 0 {
+		return nil
+	}
+	if err = t.store.LockSignIn(ctx, subject, now.Add(lockout)); err != nil {
+		return fmt.Errorf("could not lock %s out: %w", subject, err)
+	}
+	return nil
+}
+
+// lockoutAfter returns how long to lock out after the given failures in a row: nothing for the free
+// failures, then firstLockout doubled for each further failure, up to maxLockout.
+func lockoutAfter(failures uint, freeFailures uint) time.Duration {
+	if failures <= freeFailures {
+		return 0
+	}
+	lockout := firstLockout
+	for i := freeFailures + 1; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		return maxLockout
+	}
+	return lockout
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+func accountSubject(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
@@ -0,0 +1,236 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// signInFailureRetention is how long failed sign-in attempts are kept in the audit log
+const signInFailureRetention = 90 * 24 * time.Hour
+
+// SignInFailureReason tells why a sign-in attempt failed
+type SignInFailureReason string
+
+const (
+	// FailureUnknownAccount is given when no active user has the email of the sign-in form
+	FailureUnknownAccount SignInFailureReason = "unknown-account"
+	// FailureWrongPassword is given when the password does not match
+	FailureWrongPassword SignInFailureReason = "wrong-password"
+	// FailureInvalidCode is given when the two-factor authentication code is invalid
+	FailureInvalidCode SignInFailureReason = "invalid-code"
+	// FailureLockedOut is given when the IP address or the account was locked out after too many failures
+	FailureLockedOut SignInFailureReason = "locked-out"
+)
+
+// SignInFailure represents a failed sign-in attempt in the audit log
+type SignInFailure struct {
+	ID          uint
+	AttemptedAt time.Time
+	IP          string // IP address of the client. For example "192.0.2.1"
+	Account     string // Email typed in the sign-in form. For example "mike@example.com"
+	Reason      SignInFailureReason
+}
+
+// SignInThrottleStore handles database operations related to the failed sign-in attempts.
+// Subjects are the IP addresses and the accounts whose failures are counted.
+type SignInThrottleStore interface {
+	// LockedUntil returns the end of the latest lockout of the subjects. It is the zero Time when none is locked out.
+	LockedUntil(ctx context.Context, subjects []string) (time.Time, error)
+	// CountSignInFailure counts a failure of the subject at the given time and returns its failures in a row.
+	// The failures before forgetBefore are forgotten.
+	CountSignInFailure(ctx context.Context, subject string, at time.Time, forgetBefore time.Time) (uint, error)
+	// LockSignIn locks the subject out until the given time
+	LockSignIn(ctx context.Context, subject string, until time.Time) error
+	// ResetSignInFailures forgets the failures and the lockouts of the subjects
+	ResetSignInFailures(ctx context.Context, subjects []string) error
+	// SaveSignInFailure adds the failure to the audit log. It also removes the failures older than 90 days.
+	SaveSignInFailure(ctx context.Context, failure *SignInFailure) error
+	// ListSignInFailures returns a page of the audit log, most recent first, and the number of failures in the log.
+	// A zero limit returns all of them.
+	ListSignInFailures(ctx context.Context, limit uint, offset uint) ([]SignInFailure, uint, error)
+}
+
+// SignInThrottleDAO implements SignInThrottleStore
+type SignInThrottleDAO struct {
+	db *sql.DB
+}
+
+// NewSignInThrottleDAO creates a new SignInThrottleDAO
+func NewSignInThrottleDAO(db *sql.DB) *SignInThrottleDAO {
+	return &SignInThrottleDAO{db}
+}
+
+// LockedUntil returns the end of the latest lockout of the subjects
+func (d *SignInThrottleDAO) LockedUntil(ctx context.Context, subjects []string) (time.Time, error) {
+	if len(subjects) == 0 {
+		return time.Time{}, nil
+	}
+	query := `SELECT COALESCE(MAX(sign_in_throttle.locked_until), 0) FROM sign_in_throttle
+		WHERE sign_in_throttle.subject IN (` + placeholders(len(subjects)) + `)`
+	var lockedUntil int64
+	if err := d.db.QueryRowContext(ctx, query, stringArguments(subjects)...).Scan(&lockedUntil); err != nil {
+		return time.Time{}, fmt.Errorf("Could not retrieve the sign-in lockouts: %w", err)
+	}
+	if lockedUntil == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(lockedUntil, 0), nil
+}
+
+// CountSignInFailure counts a failure of the subject
+func (d *SignInThrottleDAO) CountSignInFailure(
+	ctx context.Context,
+	subject string,
+	at time.Time,
+	forgetBefore time.Time,
+) (uint, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	// Forgotten subjects are removed, including the other ones, so that the table does not keep growing
+	query := `DELETE FROM sign_in_throttle WHERE last_failure_at < ? AND locked_until < ?`
+	if _, err = tx.ExecContext(ctx, query, forgetBefore.Unix(), at.Unix()); err != nil {
+		return 0, fmt.Errorf("Could not forget the previous sign-in failures: %w", err)
+	}
+	query = `INSERT INTO sign_in_throttle(subject, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT(subject) DO UPDATE SET failures = failures + 1, last_failure_at = excluded.last_failure_at`
+	if _, err = tx.ExecContext(ctx, query, subject, at.Unix()); err != nil {
+		return 0, fmt.Errorf("Could not count the sign-in failure of %s: %w", subject, err)
+	}
+	var failures uint
+	query = `SELECT sign_in_throttle.failures FROM sign_in_throttle WHERE sign_in_throttle.subject = ?`
+	if err = tx.QueryRowContext(ctx, query, subject).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("Could not retrieve the sign-in failures of %s: %w", subject, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Could not commit the sign-in failure of %s: %w", subject, err)
+	}
+	return failures, nil
+}
+
+// LockSignIn locks the subject out
+func (d *SignInThrottleDAO) LockSignIn(ctx context.Context, subject string, until time.Time) error {
+	query := `UPDATE sign_in_throttle SET locked_until = ? WHERE subject = ?`
+	if _, err := d.db.ExecContext(ctx, query, until.Unix(), subject); err != nil {
+		return fmt.Errorf("Could not lock %s out: %w", subject, err)
+	}
+	return nil
+}
+
+// ResetSignInFailures forgets the failures of the subjects. Subsonic clients authenticate every request, so it
+// only takes the database write lock when there are failures to forget.
+func (d *SignInThrottleDAO) ResetSignInFailures(ctx context.Context, subjects []string) error {
+	if len(subjects) == 0 {
+		return nil
+	}
+	var hasFailures bool
+	query := `SELECT EXISTS (SELECT 1 FROM sign_in_throttle WHERE subject IN (` + placeholders(len(subjects)) + `))`
+	if err := d.db.QueryRowContext(ctx, query, stringArguments(subjects)...).Scan(&hasFailures); err != nil {
+		return fmt.Errorf("Could not check the sign-in failures: %w", err)
+	}
+	if !hasFailures {
+		return nil
+	}
+	query = `DELETE FROM sign_in_throttle WHERE subject IN (` + placeholders(len(subjects)) + `)`
+	if _, err := d.db.ExecContext(ctx, query, stringArguments(subjects)...); err != nil {
+		return fmt.Errorf("Could not reset the sign-in failures: %w", err)
+	}
+	return nil
+}
+
+// SaveSignInFailure adds the failure to the audit log
+func (d *SignInThrottleDAO) SaveSignInFailure(ctx context.Context, failure *SignInFailure) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	query := `DELETE FROM sign_in_failure WHERE attempted_at < ?`
+	if _, err = tx.ExecContext(ctx, query, failure.AttemptedAt.Add(-signInFailureRetention).Unix()); err != nil {
+		return fmt.Errorf("Could not remove the old sign-in failures: %w", err)
+	}
+	query = `INSERT INTO sign_in_failure(attempted_at, ip, account, reason) VALUES (?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, failure.AttemptedAt.Unix(), failure.IP, failure.Account, failure.Reason)
+	if err != nil {
+		return fmt.Errorf("Could not save the sign-in failure: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Could not retrieve the identifier of the sign-in failure: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Could not commit the sign-in failure: %w", err)
+	}
+	failure.ID = uint(id)
+	return nil
+}
+
+// ListSignInFailures returns a page of the audit log
+func (d *SignInThrottleDAO) ListSignInFailures(ctx context.Context, limit uint, offset uint) ([]SignInFailure, uint, error) {
+	var total uint
+	if err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sign_in_failure`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("Could not count the sign-in failures: %w", err)
+	}
+	sqlLimit := int64(limit)
+	if limit == 0 {
+		sqlLimit = -1
+	}
+	query := `SELECT sign_in_failure.id, sign_in_failure.attempted_at, sign_in_failure.ip, sign_in_failure.account,
+		sign_in_failure.reason FROM sign_in_failure
+		ORDER BY sign_in_failure.attempted_at DESC, sign_in_failure.id DESC LIMIT ? OFFSET ?`
+	rows, err := d.db.QueryContext(ctx, query, sqlLimit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not retrieve the sign-in failures: %w", err)
+	}
+	defer rows.Close()
+	var failures []SignInFailure
+	for rows.Next() {
+		var (
+			failure     SignInFailure
+			attemptedAt int64
+		)
+		if err = rows.Scan(&failure.ID, &attemptedAt, &failure.IP, &failure.Account, &failure.Reason); err != nil {
+			return nil, 0, fmt.Errorf("Could not read a sign-in failure: %w", err)
+		}
+		failure.AttemptedAt = time.Unix(attemptedAt, 0)
+		failures = append(failures, failure)
+	}
+	return failures, total, rows.Err()
+}
+
+// placeholders returns "?, ?, ?" for count parameters of an IN clause
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+func stringArguments(values []string) []interface{} {
+	arguments := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		arguments = append(arguments, value)
+	}
+	return arguments
+}
@@ -0,0 +1,114 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSignInThrottleDAO(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it will count the failures in a row and forget the old ones", func(t *testing.T) {
+		dao := NewSignInThrottleDAO(tests.NewDatabase(t))
+		subject := accountSubject("mike@example.com")
+		for i := 1; i <= 3; i++ {
+			failures, err := dao.CountSignInFailure(ctx, subject, testNow, testNow.Add(-failureMemory))
+			tests.AssertNoError(t, err)
+			if failures != uint(i) {
+				t.Fatalf("expected %d failures, got %d", i, failures)
+			}
+		}
+
+		later := testNow.Add(failureMemory + time.Second)
+		failures, err := dao.CountSignInFailure(ctx, subject, later, later.Add(-failureMemory))
+
+		tests.AssertNoError(t, err)
+		if failures != 1 {
+			t.Errorf("expected the old failures to be forgotten, got %d failures", failures)
+		}
+	})
+
+	t.Run("it will return the latest lockout of the subjects", func(t *testing.T) {
+		dao := NewSignInThrottleDAO(tests.NewDatabase(t))
+		for _, subject := range []string{ipSubject("192.0.2.1"), accountSubject("mike@example.com")} {
+			_, err := dao.CountSignInFailure(ctx, subject, testNow, testNow.Add(-failureMemory))
+			tests.AssertNoError(t, err)
+		}
+		tests.AssertNoError(t, dao.LockSignIn(ctx, ipSubject("192.0.2.1"), testNow.Add(time.Minute)))
+		tests.AssertNoError(t, dao.LockSignIn(ctx, accountSubject("mike@example.com"), testNow.Add(time.Hour)))
+
+		lockedUntil, err := dao.LockedUntil(ctx, []string{ipSubject("192.0.2.1"), accountSubject("mike@example.com")})
+		tests.AssertNoError(t, err)
+		if !lockedUntil.Equal(testNow.Add(time.Hour)) {
+			t.Errorf("expected the latest lockout, got %v", lockedUntil)
+		}
+
+		tests.AssertNoError(t, dao.ResetSignInFailures(ctx, []string{accountSubject("mike@example.com")}))
+		lockedUntil, err = dao.LockedUntil(ctx, []string{accountSubject("mike@example.com")})
+		tests.AssertNoError(t, err)
+		if !lockedUntil.IsZero() {
+			t.Errorf("expected no lockout once reset, got %v", lockedUntil)
+		}
+	})
+
+	t.Run("without failures to forget, resetting them does not write to the database", func(t *testing.T) {
+		db := tests.NewDatabase(t)
+		dao := NewSignInThrottleDAO(db)
+		// Another connection holds the write lock: a write would wait for it
+		writer, err := db.BeginTx(ctx, nil)
+		tests.AssertNoError(t, err)
+		defer writer.Rollback() //nolint:errcheck // Nothing was written
+
+		tests.AssertNoError(t, dao.ResetSignInFailures(ctx, []string{accountSubject("mike@example.com")}))
+	})
+
+	t.Run("it will list a page of the failures, most recent first, and remove the old ones", func(t *testing.T) {
+		dao := NewSignInThrottleDAO(tests.NewDatabase(t))
+		old := &SignInFailure{
+			AttemptedAt: testNow.Add(-signInFailureRetention - time.Second),
+			IP:          "192.0.2.1",
+			Account:     "old@example.com",
+			Reason:      FailureUnknownAccount,
+		}
+		tests.AssertNoError(t, dao.SaveSignInFailure(ctx, old))
+		for i, reason := range []SignInFailureReason{FailureWrongPassword, FailureInvalidCode, FailureLockedOut} {
+			failure := &SignInFailure{
+				AttemptedAt: testNow.Add(time.Duration(i) * time.Second),
+				IP:          "192.0.2.1",
+				Account:     "mike@example.com",
+				Reason:      reason,
+			}
+			tests.AssertNoError(t, dao.SaveSignInFailure(ctx, failure))
+		}
+
+		failures, total, err := dao.ListSignInFailures(ctx, 2, 0)
+
+		tests.AssertNoError(t, err)
+		if total != 3 {
+			t.Errorf("expected the old failure to be removed, got %d failures", total)
+		}
+		if len(failures) != 2 || failures[0].Reason != FailureLockedOut || failures[1].Reason != FailureInvalidCode {
+			t.Errorf("expected the two most recent failures, got %v", failures)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSignInThrottle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it will lock the account out after its free failures", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		throttle.now = func() time.Time { return testNow }
+		for i := 0; i < freeAccountFailures; i++ {
+			tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureWrongPassword))
+		}
+		lockedUntil, err := throttle.LockedUntil(ctx, "192.0.2.1", "mike@example.com")
+		tests.AssertNoError(t, err)
+		if !lockedUntil.IsZero() {
+			t.Fatalf("expected no lockout during the free failures, got %v", lockedUntil)
+		}
+
+		tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.2", "Mike@Example.com", FailureWrongPassword))
+
+		lockedUntil, err = throttle.LockedUntil(ctx, "192.0.2.3", "mike@example.com")
+		tests.AssertNoError(t, err)
+		if want := testNow.Add(firstLockout); !lockedUntil.Equal(want) {
+			t.Errorf("expected the account to be locked out until %v, got %v", want, lockedUntil)
+		}
+	})
+
+	t.Run("once the lockout has passed, it will not be locked out", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		throttle.now = func() time.Time { return testNow }
+		for i := 0; i <= freeAccountFailures; i++ {
+			tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureWrongPassword))
+		}
+
+		throttle.now = func() time.Time { return testNow.Add(firstLockout) }
+		lockedUntil, err := throttle.LockedUntil(ctx, "192.0.2.1", "mike@example.com")
+
+		tests.AssertNoError(t, err)
+		if !lockedUntil.IsZero() {
+			t.Errorf("expected no lockout, got %v", lockedUntil)
+		}
+	})
+
+	t.Run("it will not count the attempts during a lockout", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		throttle.now = func() time.Time { return testNow }
+		for i := 0; i <= freeAccountFailures; i++ {
+			tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureWrongPassword))
+		}
+
+		tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureLockedOut))
+
+		lockedUntil, err := throttle.LockedUntil(ctx, "192.0.2.1", "mike@example.com")
+		tests.AssertNoError(t, err)
+		if want := testNow.Add(firstLockout); !lockedUntil.Equal(want) {
+			t.Errorf("expected the lockout to stay until %v, got %v", want, lockedUntil)
+		}
+	})
+
+	t.Run("a success will forget the failures of the account but not of the IP address", func(t *testing.T) {
+		throttle := newTestSignInThrottle(t)
+		throttle.now = func() time.Time { return testNow }
+		for i := 0; i < freeIPFailures; i++ {
+			tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureWrongPassword))
+		}
+
+		tests.AssertNoError(t, throttle.RecordSuccess(ctx, "mike@example.com"))
+		tests.AssertNoError(t, throttle.RecordFailure(ctx, "192.0.2.1", "mike@example.com", FailureWrongPassword))
+
+		lockedUntil, err := throttle.LockedUntil(ctx, "192.0.2.2", "mike@example.com")
+		tests.AssertNoError(t, err)
+		if !lockedUntil.IsZero() {
+			t.Errorf("expected the account not to be locked out, got %v", lockedUntil)
+		}
+		lockedUntil, err = throttle.LockedUntil(ctx, "192.0.2.1", "someone@example.com")
+		tests.AssertNoError(t, err)
+		if lockedUntil.IsZero() {
+			t.Error("expected the IP address to be locked out")
+		}
+	})
+}
+
+func TestLockoutAfter(t *testing.T) {
+	for _, testCase := range []struct {
+		failures uint
+		want     time.Duration
+	}{
+		{0, 0},
+		{5, 0},
+		{6, time.Minute},
+		{7, 2 * time.Minute},
+		{8, 4 * time.Minute},
+		{12, time.Hour},
+		{100, time.Hour},
+	} {
+		if got := lockoutAfter(testCase.failures, 5); got != testCase.want {
+			t.Errorf("lockoutAfter(%d, 5) = %v, want %v", testCase.failures, got, testCase.want)
+		}
+	}
+}
+
+func newTestSignInThrottle(t *testing.T) *SignInThrottle {
+	t.Helper()
+	return NewSignInThrottle(NewSignInThrottleDAO(tests.NewDatabase(t)))
+}
@@ -0,0 +1,242 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// totpIssuer names the server in authenticator apps
+	totpIssuer = "Mike-sierra-sierra"
+	// totpPeriod is how long a code is valid, in seconds. Authenticator apps expect 30 seconds.
+	totpPeriod         = 30
+	recoveryCodeCount  = 10
+	recoveryCodeBytes  = 5
+	recoveryCodeLength = 2 * recoveryCodeBytes
+)
+
+// ErrInvalidCode is returned when a code is neither the current TOTP code nor an unused recovery code
+var ErrInvalidCode = errors.New("the code is invalid")
+
+// TwoFactorAuthenticator lets users sign in with a time-based one-time password (TOTP) from an
+// authenticator app after their password. Users who lose their authenticator can use one of their
+// single-use recovery codes instead.
+type TwoFactorAuthenticator struct {
+	store  TwoFactorStore
+	cipher *SecretCipher
+	now    func() time.Time
+}
+
+// NewTwoFactorAuthenticator creates a new TwoFactorAuthenticator
+func NewTwoFactorAuthenticator(store TwoFactorStore, cipher *SecretCipher) *TwoFactorAuthenticator {
+	return &TwoFactorAuthenticator{store, cipher, time.Now}
+}
+
+// IsEnabled returns true when the user must give a code to sign in
+func (a *TwoFactorAuthenticator) IsEnabled(ctx context.Context, userID uint) (bool, error) {
+	secrets, err := a.store.GetTOTPSecrets(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return secrets.Secret != nil, nil
+}
+
+// RecoveryCodesLeft returns the number of recovery codes the user has not used yet
+func (a *TwoFactorAuthenticator) RecoveryCodesLeft(ctx context.Context, userID uint) (uint, error) {
+	secrets, err := a.store.GetTOTPSecrets(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return secrets.RecoveryCodesLeft, nil
+}
+
+// StartEnrolment generates a new secret for the user and keeps it until they confirm it with a code.
+// It returns the key to add to authenticator apps. Two-factor authentication stays as it is meanwhile.
+func (a *TwoFactorAuthenticator) StartEnrolment(ctx context.Context, userID uint, accountName string) (*otp.Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: accountName, Period: totpPeriod})
+	if err != nil {
+		return nil, fmt.Errorf("could not generate a TOTP secret: %w", err)
+	}
+	encrypted, err := a.cipher.Encrypt([]byte(key.Secret()))
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt the TOTP secret: %w", err)
+	}
+	if err = a.store.SavePendingTOTPSecret(ctx, userID, encrypted); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ConfirmEnrolment enables two-factor authentication once the user gives the code of their new secret.
+// It returns new recovery codes, they cannot be retrieved later. It returns ErrInvalidCode when the code
+// does not match and ErrNotEnrolling when the user has not started to enrol.
+func (a *TwoFactorAuthenticator) ConfirmEnrolment(ctx context.Context, userID uint, code string) ([]string, error) {
+	secrets, err := a.store.GetTOTPSecrets(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if secrets.PendingSecret == nil {
+		return nil, ErrNotEnrolling
+	}
+	step, ok, err := a.matchTOTPStep(secrets.PendingSecret, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	// Recovery codes are saved as users may type them back
+	normalized := make([]string, 0, len(recoveryCodes))
+	for _, recoveryCode := range recoveryCodes {
+		normalized = append(normalized, normalizeCode(recoveryCode))
+	}
+	if err = a.store.EnableTOTP(ctx, userID, secrets.PendingSecret, step, normalized); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// Disable disables two-factor authentication when the code is valid, see Verify
+func (a *TwoFactorAuthenticator) Disable(ctx context.Context, userID uint, code string) error {
+	if err := a.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	return a.store.DisableTOTP(ctx, userID)
+}
+
+// Verify checks that the code is either the current TOTP code of the user or one of their recovery codes.
+// Each code can only be used once. It returns ErrInvalidCode otherwise.
+func (a *TwoFactorAuthenticator) Verify(ctx context.Context, userID uint, code string) error {
+	secrets, err := a.store.GetTOTPSecrets(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if secrets.Secret == nil {
+		return ErrInvalidCode
+	}
+	code = normalizeCode(code)
+	step, ok, err := a.matchTOTPStep(secrets.Secret, code)
+	if err != nil {
+		return err
+	}
+	if ok {
+		unused, err := a.store.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !unused {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	if len(code) != recoveryCodeLength {
+		return ErrInvalidCode
+	}
+	used, err := a.store.UseRecoveryCode(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// StartSignIn is called once the user gave the right password. The returned challenge must be
+// completed with CompleteSignIn before SignInChallengeLifetime has passed.
+func (a *TwoFactorAuthenticator) StartSignIn(ctx context.Context, userID uint) (*SignInChallenge, error) {
+	return a.store.CreateSignInChallenge(ctx, userID)
+}
+
+// FindSignIn returns the challenge matching the token. It returns ErrSignInChallengeNotFound when
+// the challenge does not match or has expired.
+func (a *TwoFactorAuthenticator) FindSignIn(ctx context.Context, token string) (*SignInChallenge, error) {
+	return a.store.GetSignInChallenge(ctx, token)
+}
+
+// CompleteSignIn verifies the code of the user of the challenge and returns their identifier.
+// The challenge can only be completed once. It returns ErrSignInChallengeNotFound when the challenge
+// does not match or has expired and ErrInvalidCode when the code is invalid, see Verify.
+func (a *TwoFactorAuthenticator) CompleteSignIn(ctx context.Context, token string, code string) (uint, error) {
+	challenge, err := a.store.GetSignInChallenge(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	if err = a.Verify(ctx, challenge.UserID, code); err != nil {
+		return 0, err
+	}
+	if err = a.store.DeleteSignInChallenge(ctx, token); err != nil {
+		return 0, err
+	}
+	return challenge.UserID, nil
+}
+
+// matchTOTPStep returns the time step of the code when it is valid now. Codes of the previous and of the
+// next steps are also accepted, for the clocks that drift.
+func (a *TwoFactorAuthenticator) matchTOTPStep(encryptedSecret []byte, code string) (uint64, bool, error) {
+	secret, err := a.cipher.Decrypt(encryptedSecret)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not decrypt the TOTP secret: %w", err)
+	}
+	options := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := uint64(a.now().Unix()) / totpPeriod
+	for _, step := range []uint64{current, current - 1, current + 1} {
+		expected, err := totp.GenerateCodeCustom(string(secret), time.Unix(int64(step*totpPeriod), 0), options)
+		if err != nil {
+			return 0, false, fmt.Errorf("could not generate a TOTP code: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(normalizeCode(code))) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// generateRecoveryCodes returns random codes written like "1a2b3-c4d5e"
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		randomBytes := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, fmt.Errorf("could not generate a recovery code: %w", err)
+		}
+		code := hex.EncodeToString(randomBytes)
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+	}
+	return codes, nil
+}
+
+// normalizeCode removes the spaces and dashes that users may type in codes, and ignores case
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
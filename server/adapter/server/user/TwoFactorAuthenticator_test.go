@@ -0,0 +1,164 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/tests"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+var testNow = time.Date(2021, time.June, 12, 20, 0, 0, 0, time.UTC)
+
+func TestTwoFactorAuthenticator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it enables two-factor authentication once the user confirms their secret with a code", func(t *testing.T) {
+		authenticator := newTestTwoFactorAuthenticator(t)
+		key, err := authenticator.StartEnrolment(ctx, 1, "admin@example.com")
+		tests.AssertNoError(t, err)
+		enabled, err := authenticator.IsEnabled(ctx, 1)
+		tests.AssertNoError(t, err)
+		if enabled {
+			t.Fatal("expected two-factor authentication to stay disabled until the enrolment is confirmed")
+		}
+
+		_, err = authenticator.ConfirmEnrolment(ctx, 1, "000000")
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("expected ErrInvalidCode, got %v", err)
+		}
+		recoveryCodes, err := authenticator.ConfirmEnrolment(ctx, 1, newTestCode(t, key.Secret(), 0))
+		tests.AssertNoError(t, err)
+		if len(recoveryCodes) != recoveryCodeCount {
+			t.Errorf("expected %d recovery codes, got %v", recoveryCodeCount, recoveryCodes)
+		}
+		enabled, err = authenticator.IsEnabled(ctx, 1)
+		tests.AssertNoError(t, err)
+		if !enabled {
+			t.Error("expected two-factor authentication to be enabled")
+		}
+	})
+
+	t.Run("given a user who has not started to enrol, it returns ErrNotEnrolling", func(t *testing.T) {
+		authenticator := newTestTwoFactorAuthenticator(t)
+		_, err := authenticator.ConfirmEnrolment(ctx, 1, "000000")
+		if !errors.Is(err, ErrNotEnrolling) {
+			t.Errorf("expected ErrNotEnrolling, got %v", err)
+		}
+	})
+
+	t.Run("it accepts codes of the adjacent time steps only once", func(t *testing.T) {
+		authenticator, secret, _ := newEnrolledTwoFactorAuthenticator(t)
+
+		tests.AssertNoError(t, authenticator.Verify(ctx, 1, newTestCode(t, secret, totpPeriod)))
+		for _, offset := range []time.Duration{0, totpPeriod, 3 * totpPeriod} {
+			err := authenticator.Verify(ctx, 1, newTestCode(t, secret, offset))
+			if !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("expected ErrInvalidCode for the code %v ahead, got %v", offset*time.Second, err)
+			}
+		}
+	})
+
+	t.Run("it accepts each recovery code once, whatever the dashes and case", func(t *testing.T) {
+		authenticator, _, recoveryCodes := newEnrolledTwoFactorAuthenticator(t)
+
+		tests.AssertNoError(t, authenticator.Verify(ctx, 1, recoveryCodes[0]))
+		err := authenticator.Verify(ctx, 1, recoveryCodes[0])
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("expected a used recovery code to be refused, got %v", err)
+		}
+		tests.AssertNoError(t, authenticator.Verify(ctx, 1, " "+normalizeCode(recoveryCodes[1])+" "))
+		left, err := authenticator.RecoveryCodesLeft(ctx, 1)
+		tests.AssertNoError(t, err)
+		if left != recoveryCodeCount-2 {
+			t.Errorf("expected %d recovery codes left, got %d", recoveryCodeCount-2, left)
+		}
+	})
+
+	t.Run("it disables two-factor authentication given a valid code", func(t *testing.T) {
+		authenticator, secret, _ := newEnrolledTwoFactorAuthenticator(t)
+
+		err := authenticator.Disable(ctx, 1, "000000")
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("expected ErrInvalidCode, got %v", err)
+		}
+		tests.AssertNoError(t, authenticator.Disable(ctx, 1, newTestCode(t, secret, totpPeriod)))
+		enabled, err := authenticator.IsEnabled(ctx, 1)
+		tests.AssertNoError(t, err)
+		if enabled {
+			t.Error("expected two-factor authentication to be disabled")
+		}
+	})
+
+	t.Run("it completes a sign-in challenge once with a valid code", func(t *testing.T) {
+		authenticator, secret, _ := newEnrolledTwoFactorAuthenticator(t)
+		challenge, err := authenticator.StartSignIn(ctx, 1)
+		tests.AssertNoError(t, err)
+
+		_, err = authenticator.CompleteSignIn(ctx, challenge.Token, "000000")
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("expected ErrInvalidCode, got %v", err)
+		}
+		userID, err := authenticator.CompleteSignIn(ctx, challenge.Token, newTestCode(t, secret, totpPeriod))
+		tests.AssertNoError(t, err)
+		if userID != 1 {
+			t.Errorf("expected the user of the challenge, got #%d", userID)
+		}
+		_, err = authenticator.CompleteSignIn(ctx, challenge.Token, newTestCode(t, secret, -totpPeriod))
+		if !errors.Is(err, ErrSignInChallengeNotFound) {
+			t.Errorf("expected a completed challenge to be refused, got %v", err)
+		}
+	})
+}
+
+// newTestTwoFactorAuthenticator creates a TwoFactorAuthenticator for the administrator #1 at testNow
+func newTestTwoFactorAuthenticator(t *testing.T) *TwoFactorAuthenticator {
+	t.Helper()
+	_, db := newAccountDAOWithAdministrator(t)
+	cipher, err := NewSecretCipher(make([]byte, 32))
+	tests.AssertNoError(t, err)
+	authenticator := NewTwoFactorAuthenticator(NewTwoFactorDAO(db), cipher)
+	authenticator.now = func() time.Time { return testNow }
+	return authenticator
+}
+
+// newEnrolledTwoFactorAuthenticator enables two-factor authentication for the administrator #1 with a code
+// of testNow. It returns the TOTP secret and the recovery codes.
+func newEnrolledTwoFactorAuthenticator(t *testing.T) (*TwoFactorAuthenticator, string, []string) {
+	t.Helper()
+	authenticator := newTestTwoFactorAuthenticator(t)
+	key, err := authenticator.StartEnrolment(context.Background(), 1, "admin@example.com")
+	tests.AssertNoError(t, err)
+	recoveryCodes, err := authenticator.ConfirmEnrolment(context.Background(), 1, newTestCode(t, key.Secret(), 0))
+	tests.AssertNoError(t, err)
+	return authenticator, key.Secret(), recoveryCodes
+}
+
+// newTestCode returns the TOTP code of the secret offset seconds after testNow
+func newTestCode(t *testing.T, secret string, offset time.Duration) string {
+	t.Helper()
+	options := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	code, err := totp.GenerateCodeCustom(secret, testNow.Add(offset*time.Second), options)
+	tests.AssertNoError(t, err)
+	return code
+}
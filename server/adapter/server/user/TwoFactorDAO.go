@@ -0,0 +1,262 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// SignInChallengeLifetime is how long users have to give their code once they gave their password
+	SignInChallengeLifetime   = 5 * time.Minute
+	signInChallengeTokenBytes = 16
+)
+
+var (
+	// ErrNotEnrolling is returned when confirming the enrolment of a user who has not started to enrol
+	// or who restarted meanwhile
+	ErrNotEnrolling = errors.New("the user is not enrolling an authenticator")
+	// ErrSignInChallengeNotFound is returned when a sign-in challenge token does not match or has expired
+	ErrSignInChallengeNotFound = errors.New("the sign-in challenge could not be found or has expired")
+)
+
+// TwoFactorStore handles database operations related to the two-factor authentication of users
+type TwoFactorStore interface {
+	// GetTOTPSecrets returns ErrUserNotFound when there is no such user
+	GetTOTPSecrets(ctx context.Context, userID uint) (*TOTPSecrets, error)
+	// SavePendingTOTPSecret saves the secret of an enrolment until the user confirms it with a first code
+	SavePendingTOTPSecret(ctx context.Context, userID uint, encryptedSecret []byte) error
+	// EnableTOTP replaces the secret of the user with the pending one, records the time step of the code
+	// that confirmed it and replaces the recovery codes. It returns ErrNotEnrolling when the pending
+	// secret is not pendingSecret anymore.
+	EnableTOTP(ctx context.Context, userID uint, pendingSecret []byte, step uint64, recoveryCodes []string) error
+	// DisableTOTP removes the secrets and the recovery codes of the user
+	DisableTOTP(ctx context.Context, userID uint) error
+	// UseTOTPStep records the time step of an accepted code. It returns false when a code of this step
+	// or of a later one was already accepted, so that codes cannot be replayed.
+	UseTOTPStep(ctx context.Context, userID uint, step uint64) (bool, error)
+	// UseRecoveryCode removes the recovery code. It returns false when no recovery code of the user matches.
+	UseRecoveryCode(ctx context.Context, userID uint, code string) (bool, error)
+	// CreateSignInChallenge lets the user give their code until SignInChallengeLifetime has passed
+	CreateSignInChallenge(ctx context.Context, userID uint) (*SignInChallenge, error)
+	// GetSignInChallenge returns ErrSignInChallengeNotFound when the token does not match or has expired
+	GetSignInChallenge(ctx context.Context, token string) (*SignInChallenge, error)
+	DeleteSignInChallenge(ctx context.Context, token string) error
+}
+
+// TOTPSecrets represents the two-factor authentication settings of a user. Secrets are encrypted with
+// a SecretCipher.
+type TOTPSecrets struct {
+	Email             string // Account name of the user in authenticator apps
+	Secret            []byte // It is nil when two-factor authentication is disabled
+	PendingSecret     []byte // It is nil when the user is not enrolling an authenticator
+	RecoveryCodesLeft uint
+}
+
+// SignInChallenge represents a user who gave the right password and still has to give a code to sign in.
+// Only a hash of the token is saved, the token itself is kept in a cookie.
+type SignInChallenge struct {
+	Token     string
+	UserID    uint
+	Email     string // Email of the user, only set by GetSignInChallenge
+	ExpiresAt time.Time
+}
+
+// TwoFactorDAO implements TwoFactorStore
+type TwoFactorDAO struct {
+	db *sql.DB
+}
+
+// NewTwoFactorDAO creates a new TwoFactorDAO
+func NewTwoFactorDAO(db *sql.DB) *TwoFactorDAO {
+	return &TwoFactorDAO{db}
+}
+
+// GetTOTPSecrets returns the two-factor authentication settings of the user
+func (d *TwoFactorDAO) GetTOTPSecrets(ctx context.Context, userID uint) (*TOTPSecrets, error) {
+	query := `SELECT user.email, user.totp_secret, user.totp_pending_secret,
+		(SELECT COUNT(*) FROM recovery_code WHERE recovery_code.user_id = user.id)
+		FROM user WHERE user.id = ?`
+	secrets := &TOTPSecrets{}
+	err := d.db.QueryRowContext(ctx, query, userID).
+		Scan(&secrets.Email, &secrets.Secret, &secrets.PendingSecret, &secrets.RecoveryCodesLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the TOTP secrets of user #%d: %w", userID, err)
+	}
+	return secrets, nil
+}
+
+// SavePendingTOTPSecret replaces the secret of the enrolment in progress
+func (d *TwoFactorDAO) SavePendingTOTPSecret(ctx context.Context, userID uint, encryptedSecret []byte) error {
+	query := `UPDATE user SET totp_pending_secret = ? WHERE user.id = ?`
+	if _, err := d.db.ExecContext(ctx, query, encryptedSecret, userID); err != nil {
+		return fmt.Errorf("Could not save the pending TOTP secret of user #%d: %w", userID, err)
+	}
+	return nil
+}
+
+// EnableTOTP enables two-factor authentication with the pending secret
+func (d *TwoFactorDAO) EnableTOTP(
+	ctx context.Context,
+	userID uint,
+	pendingSecret []byte,
+	step uint64,
+	recoveryCodes []string,
+) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	query := `UPDATE user SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_last_step = ?
+		WHERE user.id = ? AND user.totp_pending_secret = ?`
+	result, err := tx.ExecContext(ctx, query, step, userID, pendingSecret)
+	if err != nil {
+		return fmt.Errorf("Could not enable the TOTP of user #%d: %w", userID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Could not check that the TOTP of user #%d was enabled: %w", userID, err)
+	}
+	if updated == 0 {
+		return ErrNotEnrolling
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM recovery_code WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("Could not remove the previous recovery codes of user #%d: %w", userID, err)
+	}
+	for _, code := range recoveryCodes {
+		query = `INSERT INTO recovery_code(user_id, code_hash) VALUES (?, ?)`
+		if _, err = tx.ExecContext(ctx, query, userID, hashToken(code)); err != nil {
+			return fmt.Errorf("Could not save a recovery code of user #%d: %w", userID, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Could not commit the TOTP of user #%d: %w", userID, err)
+	}
+	return nil
+}
+
+// DisableTOTP disables two-factor authentication for the user
+func (d *TwoFactorDAO) DisableTOTP(ctx context.Context, userID uint) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Could not begin the transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit does nothing
+
+	query := `UPDATE user SET totp_secret = NULL, totp_pending_secret = NULL WHERE user.id = ?`
+	if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("Could not disable the TOTP of user #%d: %w", userID, err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM recovery_code WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("Could not remove the recovery codes of user #%d: %w", userID, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Could not commit the disabled TOTP of user #%d: %w", userID, err)
+	}
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code
+func (d *TwoFactorDAO) UseTOTPStep(ctx context.Context, userID uint, step uint64) (bool, error) {
+	// Checking and updating in the same statement prevents two concurrent uses of the same code
+	query := `UPDATE user SET totp_last_step = ? WHERE user.id = ? AND user.totp_last_step < ?`
+	result, err := d.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("Could not record the TOTP step of user #%d: %w", userID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Could not check the TOTP step of user #%d: %w", userID, err)
+	}
+	return updated != 0, nil
+}
+
+// UseRecoveryCode removes the recovery code of the user
+func (d *TwoFactorDAO) UseRecoveryCode(ctx context.Context, userID uint, code string) (bool, error) {
+	query := `DELETE FROM recovery_code WHERE user_id = ? AND code_hash = ?`
+	result, err := d.db.ExecContext(ctx, query, userID, hashToken(code))
+	if err != nil {
+		return false, fmt.Errorf("Could not use a recovery code of user #%d: %w", userID, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Could not check the recovery code of user #%d: %w", userID, err)
+	}
+	return deleted != 0, nil
+}
+
+// CreateSignInChallenge creates a sign-in challenge for the user. It also removes the expired challenges.
+func (d *TwoFactorDAO) CreateSignInChallenge(ctx context.Context, userID uint) (*SignInChallenge, error) {
+	randomBytes := make([]byte, signInChallengeTokenBytes)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, fmt.Errorf("Could not generate a sign-in challenge token: %w", err)
+	}
+	now := time.Now()
+	challenge := &SignInChallenge{
+		Token:     hex.EncodeToString(randomBytes),
+		UserID:    userID,
+		ExpiresAt: now.Add(SignInChallengeLifetime),
+	}
+	if _, err := d.db.ExecContext(ctx, `DELETE FROM sign_in_challenge WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return nil, fmt.Errorf("Could not remove the expired sign-in challenges: %w", err)
+	}
+	query := `INSERT INTO sign_in_challenge(token_hash, user_id, expires_at) VALUES (?, ?, ?)`
+	_, err := d.db.ExecContext(ctx, query, hashToken(challenge.Token), userID, challenge.ExpiresAt.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the sign-in challenge of user #%d: %w", userID, err)
+	}
+	return challenge, nil
+}
+
+// GetSignInChallenge returns the sign-in challenge matching the token
+func (d *TwoFactorDAO) GetSignInChallenge(ctx context.Context, token string) (*SignInChallenge, error) {
+	query := `SELECT sign_in_challenge.user_id, user.email, sign_in_challenge.expires_at FROM sign_in_challenge
+		INNER JOIN user ON user.id = sign_in_challenge.user_id
+		WHERE sign_in_challenge.token_hash = ? AND sign_in_challenge.expires_at > ?`
+	challenge := &SignInChallenge{Token: token}
+	var expiresAt int64
+	err := d.db.QueryRowContext(ctx, query, hashToken(token), time.Now().Unix()).
+		Scan(&challenge.UserID, &challenge.Email, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSignInChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the sign-in challenge: %w", err)
+	}
+	challenge.ExpiresAt = time.Unix(expiresAt, 0)
+	return challenge, nil
+}
+
+// DeleteSignInChallenge removes the sign-in challenge matching the token
+func (d *TwoFactorDAO) DeleteSignInChallenge(ctx context.Context, token string) error {
+	if _, err := d.db.ExecContext(ctx, `DELETE FROM sign_in_challenge WHERE token_hash = ?`, hashToken(token)); err != nil {
+		return fmt.Errorf("Could not remove the sign-in challenge: %w", err)
+	}
+	return nil
+}
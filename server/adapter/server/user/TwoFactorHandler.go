@@ -0,0 +1,231 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/http"
+
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/pquerna/otp"
+)
+
+const (
+	// TwoFactorURI is the page where signed-in users enable or disable two-factor authentication
+	TwoFactorURI = "/account/two-factor"
+	// qrCodeSize is the width and height of the QR code image in pixels
+	qrCodeSize = 200
+)
+
+type twoFactorPresenter struct {
+	StylesheetURI     string       // Public URI path to the stylesheet
+	Enabled           bool         // Enabled is true when the user must give a code to sign in
+	QRCodeURI         template.URL // Data URI of the QR code to scan with authenticator apps while enrolling
+	Secret            string       // Secret to type in authenticator apps that cannot scan QR codes
+	RecoveryCodes     []string     // New recovery codes, shown only once right after enrolling
+	RecoveryCodesLeft uint
+}
+
+// NewTwoFactorGetHandler creates a new handler for GET /account/two-factor. Users without two-factor
+// authentication start to enrol an authenticator when they open the page.
+func NewTwoFactorGetHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	us Store,
+	tf *TwoFactorAuthenticator,
+) http.Handler {
+	return server.WrapErrors(
+		&getTwoFactorHandler{te, ar, us, tf},
+	)
+}
+
+type getTwoFactorHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+	userStore        Store
+	twoFactor        *TwoFactorAuthenticator
+}
+
+func (h *getTwoFactorHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	current, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the current user: %w", err)
+	}
+	enabled, err := h.twoFactor.IsEnabled(request.Context(), current.ID)
+	if err != nil {
+		return fmt.Errorf("error while checking the two-factor authentication: %w", err)
+	}
+	presenter := &twoFactorPresenter{Enabled: enabled}
+	if enabled {
+		presenter.RecoveryCodesLeft, err = h.twoFactor.RecoveryCodesLeft(request.Context(), current.ID)
+		if err != nil {
+			return fmt.Errorf("error while counting the recovery codes: %w", err)
+		}
+	} else {
+		key, err := h.twoFactor.StartEnrolment(request.Context(), current.ID, current.Email)
+		if err != nil {
+			return fmt.Errorf("error while starting the enrolment: %w", err)
+		}
+		presenter.Secret = key.Secret()
+		presenter.QRCodeURI, err = qrCodeDataURI(key)
+		if err != nil {
+			return err
+		}
+	}
+	return loadTwoFactorTemplate(writer, h.templateExecutor, h.assetsResolver, presenter)
+}
+
+// qrCodeDataURI renders the key as a PNG QR code embedded in a data URI
+func qrCodeDataURI(key *otp.Key) (template.URL, error) {
+	image, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return "", fmt.Errorf("could not render the QR code: %w", err)
+	}
+	var buffer bytes.Buffer
+	if err = png.Encode(&buffer, image); err != nil {
+		return "", fmt.Errorf("could not encode the QR code: %w", err)
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+	return template.URL(uri), nil //nolint:gosec // The URI only contains the encoded PNG
+}
+
+func loadTwoFactorTemplate(
+	writer http.ResponseWriter,
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	presenter *twoFactorPresenter,
+) error {
+	styleSheetURI, err := ar.GetAssetURI("style.css")
+	if err != nil {
+		return fmt.Errorf("could not resolve asset %s: %w", "style.css", err)
+	}
+	presenter.StylesheetURI = styleSheetURI
+	err = te.Load(writer, presenter, "two-factor.html")
+	if err != nil {
+		return fmt.Errorf("could not load template %s: %w", "two-factor.html", err)
+	}
+	return nil
+}
+
+// NewTwoFactorPostHandler creates a new handler for POST /account/two-factor
+func NewTwoFactorPostHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+	us Store,
+	tf *TwoFactorAuthenticator,
+	de *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postTwoFactorHandler{te, ar, us, tf, de},
+	)
+}
+
+// postTwoFactorHandler enables two-factor authentication once users give the first code of their
+// authenticator, then shows their recovery codes
+type postTwoFactorHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+	userStore        Store
+	twoFactor        *TwoFactorAuthenticator
+	decoder          *schema.Decoder
+}
+
+func (h *postTwoFactorHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the code form")
+	}
+	form := new(CodeForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the code form into its representation")
+	}
+	current, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the current user: %w", err)
+	}
+
+	recoveryCodes, err := h.twoFactor.ConfirmEnrolment(request.Context(), current.ID, form.Code)
+	if errors.Is(err, ErrInvalidCode) {
+		return server.NewBadRequestError(err, "The code is invalid, check the clock of the device of your authenticator")
+	}
+	if errors.Is(err, ErrNotEnrolling) {
+		return server.NewConflictError(err, "The enrolment has changed, please reload the two-factor authentication page")
+	}
+	if err != nil {
+		return fmt.Errorf("error while enabling the two-factor authentication: %w", err)
+	}
+	presenter := &twoFactorPresenter{
+		Enabled:           true,
+		RecoveryCodes:     recoveryCodes,
+		RecoveryCodesLeft: uint(len(recoveryCodes)),
+	}
+	return loadTwoFactorTemplate(writer, h.templateExecutor, h.assetsResolver, presenter)
+}
+
+// NewTwoFactorDisablePostHandler creates a new handler for POST /account/two-factor/disable
+func NewTwoFactorDisablePostHandler(
+	us Store,
+	tf *TwoFactorAuthenticator,
+	de *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postTwoFactorDisableHandler{us, tf, de},
+	)
+}
+
+// postTwoFactorDisableHandler disables two-factor authentication. Users must give a code, so that
+// someone using a session left open cannot disable it.
+type postTwoFactorDisableHandler struct {
+	userStore Store
+	twoFactor *TwoFactorAuthenticator
+	decoder   *schema.Decoder
+}
+
+func (h *postTwoFactorDisableHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the code form")
+	}
+	form := new(CodeForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the code form into its representation")
+	}
+	current, err := h.userStore.GetUserMatchingSession(request.Context())
+	if err != nil {
+		return fmt.Errorf("error while retrieving the current user: %w", err)
+	}
+
+	err = h.twoFactor.Disable(request.Context(), current.ID, form.Code)
+	if errors.Is(err, ErrInvalidCode) {
+		return server.NewForbiddenError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error while disabling the two-factor authentication: %w", err)
+	}
+	http.Redirect(writer, request, TwoFactorURI, http.StatusFound)
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetTwoFactorHandler(t *testing.T) {
+	t.Run("when two-factor authentication is disabled, it will start an enrolment and show its QR code", func(t *testing.T) {
+		authenticator := newTestTwoFactorAuthenticator(t)
+		templateExecutor := &presenterTemplateExecutor{}
+		handler := NewTwoFactorGetHandler(templateExecutor, &stubAssetsResolver{false, "style.css"}, &stubDAOForTwoFactor{}, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, TwoFactorURI))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		presenter := templateExecutor.presenter
+		if presenter.Enabled || presenter.Secret == "" || !strings.HasPrefix(string(presenter.QRCodeURI), "data:image/png;base64,") {
+			t.Errorf("expected the secret and the QR code of a new enrolment, got %+v", presenter)
+		}
+		secrets, err := authenticator.store.GetTOTPSecrets(context.Background(), 1)
+		tests.AssertNoError(t, err)
+		if secrets.PendingSecret == nil {
+			t.Error("expected the enrolment to be saved")
+		}
+	})
+
+	t.Run("when two-factor authentication is enabled, it will show the recovery codes left", func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		templateExecutor := &presenterTemplateExecutor{}
+		handler := NewTwoFactorGetHandler(templateExecutor, &stubAssetsResolver{false, "style.css"}, &stubDAOForTwoFactor{}, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, tests.NewGetRequest(t, TwoFactorURI))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		presenter := templateExecutor.presenter
+		if !presenter.Enabled || presenter.Secret != "" || presenter.RecoveryCodesLeft != recoveryCodeCount {
+			t.Errorf("expected two-factor authentication to be enabled, got %+v", presenter)
+		}
+	})
+}
+
+func TestPostTwoFactorHandler(t *testing.T) {
+	t.Run("when the code is invalid, it will return Bad Request", func(t *testing.T) {
+		authenticator := newTestTwoFactorAuthenticator(t)
+		_, err := authenticator.StartEnrolment(context.Background(), 1, "admin@example.com")
+		tests.AssertNoError(t, err)
+		handler := NewTwoFactorPostHandler(
+			&presenterTemplateExecutor{},
+			&stubAssetsResolver{false, "style.css"},
+			&stubDAOForTwoFactor{},
+			authenticator,
+			schema.NewDecoder(),
+		)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorURI, "000000", ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("when the code is valid, it will enable two-factor authentication and show the recovery codes", func(t *testing.T) {
+		authenticator := newTestTwoFactorAuthenticator(t)
+		key, err := authenticator.StartEnrolment(context.Background(), 1, "admin@example.com")
+		tests.AssertNoError(t, err)
+		templateExecutor := &presenterTemplateExecutor{}
+		handler := NewTwoFactorPostHandler(
+			templateExecutor,
+			&stubAssetsResolver{false, "style.css"},
+			&stubDAOForTwoFactor{},
+			authenticator,
+			schema.NewDecoder(),
+		)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorURI, newTestCode(t, key.Secret(), 0), ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		presenter := templateExecutor.presenter
+		if !presenter.Enabled || len(presenter.RecoveryCodes) != recoveryCodeCount {
+			t.Errorf("expected the recovery codes, got %+v", presenter)
+		}
+	})
+}
+
+func TestPostTwoFactorDisableHandler(t *testing.T) {
+	t.Run("when the code is invalid, it will return Forbidden", func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		handler := NewTwoFactorDisablePostHandler(&stubDAOForTwoFactor{}, authenticator, schema.NewDecoder())
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorURI+"/disable", "000000", ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("when the code is valid, it will disable two-factor authentication", func(t *testing.T) {
+		authenticator, _, recoveryCodes := newEnrolledTwoFactorAuthenticator(t)
+		handler := NewTwoFactorDisablePostHandler(&stubDAOForTwoFactor{}, authenticator, schema.NewDecoder())
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorURI+"/disable", recoveryCodes[0], ""))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, TwoFactorURI)
+		enabled, err := authenticator.IsEnabled(context.Background(), 1)
+		tests.AssertNoError(t, err)
+		if enabled {
+			t.Error("expected two-factor authentication to be disabled")
+		}
+	})
+}
+
+// stubDAOForTwoFactor returns the administrator #1 as the current user
+type stubDAOForTwoFactor struct {
+	stubDAOForSignIn
+}
+
+func (s *stubDAOForTwoFactor) GetUserMatchingSession(_ context.Context) (*Current, error) {
+	return &Current{ID: 1, Email: "admin@example.com", Username: "admin", Role: RoleAdministrator}, nil
+}
+
+// presenterTemplateExecutor keeps the presenter of the two-factor authentication page
+type presenterTemplateExecutor struct {
+	presenter *twoFactorPresenter
+}
+
+func (p *presenterTemplateExecutor) Load(_ io.Writer, data interface{}, _ ...string) error {
+	p.presenter, _ = data.(*twoFactorPresenter)
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/swithek/sessionup"
+)
+
+const (
+	// TwoFactorSignInURI is the page where users who enabled two-factor authentication give their code
+	TwoFactorSignInURI = "/sign-in/two-factor"
+	// signInChallengeCookie holds the token of the sign-in challenge between the password and the code
+	signInChallengeCookie = "sign-in-challenge"
+)
+
+// newSignInChallengeCookie only sends the token to the page where users give their code
+func newSignInChallengeCookie(challenge *SignInChallenge) *http.Cookie {
+	return &http.Cookie{
+		Name:     signInChallengeCookie,
+		Value:    challenge.Token,
+		Path:     TwoFactorSignInURI,
+		Expires:  challenge.ExpiresAt,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// NewTwoFactorSignInGetHandler creates a new handler for GET /sign-in/two-factor
+func NewTwoFactorSignInGetHandler(
+	te adapter.TemplateExecutor,
+	ar adapter.AssetsResolver,
+) http.Handler {
+	return server.WrapErrors(
+		&getTwoFactorSignInHandler{te, ar},
+	)
+}
+
+type getTwoFactorSignInHandler struct {
+	templateExecutor adapter.TemplateExecutor
+	assetsResolver   adapter.AssetsResolver
+}
+
+func (h *getTwoFactorSignInHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	if _, err := request.Cookie(signInChallengeCookie); err != nil {
+		http.Redirect(writer, request, "/sign-in", http.StatusFound)
+		return nil
+	}
+	styleSheetURI, err := h.assetsResolver.GetAssetURI("style.css")
+	if err != nil {
+		return fmt.Errorf("could not resolve asset %s: %w", "style.css", err)
+	}
+	presenter := &signInPresenter{StylesheetURI: styleSheetURI}
+	err = h.templateExecutor.Load(writer, presenter, "sign-in-two-factor.html")
+	if err != nil {
+		return fmt.Errorf("could not load template %s: %w", "sign-in-two-factor.html", err)
+	}
+	return nil
+}
+
+// NewTwoFactorSignInPostHandler creates a new handler for POST /sign-in/two-factor
+// Invalid codes are recorded by throttle like wrong passwords.
+func NewTwoFactorSignInPostHandler(
+	twoFactor *TwoFactorAuthenticator,
+	throttle *SignInThrottle,
+	sessionManager *sessionup.Manager,
+	decoder *schema.Decoder,
+) http.Handler {
+	return server.WrapErrors(
+		&postTwoFactorSignInHandler{twoFactor, throttle, sessionManager, decoder},
+	)
+}
+
+// postTwoFactorSignInHandler signs in the users who gave the right password once they give their code
+type postTwoFactorSignInHandler struct {
+	twoFactor      *TwoFactorAuthenticator
+	throttle       *SignInThrottle
+	sessionManager *sessionup.Manager
+	decoder        *schema.Decoder
+}
+
+func (h *postTwoFactorSignInHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) error {
+	err := request.ParseForm()
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not parse the code form")
+	}
+	form := new(CodeForm)
+	err = h.decoder.Decode(form, request.PostForm)
+	if err != nil {
+		return server.NewBadRequestError(err, "Could not decode the code form into its representation")
+	}
+	cookie, err := request.Cookie(signInChallengeCookie)
+	if err != nil {
+		return server.NewForbiddenError(errors.New("Missing sign-in challenge"))
+	}
+
+	challenge, err := h.twoFactor.FindSignIn(request.Context(), cookie.Value)
+	if errors.Is(err, ErrSignInChallengeNotFound) {
+		return server.NewForbiddenError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not retrieve the sign-in challenge: %w", err)
+	}
+	ip := server.ClientIP(request)
+	err = checkSignInLockout(request, writer, h.throttle, ip, challenge.Email)
+	if err != nil {
+		return err
+	}
+
+	userID, err := h.twoFactor.CompleteSignIn(request.Context(), cookie.Value, form.Code)
+	if errors.Is(err, ErrInvalidCode) {
+		return signInFailed(request, h.throttle, ip, challenge.Email, FailureInvalidCode)
+	}
+	if errors.Is(err, ErrSignInChallengeNotFound) {
+		return server.NewForbiddenError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error while verifying the code: %w", err)
+	}
+	err = h.throttle.RecordSuccess(request.Context(), challenge.Email)
+	if err != nil {
+		return fmt.Errorf("could not reset the sign-in failures: %w", err)
+	}
+
+	http.SetCookie(writer, &http.Cookie{Name: signInChallengeCookie, Path: TwoFactorSignInURI, MaxAge: -1})
+	err = h.sessionManager.Init(writer, request, strconv.FormatUint(uint64(userID), 10))
+	if err != nil {
+		return fmt.Errorf("could not decode the user session: %w", err)
+	}
+	http.Redirect(writer, request, "/app", http.StatusFound)
+	return nil
+}
+
+// CodeForm represents the TOTP code or the recovery code provided by users
+type CodeForm struct {
+	Code string `schema:"code,required"`
+}
@@ -0,0 +1,141 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestGetTwoFactorSignInHandler(t *testing.T) {
+	t.Run("without a sign-in challenge, it will redirect to /sign-in", func(t *testing.T) {
+		handler := NewTwoFactorSignInGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"})
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, TwoFactorSignInURI, nil))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/sign-in")
+	})
+
+	t.Run("with a sign-in challenge, it will execute the template", func(t *testing.T) {
+		handler := NewTwoFactorSignInGetHandler(newTemplateExecutorWithValidTemplate(), &stubAssetsResolver{false, "style.css"})
+		request := httptest.NewRequest(http.MethodGet, TwoFactorSignInURI, nil)
+		request.AddCookie(&http.Cookie{Name: signInChallengeCookie, Value: "token"})
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, request)
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	})
+}
+
+func TestPostTwoFactorSignInHandler(t *testing.T) {
+	t.Run("when no code is provided, it will return Bad Request", func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		handler := newTestTwoFactorSignInPostHandler(t, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorSignInURI, "", "token"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("when the sign-in challenge does not match, it will return Forbidden", func(t *testing.T) {
+		authenticator, secret, _ := newEnrolledTwoFactorAuthenticator(t)
+		handler := newTestTwoFactorSignInPostHandler(t, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorSignInURI, newTestCode(t, secret, totpPeriod), "unknown"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("when the code is invalid, it will return Forbidden", func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		challenge, err := authenticator.StartSignIn(context.Background(), 1)
+		tests.AssertNoError(t, err)
+		handler := newTestTwoFactorSignInPostHandler(t, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorSignInURI, "000000", challenge.Token))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("when the code is invalid, it will record the failure of the account of the challenge", func(t *testing.T) {
+		authenticator, _, _ := newEnrolledTwoFactorAuthenticator(t)
+		challenge, err := authenticator.StartSignIn(context.Background(), 1)
+		tests.AssertNoError(t, err)
+		throttle := newTestSignInThrottle(t)
+		handler := NewTwoFactorSignInPostHandler(authenticator, throttle, tests.NewValidSessionManager(t), schema.NewDecoder())
+
+		handler.ServeHTTP(httptest.NewRecorder(), newPostCodeRequest(TwoFactorSignInURI, "000000", challenge.Token))
+
+		failures, _, err := throttle.store.ListSignInFailures(context.Background(), 0, 0)
+		tests.AssertNoError(t, err)
+		if len(failures) != 1 || failures[0].Reason != FailureInvalidCode || failures[0].Account != "admin@example.com" {
+			t.Errorf("expected an invalid code failure of the administrator, got %v", failures)
+		}
+	})
+
+	t.Run("when successful, it will initialize the session and redirect to /app", func(t *testing.T) {
+		authenticator, secret, _ := newEnrolledTwoFactorAuthenticator(t)
+		challenge, err := authenticator.StartSignIn(context.Background(), 1)
+		tests.AssertNoError(t, err)
+		handler := newTestTwoFactorSignInPostHandler(t, authenticator)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newPostCodeRequest(TwoFactorSignInURI, newTestCode(t, secret, totpPeriod), challenge.Token))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/app")
+		var hasSession bool
+		for _, cookie := range response.Result().Cookies() {
+			hasSession = hasSession || (cookie.Name == "id" && cookie.Value != "")
+		}
+		if !hasSession {
+			t.Error("expected a session cookie")
+		}
+	})
+}
+
+// newPostCodeRequest posts the code form. The sign-in challenge cookie is only added when token is not empty.
+func newPostCodeRequest(uri string, code string, token string) *http.Request {
+	body := ""
+	if code != "" {
+		body = "code=" + code
+	}
+	request := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		request.AddCookie(&http.Cookie{Name: signInChallengeCookie, Value: token})
+	}
+	return request
+}
+
+func newTestTwoFactorSignInPostHandler(t *testing.T, authenticator *TwoFactorAuthenticator) http.Handler {
+	t.Helper()
+	return NewTwoFactorSignInPostHandler(authenticator, newTestSignInThrottle(t), tests.NewValidSessionManager(t), schema.NewDecoder())
+}
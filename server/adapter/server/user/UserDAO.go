@@ -20,16 +20,40 @@ package user
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/swithek/sessionup"
 )
 
-// Store handles database operations related to Users
+// ErrAdministratorExists is returned when registering the first administrator after setup
+var ErrAdministratorExists = errors.New("an administrator account already exists")
+
+// Role decides what a user is allowed to do
+type Role string
+
+const (
+	// RoleAdministrator users can listen to music and manage the other users
+	RoleAdministrator Role = "admin"
+	// RoleListener users can listen to music
+	RoleListener Role = "listener"
+)
+
+// IsValid returns true when the role is one of the known roles
+func (r Role) IsValid() bool {
+	return r == RoleAdministrator || r == RoleListener
+}
+
+// Store handles database operations related to Users. Disabled users never match.
 type Store interface {
 	GetUserMatchingEmail(ctx context.Context, email string) (*PossibleMatch, error)
 	GetUserMatchingSession(ctx context.Context) (*Current, error)
+	// HasAdministrator returns true once the first administrator account has been registered
+	HasAdministrator(ctx context.Context) (bool, error)
+	// SaveFirstAdministrator returns ErrAdministratorExists when an administrator account already exists
 	SaveFirstAdministrator(ctx context.Context, registration *Registration) error
+	GetSubsonicCredentials(ctx context.Context, username string) (*SubsonicCredentials, error)
+	SaveSubsonicPassword(ctx context.Context, userID uint, encryptedPassword []byte) error
 }
 
 // DAO implements Store
@@ -45,7 +69,7 @@ func NewDAO(db *sql.DB) Store {
 // GetUserMatchingEmail retrieves the user matching the provided email address.
 // If the email is found, it will return a PossibleMatch. If it isn't found, it will return an error.
 func (d *DAO) GetUserMatchingEmail(ctx context.Context, email string) (*PossibleMatch, error) {
-	query := `SELECT user.id, user.email, user.password FROM user WHERE user.email = ?`
+	query := `SELECT user.id, user.email, user.password FROM user WHERE user.email = ? AND user.disabled = 0`
 	row := d.db.QueryRowContext(ctx, query, email)
 	var (
 		id           uint
@@ -70,17 +94,13 @@ type PossibleMatch struct {
 // the request context.
 func (d *DAO) GetUserMatchingSession(ctx context.Context) (*Current, error) {
 	session, _ := sessionup.FromContext(ctx)
-	query := `SELECT user.id, user.email, user.username FROM user WHERE user.id = ?`
+	query := `SELECT user.id, user.email, user.username, user.role FROM user WHERE user.id = ? AND user.disabled = 0`
 	row := d.db.QueryRowContext(ctx, query, session.UserKey)
-	var (
-		id       uint
-		email    string
-		username string
-	)
-	if err := row.Scan(&id, &email, &username); err != nil {
+	current := &Current{}
+	if err := row.Scan(&current.ID, &current.Email, &current.Username, &current.Role); err != nil {
 		return nil, fmt.Errorf("Could not retrieve current user from session: %w", err)
 	}
-	return &Current{id, email, username}, nil
+	return current, nil
 }
 
 // Current represents the currently signed-in user authentified by its session.
@@ -88,14 +108,51 @@ type Current struct {
 	ID       uint
 	Email    string
 	Username string
+	Role     Role
+}
+
+// IsAdministrator returns true when the user can manage the other users
+func (c *Current) IsAdministrator() bool {
+	return c.Role == RoleAdministrator
+}
+
+// HasAdministrator returns true once the first administrator account has been registered
+func (d *DAO) HasAdministrator(ctx context.Context) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM user WHERE user.role = ?)`
+	var exists bool
+	if err := d.db.QueryRowContext(ctx, query, RoleAdministrator).Scan(&exists); err != nil {
+		return false, fmt.Errorf("Could not check whether an administrator exists: %w", err)
+	}
+	return exists, nil
 }
 
 // SaveFirstAdministrator creates the first administrator account whose
-// credentials are given in the registration.
+// credentials are given in the registration. The role of the registration is ignored.
+// It returns ErrAdministratorExists when an administrator account already exists.
 func (d *DAO) SaveFirstAdministrator(ctx context.Context, registration *Registration) error {
-	query := `INSERT INTO user(email, password, username) VALUES (?, ?, ?)`
-	_, err := d.db.ExecContext(ctx, query, registration.Email, registration.PasswordHash, registration.Username)
-	return err
+	// Checking and inserting in the same statement prevents two concurrent registrations
+	query := `INSERT INTO user(email, password, username, role) SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM user WHERE user.role = ?)`
+	result, err := d.db.ExecContext(
+		ctx,
+		query,
+		registration.Email,
+		registration.PasswordHash,
+		registration.Username,
+		RoleAdministrator,
+		RoleAdministrator,
+	)
+	if err != nil {
+		return fmt.Errorf("Could not save the first administrator: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Could not check that the first administrator was saved: %w", err)
+	}
+	if inserted == 0 {
+		return ErrAdministratorExists
+	}
+	return nil
 }
 
 // Registration represents the data needed to save the user in databse. Instead of a password,
@@ -104,4 +161,40 @@ type Registration struct {
 	Email        string
 	PasswordHash []byte
 	Username     string
+	Role         Role
+}
+
+// GetSubsonicCredentials retrieves the credentials of the user matching the provided username.
+// Subsonic API clients identify users by their username instead of their email.
+func (d *DAO) GetSubsonicCredentials(ctx context.Context, username string) (*SubsonicCredentials, error) {
+	query := `SELECT user.id, user.email, user.username, user.subsonic_password
+		FROM user WHERE user.username = ? AND user.disabled = 0`
+	credentials := &SubsonicCredentials{}
+	row := d.db.QueryRowContext(ctx, query, username)
+	err := row.Scan(&credentials.ID, &credentials.Email, &credentials.Username, &credentials.EncryptedSubsonicPassword)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the user by its username: %w", err)
+	}
+	return credentials, nil
+}
+
+// SubsonicCredentials represents a user of the Subsonic API. Subsonic's token authentication needs
+// the server to know the password, so users have a separate, generated password for the Subsonic API.
+type SubsonicCredentials struct {
+	ID       uint
+	Email    string // Email identifies the account for the sign-in throttle
+	Username string
+	// EncryptedSubsonicPassword is the password for the Subsonic API, encrypted with a SecretCipher.
+	// It is nil when the user has not generated any.
+	EncryptedSubsonicPassword []byte
+}
+
+// SaveSubsonicPassword replaces the password of the user for the Subsonic API. The password must have been
+// encrypted with a SecretCipher. A nil password prevents the user from using the Subsonic API.
+func (d *DAO) SaveSubsonicPassword(ctx context.Context, userID uint, encryptedPassword []byte) error {
+	query := `UPDATE user SET subsonic_password = ? WHERE user.id = ?`
+	if _, err := d.db.ExecContext(ctx, query, encryptedPassword, userID); err != nil {
+		return fmt.Errorf("Could not save the Subsonic password of user #%d: %w", userID, err)
+	}
+	return nil
 }
@@ -23,31 +23,73 @@ package user
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
 	"github.com/swithek/sessionup"
 )
 
+const (
+	// signInBurst is how many sign-in forms a client IP address can post at once
+	signInBurst = 10
+	// signInInterval is how long a client IP address waits for each further sign-in form
+	signInInterval = 6 * time.Second
+)
+
 // Register registers the routes on the given gorilla/mux router.
+// The forms are protected against cross-site request forgery by csrf and
+// the sign-in forms are rate-limited for each client IP address.
 func Register(
 	router *mux.Router,
 	templateExecutor adapter.TemplateExecutor,
 	assetsResolver adapter.AssetsResolver,
 	userStore Store,
+	accountStore AccountStore,
+	twoFactor *TwoFactorAuthenticator,
+	throttle *SignInThrottle,
 	sessionManager *sessionup.Manager,
+	csrf *server.CSRFProtection,
 	decoder *schema.Decoder,
 ) {
 	getSignInHandler := NewSignInGetHandler(templateExecutor, assetsResolver)
-	postSignInHandler := NewSignInPostHandler(userStore, sessionManager, decoder)
-	getFirstTimeRegistrationHandler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver)
+	signInLimiter := server.NewRateLimiter(signInBurst, signInInterval)
+	postSignInHandler := signInLimiter.Middleware(NewSignInPostHandler(userStore, twoFactor, throttle, sessionManager, decoder))
+	getTwoFactorSignInHandler := NewTwoFactorSignInGetHandler(templateExecutor, assetsResolver)
+	postTwoFactorSignInHandler := signInLimiter.Middleware(
+		NewTwoFactorSignInPostHandler(twoFactor, throttle, sessionManager, decoder),
+	)
+	getTwoFactorHandler := sessionManager.Auth(NewTwoFactorGetHandler(templateExecutor, assetsResolver, userStore, twoFactor))
+	postTwoFactorHandler := sessionManager.Auth(
+		NewTwoFactorPostHandler(templateExecutor, assetsResolver, userStore, twoFactor, decoder),
+	)
+	postTwoFactorDisableHandler := sessionManager.Auth(NewTwoFactorDisablePostHandler(userStore, twoFactor, decoder))
+	getFirstTimeRegistrationHandler := NewFirstTimeRegistrationGetHandler(templateExecutor, assetsResolver, userStore)
 	postFirstTimeRegistrationHandler := NewFirstTimeRegistrationPostHandler(userStore, decoder)
+	getInvitationHandler := NewInvitationGetHandler(templateExecutor, assetsResolver, accountStore)
+	postInvitationHandler := NewInvitationPostHandler(accountStore, decoder)
 	postSignOutHandler := sessionManager.Auth(NewSignOutPostHandler(sessionManager))
+	getPasswordHandler := sessionManager.Auth(NewPasswordGetHandler(templateExecutor, assetsResolver))
+	postPasswordHandler := sessionManager.Auth(NewPasswordPostHandler(userStore, accountStore, sessionManager, decoder))
+	getPasswordResetHandler := NewPasswordResetGetHandler(templateExecutor, assetsResolver, accountStore)
+	postPasswordResetHandler := NewPasswordResetPostHandler(accountStore, sessionManager, decoder)
 
-	router.Handle("/first-time-registration", getFirstTimeRegistrationHandler).Methods(http.MethodGet)
-	router.Handle("/first-time-registration", postFirstTimeRegistrationHandler).Methods(http.MethodPost)
-	router.Handle("/sign-in", getSignInHandler).Methods(http.MethodGet)
-	router.Handle("/sign-in", postSignInHandler).Methods(http.MethodPost)
-	router.Handle("/sign-out", postSignOutHandler).Methods(http.MethodPost)
+	router.Handle("/first-time-registration", csrf.Middleware(getFirstTimeRegistrationHandler)).Methods(http.MethodGet)
+	router.Handle("/first-time-registration", csrf.Middleware(postFirstTimeRegistrationHandler)).Methods(http.MethodPost)
+	router.Handle("/invitations/{token:[0-9a-f]+}", csrf.Middleware(getInvitationHandler)).Methods(http.MethodGet)
+	router.Handle("/invitations/{token:[0-9a-f]+}", csrf.Middleware(postInvitationHandler)).Methods(http.MethodPost)
+	router.Handle("/password-reset/{token:[0-9a-f]+}", csrf.Middleware(getPasswordResetHandler)).Methods(http.MethodGet)
+	router.Handle("/password-reset/{token:[0-9a-f]+}", csrf.Middleware(postPasswordResetHandler)).Methods(http.MethodPost)
+	router.Handle("/sign-in", csrf.Middleware(getSignInHandler)).Methods(http.MethodGet)
+	router.Handle("/sign-in", csrf.Middleware(postSignInHandler)).Methods(http.MethodPost)
+	router.Handle(TwoFactorSignInURI, csrf.Middleware(getTwoFactorSignInHandler)).Methods(http.MethodGet)
+	router.Handle(TwoFactorSignInURI, csrf.Middleware(postTwoFactorSignInHandler)).Methods(http.MethodPost)
+	router.Handle("/sign-out", csrf.Middleware(postSignOutHandler)).Methods(http.MethodPost)
+	router.Handle(PasswordURI, csrf.Middleware(getPasswordHandler)).Methods(http.MethodGet)
+	router.Handle(PasswordURI, csrf.Middleware(postPasswordHandler)).Methods(http.MethodPost)
+	router.Handle(TwoFactorURI, csrf.Middleware(getTwoFactorHandler)).Methods(http.MethodGet)
+	router.Handle(TwoFactorURI, csrf.Middleware(postTwoFactorHandler)).Methods(http.MethodPost)
+	router.Handle(TwoFactorURI+"/disable", csrf.Middleware(postTwoFactorDisableHandler)).Methods(http.MethodPost)
 }
@@ -0,0 +1,74 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestRouter(t *testing.T) {
+	router := mux.NewRouter()
+	csrf := server.NewCSRFProtection(make([]byte, 32))
+	Register(
+		router,
+		newTemplateExecutorWithValidTemplate(),
+		&stubAssetsResolver{false, "style.css"},
+		&stubDAOForSignIn{true},
+		&stubAccountStore{},
+		newTestTwoFactorAuthenticator(t),
+		newTestSignInThrottle(t),
+		tests.NewValidSessionManager(t),
+		csrf,
+		schema.NewDecoder(),
+	)
+	credentials := url.Values{"email": {"mike@example.com"}, "password": {"welcome0"}}
+
+	t.Run("GET /sign-in will give an anti-CSRF cookie", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, tests.NewGetRequest(t, "/sign-in"))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertCSRFCookieSet(t, response)
+	})
+
+	t.Run("POST /sign-in without anti-CSRF token will return Forbidden", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, tests.NewPostFormRequest(t, nil, "/sign-in", credentials))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("POST /sign-in with an anti-CSRF token will sign in", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, tests.NewPostFormRequest(t, csrf, "/sign-in", credentials))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusFound)
+		tests.AssertLocationHeaderEquals(t, response, "/app")
+	})
+}
@@ -0,0 +1,245 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// Subsonic identifies everything with strings. Folders are identified by their path, prefixed so that
+// they are not mistaken for songs, which are identified by their ID in the library index.
+const (
+	folderIDPrefix = "f-"
+	// ignoredArticles are skipped when sorting and grouping folders by their first letter
+	ignoredArticles = "The El La Los Las Le Les"
+)
+
+var errInvalidID = errors.New("the identifier is not valid")
+
+func folderID(folderPath string) string {
+	return folderIDPrefix + base64.RawURLEncoding.EncodeToString([]byte(folderPath))
+}
+
+func parseFolderID(id string) (string, error) {
+	if !strings.HasPrefix(id, folderIDPrefix) {
+		return "", errInvalidID
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, folderIDPrefix))
+	if err != nil {
+		return "", errInvalidID
+	}
+	folderPath := path.Clean(string(decoded))
+	if folderPath == ".." || strings.HasPrefix(folderPath, "../") || path.IsAbs(folderPath) {
+		return "", errInvalidID
+	}
+	return folderPath, nil
+}
+
+func parseSongID(id string) (uint, error) {
+	songID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, errInvalidID
+	}
+	return uint(songID), nil
+}
+
+func coverArtID(coverID uint) string {
+	if coverID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(coverID), 10)
+}
+
+// songPath returns the path of the song from the top-level folder of the music library, starting with its root.
+// For example "music/Nightwish/Once/ghost.mp3"
+func songPath(song music.Song) string {
+	return strings.TrimPrefix(song.URI, music.MusicPath+"/")
+}
+
+func fromSubFolder(folder music.SubFolder, parentID string) child {
+	return child{
+		ID:       folderID(folder.Path),
+		Parent:   parentID,
+		IsDir:    true,
+		Title:    folder.Name,
+		CoverArt: coverArtID(folder.CoverID),
+	}
+}
+
+func fromSong(song music.Song) child {
+	filePath := songPath(song)
+	return child{
+		ID:          strconv.FormatUint(uint64(song.ID), 10),
+		Parent:      folderID(path.Dir(filePath)),
+		Title:       song.Title,
+		Album:       song.Album,
+		Artist:      song.Artist,
+		Genre:       song.Genre,
+		Track:       song.TrackNumber,
+		DiscNumber:  song.DiskNumber,
+		CoverArt:    coverArtID(song.CoverID),
+		ContentType: song.Type,
+		Suffix:      strings.TrimPrefix(strings.ToLower(path.Ext(filePath)), "."),
+		Duration:    song.Duration,
+		Path:        filePath,
+		Type:        "music",
+	}
+}
+
+type pingEndpoint struct{}
+
+func (e *pingEndpoint) ServeSubsonic(_ http.ResponseWriter, _ *http.Request) (*response, error) {
+	return newResponse(), nil
+}
+
+// getMusicFoldersEndpoint lists the roots of the music library as music folders.
+// Their identifiers are their position in the top-level folder, starting at 1.
+type getMusicFoldersEndpoint struct {
+	explorer music.MusicLibraryExplorer
+}
+
+func (e *getMusicFoldersEndpoint) ServeSubsonic(_ http.ResponseWriter, _ *http.Request) (*response, error) {
+	roots, _, err := e.explorer.ListContents(".")
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the roots of the music library: %w", err)
+	}
+	result := newResponse()
+	result.MusicFolders = &musicFolders{make([]musicFolder, 0, len(roots))}
+	for i, root := range roots {
+		result.MusicFolders.Folders = append(result.MusicFolders.Folders, musicFolder{ID: i + 1, Name: root.Name})
+	}
+	return result, nil
+}
+
+// getIndexesEndpoint groups the folders at the top of the roots of the music library by their first letter.
+// The optional "musicFolderId" parameter restricts them to a single root.
+type getIndexesEndpoint struct {
+	explorer music.MusicLibraryExplorer
+}
+
+func (e *getIndexesEndpoint) ServeSubsonic(_ http.ResponseWriter, request *http.Request) (*response, error) {
+	roots, _, err := e.explorer.ListContents(".")
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the roots of the music library: %w", err)
+	}
+	if id := request.Form.Get("musicFolderId"); id != "" {
+		position, err := strconv.Atoi(id)
+		if err != nil || position < 1 || position > len(roots) {
+			return nil, newNotFoundError(errInvalidID)
+		}
+		roots = roots[position-1 : position]
+	}
+	var folders []music.SubFolder
+	var songs []music.Song
+	for _, root := range roots {
+		rootFolders, rootSongs, err := e.explorer.ListContents(root.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error while retrieving the contents of the root %s: %w", root.Name, err)
+		}
+		folders = append(folders, rootFolders...)
+		songs = append(songs, rootSongs...)
+	}
+	sort.SliceStable(folders, func(i, j int) bool {
+		return strings.ToLower(withoutArticle(folders[i].Name)) < strings.ToLower(withoutArticle(folders[j].Name))
+	})
+	result := newResponse()
+	result.Indexes = &indexes{IgnoredArticles: ignoredArticles, Indexes: make([]index, 0)}
+	for _, folder := range folders {
+		name := indexName(folder.Name)
+		last := len(result.Indexes.Indexes) - 1
+		if last < 0 || result.Indexes.Indexes[last].Name != name {
+			result.Indexes.Indexes = append(result.Indexes.Indexes, index{Name: name})
+			last++
+		}
+		result.Indexes.Indexes[last].Artists = append(result.Indexes.Indexes[last].Artists, indexArtist{
+			ID:       folderID(folder.Path),
+			Name:     folder.Name,
+			CoverArt: coverArtID(folder.CoverID),
+		})
+	}
+	for _, song := range songs {
+		result.Indexes.Children = append(result.Indexes.Children, fromSong(song))
+	}
+	return result, nil
+}
+
+// withoutArticle removes the leading article of the name. For example "The Beatles" becomes "Beatles"
+func withoutArticle(name string) string {
+	for _, article := range strings.Fields(ignoredArticles) {
+		if len(name) > len(article)+1 && strings.EqualFold(name[:len(article)+1], article+" ") {
+			return name[len(article)+1:]
+		}
+	}
+	return name
+}
+
+// indexName returns the uppercase first letter of the name, or "#" when it does not start with a letter
+func indexName(name string) string {
+	for _, r := range withoutArticle(name) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+// getMusicDirectoryEndpoint lists the folders and songs of a folder
+type getMusicDirectoryEndpoint struct {
+	explorer music.MusicLibraryExplorer
+}
+
+func (e *getMusicDirectoryEndpoint) ServeSubsonic(_ http.ResponseWriter, request *http.Request) (*response, error) {
+	id := request.Form.Get("id")
+	if id == "" {
+		return nil, newMissingParameterError("id")
+	}
+	folderPath, err := parseFolderID(id)
+	if err != nil {
+		return nil, newNotFoundError(err)
+	}
+	folders, songs, err := e.explorer.ListContents(folderPath)
+	if errors.Is(err, music.ErrFolderNotFound) {
+		return nil, newNotFoundError(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the contents of the folder %s: %w", folderPath, err)
+	}
+	result := newResponse()
+	result.Directory = &directory{ID: id, Name: path.Base(folderPath), Children: make([]child, 0)}
+	if folderPath != "." {
+		result.Directory.Parent = folderID(path.Dir(folderPath))
+	}
+	for _, folder := range folders {
+		result.Directory.Children = append(result.Directory.Children, fromSubFolder(folder, id))
+	}
+	for _, song := range songs {
+		result.Directory.Children = append(result.Directory.Children, fromSong(song))
+	}
+	return result, nil
+}
@@ -0,0 +1,175 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestGetIndexes(t *testing.T) {
+	got := serveSubsonic(t, newTestRouter(t), "/rest/getIndexes", url.Values{})
+
+	assertResponseStatus(t, got, "ok")
+	if got.Indexes == nil {
+		t.Fatal("expected indexes in the response")
+	}
+	want := map[string][]string{"#": {"2Cellos"}, "B": {"The Beatles"}, "N": {"Nightwish"}}
+	if len(got.Indexes.Indexes) != len(want) {
+		t.Fatalf("expected %d indexes, got %+v", len(want), got.Indexes.Indexes)
+	}
+	for _, index := range got.Indexes.Indexes {
+		wantNames := want[index.Name]
+		if len(index.Artists) != len(wantNames) || index.Artists[0].Name != wantNames[0] {
+			t.Errorf("expected index %s to contain %v, got %+v", index.Name, wantNames, index.Artists)
+		}
+	}
+	if got.Indexes.Indexes[1].Artists[0].ID != folderID("music/The Beatles") {
+		t.Errorf("expected the artist ID to be the folder ID of its path, got %s", got.Indexes.Indexes[1].Artists[0].ID)
+	}
+	if len(got.Indexes.Children) != 2 || got.Indexes.Children[0].Title != "Intro" {
+		t.Errorf("expected the songs at the top of the roots as children, got %+v", got.Indexes.Children)
+	}
+
+	t.Run("given a music folder, it only lists the contents of its root", func(t *testing.T) {
+		got := serveSubsonic(t, newTestRouter(t), "/rest/getIndexes", url.Values{"musicFolderId": {"2"}})
+
+		assertResponseStatus(t, got, "ok")
+		if len(got.Indexes.Indexes) != 0 || len(got.Indexes.Children) != 1 || got.Indexes.Children[0].Title != "Episode 1" {
+			t.Errorf("expected only the contents of the podcasts root, got %+v", got.Indexes)
+		}
+	})
+
+	t.Run("given an unknown music folder, it returns a Not found error", func(t *testing.T) {
+		got := serveSubsonic(t, newTestRouter(t), "/rest/getIndexes", url.Values{"musicFolderId": {"3"}})
+		assertResponseError(t, got, errorNotFound)
+	})
+}
+
+func TestGetMusicFolders(t *testing.T) {
+	got := serveSubsonic(t, newTestRouter(t), "/rest/getMusicFolders", url.Values{})
+
+	assertResponseStatus(t, got, "ok")
+	if got.MusicFolders == nil || len(got.MusicFolders.Folders) != 2 {
+		t.Fatalf("expected a music folder for each root, got %+v", got.MusicFolders)
+	}
+	if podcasts := got.MusicFolders.Folders[1]; podcasts.ID != 2 || podcasts.Name != "podcasts" {
+		t.Errorf("unexpected music folder %+v", podcasts)
+	}
+}
+
+func TestGetMusicDirectory(t *testing.T) {
+	router := newTestRouter(t)
+
+	t.Run("it lists the folders and songs of the folder", func(t *testing.T) {
+		got := serveSubsonic(t, router, "/rest/getMusicDirectory.view", url.Values{"id": {folderID("music/Nightwish")}})
+
+		assertResponseStatus(t, got, "ok")
+		if got.Directory == nil || got.Directory.Name != "Nightwish" || got.Directory.Parent != folderID("music") {
+			t.Fatalf("unexpected directory %+v", got.Directory)
+		}
+		if len(got.Directory.Children) != 2 {
+			t.Fatalf("expected a folder and a song, got %+v", got.Directory.Children)
+		}
+		folder, song := got.Directory.Children[0], got.Directory.Children[1]
+		if !folder.IsDir || folder.ID != folderID("music/Nightwish/Once") || folder.CoverArt != "8" {
+			t.Errorf("unexpected folder %+v", folder)
+		}
+		if song.IsDir || song.ID != "12" || song.Suffix != "flac" || song.Path != "music/Nightwish/Ghost Love Score.flac" {
+			t.Errorf("unexpected song %+v", song)
+		}
+	})
+
+	for name, id := range map[string]string{
+		"a song identifier":     "12",
+		"a parent of the root":  folderID("../etc"),
+		"an absolute path":      folderID("/etc"),
+		"an unknown folder":     folderID("Unknown"),
+		"an invalid identifier": "f-$$$",
+	} {
+		t.Run("given "+name+", it returns a Not found error", func(t *testing.T) {
+			got := serveSubsonic(t, router, "/rest/getMusicDirectory", url.Values{"id": {id}})
+			assertResponseError(t, got, errorNotFound)
+		})
+	}
+
+	t.Run("given no id, it returns a Missing parameter error", func(t *testing.T) {
+		got := serveSubsonic(t, router, "/rest/getMusicDirectory", url.Values{})
+		assertResponseError(t, got, errorMissingParameter)
+	})
+}
+
+func TestIndexName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Nightwish":   "N",
+		"the Beatles": "B",
+		"Les Ogres":   "O",
+		"Theatre":     "T",
+		"The":         "T",
+		"2Cellos":     "#",
+		"évanescence": "É",
+		"(hed) p.e.":  "#",
+	} {
+		if got := indexName(name); got != want {
+			t.Errorf("expected the index of %q to be %s, got %s", name, want, got)
+		}
+	}
+}
+
+// stubExplorer lists the contents of a small music library
+type stubExplorer struct {
+	folders map[string][]music.SubFolder
+	songs   map[string][]music.Song
+}
+
+func newStubExplorer() *stubExplorer {
+	return &stubExplorer{
+		folders: map[string][]music.SubFolder{
+			".": {{Name: "music", Path: "music"}, {Name: "podcasts", Path: "podcasts"}},
+			"music": {
+				{Name: "Nightwish", Path: "music/Nightwish"},
+				{Name: "2Cellos", Path: "music/2Cellos"},
+				{Name: "The Beatles", Path: "music/The Beatles"},
+			},
+			"music/Nightwish": {{Name: "Once", Path: "music/Nightwish/Once", CoverID: 8}},
+			"podcasts":        {},
+		},
+		songs: map[string][]music.Song{
+			"music":    {{ID: 3, Title: "Intro", URI: "/music/music/Intro.mp3", Type: "audio/mpeg"}},
+			"podcasts": {{ID: 20, Title: "Episode 1", URI: "/music/podcasts/episode1.mp3", Type: "audio/mpeg"}},
+			"music/Nightwish": {{
+				ID:     12,
+				Title:  "Ghost Love Score",
+				Artist: "Nightwish",
+				URI:    "/music/music/Nightwish/Ghost Love Score.flac",
+				Type:   "audio/flac",
+			}},
+		},
+	}
+}
+
+func (s *stubExplorer) ListContents(folderPath string) ([]music.SubFolder, []music.Song, error) {
+	folders, ok := s.folders[folderPath]
+	if !ok {
+		return nil, nil, music.ErrFolderNotFound
+	}
+	// Copy so that sorting does not change the stub between tests
+	return append([]music.SubFolder(nil), folders...), s.songs[folderPath], nil
+}
@@ -0,0 +1,125 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// defaultStreamFormat is used when clients only ask for a maximum bitrate
+const defaultStreamFormat = "mp3"
+
+// streamEndpoint sends a song, transcoded when the client asks for another format or a maximum bitrate
+type streamEndpoint struct {
+	songStore    music.SongStore
+	musicHandler http.Handler
+}
+
+func (e *streamEndpoint) ServeSubsonic(writer http.ResponseWriter, request *http.Request) (*response, error) {
+	id := request.Form.Get("id")
+	if id == "" {
+		return nil, newMissingParameterError("id")
+	}
+	songID, err := parseSongID(id)
+	if err != nil {
+		return nil, newNotFoundError(err)
+	}
+	song, err := e.songStore.GetSong(request.Context(), songID)
+	if errors.Is(err, music.ErrSongNotFound) {
+		return nil, newNotFoundError(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the song #%d: %w", songID, err)
+	}
+
+	streamRequest := request.Clone(server.WithListener(request.Context(), currentUser(request).ID))
+	streamRequest.URL.Path = song.Path
+	streamRequest.URL.RawPath = ""
+	streamRequest.URL.RawQuery = transcodingQuery(request.Form.Get("format"), request.Form.Get("maxBitRate")).Encode()
+	e.musicHandler.ServeHTTP(writer, streamRequest)
+	return nil, nil
+}
+
+// transcodingQuery translates Subsonic's "format" and "maxBitRate" parameters to the query parameters of
+// the music files. Songs are sent as they are for the "raw" format and for formats that cannot be transcoded to.
+func transcodingQuery(format string, maxBitRate string) url.Values {
+	query := url.Values{}
+	bitrate, err := strconv.ParseUint(maxBitRate, 10, 32)
+	if err != nil {
+		bitrate = 0
+	}
+	if format == "raw" || (format == "" && bitrate == 0) {
+		return query
+	}
+	if format == "" {
+		format = defaultStreamFormat
+	}
+	_, err = music.NewTranscodingProfile(format, uint(bitrate))
+	if errors.Is(err, music.ErrInvalidBitrate) {
+		// The maximum bitrate is out of the supported bounds, the format's default bitrate is close enough
+		bitrate = 0
+	} else if err != nil {
+		return query
+	}
+	query.Set("format", format)
+	if bitrate != 0 {
+		query.Set("bitrate", strconv.FormatUint(bitrate, 10))
+	}
+	return query
+}
+
+// getCoverArtEndpoint sends a cover image, resized when the client gives a size
+type getCoverArtEndpoint struct {
+	coverLoader music.CoverLoader
+}
+
+func (e *getCoverArtEndpoint) ServeSubsonic(writer http.ResponseWriter, request *http.Request) (*response, error) {
+	id := request.Form.Get("id")
+	if id == "" {
+		return nil, newMissingParameterError("id")
+	}
+	coverID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, newNotFoundError(errInvalidID)
+	}
+	size, err := strconv.ParseUint(request.Form.Get("size"), 10, 32)
+	if err != nil {
+		size = 0
+	}
+	picture, err := e.coverLoader.LoadCover(request.Context(), uint(coverID), uint(size))
+	if errors.Is(err, music.ErrCoverNotFound) {
+		return nil, newNotFoundError(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while loading the cover #%d: %w", coverID, err)
+	}
+	writer.Header().Set("Content-Type", picture.MIMEType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(picture.Data)))
+	writer.Header().Set("Cache-Control", "private, max-age=86400")
+	if _, err = writer.Write(picture.Data); err != nil {
+		return nil, fmt.Errorf("could not write the cover #%d: %w", coverID, err)
+	}
+	return nil, nil
+}
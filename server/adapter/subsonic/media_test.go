@@ -0,0 +1,155 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestStream(t *testing.T) {
+	t.Run("it delegates to the music handler with the path of the song", func(t *testing.T) {
+		musicHandler := &stubMusicHandler{}
+		router := mux.NewRouter()
+		Register(router, newStubExplorer(), newStubSongStore(), &stubSearcher{}, &stubPlaylistStore{},
+			&stubCoverLoader{}, musicHandler, &stubUserStore{subsonicPassword: "sesame"}, newTestThrottle(), testCipher)
+
+		parameters := tokenParameters("sesame", "a1")
+		parameters.Set("id", "12")
+		parameters.Set("format", "opus")
+		parameters.Set("maxBitRate", "96")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, newSubsonicRequest(t, "/rest/stream.view", parameters))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		if musicHandler.path != "Nightwish/Ghost Love Score.flac" {
+			t.Errorf("expected the music handler to serve the song's path, got %q", musicHandler.path)
+		}
+		if musicHandler.query != "bitrate=96&format=opus" {
+			t.Errorf("expected the music handler to receive the transcoding query, got %q", musicHandler.query)
+		}
+	})
+
+	t.Run("given an unknown song, it returns a Not found error", func(t *testing.T) {
+		got := serveSubsonic(t, newTestRouter(t), "/rest/stream", url.Values{"id": {"404"}})
+		assertResponseError(t, got, errorNotFound)
+	})
+}
+
+func TestTranscodingQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		format     string
+		maxBitRate string
+		want       string
+	}{
+		{"no format and no bitrate sends the original", "", "", ""},
+		{"no format and a zero bitrate sends the original", "", "0", ""},
+		{"the raw format sends the original", "raw", "128", ""},
+		{"an unsupported format sends the original", "wav", "", ""},
+		{"a format without bitrate uses its default bitrate", "opus", "", "format=opus"},
+		{"a bitrate without format transcodes to mp3", "", "128", "bitrate=128&format=mp3"},
+		{"an out of bounds bitrate uses the default bitrate", "aac", "1411", "format=aac"},
+		{"an invalid bitrate is ignored", "mp3", "fast", "format=mp3"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := transcodingQuery(testCase.format, testCase.maxBitRate).Encode()
+			if got != testCase.want {
+				t.Errorf("expected query %q, got %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCoverArt(t *testing.T) {
+	router := newTestRouter(t)
+
+	t.Run("it sends the cover resized to the given size", func(t *testing.T) {
+		parameters := tokenParameters("sesame", "a1")
+		parameters.Set("id", "8")
+		parameters.Set("size", "128")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, newSubsonicRequest(t, "/rest/getCoverArt", parameters))
+
+		tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+		tests.AssertContentTypeHeaderEquals(t, response, "image/png")
+		if response.Body.String() != "png-128" {
+			t.Errorf("unexpected cover data %q", response.Body.String())
+		}
+	})
+
+	for name, id := range map[string]string{"an unknown cover": "404", "an invalid identifier": "cover"} {
+		t.Run("given "+name+", it returns a Not found error", func(t *testing.T) {
+			got := serveSubsonic(t, router, "/rest/getCoverArt", url.Values{"id": {id}})
+			assertResponseError(t, got, errorNotFound)
+		})
+	}
+}
+
+// stubMusicHandler records the path and query of the last request it served
+type stubMusicHandler struct {
+	path  string
+	query string
+}
+
+func (s *stubMusicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	s.path = request.URL.Path
+	s.query = request.URL.RawQuery
+	writer.WriteHeader(http.StatusOK)
+}
+
+// stubSongStore knows the song #12 "Nightwish/Ghost Love Score.flac"
+type stubSongStore struct {
+	songs map[uint]*music.IndexedSong
+}
+
+func newStubSongStore() *stubSongStore {
+	return &stubSongStore{songs: map[uint]*music.IndexedSong{
+		12: {
+			Song: music.Song{ID: 12, Title: "Ghost Love Score", URI: "/music/Nightwish/Ghost Love Score.flac"},
+			Path: "Nightwish/Ghost Love Score.flac",
+		},
+	}}
+}
+
+func (s *stubSongStore) GetSong(_ context.Context, songID uint) (*music.IndexedSong, error) {
+	song, ok := s.songs[songID]
+	if !ok {
+		return nil, music.ErrSongNotFound
+	}
+	return song, nil
+}
+
+// stubCoverLoader knows the cover #8, its data tells the size it was resized to
+type stubCoverLoader struct{}
+
+func (s *stubCoverLoader) LoadCover(_ context.Context, coverID uint, size uint) (*music.Picture, error) {
+	if coverID != 8 {
+		return nil, music.ErrCoverNotFound
+	}
+	return &music.Picture{MIMEType: "image/png", Data: []byte("png-" + strconv.FormatUint(uint64(size), 10))}, nil
+}
@@ -0,0 +1,88 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func fromPlaylistSummary(summary music.PlaylistSummary, owner string) playlist {
+	return playlist{
+		ID:        strconv.FormatUint(uint64(summary.ID), 10),
+		Name:      summary.Name,
+		Owner:     owner,
+		SongCount: summary.SongCount,
+	}
+}
+
+// getPlaylistsEndpoint lists the playlists of the current user
+type getPlaylistsEndpoint struct {
+	playlistStore music.PlaylistStore
+}
+
+func (e *getPlaylistsEndpoint) ServeSubsonic(_ http.ResponseWriter, request *http.Request) (*response, error) {
+	owner := currentUser(request)
+	summaries, err := e.playlistStore.ListPlaylists(request.Context(), owner.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the playlists: %w", err)
+	}
+	result := newResponse()
+	result.Playlists = &playlists{make([]playlist, 0)}
+	for _, summary := range summaries {
+		result.Playlists.Playlists = append(result.Playlists.Playlists, fromPlaylistSummary(summary, owner.Username))
+	}
+	return result, nil
+}
+
+// getPlaylistEndpoint lists the songs of a playlist of the current user
+type getPlaylistEndpoint struct {
+	playlistStore music.PlaylistStore
+}
+
+func (e *getPlaylistEndpoint) ServeSubsonic(_ http.ResponseWriter, request *http.Request) (*response, error) {
+	id := request.Form.Get("id")
+	if id == "" {
+		return nil, newMissingParameterError("id")
+	}
+	playlistID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, newNotFoundError(errInvalidID)
+	}
+	owner := currentUser(request)
+	found, err := e.playlistStore.GetPlaylist(request.Context(), owner.ID, uint(playlistID))
+	if errors.Is(err, music.ErrPlaylistNotFound) {
+		return nil, newNotFoundError(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the playlist #%d: %w", playlistID, err)
+	}
+	result := newResponse()
+	converted := fromPlaylistSummary(found.PlaylistSummary, owner.Username)
+	converted.Entries = make([]child, 0, len(found.Entries))
+	for _, entry := range found.Entries {
+		converted.Entries = append(converted.Entries, fromSong(entry.Song))
+		converted.Duration += entry.Song.Duration
+	}
+	result.Playlist = &converted
+	return result, nil
+}
@@ -0,0 +1,111 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestGetPlaylists(t *testing.T) {
+	got := serveSubsonic(t, newTestRouter(t), "/rest/getPlaylists", url.Values{})
+
+	assertResponseStatus(t, got, "ok")
+	if got.Playlists == nil || len(got.Playlists.Playlists) != 1 {
+		t.Fatalf("expected the playlists of the current user, got %+v", got.Playlists)
+	}
+	if playlist := got.Playlists.Playlists[0]; playlist.ID != "5" || playlist.Owner != "admin" || playlist.SongCount != 2 {
+		t.Errorf("unexpected playlist %+v", playlist)
+	}
+}
+
+func TestGetPlaylist(t *testing.T) {
+	router := newTestRouter(t)
+
+	t.Run("it lists the songs of the playlist", func(t *testing.T) {
+		got := serveSubsonic(t, router, "/rest/getPlaylist.view", url.Values{"id": {"5"}})
+
+		assertResponseStatus(t, got, "ok")
+		if got.Playlist == nil || got.Playlist.Name != "Favorites" || got.Playlist.Duration != 900 {
+			t.Fatalf("unexpected playlist %+v", got.Playlist)
+		}
+		if len(got.Playlist.Entries) != 2 || got.Playlist.Entries[1].ID != "12" {
+			t.Errorf("unexpected entries %+v", got.Playlist.Entries)
+		}
+	})
+
+	for name, id := range map[string]string{"an unknown playlist": "404", "an invalid identifier": "favorites"} {
+		t.Run("given "+name+", it returns a Not found error", func(t *testing.T) {
+			got := serveSubsonic(t, router, "/rest/getPlaylist", url.Values{"id": {id}})
+			assertResponseError(t, got, errorNotFound)
+		})
+	}
+}
+
+// stubPlaylistStore knows the playlist #5 of user #27
+type stubPlaylistStore struct{}
+
+var favorites = music.PlaylistSummary{ID: 5, Name: "Favorites", SongCount: 2}
+
+func (s *stubPlaylistStore) ListPlaylists(_ context.Context, ownerID uint) ([]music.PlaylistSummary, error) {
+	if ownerID != 27 {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return []music.PlaylistSummary{favorites}, nil
+}
+
+func (s *stubPlaylistStore) GetPlaylist(_ context.Context, ownerID uint, playlistID uint) (*music.Playlist, error) {
+	if ownerID != 27 || playlistID != favorites.ID {
+		return nil, music.ErrPlaylistNotFound
+	}
+	return &music.Playlist{
+		PlaylistSummary: favorites,
+		Entries: []music.PlaylistEntry{
+			{ID: 1, Song: music.Song{ID: 3, Title: "Intro", Duration: 300, URI: "/music/Intro.mp3"}},
+			{ID: 2, Song: music.Song{ID: 12, Title: "Ghost Love Score", Duration: 600, URI: "/music/Nightwish/Ghost Love Score.flac"}},
+		},
+	}, nil
+}
+
+func (s *stubPlaylistStore) CreatePlaylist(_ context.Context, _ uint, _ string) (*music.PlaylistSummary, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubPlaylistStore) RenamePlaylist(_ context.Context, _ uint, _ uint, _ string) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubPlaylistStore) AppendSongs(_ context.Context, _ uint, _ uint, _ []uint) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubPlaylistStore) RemoveEntry(_ context.Context, _ uint, _ uint, _ uint) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubPlaylistStore) ReorderEntries(_ context.Context, _ uint, _ uint, _ []uint) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubPlaylistStore) DeletePlaylist(_ context.Context, _ uint, _ uint) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
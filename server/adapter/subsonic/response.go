@@ -0,0 +1,235 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// apiVersion is the version of the Subsonic API implemented by the server
+const apiVersion = "1.16.1"
+
+// Error codes of the Subsonic API. See http://www.subsonic.org/pages/api.jsp
+const (
+	errorGeneric          = 0
+	errorMissingParameter = 10
+	errorWrongCredentials = 40
+	errorNotFound         = 70
+)
+
+// jsonpCallbackPattern restricts JSONP callbacks to JavaScript identifiers
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// response is the envelope of every Subsonic API response. The same structs are encoded to XML and JSON,
+// XML attributes and child elements become JSON fields.
+type response struct {
+	XMLName       xml.Name       `xml:"subsonic-response" json:"-"`
+	XMLNS         string         `xml:"xmlns,attr" json:"-"`
+	Status        string         `xml:"status,attr" json:"status"`
+	Version       string         `xml:"version,attr" json:"version"`
+	Type          string         `xml:"type,attr" json:"type"`
+	Error         *apiError      `xml:"error,omitempty" json:"error,omitempty"`
+	MusicFolders  *musicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *indexes       `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory     *directory     `xml:"directory,omitempty" json:"directory,omitempty"`
+	SearchResult3 *searchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists     *playlists     `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist      *playlist      `xml:"playlist,omitempty" json:"playlist,omitempty"`
+}
+
+func newResponse() *response {
+	return &response{XMLNS: "http://subsonic.org/restapi", Status: "ok", Version: apiVersion, Type: "mike-sierra-sierra"}
+}
+
+// apiError is an error of the Subsonic API. It is sent with a 200 OK status, in a "failed" response.
+type apiError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+	err     error
+}
+
+func (a *apiError) Error() string {
+	return a.Message
+}
+
+func (a *apiError) Unwrap() error {
+	return a.err
+}
+
+func newMissingParameterError(name string) *apiError {
+	return &apiError{errorMissingParameter, fmt.Sprintf("Required parameter is missing: %s", name), nil}
+}
+
+func newNotFoundError(err error) *apiError {
+	return &apiError{errorNotFound, "The requested data was not found", err}
+}
+
+type musicFolders struct {
+	Folders []musicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type musicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type indexes struct {
+	LastModified    int64   `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []index `xml:"index" json:"index"`
+	Children        []child `xml:"child" json:"child,omitempty"`
+}
+
+type index struct {
+	Name    string        `xml:"name,attr" json:"name"`
+	Artists []indexArtist `xml:"artist" json:"artist"`
+}
+
+type indexArtist struct {
+	ID       string `xml:"id,attr" json:"id"`
+	Name     string `xml:"name,attr" json:"name"`
+	CoverArt string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+}
+
+type directory struct {
+	ID       string  `xml:"id,attr" json:"id"`
+	Parent   string  `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string  `xml:"name,attr" json:"name"`
+	Children []child `xml:"child" json:"child"`
+}
+
+// child is either a folder or a song
+type child struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Track       uint   `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  uint   `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    uint   `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+type searchResult3 struct {
+	Artists []artistID3 `xml:"artist" json:"artist,omitempty"`
+	Albums  []albumID3  `xml:"album" json:"album,omitempty"`
+	Songs   []child     `xml:"song" json:"song,omitempty"`
+}
+
+type artistID3 struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	AlbumCount uint   `xml:"albumCount,attr" json:"albumCount"`
+}
+
+type albumID3 struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	SongCount uint   `xml:"songCount,attr" json:"songCount"`
+	Duration  uint   `xml:"duration,attr" json:"duration"`
+}
+
+type playlists struct {
+	Playlists []playlist `xml:"playlist" json:"playlist"`
+}
+
+type playlist struct {
+	ID        string  `xml:"id,attr" json:"id"`
+	Name      string  `xml:"name,attr" json:"name"`
+	Owner     string  `xml:"owner,attr" json:"owner"`
+	Public    bool    `xml:"public,attr" json:"public"`
+	SongCount uint    `xml:"songCount,attr" json:"songCount"`
+	Duration  uint    `xml:"duration,attr" json:"duration"`
+	Entries   []child `xml:"entry" json:"entry,omitempty"`
+}
+
+// endpoint handles a method of the Subsonic API
+type endpoint interface {
+	// ServeSubsonic returns the response to send. It returns a nil response when it has written
+	// the response itself, for example to send a music file.
+	ServeSubsonic(writer http.ResponseWriter, request *http.Request) (*response, error)
+}
+
+// wrap sends the response of the endpoint in the format requested by the client. Errors are sent as
+// "failed" responses. Errors that are not apiErrors are logged and sent as generic errors.
+func wrap(next endpoint) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		result, err := next.ServeSubsonic(writer, request)
+		if err != nil {
+			writeError(writer, request, err)
+			return
+		}
+		if result != nil {
+			writeResponse(writer, request, result)
+		}
+	})
+}
+
+func writeError(writer http.ResponseWriter, request *http.Request, err error) {
+	var subsonicErr *apiError
+	if !errors.As(err, &subsonicErr) {
+		subsonicErr = &apiError{errorGeneric, "An error occurred on the server", err}
+	}
+	if subsonicErr.err != nil || subsonicErr.Code == errorGeneric {
+		log.Printf("subsonic %s: %v", request.URL.Path, err)
+	}
+	result := newResponse()
+	result.Status = "failed"
+	result.Error = subsonicErr
+	writeResponse(writer, request, result)
+}
+
+// writeResponse encodes the response following the "f" parameter: "xml" (default), "json" or "jsonp"
+func writeResponse(writer http.ResponseWriter, request *http.Request, result *response) {
+	var err error
+	switch format := request.Form.Get("f"); {
+	case format == "json":
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = json.NewEncoder(writer).Encode(map[string]*response{"subsonic-response": result})
+	case format == "jsonp" && jsonpCallbackPattern.MatchString(request.Form.Get("callback")):
+		writer.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		var encoded []byte
+		encoded, err = json.Marshal(map[string]*response{"subsonic-response": result})
+		if err == nil {
+			_, err = fmt.Fprintf(writer, "%s(%s);", request.Form.Get("callback"), encoded)
+		}
+	default:
+		writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		_, err = writer.Write([]byte(xml.Header))
+		if err == nil {
+			err = xml.NewEncoder(writer).Encode(result)
+		}
+	}
+	if err != nil {
+		log.Printf("could not write the subsonic response to %s: %v", request.URL.Path, err)
+	}
+}
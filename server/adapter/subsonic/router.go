@@ -0,0 +1,216 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package subsonic implements a subset of the Subsonic API so that existing Subsonic clients
+can browse and play the music library. See http://www.subsonic.org/pages/api.jsp
+*/
+package subsonic
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // Subsonic's token authentication is defined with MD5
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// Register registers a gorilla/mux Subrouter for the Subsonic API on the given router.
+// musicHandler serves the music files, request paths are relative to the music library's root folder.
+// secretCipher decrypts the Subsonic passwords of the users.
+func Register(
+	router *mux.Router,
+	explorer music.MusicLibraryExplorer,
+	songStore music.SongStore,
+	searcher music.Searcher,
+	playlistStore music.PlaylistStore,
+	coverLoader music.CoverLoader,
+	musicHandler http.Handler,
+	userStore user.Store,
+	throttle *user.SignInThrottle,
+	secretCipher *user.SecretCipher,
+) {
+	subsonicRouter := router.PathPrefix("/rest/").Subrouter()
+	// Subsonic clients authenticate every request with their credentials instead of a session
+	subsonicRouter.Use((&authenticator{userStore, throttle, secretCipher}).middleware)
+	handle := func(method string, handler endpoint) {
+		// Clients call the methods with or without the ".view" suffix
+		subsonicRouter.Handle("/"+method, wrap(handler))
+		subsonicRouter.Handle("/"+method+".view", wrap(handler))
+	}
+	handle("ping", &pingEndpoint{})
+	handle("getMusicFolders", &getMusicFoldersEndpoint{explorer})
+	handle("getIndexes", &getIndexesEndpoint{explorer})
+	handle("getMusicDirectory", &getMusicDirectoryEndpoint{explorer})
+	handle("stream", &streamEndpoint{songStore, musicHandler})
+	handle("getCoverArt", &getCoverArtEndpoint{coverLoader})
+	handle("search3", &search3Endpoint{searcher})
+	handle("getPlaylists", &getPlaylistsEndpoint{playlistStore})
+	handle("getPlaylist", &getPlaylistEndpoint{playlistStore})
+}
+
+type contextKey int
+
+const credentialsKey contextKey = iota
+
+// currentUser returns the user authenticated by the authenticator middleware
+func currentUser(request *http.Request) *user.SubsonicCredentials {
+	credentials, _ := request.Context().Value(credentialsKey).(*user.SubsonicCredentials)
+	return credentials
+}
+
+// authenticator checks the credentials sent with every request, either a token and its salt
+// or a password, optionally hex-encoded with an "enc:" prefix. Failures go through the same
+// throttle and audit log as the sign-in page.
+type authenticator struct {
+	userStore user.Store
+	throttle  *user.SignInThrottle
+	cipher    *user.SecretCipher
+}
+
+var (
+	errWrongCredentials = &apiError{errorWrongCredentials, "Wrong username or password", nil}
+	errLockedOut        = &apiError{errorWrongCredentials, "Too many failed sign-in attempts, please try again later", nil}
+)
+
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writeError(writer, request, &apiError{errorGeneric, "Could not parse the request parameters", err})
+			return
+		}
+		credentials, err := a.authenticate(request)
+		if err != nil {
+			writeError(writer, request, err)
+			return
+		}
+		ctx := context.WithValue(request.Context(), credentialsKey, credentials)
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+func (a *authenticator) authenticate(request *http.Request) (*user.SubsonicCredentials, error) {
+	username := request.Form.Get("u")
+	if username == "" {
+		return nil, newMissingParameterError("u")
+	}
+	token, salt, password := request.Form.Get("t"), request.Form.Get("s"), request.Form.Get("p")
+	if token == "" && password == "" {
+		return nil, newMissingParameterError("t")
+	}
+	if token != "" && salt == "" {
+		return nil, newMissingParameterError("s")
+	}
+	ip := server.ClientIP(request)
+	credentials, err := a.userStore.GetSubsonicCredentials(request.Context(), username)
+	if err != nil {
+		// Unknown usernames are throttled like accounts, so that guessing them is as slow as guessing passwords
+		if err = a.checkLockout(request, ip, username); err != nil {
+			return nil, err
+		}
+		return nil, a.failed(request, ip, username, user.FailureUnknownAccount)
+	}
+	if err = a.checkLockout(request, ip, credentials.Email); err != nil {
+		return nil, err
+	}
+	if !a.matches(credentials, token, salt, password) {
+		return nil, a.failed(request, ip, credentials.Email, user.FailureWrongPassword)
+	}
+	if err = a.throttle.RecordSuccess(request.Context(), credentials.Email); err != nil {
+		return nil, fmt.Errorf("could not record the sign-in success: %w", err)
+	}
+	return credentials, nil
+}
+
+func (a *authenticator) matches(credentials *user.SubsonicCredentials, token string, salt string, password string) bool {
+	if token != "" {
+		return matchesToken(a.cipher, credentials.EncryptedSubsonicPassword, salt, token)
+	}
+	if strings.HasPrefix(password, "enc:") {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(password, "enc:"))
+		if err != nil {
+			return false
+		}
+		password = string(decoded)
+	}
+	return matchesPassword(a.cipher, credentials.EncryptedSubsonicPassword, password)
+}
+
+// checkLockout returns a Wrong username or password error while the IP address or the account is
+// locked out by the sign-in throttle. Subsonic has no error code for lockouts.
+func (a *authenticator) checkLockout(request *http.Request, ip string, account string) error {
+	lockedUntil, err := a.throttle.LockedUntil(request.Context(), ip, account)
+	if err != nil {
+		return fmt.Errorf("could not check the sign-in lockout: %w", err)
+	}
+	if lockedUntil.IsZero() {
+		return nil
+	}
+	if err = a.throttle.RecordFailure(request.Context(), ip, account, user.FailureLockedOut); err != nil {
+		return fmt.Errorf("could not record the sign-in failure: %w", err)
+	}
+	return errLockedOut
+}
+
+// failed records the failure with the sign-in throttle and returns the error sent to clients
+func (a *authenticator) failed(request *http.Request, ip string, account string, reason user.SignInFailureReason) error {
+	if err := a.throttle.RecordFailure(request.Context(), ip, account, reason); err != nil {
+		return fmt.Errorf("could not record the sign-in failure: %w", err)
+	}
+	return errWrongCredentials
+}
+
+// matchesToken checks that token is the MD5 hash of the Subsonic password followed by the salt
+func matchesToken(cipher *user.SecretCipher, encryptedPassword []byte, salt string, token string) bool {
+	subsonicPassword := decryptSubsonicPassword(cipher, encryptedPassword)
+	if subsonicPassword == "" {
+		return false
+	}
+	hash := md5.Sum([]byte(subsonicPassword + salt)) //nolint:gosec // Subsonic's token authentication is defined with MD5
+	expected := hex.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(token))) == 1
+}
+
+// matchesPassword accepts only the Subsonic password. The password users sign in with is refused,
+// Subsonic requests have no second step and it would bypass two-factor authentication.
+func matchesPassword(cipher *user.SecretCipher, encryptedPassword []byte, password string) bool {
+	subsonicPassword := decryptSubsonicPassword(cipher, encryptedPassword)
+	if subsonicPassword == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(subsonicPassword), []byte(password)) == 1
+}
+
+// decryptSubsonicPassword returns an empty password when the user has not generated any or when it cannot be
+// decrypted, for example after the secret key has changed. Users then have to generate a new one.
+func decryptSubsonicPassword(cipher *user.SecretCipher, encryptedPassword []byte) string {
+	if encryptedPassword == nil {
+		return ""
+	}
+	subsonicPassword, err := cipher.Decrypt(encryptedPassword)
+	if err != nil {
+		return ""
+	}
+	return string(subsonicPassword)
+}
@@ -0,0 +1,368 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // Subsonic's token authentication is defined with MD5
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/adapter/server/user"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestAuthentication(t *testing.T) {
+	router := newTestRouter(t)
+
+	t.Run("given a valid token and salt, it authenticates the user", func(t *testing.T) {
+		got := serveSubsonic(t, router, "/rest/ping.view", tokenParameters("sesame", "c19b2d"))
+		assertResponseStatus(t, got, "ok")
+	})
+
+	t.Run("given the Subsonic password, hex-encoded or not, it authenticates the user", func(t *testing.T) {
+		for _, password := range []string{"sesame", "enc:" + hex.EncodeToString([]byte("sesame"))} {
+			got := serveSubsonic(t, router, "/rest/ping", url.Values{"u": {"admin"}, "p": {password}})
+			assertResponseStatus(t, got, "ok")
+		}
+	})
+
+	for name, parameters := range map[string]url.Values{
+		"a wrong token":    tokenParameters("wrong", "c19b2d"),
+		"a wrong password": {"u": {"admin"}, "p": {"wrong"}},
+		"an unknown user":  {"u": {"nobody"}, "p": {"sesame"}},
+	} {
+		t.Run("given "+name+", it returns a Wrong username or password error", func(t *testing.T) {
+			got := serveSubsonic(t, router, "/rest/ping", parameters)
+			assertResponseError(t, got, errorWrongCredentials)
+		})
+	}
+
+	t.Run("given no credentials, it returns a Missing parameter error", func(t *testing.T) {
+		got := serveSubsonic(t, router, "/rest/ping", url.Values{"u": {"admin"}})
+		assertResponseError(t, got, errorMissingParameter)
+	})
+
+	t.Run("given the password of a user with two-factor authentication, it does not bypass the second step", func(t *testing.T) {
+		for _, password := range []string{"welcome0", "enc:" + hex.EncodeToString([]byte("welcome0"))} {
+			got := serveSubsonic(t, router, "/rest/ping", url.Values{"u": {"admin"}, "p": {password}})
+			assertResponseError(t, got, errorWrongCredentials)
+		}
+	})
+
+	t.Run("when the user has no Subsonic password, token authentication fails", func(t *testing.T) {
+		router := mux.NewRouter()
+		Register(router, newStubExplorer(), newStubSongStore(), &stubSearcher{}, &stubPlaylistStore{},
+			&stubCoverLoader{}, http.NotFoundHandler(), &stubUserStore{subsonicPassword: ""}, newTestThrottle(), testCipher)
+
+		got := serveSubsonic(t, router, "/rest/ping", tokenParameters("", "c19b2d"))
+		assertResponseError(t, got, errorWrongCredentials)
+	})
+
+	t.Run("when the Subsonic password was encrypted with another key, authentication fails", func(t *testing.T) {
+		otherCipher, err := user.NewSecretCipher(bytes.Repeat([]byte{1}, 32))
+		tests.AssertNoError(t, err)
+		router := mux.NewRouter()
+		Register(router, newStubExplorer(), newStubSongStore(), &stubSearcher{}, &stubPlaylistStore{},
+			&stubCoverLoader{}, http.NotFoundHandler(), &stubUserStore{subsonicPassword: "sesame"}, newTestThrottle(), otherCipher)
+
+		got := serveSubsonic(t, router, "/rest/ping", tokenParameters("sesame", "c19b2d"))
+		assertResponseError(t, got, errorWrongCredentials)
+	})
+}
+
+func TestAuthenticationThrottle(t *testing.T) {
+	t.Run("given wrong credentials, it records the failure in the audit log", func(t *testing.T) {
+		store := &stubSignInThrottleStore{}
+		router := newTestRouterWithThrottle(t, store)
+
+		got := serveSubsonic(t, router, "/rest/ping", url.Values{"u": {"admin"}, "p": {"wrong"}})
+		assertResponseError(t, got, errorWrongCredentials)
+		assertFailuresEqual(t, store, "admin@example.com", user.FailureWrongPassword)
+	})
+
+	t.Run("given an unknown user, it records the failure for the username", func(t *testing.T) {
+		store := &stubSignInThrottleStore{}
+		router := newTestRouterWithThrottle(t, store)
+
+		got := serveSubsonic(t, router, "/rest/ping", url.Values{"u": {"nobody"}, "p": {"sesame"}})
+		assertResponseError(t, got, errorWrongCredentials)
+		assertFailuresEqual(t, store, "nobody", user.FailureUnknownAccount)
+	})
+
+	t.Run("when the account is locked out, it refuses even valid credentials", func(t *testing.T) {
+		store := &stubSignInThrottleStore{lockedUntil: time.Now().Add(time.Hour)}
+		router := newTestRouterWithThrottle(t, store)
+
+		got := serveSubsonic(t, router, "/rest/ping", tokenParameters("sesame", "c19b2d"))
+		assertResponseError(t, got, errorWrongCredentials)
+		if got.Error.Message != errLockedOut.Message {
+			t.Errorf("expected the lockout message, got %s", got.Error.Message)
+		}
+		assertFailuresEqual(t, store, "admin@example.com", user.FailureLockedOut)
+	})
+
+	t.Run("given valid credentials, it forgets the failures of the account", func(t *testing.T) {
+		store := &stubSignInThrottleStore{}
+		router := newTestRouterWithThrottle(t, store)
+
+		got := serveSubsonic(t, router, "/rest/ping", tokenParameters("sesame", "c19b2d"))
+		assertResponseStatus(t, got, "ok")
+		if len(store.reset) != 1 || store.reset[0] != "account:admin@example.com" {
+			t.Errorf("expected the failures of the account to be reset, got %v", store.reset)
+		}
+	})
+
+	t.Run("when the lockout cannot be checked, it returns a generic error", func(t *testing.T) {
+		router := newTestRouterWithThrottle(t, &stubSignInThrottleStore{shouldError: true})
+
+		got := serveSubsonic(t, router, "/rest/ping", tokenParameters("sesame", "c19b2d"))
+		assertResponseError(t, got, errorGeneric)
+	})
+}
+
+func assertFailuresEqual(t *testing.T, store *stubSignInThrottleStore, account string, reason user.SignInFailureReason) {
+	t.Helper()
+	if len(store.failures) != 1 {
+		t.Fatalf("expected a single failure in the audit log, got %d", len(store.failures))
+	}
+	got := store.failures[0]
+	if got.Account != account || got.Reason != reason || got.IP != "192.0.2.1" {
+		t.Errorf("unexpected failure in the audit log %+v", got)
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	router := newTestRouter(t)
+
+	t.Run("by default, it responds in XML", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, newSubsonicRequest(t, "/rest/ping", tokenParameters("sesame", "a1")))
+
+		tests.AssertContentTypeHeaderEquals(t, response, "application/xml; charset=utf-8")
+		body := response.Body.String()
+		if !strings.Contains(body, `<subsonic-response xmlns="http://subsonic.org/restapi" status="ok" version="1.16.1"`) {
+			t.Errorf("unexpected XML response %s", body)
+		}
+	})
+
+	t.Run("given f=json, it responds in JSON", func(t *testing.T) {
+		parameters := tokenParameters("sesame", "a1")
+		parameters.Set("f", "json")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, newSubsonicRequest(t, "/rest/ping", parameters))
+
+		tests.AssertContentTypeHeaderEquals(t, response, "application/json; charset=utf-8")
+		var got map[string]map[string]interface{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode the JSON response %v", err)
+		}
+		if got["subsonic-response"]["status"] != "ok" || got["subsonic-response"]["version"] != apiVersion {
+			t.Errorf("unexpected JSON response %v", got)
+		}
+	})
+
+	t.Run("given f=jsonp and a callback, it wraps the JSON response in a call to the callback", func(t *testing.T) {
+		parameters := tokenParameters("sesame", "a1")
+		parameters.Set("f", "jsonp")
+		parameters.Set("callback", "handle")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, newSubsonicRequest(t, "/rest/ping", parameters))
+
+		if !strings.HasPrefix(response.Body.String(), `handle({"subsonic-response":`) {
+			t.Errorf("unexpected JSONP response %s", response.Body.String())
+		}
+	})
+
+	t.Run("it accepts parameters sent as a POST form", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/rest/ping", strings.NewReader(tokenParameters("sesame", "a1").Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assertResponseStatus(t, decodeResponse(t, response), "ok")
+	})
+}
+
+func newTestRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	return newTestRouterWithThrottle(t, &stubSignInThrottleStore{})
+}
+
+func newTestRouterWithThrottle(t *testing.T, throttleStore user.SignInThrottleStore) *mux.Router {
+	t.Helper()
+	router := mux.NewRouter()
+	Register(
+		router,
+		newStubExplorer(),
+		newStubSongStore(),
+		&stubSearcher{},
+		&stubPlaylistStore{},
+		&stubCoverLoader{},
+		&stubMusicHandler{},
+		&stubUserStore{subsonicPassword: "sesame"},
+		user.NewSignInThrottle(throttleStore),
+		testCipher,
+	)
+	return router
+}
+
+// testCipher encrypts the Subsonic passwords of the stubUserStore
+var testCipher, _ = user.NewSecretCipher(make([]byte, 32))
+
+func newTestThrottle() *user.SignInThrottle {
+	return user.NewSignInThrottle(&stubSignInThrottleStore{})
+}
+
+// tokenParameters authenticates the "admin" user with a token computed from password and salt
+func tokenParameters(password string, salt string) url.Values {
+	hash := md5.Sum([]byte(password + salt)) //nolint:gosec // Subsonic's token authentication is defined with MD5
+	return url.Values{"u": {"admin"}, "t": {hex.EncodeToString(hash[:])}, "s": {salt}, "c": {"tests"}, "v": {"1.16.1"}}
+}
+
+func newSubsonicRequest(t *testing.T, target string, parameters url.Values) *http.Request {
+	t.Helper()
+	return tests.NewGetRequest(t, target+"?"+parameters.Encode())
+}
+
+// serveSubsonic authenticates as the "admin" user when parameters do not contain credentials
+func serveSubsonic(t *testing.T, router http.Handler, target string, parameters url.Values) *response {
+	t.Helper()
+	if parameters.Get("u") == "" && parameters.Get("t") == "" {
+		for name, values := range tokenParameters("sesame", "a1") {
+			parameters[name] = values
+		}
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, newSubsonicRequest(t, target, parameters))
+	tests.AssertStatusEquals(t, response.Code, http.StatusOK)
+	return decodeResponse(t, response)
+}
+
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder) *response {
+	t.Helper()
+	got := newResponse()
+	if err := xml.NewDecoder(response.Body).Decode(got); err != nil {
+		t.Fatalf("could not decode the XML response %q: %v", response.Body.String(), err)
+	}
+	return got
+}
+
+func assertResponseStatus(t *testing.T, got *response, want string) {
+	t.Helper()
+	if got.Status != want {
+		t.Errorf("expected a response with status %s, got %s and error %+v", want, got.Status, got.Error)
+	}
+}
+
+func assertResponseError(t *testing.T, got *response, wantCode int) {
+	t.Helper()
+	if got.Status != "failed" || got.Error == nil || got.Error.Code != wantCode {
+		t.Errorf("expected a failed response with error code %d, got status %s and error %+v", wantCode, got.Status, got.Error)
+	}
+}
+
+// stubUserStore knows a single user #27 named "admin", who signs in with the "welcome0" password and two-factor authentication
+type stubUserStore struct {
+	subsonicPassword string
+}
+
+func (s *stubUserStore) GetUserMatchingEmail(_ context.Context, _ string) (*user.PossibleMatch, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) GetUserMatchingSession(_ context.Context) (*user.Current, error) {
+	return nil, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) HasAdministrator(_ context.Context) (bool, error) {
+	return false, errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) SaveFirstAdministrator(_ context.Context, _ *user.Registration) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubUserStore) GetSubsonicCredentials(_ context.Context, username string) (*user.SubsonicCredentials, error) {
+	if username != "admin" {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	credentials := &user.SubsonicCredentials{ID: 27, Email: "admin@example.com", Username: "admin"}
+	if s.subsonicPassword != "" {
+		encryptedPassword, err := testCipher.Encrypt([]byte(s.subsonicPassword))
+		if err != nil {
+			return nil, err
+		}
+		credentials.EncryptedSubsonicPassword = encryptedPassword
+	}
+	return credentials, nil
+}
+
+func (s *stubUserStore) SaveSubsonicPassword(_ context.Context, _ uint, _ []byte) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+type stubSignInThrottleStore struct {
+	shouldError bool
+	lockedUntil time.Time
+	failures    []*user.SignInFailure
+	reset       []string
+}
+
+func (s *stubSignInThrottleStore) LockedUntil(_ context.Context, _ []string) (time.Time, error) {
+	if s.shouldError {
+		return time.Time{}, errors.New("error while checking the lockout")
+	}
+	return s.lockedUntil, nil
+}
+
+func (s *stubSignInThrottleStore) CountSignInFailure(_ context.Context, _ string, _ time.Time, _ time.Time) (uint, error) {
+	return 1, nil
+}
+
+func (s *stubSignInThrottleStore) LockSignIn(_ context.Context, _ string, _ time.Time) error {
+	return errors.New("This method is not supposed to be called in the tests")
+}
+
+func (s *stubSignInThrottleStore) ResetSignInFailures(_ context.Context, subjects []string) error {
+	s.reset = subjects
+	return nil
+}
+
+func (s *stubSignInThrottleStore) SaveSignInFailure(_ context.Context, failure *user.SignInFailure) error {
+	s.failures = append(s.failures, failure)
+	return nil
+}
+
+func (s *stubSignInThrottleStore) ListSignInFailures(
+	_ context.Context,
+	_ uint,
+	_ uint,
+) ([]user.SignInFailure, uint, error) {
+	return nil, 0, errors.New("This method is not supposed to be called in the tests")
+}
@@ -0,0 +1,113 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// Page sizes of search3, following the Subsonic API defaults
+const (
+	defaultSearchCount = 20
+	maximumSearchCount = 500
+)
+
+// search3Endpoint searches songs, albums and artists. Each kind of result has its own page.
+type search3Endpoint struct {
+	searcher music.Searcher
+}
+
+func (e *search3Endpoint) ServeSubsonic(_ http.ResponseWriter, request *http.Request) (*response, error) {
+	if _, ok := request.Form["query"]; !ok {
+		return nil, newMissingParameterError("query")
+	}
+	result := newResponse()
+	result.SearchResult3 = &searchResult3{}
+
+	artists, err := e.search(request, "artistCount", "artistOffset")
+	if err != nil {
+		return nil, err
+	}
+	for _, artist := range artists.Artists {
+		result.SearchResult3.Artists = append(result.SearchResult3.Artists, artistID3{
+			ID:   "ar-" + base64.RawURLEncoding.EncodeToString([]byte(artist.Name)),
+			Name: artist.Name,
+		})
+	}
+	albums, err := e.search(request, "albumCount", "albumOffset")
+	if err != nil {
+		return nil, err
+	}
+	for _, album := range albums.Albums {
+		result.SearchResult3.Albums = append(result.SearchResult3.Albums, albumID3{
+			ID:        "al-" + base64.RawURLEncoding.EncodeToString([]byte(album.Artist+"\x00"+album.Name)),
+			Name:      album.Name,
+			Artist:    album.Artist,
+			SongCount: album.SongCount,
+		})
+	}
+	songs, err := e.search(request, "songCount", "songOffset")
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range songs.Songs {
+		result.SearchResult3.Songs = append(result.SearchResult3.Songs, fromSong(song))
+	}
+	return result, nil
+}
+
+// search returns the page of results given by the count and offset parameters. Clients browsing the whole
+// library send an empty query, it finds nothing as the search index only finds songs matching terms.
+func (e *search3Endpoint) search(request *http.Request, countName string, offsetName string) (*music.SearchResults, error) {
+	count := parseUintForm(request.Form, countName, defaultSearchCount)
+	if count > maximumSearchCount {
+		count = maximumSearchCount
+	}
+	if count == 0 {
+		return &music.SearchResults{}, nil
+	}
+	query := music.SearchQuery{
+		Terms:  request.Form.Get("query"),
+		Limit:  count,
+		Offset: parseUintForm(request.Form, offsetName, 0),
+	}
+	results, err := e.searcher.Search(request.Context(), query)
+	if errors.Is(err, music.ErrEmptySearch) {
+		return &music.SearchResults{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while searching for %q: %w", query.Terms, err)
+	}
+	return results, nil
+}
+
+// parseUintForm returns the form value named name, or defaultValue when it is missing or invalid
+func parseUintForm(form url.Values, name string, defaultValue uint) uint {
+	value, err := strconv.ParseUint(form.Get(name), 10, 32)
+	if err != nil {
+		return defaultValue
+	}
+	return uint(value)
+}
@@ -0,0 +1,99 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestSearch3(t *testing.T) {
+	t.Run("it searches each kind of result with its own page", func(t *testing.T) {
+		searcher := &stubSearcher{}
+		router := newRouterWithSearcher(searcher)
+
+		got := serveSubsonic(t, router, "/rest/search3.view", url.Values{
+			"query":       {"nightwish"},
+			"artistCount": {"0"},
+			"albumCount":  {"1000"},
+			"albumOffset": {"5"},
+			"songOffset":  {"10"},
+		})
+
+		assertResponseStatus(t, got, "ok")
+		want := []music.SearchQuery{
+			{Terms: "nightwish", Limit: maximumSearchCount, Offset: 5},
+			{Terms: "nightwish", Limit: defaultSearchCount, Offset: 10},
+		}
+		if len(searcher.queries) != len(want) || searcher.queries[0] != want[0] || searcher.queries[1] != want[1] {
+			t.Errorf("expected queries %+v, got %+v", want, searcher.queries)
+		}
+		if len(got.SearchResult3.Artists) != 0 {
+			t.Errorf("expected no artists, got %+v", got.SearchResult3.Artists)
+		}
+		if len(got.SearchResult3.Albums) != 1 || got.SearchResult3.Albums[0].Name != "Once" {
+			t.Errorf("unexpected albums %+v", got.SearchResult3.Albums)
+		}
+		if len(got.SearchResult3.Songs) != 1 || got.SearchResult3.Songs[0].ID != "12" {
+			t.Errorf("unexpected songs %+v", got.SearchResult3.Songs)
+		}
+	})
+
+	t.Run("given an empty query, it returns no results", func(t *testing.T) {
+		got := serveSubsonic(t, newRouterWithSearcher(&stubSearcher{}), "/rest/search3", url.Values{"query": {""}})
+
+		assertResponseStatus(t, got, "ok")
+		if got.SearchResult3 == nil || len(got.SearchResult3.Songs) != 0 {
+			t.Errorf("expected empty search results, got %+v", got.SearchResult3)
+		}
+	})
+
+	t.Run("given no query, it returns a Missing parameter error", func(t *testing.T) {
+		got := serveSubsonic(t, newTestRouter(t), "/rest/search3", url.Values{})
+		assertResponseError(t, got, errorMissingParameter)
+	})
+}
+
+func newRouterWithSearcher(searcher music.Searcher) http.Handler {
+	router := mux.NewRouter()
+	Register(router, newStubExplorer(), newStubSongStore(), searcher, &stubPlaylistStore{},
+		&stubCoverLoader{}, &stubMusicHandler{}, &stubUserStore{subsonicPassword: "sesame"}, newTestThrottle(), testCipher)
+	return router
+}
+
+// stubSearcher records the queries it receives and finds the same album and song for every query
+type stubSearcher struct {
+	queries []music.SearchQuery
+}
+
+func (s *stubSearcher) Search(_ context.Context, query music.SearchQuery) (*music.SearchResults, error) {
+	if query.Terms == "" {
+		return nil, music.ErrEmptySearch
+	}
+	s.queries = append(s.queries, query)
+	return &music.SearchResults{
+		Songs:   []music.Song{{ID: 12, Title: "Ghost Love Score", URI: "/music/Nightwish/Ghost Love Score.flac"}},
+		Albums:  []music.Album{{Name: "Once", Artist: "Nightwish", SongCount: 1}},
+		Artists: []music.Artist{{Name: "Nightwish"}},
+	}, nil
+}
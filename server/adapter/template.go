@@ -32,6 +32,12 @@ type TemplateExecutor interface {
 	Load(writer io.Writer, data interface{}, templatePaths ...string) error
 }
 
+// CSRFTokenWriter is implemented by the writers of the requests protected against cross-site request forgery.
+// Templates output its token with the csrfToken function, in the hidden field of their forms.
+type CSRFTokenWriter interface {
+	CSRFToken() string
+}
+
 // templateBaseExecutor implements TemplateExecutor for production code
 type templateBaseExecutor struct {
 	basePath string // absolute path to the /templates directory
@@ -51,7 +57,12 @@ func (t *templateBaseExecutor) Load(writer io.Writer, data interface{}, template
 		cleanedPaths = append(cleanedPaths, path.Join(t.basePath, filepath.Clean(templatePath)))
 	}
 
-	tmpl, err := template.ParseFiles(cleanedPaths...)
+	if len(cleanedPaths) == 0 {
+		return fmt.Errorf("could not load the templates: no template path given")
+	}
+	tmpl, err := template.New(path.Base(cleanedPaths[0])).
+		Funcs(template.FuncMap{"csrfToken": csrfTokenOf(writer)}).
+		ParseFiles(cleanedPaths...)
 	if err != nil {
 		return fmt.Errorf("could not load the templates %v: %w", templatePaths, err)
 	}
@@ -61,3 +72,14 @@ func (t *templateBaseExecutor) Load(writer io.Writer, data interface{}, template
 	}
 	return nil
 }
+
+// csrfTokenOf returns the template function giving the token of the writer. The token is empty
+// when the request is not protected against cross-site request forgery.
+func csrfTokenOf(writer io.Writer) func() string {
+	return func() string {
+		if tokenWriter, ok := writer.(CSRFTokenWriter); ok {
+			return tokenWriter.CSRFToken()
+		}
+		return ""
+	}
+}
@@ -47,9 +47,28 @@ func TestTemplateExecutor(t *testing.T) {
 		tests.AssertNoError(t, err)
 	})
 
+	t.Run("it gives the token of the writer to the csrfToken function of the templates", func(t *testing.T) {
+		writer := &csrfTokenWriter{token: "0123456789abcdef"}
+		err := loader.Load(writer, nil, "../../templates/sign-in.html")
+		tests.AssertNoError(t, err)
+
+		if !strings.Contains(writer.String(), `value="0123456789abcdef"`) {
+			t.Errorf("expected the form to contain the token, got %s", writer.String())
+		}
+	})
+
 	t.Run("when it cannot load a template, it returns an error", func(t *testing.T) {
 		writer := &strings.Builder{}
 		err := loader.Load(writer, nil, "./unknown-template.html")
 		tests.AssertError(t, err)
 	})
 }
+
+type csrfTokenWriter struct {
+	strings.Builder
+	token string
+}
+
+func (w *csrfTokenWriter) CSRFToken() string {
+	return w.token
+}
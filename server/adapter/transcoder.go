@@ -0,0 +1,125 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+// ffmpegCodecs maps transcoding formats to the ffmpeg encoder and container (muxer) producing them
+var ffmpegCodecs = map[string][2]string{
+	"opus": {"libopus", "ogg"},
+	"mp3":  {"libmp3lame", "mp3"},
+	"aac":  {"aac", "adts"},
+}
+
+// maximumFFmpegErrorOutput bounds how much of ffmpeg's error output is kept to explain failures
+const maximumFFmpegErrorOutput = 4096
+
+// NewFFmpegTranscoder creates a new music.Transcoder running the ffmpeg executable found at binaryPath.
+// It returns an error when there is no such executable.
+func NewFFmpegTranscoder(binaryPath string) (music.Transcoder, error) {
+	resolved, err := exec.LookPath(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not find ffmpeg at %s: %w", binaryPath, err)
+	}
+	return &ffmpegTranscoder{resolved}, nil
+}
+
+// ffmpegTranscoder implements music.Transcoder. It runs one ffmpeg process per transcode and reads
+// the transcoded audio from its standard output.
+type ffmpegTranscoder struct {
+	binaryPath string
+}
+
+func (f *ffmpegTranscoder) Transcode(
+	ctx context.Context,
+	sourcePath string,
+	profile music.TranscodingProfile,
+	output io.Writer,
+) error {
+	codec, ok := ffmpegCodecs[profile.Format.Name]
+	if !ok {
+		return fmt.Errorf("ffmpeg cannot transcode to %s: %w", profile.Format.Name, music.ErrUnsupportedTranscodingFormat)
+	}
+	// The context kills ffmpeg when it is done, for example when the client disconnects
+	command := exec.CommandContext(
+		ctx,
+		f.binaryPath,
+		"-nostdin",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", sourcePath,
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", codec[0],
+		"-b:a", strconv.FormatUint(uint64(profile.Bitrate), 10)+"k",
+		"-f", codec[1],
+		"pipe:1",
+	)
+	errorOutput := &limitedBuffer{limit: maximumFFmpegErrorOutput}
+	command.Stderr = errorOutput
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("could not connect to the output of ffmpeg: %w", err)
+	}
+	if err = command.Start(); err != nil {
+		return fmt.Errorf("could not start ffmpeg: %w", err)
+	}
+	_, copyErr := io.Copy(output, stdout)
+	if copyErr != nil {
+		// Nobody reads the output anymore, there is no reason to go on
+		command.Process.Kill() //nolint:errcheck // The process may have exited already
+	}
+	waitErr := command.Wait()
+	if copyErr != nil {
+		return fmt.Errorf("could not write the transcoded %s: %w", sourcePath, copyErr)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("transcoding of %s was interrupted: %w", sourcePath, ctx.Err())
+	}
+	if waitErr != nil {
+		return fmt.Errorf("ffmpeg could not transcode %s: %w: %s", sourcePath, waitErr, strings.TrimSpace(errorOutput.String()))
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first bytes written to it and silently drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (l *limitedBuffer) Write(data []byte) (int, error) {
+	if remaining := l.limit - l.Len(); remaining > 0 {
+		if len(data) > remaining {
+			l.Buffer.Write(data[:remaining])
+		} else {
+			l.Buffer.Write(data)
+		}
+	}
+	return len(data), nil
+}
@@ -0,0 +1,100 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestFFmpegTranscoder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg executables are shell scripts")
+	}
+	profile, err := music.NewTranscodingProfile("opus", 96)
+	tests.AssertNoError(t, err)
+	musicFile := filepath.Join(t.TempDir(), "song.flac")
+	if err = os.WriteFile(musicFile, []byte("original"), 0o600); err != nil {
+		t.Fatalf("could not create the music file %v", err)
+	}
+
+	t.Run("it runs ffmpeg with the profile's codec and bitrate and writes its output", func(t *testing.T) {
+		// The fake ffmpeg prints its arguments
+		transcoder := newFakeFFmpegTranscoder(t, `echo "$@"`)
+		var output bytes.Buffer
+
+		err := transcoder.Transcode(context.Background(), musicFile, profile, &output)
+
+		tests.AssertNoError(t, err)
+		for _, want := range []string{"-i " + musicFile, "-c:a libopus", "-b:a 96k", "-f ogg pipe:1"} {
+			if !strings.Contains(output.String(), want) {
+				t.Errorf("expected ffmpeg arguments %q to contain %q", output.String(), want)
+			}
+		}
+	})
+
+	t.Run("when ffmpeg fails, it returns an error with its error output", func(t *testing.T) {
+		transcoder := newFakeFFmpegTranscoder(t, `echo "Invalid data found" >&2; exit 1`)
+
+		err := transcoder.Transcode(context.Background(), musicFile, profile, &bytes.Buffer{})
+
+		if err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+			t.Errorf("expected an error explaining why ffmpeg failed, got %v", err)
+		}
+	})
+
+	t.Run("when the context is done, it kills ffmpeg", func(t *testing.T) {
+		transcoder := newFakeFFmpegTranscoder(t, `echo started; exec sleep 30`)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+
+		err := transcoder.Transcode(ctx, musicFile, profile, &bytes.Buffer{})
+
+		tests.AssertError(t, err)
+		if time.Since(start) > 10*time.Second {
+			t.Errorf("expected ffmpeg to be killed when the context is done")
+		}
+	})
+
+	t.Run("given a missing executable, it returns an error", func(t *testing.T) {
+		_, err := adapter.NewFFmpegTranscoder(filepath.Join(t.TempDir(), "ffmpeg"))
+		tests.AssertError(t, err)
+	})
+}
+
+func newFakeFFmpegTranscoder(t *testing.T, script string) music.Transcoder {
+	t.Helper()
+	binaryPath := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\n"+script+"\n"), 0o700); err != nil {
+		t.Fatalf("could not create the fake ffmpeg %v", err)
+	}
+	transcoder, err := adapter.NewFFmpegTranscoder(binaryPath)
+	tests.AssertNoError(t, err)
+	return transcoder
+}
@@ -0,0 +1,182 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// LibraryWatcher watches the folders of the music library roots with inotify, or the equivalent of the OS.
+// It gives the paths in the music library of the created, written, moved and removed files and folders,
+// for example "lossless/Nightwish/Once/ghost.flac" for a file of the root named "lossless".
+// New folders are watched as soon as they are created.
+type LibraryWatcher struct {
+	watcher *fsnotify.Watcher
+	roots   []watchedRoot
+	changes chan string
+	errors  chan error
+	done    chan struct{} // Closed when the watcher is closed
+}
+
+// watchedRoot is a root of the music library, its path is absolute and clean
+type watchedRoot struct {
+	name string
+	path string
+}
+
+// NewLibraryWatcher starts watching every folder of the roots. rootPaths maps the name of each root to its path.
+// It returns an error when a folder cannot be watched, for example when the file system does not support it
+// or when the OS limit of watched folders is reached.
+func NewLibraryWatcher(rootPaths map[string]string) (*LibraryWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("could not create the file system watcher: %w", err)
+	}
+	libraryWatcher := &LibraryWatcher{
+		watcher: watcher,
+		changes: make(chan string, 64),
+		errors:  make(chan error, 8),
+		done:    make(chan struct{}),
+	}
+	for name, rootPath := range rootPaths {
+		absolutePath, err := filepath.Abs(rootPath)
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("could not find the path of the library root %s: %w", name, err)
+		}
+		libraryWatcher.roots = append(libraryWatcher.roots, watchedRoot{name, absolutePath})
+	}
+	// Nested roots belong to the most specific one
+	sort.Slice(libraryWatcher.roots, func(i, j int) bool {
+		return len(libraryWatcher.roots[i].path) > len(libraryWatcher.roots[j].path)
+	})
+	for _, root := range libraryWatcher.roots {
+		if err = libraryWatcher.watchFolders(root.path); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	go libraryWatcher.forwardEvents()
+	return libraryWatcher, nil
+}
+
+// Changes returns the paths in the music library of the files and folders that changed.
+// The channel is closed when the watcher is closed.
+func (l *LibraryWatcher) Changes() <-chan string {
+	return l.changes
+}
+
+// Errors returns the errors of the watcher, for example when a new folder cannot be watched.
+// Changes may have been missed. The channel is closed when the watcher is closed.
+func (l *LibraryWatcher) Errors() <-chan error {
+	return l.errors
+}
+
+// Close stops watching the music library
+func (l *LibraryWatcher) Close() error {
+	close(l.done)
+	return l.watcher.Close()
+}
+
+// watchFolders watches the folder at folderPath and all its sub-folders
+func (l *LibraryWatcher) watchFolders(folderPath string) error {
+	return filepath.WalkDir(folderPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("could not read the folder %s: %w", filePath, err)
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if err = l.watcher.Add(filePath); err != nil {
+			return fmt.Errorf("could not watch the folder %s: %w", filePath, err)
+		}
+		return nil
+	})
+}
+
+func (l *LibraryWatcher) forwardEvents() {
+	defer close(l.changes)
+	defer close(l.errors)
+	for {
+		select {
+		case <-l.done:
+			return
+		case event, ok := <-l.watcher.Events:
+			if !ok {
+				return
+			}
+			l.handleEvent(event)
+		case err, ok := <-l.watcher.Errors:
+			if !ok {
+				return
+			}
+			l.reportError(fmt.Errorf("the file system watcher failed, changes may have been missed: %w", err))
+		}
+	}
+}
+
+func (l *LibraryWatcher) handleEvent(event fsnotify.Event) {
+	// Changes of permissions or of access times do not change the library
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	libraryPath, ok := l.libraryPath(event.Name)
+	if !ok {
+		return
+	}
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			// Files may have been moved into the new folder before it was watched, they are found when it is scanned
+			if err = l.watchFolders(event.Name); err != nil {
+				l.reportError(err)
+			}
+		}
+	}
+	select {
+	case l.changes <- libraryPath:
+	case <-l.done:
+	}
+}
+
+// reportError drops the error when nobody reads them, the watcher must go on
+func (l *LibraryWatcher) reportError(err error) {
+	select {
+	case l.errors <- err:
+	default:
+	}
+}
+
+// libraryPath returns the path in the music library of the file at filePath
+func (l *LibraryWatcher) libraryPath(filePath string) (string, bool) {
+	for _, root := range l.roots {
+		relativePath, err := filepath.Rel(root.path, filePath)
+		if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return path.Join(root.name, filepath.ToSlash(relativePath)), true
+	}
+	return "", false
+}
@@ -0,0 +1,80 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package adapter_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/adapter"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestLibraryWatcher(t *testing.T) {
+	rootPath := t.TempDir()
+	tests.AssertNoError(t, os.MkdirAll(filepath.Join(rootPath, "Nightwish", "Once"), 0o750))
+	watcher, err := adapter.NewLibraryWatcher(map[string]string{"lossless": rootPath})
+	if err != nil {
+		t.Skipf("the file system cannot be watched: %v", err)
+	}
+	t.Cleanup(func() { watcher.Close() })
+
+	t.Run("it gives the library path of the files written in watched folders", func(t *testing.T) {
+		tests.AssertNoError(t, os.WriteFile(filepath.Join(rootPath, "Nightwish", "Once", "ghost.flac"), []byte("flac"), 0o600))
+
+		waitForChange(t, watcher, "lossless/Nightwish/Once/ghost.flac")
+	})
+
+	t.Run("it watches the folders created after it started", func(t *testing.T) {
+		albumPath := filepath.Join(rootPath, "Epica", "The Quantum Enigma")
+		tests.AssertNoError(t, os.MkdirAll(albumPath, 0o750))
+		waitForChange(t, watcher, "lossless/Epica")
+		// The sub-folder may be created before its parent is watched
+		time.Sleep(50 * time.Millisecond)
+
+		tests.AssertNoError(t, os.WriteFile(filepath.Join(albumPath, "kingdom.flac"), []byte("flac"), 0o600))
+
+		waitForChange(t, watcher, "lossless/Epica/The Quantum Enigma/kingdom.flac")
+	})
+
+	t.Run("it gives the library path of removed files", func(t *testing.T) {
+		tests.AssertNoError(t, os.Remove(filepath.Join(rootPath, "Nightwish", "Once", "ghost.flac")))
+
+		waitForChange(t, watcher, "lossless/Nightwish/Once/ghost.flac")
+	})
+}
+
+// waitForChange skips the other changes until it receives want
+func waitForChange(t *testing.T, watcher *adapter.LibraryWatcher, want string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-watcher.Changes():
+			if got == want {
+				return
+			}
+		case err := <-watcher.Errors():
+			t.Fatalf("unexpected watcher error: %v", err)
+		case <-timeout:
+			t.Fatalf("expected a change of %s", want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrArtistNotFound is returned when an artist is not in the library index
+	ErrArtistNotFound = errors.New("artist not found in the library index")
+	// ErrAlbumNotFound is returned when an album is not in the library index
+	ErrAlbumNotFound = errors.New("album not found in the library index")
+)
+
+// LibraryArtist represents the songs sharing the same artist tag in the library index
+type LibraryArtist struct {
+	ID         uint   // Identifier of the artist in the library index
+	Name       string // Name of the artist. For example "Nightwish"
+	AlbumCount uint   // Number of albums of the artist
+	SongCount  uint   // Number of songs of the artist, including the songs without album
+	CoverID    uint   // Identifier of the cover art of one of the artist's songs. It is zero when none has cover art
+}
+
+// LibraryAlbum represents the songs sharing the same album and artist tags in the library index
+type LibraryAlbum struct {
+	ID        uint   // Identifier of the album in the library index
+	Name      string // Name of the album. For example "Once"
+	ArtistID  uint   // Identifier of the album's artist. It is zero when the songs have no artist tag
+	Artist    string // Name of the album's artist. For example "Nightwish"
+	SongCount uint   // Number of songs of the album
+	Duration  uint   // Total duration of the songs in seconds
+	CoverID   uint   // Identifier of the cover art of one of the album's songs. It is zero when none has cover art
+}
+
+// Genre represents the songs sharing the same genre tag in the library index
+type Genre struct {
+	ID         uint   // Identifier of the genre in the library index
+	Name       string // Name of the genre. For example "Symphonic Metal"
+	AlbumCount uint   // Number of albums with songs of the genre
+	SongCount  uint   // Number of songs of the genre
+}
+
+// AlbumSort orders albums
+type AlbumSort string
+
+// Albums can be sorted by their name or by the name of their artist, then by name
+const (
+	AlbumsByName   AlbumSort = "name"
+	AlbumsByArtist AlbumSort = "artist"
+)
+
+// AlbumQuery selects a page of albums. Zero identifiers do not filter anything.
+type AlbumQuery struct {
+	ArtistID   uint // Only the albums of this artist
+	GenreID    uint // Only the albums with songs of this genre
+	Sort       AlbumSort
+	Descending bool
+	Limit      uint // Maximum number of albums to return. Zero returns all of them
+	Offset     uint // Number of albums to skip
+}
+
+// SongSort orders songs
+type SongSort string
+
+// Songs sorted by artist or album are then ordered like on the albums: by album, disk and track number
+const (
+	SongsByTitle    SongSort = "title"
+	SongsByArtist   SongSort = "artist"
+	SongsByAlbum    SongSort = "album"
+	SongsByDuration SongSort = "duration"
+)
+
+// SongQuery selects a page of songs. Zero identifiers do not filter anything.
+type SongQuery struct {
+	ArtistID   uint // Only the songs of this artist
+	AlbumID    uint // Only the songs of this album
+	GenreID    uint // Only the songs of this genre
+	Sort       SongSort
+	Descending bool
+	Limit      uint // Maximum number of songs to return. Zero returns all of them
+	Offset     uint // Number of songs to skip
+}
+
+// CatalogStore lists the artists, albums and genres of the library index, derived from the tags of the songs,
+// so that the library can be browsed without following its folders.
+type CatalogStore interface {
+	// ListArtists returns all the artists, ordered by name
+	ListArtists(ctx context.Context) ([]LibraryArtist, error)
+	// GetArtist returns the artist identified by artistID. It returns ErrArtistNotFound when there is no such artist.
+	GetArtist(ctx context.Context, artistID uint) (*LibraryArtist, error)
+	// ListAlbums returns the page of albums selected by query and the total number of albums it selects
+	ListAlbums(ctx context.Context, query AlbumQuery) ([]LibraryAlbum, uint, error)
+	// GetAlbum returns the album identified by albumID. It returns ErrAlbumNotFound when there is no such album.
+	GetAlbum(ctx context.Context, albumID uint) (*LibraryAlbum, error)
+	// ListGenres returns all the genres, ordered by name
+	ListGenres(ctx context.Context) ([]Genre, error)
+	// ListSongs returns the page of songs selected by query and the total number of songs it selects
+	ListSongs(ctx context.Context, query SongQuery) ([]IndexedSong, uint, error)
+}
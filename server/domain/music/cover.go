@@ -0,0 +1,345 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	_ "image/gif" // Registers the GIF decoder for cover art
+)
+
+var (
+	// ErrCoverNotFound is returned when a cover is not in the library index or when its image is gone
+	ErrCoverNotFound = errors.New("cover not found in the library index")
+	// ErrNoPicture is returned when a music file does not embed any picture
+	ErrNoPicture = errors.New("the music file does not embed any picture")
+)
+
+// frontCoverPictureType identifies front covers in ID3v2 APIC frames and FLAC PICTURE blocks
+const frontCoverPictureType = 3
+
+// maximumCoverFileSize bounds the size of the cover image files that are read
+const maximumCoverFileSize = 20 << 20
+
+// maximumCoverPixels bounds the dimensions of the cover images that are decoded to be resized.
+// A small compressed file can declare huge dimensions, and decoding allocates 4 bytes per pixel.
+const maximumCoverPixels = 40_000_000
+
+// coverSizes are the sizes in pixels covers can be resized to. Requested sizes are rounded up to
+// one of them so that the thumbnail cache stays small.
+var coverSizes = [5]uint{64, 128, 256, 512, 1024}
+
+// coverFileNames are the base names of the image files used as folder covers, by order of preference
+var coverFileNames = [5]string{"cover", "folder", "front", "album", "albumart"}
+
+var imageMIMETypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// Picture represents an image, either a cover image file or a picture embedded in a music file's tags
+type Picture struct {
+	MIMEType     string // MIME type of the image. For example "image/jpeg"
+	Data         []byte
+	isFrontCover bool
+}
+
+// Cover represents the cover art of a folder or of a song in the library index. Its image is either
+// a file next to the songs (such as "cover.jpg") or embedded in a song's tags.
+type Cover struct {
+	ID               uint      // Identifier of the cover in the library index
+	Path             string    // Path from the root music folder of the image file, or of the song embedding the image
+	Embedded         bool      // Whether the image is embedded in the tags of the song at Path
+	ModificationTime time.Time // Modification time of the file at Path when it was last scanned
+	Size             int64     // Size in bytes of the file at Path when it was last scanned
+}
+
+// CoverStore retrieves covers from the library index
+type CoverStore interface {
+	// GetCover returns the cover identified by coverID. It returns ErrCoverNotFound when there is no such cover.
+	GetCover(ctx context.Context, coverID uint) (*Cover, error)
+}
+
+// CoverCache stores resized covers so that they are not resized again
+type CoverCache interface {
+	// Get returns the picture stored under key. It returns false when there is none.
+	Get(key string) (*Picture, bool)
+	Put(key string, picture *Picture) error
+}
+
+// CoverLoader loads cover art images
+type CoverLoader interface {
+	// LoadCover returns the image of the cover identified by coverID, resized so that it fits in a
+	// size x size square. Size zero returns the original image. Images are never enlarged.
+	// It returns ErrCoverNotFound when there is no such cover.
+	LoadCover(ctx context.Context, coverID uint, size uint) (*Picture, error)
+}
+
+// baseCoverLoader implements CoverLoader
+type baseCoverLoader struct {
+	filesystem fs.FS
+	store      CoverStore
+	cache      CoverCache
+}
+
+// NewCoverLoader creates a new CoverLoader
+func NewCoverLoader(filesystem fs.FS, store CoverStore, cache CoverCache) CoverLoader {
+	return &baseCoverLoader{filesystem, store, cache}
+}
+
+func (b *baseCoverLoader) LoadCover(ctx context.Context, coverID uint, size uint) (*Picture, error) {
+	cover, err := b.store.GetCover(ctx, coverID)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return b.readOriginal(cover)
+	}
+	size = roundCoverSize(size)
+	key := coverCacheKey(cover, size)
+	if picture, ok := b.cache.Get(key); ok {
+		return picture, nil
+	}
+	original, err := b.readOriginal(cover)
+	if err != nil {
+		return nil, err
+	}
+	resized, err := resizePicture(original, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not resize the cover #%d: %w", coverID, err)
+	}
+	// The resized cover can still be served when it cannot be cached, it will be resized again next time
+	_ = b.cache.Put(key, resized)
+	return resized, nil
+}
+
+func (b *baseCoverLoader) readOriginal(cover *Cover) (*Picture, error) {
+	file, err := b.filesystem.Open(cover.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not open the image of cover #%d: %w", cover.ID, ErrCoverNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open the image of cover #%d: %w", cover.ID, err)
+	}
+	defer file.Close()
+	if cover.Embedded {
+		seeker, ok := file.(io.ReadSeeker)
+		if !ok {
+			return nil, fmt.Errorf("could not seek in the file %v", cover.Path)
+		}
+		picture, err := ReadPicture(seeker, path.Base(cover.Path))
+		if errors.Is(err, ErrNoPicture) {
+			return nil, fmt.Errorf("the song %v does not embed cover #%d anymore: %w", cover.Path, cover.ID, ErrCoverNotFound)
+		}
+		return picture, err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maximumCoverFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the image of cover #%d: %w", cover.ID, err)
+	}
+	mimeType := imageMIMETypes[strings.ToLower(path.Ext(cover.Path))]
+	return &Picture{MIMEType: normalizeImageMIMEType(mimeType, data), Data: data}, nil
+}
+
+// ReadPicture returns the picture embedded in the tags of the given music file, preferably the front cover.
+// It returns ErrNoPicture when the file does not embed any picture.
+func ReadPicture(file io.ReadSeeker, fileName string) (*Picture, error) {
+	tags, err := ReadTags(file, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if tags.Picture == nil {
+		return nil, ErrNoPicture
+	}
+	return tags.Picture, nil
+}
+
+// findCoverFile returns the name of the image file to use as the folder's cover, or an empty
+// string when there is none.
+func findCoverFile(fileNames []string) string {
+	best, bestRank := "", len(coverFileNames)
+	for _, fileName := range fileNames {
+		extension := strings.ToLower(path.Ext(fileName))
+		if _, isImage := imageMIMETypes[extension]; !isImage {
+			continue
+		}
+		baseName := strings.ToLower(strings.TrimSuffix(fileName, path.Ext(fileName)))
+		for rank, coverFileName := range coverFileNames {
+			if baseName == coverFileName && rank < bestRank {
+				best, bestRank = fileName, rank
+			}
+		}
+	}
+	return best
+}
+
+// preferredPicture returns the front cover among current and candidate. When neither is a front cover,
+// it keeps the first one found.
+func preferredPicture(current *Picture, candidate *Picture) *Picture {
+	if current == nil || (candidate != nil && candidate.isFrontCover && !current.isFrontCover) {
+		return candidate
+	}
+	return current
+}
+
+// imageMIMETypeForFormat returns the MIME type of ID3v2.2 image formats such as "JPG" or "PNG"
+func imageMIMETypeForFormat(format string) string {
+	return imageMIMETypes["."+strings.ToLower(format)]
+}
+
+// normalizeImageMIMEType recognizes the image format from its first bytes because taggers often write
+// wrong MIME types such as "image/jpg". It falls back to mimeType for unknown formats.
+func normalizeImageMIMEType(mimeType string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return "image/gif"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	case mimeType == "":
+		return "application/octet-stream"
+	}
+	return mimeType
+}
+
+// roundCoverSize rounds size up to the closest of coverSizes
+func roundCoverSize(size uint) uint {
+	for _, coverSize := range coverSizes {
+		if size <= coverSize {
+			return coverSize
+		}
+	}
+	return coverSizes[len(coverSizes)-1]
+}
+
+// coverCacheKey changes whenever the file containing the cover image changes
+func coverCacheKey(cover *Cover, size uint) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s\x00%t\x00%d\x00%d\x00%d",
+		cover.Path,
+		cover.Embedded,
+		cover.ModificationTime.UnixNano(),
+		cover.Size,
+		size,
+	)))
+	return hex.EncodeToString(hash[:])
+}
+
+// resizePicture shrinks the picture so that it fits in a size x size square. PNG pictures stay PNG
+// to keep their transparency, other formats are encoded to JPEG. Pictures that already fit and
+// formats that cannot be decoded are returned unchanged.
+func resizePicture(picture *Picture, size uint) (*Picture, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(picture.Data))
+	if err != nil {
+		return picture, nil
+	}
+	if config.Width*config.Height > maximumCoverPixels {
+		return nil, fmt.Errorf("the picture of %dx%d pixels is too large to be resized", config.Width, config.Height)
+	}
+	source, format, err := image.Decode(bytes.NewReader(picture.Data))
+	if err != nil {
+		return picture, nil
+	}
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= int(size) && height <= int(size) {
+		return picture, nil
+	}
+	targetWidth, targetHeight := int(size), int(size)
+	if width > height {
+		targetHeight = maxInt(1, height*int(size)/width)
+	} else {
+		targetWidth = maxInt(1, width*int(size)/height)
+	}
+	resized := downscale(source, targetWidth, targetHeight)
+
+	var buffer bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buffer, resized)
+	} else {
+		err = jpeg.Encode(&buffer, resized, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+	mimeType := "image/jpeg"
+	if format == "png" {
+		mimeType = "image/png"
+	}
+	return &Picture{MIMEType: mimeType, Data: buffer.Bytes()}, nil
+}
+
+// downscale averages the source pixels covered by each target pixel (box filter)
+func downscale(source image.Image, targetWidth int, targetHeight int) *image.NRGBA {
+	bounds := source.Bounds()
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+	width, height := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	target := image.NewNRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		top, bottom := y*height/targetHeight, maxInt((y+1)*height/targetHeight, y*height/targetHeight+1)
+		for x := 0; x < targetWidth; x++ {
+			left, right := x*width/targetWidth, maxInt((x+1)*width/targetWidth, x*width/targetWidth+1)
+			var sum [4]int
+			for sourceY := top; sourceY < bottom; sourceY++ {
+				offset := rgba.PixOffset(left, sourceY)
+				for sourceX := left; sourceX < right; sourceX++ {
+					for channel := 0; channel < 4; channel++ {
+						sum[channel] += int(rgba.Pix[offset+channel])
+					}
+					offset += 4
+				}
+			}
+			count := (bottom - top) * (right - left)
+			targetOffset := target.PixOffset(x, y)
+			for channel := 0; channel < 4; channel++ {
+				target.Pix[targetOffset+channel] = uint8(sum[channel] / count)
+			}
+		}
+	}
+	return target
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,229 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestReadPicture(t *testing.T) {
+	t.Run("given an MP3 file with APIC frames, it returns the front cover", func(t *testing.T) {
+		backCover := newAPICFrame(t, 4, []byte("\xff\xd8\xffback"))
+		frontCover := newAPICFrame(t, 3, []byte("\xff\xd8\xfffront"))
+		data := append(newID3v2Tag(3, append(backCover, frontCover...)), newCBRFrames(t, 16000)...)
+
+		got, err := music.ReadPicture(bytes.NewReader(data), "song.mp3")
+
+		tests.AssertNoError(t, err)
+		assertPictureEquals(t, got, "image/jpeg", "\xff\xd8\xfffront")
+	})
+
+	t.Run("given an Ogg or FLAC file with a METADATA_BLOCK_PICTURE comment, it returns the picture", func(t *testing.T) {
+		block := newFLACPictureBlock(t, "image/png", []byte("\x89PNG\r\n\x1a\npicture"))
+		comment := "METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(block)
+		data := newFLAC(t, 44100, 44100, newVorbisComment(t, "TITLE=Eva", comment))
+
+		got, err := music.ReadPicture(bytes.NewReader(data), "song.flac")
+
+		tests.AssertNoError(t, err)
+		assertPictureEquals(t, got, "image/png", "\x89PNG\r\n\x1a\npicture")
+	})
+
+	t.Run("given a music file without picture, it returns ErrNoPicture", func(t *testing.T) {
+		_, err := music.ReadPicture(bytes.NewReader(newID3v23MP3(t)), "song.mp3")
+		if !errors.Is(err, music.ErrNoPicture) {
+			t.Errorf("expected ErrNoPicture, got %v", err)
+		}
+	})
+}
+
+func TestCoverLoader(t *testing.T) {
+	wide := newPNG(t, 600, 300)
+
+	t.Run("given size zero, it returns the original cover image", func(t *testing.T) {
+		testFS := fstest.MapFS{"Once/Cover.PNG": {Data: wide}}
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/Cover.PNG"}}
+		loader := music.NewCoverLoader(testFS, store, newStubCoverCache())
+
+		got, err := loader.LoadCover(context.Background(), 1, 0)
+
+		tests.AssertNoError(t, err)
+		assertPictureEquals(t, got, "image/png", string(wide))
+	})
+
+	t.Run(`given a size, it rounds it up, shrinks the cover to fit in that size and caches it`, func(t *testing.T) {
+		testFS := fstest.MapFS{"Once/cover.png": {Data: wide}}
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/cover.png"}}
+		cache := newStubCoverCache()
+		loader := music.NewCoverLoader(testFS, store, cache)
+
+		got, err := loader.LoadCover(context.Background(), 1, 200)
+
+		tests.AssertNoError(t, err)
+		assertImageSize(t, got, 256, 128)
+		if len(cache.pictures) != 1 {
+			t.Fatalf("expected the resized cover to be cached, got %d cached covers", len(cache.pictures))
+		}
+
+		delete(testFS, "Once/cover.png")
+		cached, err := loader.LoadCover(context.Background(), 1, 256)
+		tests.AssertNoError(t, err)
+		assertImageSize(t, cached, 256, 128)
+	})
+
+	t.Run("it never enlarges covers", func(t *testing.T) {
+		testFS := fstest.MapFS{"Once/cover.png": {Data: wide}}
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/cover.png"}}
+		loader := music.NewCoverLoader(testFS, store, newStubCoverCache())
+
+		got, err := loader.LoadCover(context.Background(), 1, 1024)
+
+		tests.AssertNoError(t, err)
+		assertImageSize(t, got, 600, 300)
+	})
+
+	t.Run("given a cover declaring huge dimensions, it returns an error instead of decoding it", func(t *testing.T) {
+		// GIF header of a 65535 x 65535 image without any pixel
+		huge := []byte{'G', 'I', 'F', '8', '9', 'a', 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0}
+		testFS := fstest.MapFS{"Once/cover.gif": {Data: huge}}
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/cover.gif"}}
+		loader := music.NewCoverLoader(testFS, store, newStubCoverCache())
+
+		_, err := loader.LoadCover(context.Background(), 1, 256)
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given an embedded cover, it reads the picture from the song's tags", func(t *testing.T) {
+		frame := newAPICFrame(t, 3, wide)
+		testFS := fstest.MapFS{"Once/ghost.mp3": {Data: append(newID3v2Tag(3, frame), newCBRFrames(t, 16000)...)}}
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/ghost.mp3", Embedded: true}}
+		loader := music.NewCoverLoader(testFS, store, newStubCoverCache())
+
+		got, err := loader.LoadCover(context.Background(), 1, 64)
+
+		tests.AssertNoError(t, err)
+		assertImageSize(t, got, 64, 32)
+	})
+
+	t.Run("given an unknown cover or a cover whose image is gone, it returns ErrCoverNotFound", func(t *testing.T) {
+		store := &stubCoverStore{music.Cover{ID: 1, Path: "Once/cover.png"}}
+		loader := music.NewCoverLoader(fstest.MapFS{}, store, newStubCoverCache())
+
+		_, err := loader.LoadCover(context.Background(), 2, 0)
+		if !errors.Is(err, music.ErrCoverNotFound) {
+			t.Errorf("expected ErrCoverNotFound for an unknown cover, got %v", err)
+		}
+		_, err = loader.LoadCover(context.Background(), 1, 0)
+		if !errors.Is(err, music.ErrCoverNotFound) {
+			t.Errorf("expected ErrCoverNotFound for a deleted image, got %v", err)
+		}
+	})
+}
+
+func assertPictureEquals(t *testing.T, got *music.Picture, wantMIMEType string, wantData string) {
+	t.Helper()
+	if got.MIMEType != wantMIMEType || string(got.Data) != wantData {
+		t.Errorf("expected a picture of type %s with data %q, got type %s with data %q", wantMIMEType, wantData, got.MIMEType, got.Data)
+	}
+}
+
+func assertImageSize(t *testing.T, picture *music.Picture, wantWidth int, wantHeight int) {
+	t.Helper()
+	config, _, err := image.DecodeConfig(bytes.NewReader(picture.Data))
+	if err != nil {
+		t.Fatalf("could not decode the picture: %v", err)
+	}
+	if config.Width != wantWidth || config.Height != wantHeight {
+		t.Errorf("expected a %dx%d picture, got %dx%d", wantWidth, wantHeight, config.Width, config.Height)
+	}
+}
+
+func newPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	picture := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			picture.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, picture); err != nil {
+		t.Fatalf("could not encode the test picture %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func newAPICFrame(t *testing.T, pictureType byte, data []byte) []byte {
+	t.Helper()
+	frameData := append([]byte{0}, "image/jpg\x00"...) // Taggers often write wrong MIME types
+	frameData = append(frameData, pictureType)
+	frameData = append(frameData, "description\x00"...)
+	return newID3v2Frame(t, 3, "APIC", append(frameData, data...))
+}
+
+func newFLACPictureBlock(t *testing.T, mimeType string, data []byte) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	for _, value := range []interface{}{uint32(3), uint32(len(mimeType)), []byte(mimeType), uint32(0), make([]byte, 16), uint32(len(data)), data} {
+		if err := binary.Write(buffer, binary.BigEndian, value); err != nil {
+			t.Fatalf("could not write test data %v", err)
+		}
+	}
+	return buffer.Bytes()
+}
+
+type stubCoverStore struct {
+	cover music.Cover
+}
+
+func (s *stubCoverStore) GetCover(_ context.Context, coverID uint) (*music.Cover, error) {
+	if coverID != s.cover.ID {
+		return nil, music.ErrCoverNotFound
+	}
+	return &s.cover, nil
+}
+
+func newStubCoverCache() *stubCoverCache {
+	return &stubCoverCache{pictures: make(map[string]*music.Picture)}
+}
+
+type stubCoverCache struct {
+	pictures map[string]*music.Picture
+}
+
+func (s *stubCoverCache) Get(key string) (*music.Picture, bool) {
+	picture, ok := s.pictures[key]
+	return picture, ok
+}
+
+func (s *stubCoverCache) Put(key string, picture *music.Picture) error {
+	s.pictures[key] = picture
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// FolderSort orders the contents of a folder. Sub-folders always come before songs.
+type FolderSort string
+
+// Sub-folders and songs are sorted by name in natural order, or by modification time. Sorted by track,
+// songs are ordered by disk and track number, and sub-folders by name.
+const (
+	FolderEntriesByName             FolderSort = "name"
+	FolderEntriesByModificationTime FolderSort = "mtime"
+	FolderEntriesByTrack            FolderSort = "track"
+)
+
+// FolderEntryType selects the sub-folders or the songs of a folder
+type FolderEntryType string
+
+// FolderEntriesOfAllTypes selects both the sub-folders and the songs
+const (
+	FolderEntriesOfAllTypes FolderEntryType = ""
+	FolderEntriesOfFolders  FolderEntryType = "folders"
+	FolderEntriesOfSongs    FolderEntryType = "songs"
+)
+
+// FolderQuery selects a page of the contents of a folder. Sub-folders and songs are paginated as a single list,
+// sub-folders first.
+type FolderQuery struct {
+	Sort       FolderSort // Sorts by name when it is empty
+	Type       FolderEntryType
+	Descending bool
+	Limit      uint // Maximum number of sub-folders and songs to return. Zero returns all of them
+	Offset     uint // Number of sub-folders and songs to skip
+}
+
+// FolderPage represents a page of the contents of a folder. Totals count the sub-folders and songs
+// of the selected types, not only this page.
+type FolderPage struct {
+	Folders      []SubFolder
+	Songs        []Song
+	TotalFolders uint
+	TotalSongs   uint
+}
+
+// FolderBrowser lists the contents of the folders of the library index, page by page
+type FolderBrowser interface {
+	// BrowseFolder returns the page of the contents of the folder at folderPath selected by query.
+	// Given "." as folderPath, it lists the roots of the music library. It returns ErrFolderNotFound
+	// when the folder is not in the library index.
+	BrowseFolder(ctx context.Context, folderPath string, query FolderQuery) (*FolderPage, error)
+}
+
+// indexedFolderBrowser implements FolderBrowser
+type indexedFolderBrowser struct {
+	index LibraryIndex
+}
+
+// NewFolderBrowser creates a new FolderBrowser that reads the library index
+func NewFolderBrowser(index LibraryIndex) FolderBrowser {
+	return &indexedFolderBrowser{index}
+}
+
+func (i *indexedFolderBrowser) BrowseFolder(ctx context.Context, folderPath string, query FolderQuery) (*FolderPage, error) {
+	folderPath = path.Clean(folderPath)
+	folders, songs, err := i.index.ListFolder(ctx, folderPath)
+	if errors.Is(err, ErrFolderNotFound) && folderPath == "." {
+		// The library has not been scanned yet
+		return &FolderPage{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the %v folder from the library index: %w", folderPath, err)
+	}
+	switch query.Type {
+	case FolderEntriesOfFolders:
+		songs = nil
+	case FolderEntriesOfSongs:
+		folders = nil
+	}
+	folderLess, songLess, err := folderEntriesOrder(query.Sort)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(folders, func(a, b int) bool {
+		if query.Descending {
+			return folderLess(folders[b], folders[a])
+		}
+		return folderLess(folders[a], folders[b])
+	})
+	sort.Slice(songs, func(a, b int) bool {
+		if query.Descending {
+			return songLess(songs[b], songs[a])
+		}
+		return songLess(songs[a], songs[b])
+	})
+
+	page := &FolderPage{TotalFolders: uint(len(folders)), TotalSongs: uint(len(songs))}
+	total := page.TotalFolders + page.TotalSongs
+	start := minimum(query.Offset, total)
+	end := total
+	if query.Limit != 0 {
+		end = minimum(start+query.Limit, total)
+	}
+	page.Folders = folders[minimum(start, page.TotalFolders):minimum(end, page.TotalFolders)]
+	for _, song := range songs[start-minimum(start, page.TotalFolders) : end-minimum(end, page.TotalFolders)] {
+		page.Songs = append(page.Songs, song.Song)
+	}
+	return page, nil
+}
+
+func folderEntriesOrder(folderSort FolderSort) (func(a, b SubFolder) bool, func(a, b IndexedSong) bool, error) {
+	folderByName := func(a, b SubFolder) bool { return NaturalLess(a.Name, b.Name) }
+	songByName := func(a, b IndexedSong) bool { return NaturalLess(path.Base(a.Path), path.Base(b.Path)) }
+	switch folderSort {
+	case "", FolderEntriesByName:
+		return folderByName, songByName, nil
+	case FolderEntriesByModificationTime:
+		folderByTime := func(a, b SubFolder) bool {
+			if a.ModificationTime.Equal(b.ModificationTime) {
+				return folderByName(a, b)
+			}
+			return a.ModificationTime.Before(b.ModificationTime)
+		}
+		songByTime := func(a, b IndexedSong) bool {
+			if a.ModificationTime.Equal(b.ModificationTime) {
+				return songByName(a, b)
+			}
+			return a.ModificationTime.Before(b.ModificationTime)
+		}
+		return folderByTime, songByTime, nil
+	case FolderEntriesByTrack:
+		songByTrack := func(a, b IndexedSong) bool {
+			if a.DiskNumber != b.DiskNumber {
+				return a.DiskNumber < b.DiskNumber
+			}
+			if a.TrackNumber != b.TrackNumber {
+				return a.TrackNumber < b.TrackNumber
+			}
+			return songByName(a, b)
+		}
+		return folderByName, songByTrack, nil
+	default:
+		return nil, nil, fmt.Errorf("could not sort the contents of the folder by %q", folderSort)
+	}
+}
+
+func minimum(a uint, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,156 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestBrowseFolder(t *testing.T) {
+	ctx := context.Background()
+	newIndex := func() *stubLibraryIndex {
+		index := newStubLibraryIndex()
+		index.folders["Nightwish"] = []music.SubFolder{
+			{Name: "Once", Path: "Nightwish/Once", ModificationTime: time.Unix(300, 0)},
+			{Name: "Angels Fall First", Path: "Nightwish/Angels Fall First", ModificationTime: time.Unix(100, 0)},
+		}
+		index.songs["Nightwish"] = []music.IndexedSong{
+			newBrowsedSong("Nightwish/10 - Ghost Love Score.flac", 1, 10, 200),
+			newBrowsedSong("Nightwish/2 - Wish I Had an Angel.flac", 2, 2, 100),
+			newBrowsedSong("Nightwish/04 - Nemo.flac", 1, 4, 400),
+		}
+		return index
+	}
+
+	t.Run("it lists the sub-folders then the songs in natural order", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		page, err := browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, []string{"Angels Fall First", "Once"}, []string{"2 - Wish I Had an Angel", "04 - Nemo", "10 - Ghost Love Score"})
+		if page.TotalFolders != 2 || page.TotalSongs != 3 {
+			t.Errorf("unexpected totals %d and %d", page.TotalFolders, page.TotalSongs)
+		}
+	})
+
+	t.Run("it sorts by modification time or by track, in both orders", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		page, err := browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Sort: music.FolderEntriesByModificationTime, Descending: true})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, []string{"Once", "Angels Fall First"}, []string{"04 - Nemo", "10 - Ghost Love Score", "2 - Wish I Had an Angel"})
+
+		page, err = browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Sort: music.FolderEntriesByTrack})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, []string{"Angels Fall First", "Once"}, []string{"04 - Nemo", "10 - Ghost Love Score", "2 - Wish I Had an Angel"})
+	})
+
+	t.Run("it paginates the sub-folders and songs as a single list", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		page, err := browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Limit: 2, Offset: 1})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, []string{"Once"}, []string{"2 - Wish I Had an Angel"})
+
+		page, err = browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Limit: 2, Offset: 10})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, nil, nil)
+		if page.TotalFolders != 2 || page.TotalSongs != 3 {
+			t.Errorf("unexpected totals %d and %d", page.TotalFolders, page.TotalSongs)
+		}
+	})
+
+	t.Run("it lists only the songs or only the sub-folders", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		page, err := browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Type: music.FolderEntriesOfSongs, Limit: 1})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, nil, []string{"2 - Wish I Had an Angel"})
+		if page.TotalFolders != 0 || page.TotalSongs != 3 {
+			t.Errorf("unexpected totals %d and %d", page.TotalFolders, page.TotalSongs)
+		}
+
+		page, err = browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Type: music.FolderEntriesOfFolders})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, []string{"Angels Fall First", "Once"}, nil)
+	})
+
+	t.Run("given a folder that is not in the index, it returns ErrFolderNotFound", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		_, err := browser.BrowseFolder(ctx, "Epica", music.FolderQuery{})
+		if !errors.Is(err, music.ErrFolderNotFound) {
+			t.Errorf("expected ErrFolderNotFound, got %v", err)
+		}
+	})
+
+	t.Run("when the library has not been scanned yet, the root folder is empty", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newStubLibraryIndex())
+
+		page, err := browser.BrowseFolder(ctx, ".", music.FolderQuery{})
+		tests.AssertNoError(t, err)
+		assertFolderPage(t, page, nil, nil)
+	})
+
+	t.Run("given an unknown sort, it returns an error", func(t *testing.T) {
+		browser := music.NewFolderBrowser(newIndex())
+
+		_, err := browser.BrowseFolder(ctx, "Nightwish", music.FolderQuery{Sort: "size"})
+		tests.AssertError(t, err)
+	})
+}
+
+// newBrowsedSong returns a song whose title is its file name without extension
+func newBrowsedSong(songPath string, disk uint, track uint, modifiedAt int64) music.IndexedSong {
+	name := songPath[len("Nightwish/") : len(songPath)-len(".flac")]
+	return music.IndexedSong{
+		Song:             music.Song{Title: name, DiskNumber: disk, TrackNumber: track},
+		Path:             songPath,
+		ModificationTime: time.Unix(modifiedAt, 0),
+	}
+}
+
+func assertFolderPage(t *testing.T, page *music.FolderPage, wantFolders []string, wantSongs []string) {
+	t.Helper()
+	var folders, songs []string
+	for _, folder := range page.Folders {
+		folders = append(folders, folder.Name)
+	}
+	for _, song := range page.Songs {
+		songs = append(songs, song.Title)
+	}
+	if len(folders) != len(wantFolders) || len(songs) != len(wantSongs) {
+		t.Fatalf("expected the folders %q and the songs %q, got %q and %q", wantFolders, wantSongs, folders, songs)
+	}
+	for index := range wantFolders {
+		if folders[index] != wantFolders[index] {
+			t.Errorf("expected the folders %q, got %q", wantFolders, folders)
+		}
+	}
+	for index := range wantSongs {
+		if songs[index] != wantSongs[index] {
+			t.Errorf("expected the songs %q, got %q", wantSongs, songs)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// AudioFormat describes a format of music files supported by the library. Files are recognised
+// by their extension, case does not matter, and by the magic bytes at the start of their contents.
+// Files whose contents are in a common format that is not audio, such as images, are never songs.
+type AudioFormat struct {
+	Name       string   // Short name of the format. For example "flac"
+	MIMEType   string   // MIME type of the files. For example "audio/flac"
+	Extensions []string // Lowercase extensions of the files, with the dot. For example [".flac"]
+	// matches tells whether the start of a file is in this format
+	matches func(header []byte) bool
+	// readTags reads the tags of a file in this format. It is nil when the tags cannot be read
+	readTags func(file io.ReadSeeker) (*Tags, error)
+}
+
+// audioFormats is the registry of supported formats. When sniffing, formats are tried in this order.
+var audioFormats = []AudioFormat{
+	{Name: "mp3", MIMEType: "audio/mpeg", Extensions: []string{".mp3"}, matches: isMP3Header, readTags: readMP3Tags},
+	{Name: "flac", MIMEType: "audio/flac", Extensions: []string{".flac"}, matches: hasMagic(0, "fLaC"), readTags: readFLACTags},
+	{Name: "opus", MIMEType: "audio/ogg; codecs=opus", Extensions: []string{".opus"}, matches: isOpusHeader, readTags: readOggTags},
+	{Name: "ogg", MIMEType: "audio/ogg", Extensions: []string{".ogg", ".oga"}, matches: isOggHeader, readTags: readOggTags},
+	{Name: "m4a", MIMEType: "audio/mp4", Extensions: []string{".m4a", ".m4b"}, matches: isM4AHeader},
+	{Name: "aac", MIMEType: "audio/aac", Extensions: []string{".aac"}, matches: isADTSHeader},
+	{Name: "wav", MIMEType: "audio/wav", Extensions: []string{".wav"}, matches: isWAVHeader},
+	{Name: "wma", MIMEType: "audio/x-ms-wma", Extensions: []string{".wma"}, matches: hasMagic(0, asfHeaderGUID)},
+	{Name: "ape", MIMEType: "audio/x-ape", Extensions: []string{".ape"}, matches: hasMagic(0, "MAC ")},
+}
+
+// nonAudioFormats match the start of files in common formats that are not audio, such as the cover
+// images, booklets and archives found next to songs
+var nonAudioFormats = []func(header []byte) bool{
+	hasMagic(0, "\x89PNG\r\n\x1a\n"),
+	hasMagic(0, "\xff\xd8\xff"), // JPEG
+	hasMagic(0, "GIF8"),
+	isWebPHeader,
+	hasMagic(0, "%PDF-"),
+	hasMagic(0, "PK\x03\x04"), // ZIP
+	hasMagic(0, "Rar!\x1a\x07"),
+	hasMagic(0, "7z\xbc\xaf\x27\x1c"),
+	hasMagic(0, "\x1f\x8b"), // gzip
+}
+
+// sniffLength is the number of bytes read at the start of a file to recognise its format
+const sniffLength = 64
+
+// asfHeaderGUID starts the ASF container of WMA files
+const asfHeaderGUID = "\x30\x26\xb2\x75\x8e\x66\xcf\x11\xa6\xd9\x00\xaa\x00\x62\xce\x6c"
+
+// AudioFormatOfType returns the supported format with the given MIME type. For example "audio/flac"
+// gives the "flac" format.
+func AudioFormatOfType(mimeType string) (AudioFormat, bool) {
+	for _, format := range audioFormats {
+		if format.MIMEType == mimeType {
+			return format, true
+		}
+	}
+	return AudioFormat{}, false
+}
+
+// audioFormatOfFileName returns the supported format of the given file name's extension, whatever its case
+func audioFormatOfFileName(fileName string) (AudioFormat, bool) {
+	extension := strings.ToLower(path.Ext(fileName))
+	for _, format := range audioFormats {
+		for _, candidate := range format.Extensions {
+			if candidate == extension {
+				return format, true
+			}
+		}
+	}
+	return AudioFormat{}, false
+}
+
+// isFileASong tells whether the file at filePath is a song, see detectAudioFormat. When the file
+// cannot be read, its extension tells.
+func isFileASong(filesystem fs.FS, filePath string) bool {
+	_, hasAudioExtension := audioFormatOfFileName(filePath)
+	file, err := filesystem.Open(filePath)
+	if err != nil {
+		return hasAudioExtension
+	}
+	defer file.Close()
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		return hasAudioExtension
+	}
+	_, ok = detectAudioFormat(seeker, path.Base(filePath))
+	return ok
+}
+
+// detectAudioFormat tells the format of file from its extension and its first bytes, then rewinds it.
+// The contents win over a wrong or unknown extension, for example an Opus song saved as ".ogg" or an
+// MP3 song saved as ".bin". When the contents are not recognised, the extension's format is trusted.
+// It returns false when the contents are in a format that is not audio, for example a cover image
+// saved as ".mp3", and when neither the extension nor the contents are of a supported format.
+func detectAudioFormat(file io.ReadSeeker, fileName string) (AudioFormat, bool) {
+	byExtension, hasAudioExtension := audioFormatOfFileName(fileName)
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, header)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil || (err != nil && err != io.ErrUnexpectedEOF && err != io.EOF) {
+		return byExtension, hasAudioExtension
+	}
+	return sniffAudioFormat(header[:n], byExtension, hasAudioExtension)
+}
+
+// sniffAudioFormat returns the format whose magic bytes start header, preferring byExtension when
+// hasAudioExtension is true
+func sniffAudioFormat(header []byte, byExtension AudioFormat, hasAudioExtension bool) (AudioFormat, bool) {
+	for _, isNonAudio := range nonAudioFormats {
+		if isNonAudio(header) {
+			return AudioFormat{}, false
+		}
+	}
+	// An ID3v2 tag can precede other formats than MP3, it does not tell what follows
+	if hasAudioExtension && (byExtension.matches(header) || isID3v2Header(header)) {
+		return byExtension, true
+	}
+	for _, format := range audioFormats {
+		if format.matches(header) {
+			return format, true
+		}
+	}
+	return byExtension, hasAudioExtension
+}
+
+func hasMagic(offset int, magic string) func([]byte) bool {
+	return func(header []byte) bool {
+		return len(header) >= offset+len(magic) && string(header[offset:offset+len(magic)]) == magic
+	}
+}
+
+// isMP3Header matches an ID3v2 tag or an MPEG audio frame header of layer I, II or III
+func isMP3Header(header []byte) bool {
+	if isID3v2Header(header) {
+		return true
+	}
+	return len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0 && header[1]&0x06 != 0
+}
+
+// isID3v2Header matches the header of an ID3v2 tag: its major version is 2 to 4 and its size is
+// written on 4 bytes of 7 bits. A text file starting with "ID3" does not match.
+func isID3v2Header(header []byte) bool {
+	if !bytes.HasPrefix(header, []byte("ID3")) || len(header) < 10 || header[3] < 2 || header[3] > 4 {
+		return false
+	}
+	return header[6]|header[7]|header[8]|header[9] < 0x80
+}
+
+// isM4AHeader matches MP4 files branded as audio. Other MP4 files can be videos or images.
+func isM4AHeader(header []byte) bool {
+	return hasMagic(4, "ftypM4A ")(header) || hasMagic(4, "ftypM4B ")(header)
+}
+
+// isADTSHeader matches the frame header of raw AAC streams, which looks like MPEG audio with layer 0
+func isADTSHeader(header []byte) bool {
+	return len(header) >= 2 && header[0] == 0xff && header[1]&0xf6 == 0xf0
+}
+
+func isWAVHeader(header []byte) bool {
+	return hasMagic(0, "RIFF")(header) && hasMagic(8, "WAVE")(header)
+}
+
+func isWebPHeader(header []byte) bool {
+	return hasMagic(0, "RIFF")(header) && hasMagic(8, "WEBP")(header)
+}
+
+// oggFirstPacket returns the start of the first packet of an Ogg stream, or nil when header is not Ogg
+func oggFirstPacket(header []byte) []byte {
+	const pageHeaderSize = 27
+	if !hasMagic(0, "OggS")(header) || len(header) < pageHeaderSize {
+		return nil
+	}
+	start := pageHeaderSize + int(header[pageHeaderSize-1])
+	if start > len(header) {
+		return nil
+	}
+	return header[start:]
+}
+
+func isOpusHeader(header []byte) bool {
+	return bytes.HasPrefix(oggFirstPacket(header), []byte("OpusHead"))
+}
+
+// isOggHeader matches Ogg streams other than Opus, such as Vorbis
+func isOggHeader(header []byte) bool {
+	return hasMagic(0, "OggS")(header) && !isOpusHeader(header)
+}
@@ -0,0 +1,121 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestAudioFormatDetection(t *testing.T) {
+	opus := newOgg(t, []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00"), []byte("OpusTags"), 48000)
+	testFS := fstest.MapFS{
+		"Once/GHOST.MP3":       {Data: newID3v23MP3(t)},
+		"Once/nemo.opus":       {Data: opus},
+		"Once/mislabeled.ogg":  {Data: opus},
+		"Once/wish.m4a":        {Data: []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00")},
+		"Once/planet.Wav":      {Data: []byte("RIFF\x24\x00\x00\x00WAVEfmt ")},
+		"Once/siren.aac":       {Data: []byte{0xff, 0xf1, 0x50, 0x80}},
+		"Once/kuolema.wma":     {Data: []byte("\x30\x26\xb2\x75\x8e\x66\xcf\x11\xa6\xd9\x00\xaa\x00\x62\xce\x6c")},
+		"Once/romanticide.ape": {Data: []byte("MAC \x96\x0f")},
+		"Once/empty.flac":      {},
+		"Once/cover.jpg":       {Data: []byte("\xff\xd8\xff")},
+		"Once/notes.txt":       {Data: []byte("ID3")},
+		"Once/ghost.bin":       {Data: newID3v23MP3(t)},
+		"Once/booklet.mp3":     {Data: []byte("%PDF-1.7\n")},
+		"Once/cover.flac":      {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"Once/clip.mp4":        {Data: []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00")},
+	}
+	explorer := music.NewMusicLibraryExplorer(testFS)
+	_, songs, err := explorer.ListContents("Once")
+	tests.AssertNoError(t, err)
+
+	wantTypes := map[string]string{
+		"/music/Once/GHOST.MP3":       "audio/mpeg",
+		"/music/Once/nemo.opus":       "audio/ogg; codecs=opus",
+		"/music/Once/mislabeled.ogg":  "audio/ogg; codecs=opus",
+		"/music/Once/wish.m4a":        "audio/mp4",
+		"/music/Once/planet.Wav":      "audio/wav",
+		"/music/Once/siren.aac":       "audio/aac",
+		"/music/Once/kuolema.wma":     "audio/x-ms-wma",
+		"/music/Once/romanticide.ape": "audio/x-ape",
+		"/music/Once/empty.flac":      "audio/flac",
+		"/music/Once/ghost.bin":       "audio/mpeg",
+	}
+	t.Run(`it lists songs whatever the case of their extension, ignores other files
+		and detects their type from their contents, even when their extension is unknown`, func(t *testing.T) {
+		if len(songs) != len(wantTypes) {
+			t.Fatalf("expected %d songs, got %v", len(wantTypes), songs)
+		}
+		for _, song := range songs {
+			if want, ok := wantTypes[song.URI]; !ok || song.Type != want {
+				t.Errorf("expected song %s to have type %q, got %q", song.URI, want, song.Type)
+			}
+		}
+	})
+
+	t.Run("it reads the tags of songs whose extension is uppercase", func(t *testing.T) {
+		assertSongsContain(t, songs, music.Song{Title: "Ghost Love Score", URI: "/music/Once/GHOST.MP3"})
+	})
+
+	t.Run("it reads the tags of songs whose extension is unknown", func(t *testing.T) {
+		for _, song := range songs {
+			if song.URI == "/music/Once/ghost.bin" && song.Title != "Ghost Love Score" {
+				t.Errorf("expected the title from the tags, got %q", song.Title)
+			}
+		}
+	})
+
+	t.Run("given a format whose tags cannot be read, it returns ErrUnsupportedFormat", func(t *testing.T) {
+		_, err := music.ReadTags(bytes.NewReader(testFS["Once/wish.m4a"].Data), "wish.m4a")
+		if !errors.Is(err, music.ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+
+	t.Run("it opens songs to stream them with their detected type", func(t *testing.T) {
+		song, err := music.OpenSongFile(testFS, "Once/mislabeled.ogg")
+		tests.AssertNoError(t, err)
+		defer song.Close()
+		if song.Type != "audio/ogg; codecs=opus" {
+			t.Errorf("expected the Opus type, got %q", song.Type)
+		}
+	})
+
+	t.Run("given a file that is not audio with the extension of a song, it does not open it to stream it", func(t *testing.T) {
+		_, err := music.OpenSongFile(testFS, "Once/booklet.mp3")
+		if !errors.Is(err, music.ErrNotASong) {
+			t.Errorf("expected ErrNotASong, got %v", err)
+		}
+	})
+}
+
+func TestAudioFormatOfType(t *testing.T) {
+	format, ok := music.AudioFormatOfType("audio/flac")
+	if !ok || format.Name != "flac" {
+		t.Errorf("expected the flac format, got %+v", format)
+	}
+	if _, ok := music.AudioFormatOfType("image/jpeg"); ok {
+		t.Error("expected no format for a MIME type that is not audio")
+	}
+}
@@ -0,0 +1,438 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// See https://id3.org/id3v2.4.0-structure and https://id3.org/id3v2.3.0
+const (
+	id3v2HeaderSize          = 10
+	id3v1TagSize             = 128
+	id3v2FlagUnsynchronised  = 0x80
+	id3v2FlagExtendedHeader  = 0x40
+	id3v24FrameUnsynchronise = 0x02
+	id3v24FrameDataLength    = 0x01
+	id3v24FrameCompressed    = 0x08
+	id3v24FrameEncrypted     = 0x04
+	id3v23FrameCompressed    = 0x80
+	id3v23FrameEncrypted     = 0x40
+)
+
+// id3v2 frame identifiers by major version. ID3v2.2 uses three-character identifiers.
+var (
+	id3v22Frames = map[string]string{"TT2": "title", "TP1": "artist", "TAL": "album", "TCO": "genre", "TRK": "track", "TPA": "disk", "TLE": "length"}
+	id3v23Frames = map[string]string{"TIT2": "title", "TPE1": "artist", "TALB": "album", "TCON": "genre", "TRCK": "track", "TPOS": "disk", "TLEN": "length"}
+)
+
+// readMP3Tags reads the ID3v2 tag at the start of the file, falls back to the ID3v1 tag
+// at the end of the file for missing fields and computes the duration from the MPEG frames.
+func readMP3Tags(file io.ReadSeeker) (*Tags, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek to the end of the file: %w", err)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to the start of the file: %w", err)
+	}
+
+	tags := &Tags{}
+	audioStart, err := readID3v2(file, tags)
+	if err != nil {
+		return nil, err
+	}
+	audioEnd := size
+	v1Tags, err := readID3v1(file, size)
+	if err != nil {
+		return nil, err
+	}
+	if v1Tags != nil {
+		tags.mergeMissing(v1Tags)
+		audioEnd -= id3v1TagSize
+	}
+	if duration, err := readMPEGDuration(file, audioStart, audioEnd); err == nil && duration != 0 {
+		tags.Duration = duration
+	}
+	if tags.Title == "" && tags.Artist == "" && tags.Album == "" && tags.Duration == 0 {
+		return nil, errors.New("could not find any ID3 tag or MPEG frame")
+	}
+	return tags, nil
+}
+
+// readID3v2 reads the ID3v2 tag at the start of the file into tags. It returns the offset
+// of the first byte after the tag (zero when there is no ID3v2 tag).
+func readID3v2(file io.ReadSeeker, tags *Tags) (int64, error) {
+	header := make([]byte, id3v2HeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[0:3]) != "ID3" {
+		return 0, nil
+	}
+	majorVersion := header[3]
+	flags := header[5]
+	tagSize := int64(synchsafeInt(header[6:10]))
+	tagEnd := id3v2HeaderSize + tagSize
+	if majorVersion < 2 || majorVersion > 4 {
+		return tagEnd, nil
+	}
+
+	remaining, err := remainingBytes(file)
+	if err != nil {
+		return 0, err
+	}
+	if tagSize > remaining {
+		// Do not allocate a buffer for a corrupted size
+		return 0, fmt.Errorf("the ID3v2 tag of %d bytes is larger than the rest of the file, %d bytes", tagSize, remaining)
+	}
+	data, err := readFull(file, int(tagSize))
+	if err != nil {
+		return 0, fmt.Errorf("could not read the ID3v2 tag: %w", err)
+	}
+	if flags&id3v2FlagUnsynchronised != 0 && majorVersion < 4 {
+		data = removeUnsynchronisation(data)
+	}
+	if flags&id3v2FlagExtendedHeader != 0 && majorVersion > 2 {
+		data = skipID3v2ExtendedHeader(data, majorVersion)
+	}
+
+	var lengthInMilliseconds uint
+	for len(data) > 0 {
+		id, frameData, rest, ok := nextID3v2Frame(data, majorVersion)
+		if !ok {
+			break
+		}
+		data = rest
+		if id == "APIC" || (id == "PIC" && majorVersion == 2) {
+			if picture, ok := parseID3v2Picture(frameData, majorVersion); ok {
+				tags.Picture = preferredPicture(tags.Picture, picture)
+			}
+			continue
+		}
+		field, isKnown := id3v2FieldFor(id, majorVersion)
+		if !isKnown || len(frameData) == 0 {
+			continue
+		}
+		value := decodeID3v2Text(frameData)
+		switch field {
+		case "title":
+			tags.Title = value
+		case "artist":
+			tags.Artist = value
+		case "album":
+			tags.Album = value
+		case "genre":
+			tags.Genre = parseID3Genre(value)
+		case "track":
+			tags.TrackNumber = parsePositionNumber(value)
+		case "disk":
+			tags.DiskNumber = parsePositionNumber(value)
+		case "length":
+			lengthInMilliseconds = parsePositionNumber(value)
+		}
+	}
+	tags.Duration = lengthInMilliseconds / 1000
+	return tagEnd, nil
+}
+
+func id3v2FieldFor(id string, majorVersion byte) (string, bool) {
+	if majorVersion == 2 {
+		field, ok := id3v22Frames[id]
+		return field, ok
+	}
+	field, ok := id3v23Frames[id]
+	return field, ok
+}
+
+// nextID3v2Frame splits the next frame out of data. It returns false when it reaches padding
+// or when the frame is malformed.
+func nextID3v2Frame(data []byte, majorVersion byte) (id string, frameData []byte, rest []byte, ok bool) {
+	var headerSize, frameSize int
+	var formatFlags byte
+	if majorVersion == 2 {
+		headerSize = 6
+		if len(data) < headerSize {
+			return "", nil, nil, false
+		}
+		id = string(data[0:3])
+		frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+	} else {
+		headerSize = 10
+		if len(data) < headerSize {
+			return "", nil, nil, false
+		}
+		id = string(data[0:4])
+		if majorVersion == 4 {
+			frameSize = int(synchsafeInt(data[4:8]))
+		} else {
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+		}
+		formatFlags = data[9]
+	}
+	if id[0] == 0 || frameSize <= 0 || headerSize+frameSize > len(data) {
+		return "", nil, nil, false
+	}
+	frameData = data[headerSize : headerSize+frameSize]
+	rest = data[headerSize+frameSize:]
+
+	switch majorVersion {
+	case 3:
+		if formatFlags&(id3v23FrameCompressed|id3v23FrameEncrypted) != 0 {
+			return id, nil, rest, true
+		}
+	case 4:
+		if formatFlags&(id3v24FrameCompressed|id3v24FrameEncrypted) != 0 {
+			return id, nil, rest, true
+		}
+		if formatFlags&id3v24FrameDataLength != 0 && len(frameData) >= 4 {
+			frameData = frameData[4:]
+		}
+		if formatFlags&id3v24FrameUnsynchronise != 0 {
+			frameData = removeUnsynchronisation(frameData)
+		}
+	}
+	return id, frameData, rest, true
+}
+
+// parseID3v2Picture parses an APIC frame or, in ID3v2.2, a PIC frame which has a three-character
+// image format instead of a MIME type.
+func parseID3v2Picture(frameData []byte, majorVersion byte) (*Picture, bool) {
+	if len(frameData) < 2 {
+		return nil, false
+	}
+	encoding, data := frameData[0], frameData[1:]
+	var mimeType string
+	if majorVersion == 2 {
+		if len(data) < 3 {
+			return nil, false
+		}
+		mimeType = imageMIMETypeForFormat(string(data[0:3]))
+		data = data[3:]
+	} else {
+		end := bytes.IndexByte(data, 0)
+		if end == -1 {
+			return nil, false
+		}
+		mimeType = strings.ToLower(string(data[:end]))
+		data = data[end+1:]
+	}
+	if len(data) < 1 {
+		return nil, false
+	}
+	pictureType := data[0]
+	data = skipID3v2Description(data[1:], encoding)
+	if len(data) == 0 {
+		return nil, false
+	}
+	return &Picture{MIMEType: normalizeImageMIMEType(mimeType, data), Data: data, isFrontCover: pictureType == frontCoverPictureType}, true
+}
+
+// skipID3v2Description skips the null-terminated description. UTF-16 descriptions end with two null bytes.
+func skipID3v2Description(data []byte, encoding byte) []byte {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[i+2:]
+			}
+		}
+		return nil
+	}
+	end := bytes.IndexByte(data, 0)
+	if end == -1 {
+		return nil
+	}
+	return data[end+1:]
+}
+
+func skipID3v2ExtendedHeader(data []byte, majorVersion byte) []byte {
+	if len(data) < 4 {
+		return data
+	}
+	var size int
+	if majorVersion == 4 {
+		// In ID3v2.4, the extended header size includes the size bytes
+		size = int(synchsafeInt(data[0:4]))
+	} else {
+		size = int(binary.BigEndian.Uint32(data[0:4])) + 4
+	}
+	if size > len(data) {
+		return nil
+	}
+	return data[size:]
+}
+
+// decodeID3v2Text decodes a text frame. The first byte gives the encoding.
+// ID3v2.4 allows several values separated by a null character, we only keep the first one.
+func decodeID3v2Text(frameData []byte) string {
+	encoding, text := frameData[0], frameData[1:]
+	var decoded string
+	switch encoding {
+	case 0: // ISO-8859-1
+		decoded = decodeLatin1(text)
+	case 1: // UTF-16 with BOM
+		decoded = decodeUTF16(text, true)
+	case 2: // UTF-16BE without BOM
+		decoded = decodeUTF16(text, false)
+	case 3: // UTF-8
+		decoded = string(text)
+	default:
+		return ""
+	}
+	if index := strings.IndexRune(decoded, 0); index != -1 {
+		decoded = decoded[:index]
+	}
+	return strings.TrimSpace(decoded)
+}
+
+func decodeLatin1(text []byte) string {
+	runes := make([]rune, len(text))
+	for i, b := range text {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func decodeUTF16(text []byte, hasBOM bool) string {
+	var order binary.ByteOrder = binary.BigEndian
+	if hasBOM && len(text) >= 2 {
+		if text[0] == 0xFF && text[1] == 0xFE {
+			order = binary.LittleEndian
+		}
+		text = text[2:]
+	}
+	units := make([]uint16, 0, len(text)/2)
+	for i := 0; i+1 < len(text); i += 2 {
+		units = append(units, order.Uint16(text[i:i+2]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// removeUnsynchronisation replaces every 0xFF 0x00 sequence by 0xFF
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// synchsafeInt decodes a 28-bit integer stored in four bytes whose most significant bit is always zero
+func synchsafeInt(data []byte) uint32 {
+	return uint32(data[0]&0x7F)<<21 | uint32(data[1]&0x7F)<<14 | uint32(data[2]&0x7F)<<7 | uint32(data[3]&0x7F)
+}
+
+// readID3v1 reads the ID3v1 tag in the last 128 bytes of the file.
+// It returns nil tags when there is no ID3v1 tag.
+func readID3v1(file io.ReadSeeker, size int64) (*Tags, error) {
+	if size < id3v1TagSize {
+		return nil, nil
+	}
+	if _, err := file.Seek(size-id3v1TagSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to the ID3v1 tag: %w", err)
+	}
+	data, err := readFull(file, id3v1TagSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the ID3v1 tag: %w", err)
+	}
+	if string(data[0:3]) != "TAG" {
+		return nil, nil
+	}
+	tags := &Tags{
+		Title:  decodeID3v1Text(data[3:33]),
+		Artist: decodeID3v1Text(data[33:63]),
+		Album:  decodeID3v1Text(data[63:93]),
+		Genre:  id3v1GenreName(data[127]),
+	}
+	// ID3v1.1 stores the track number in the last byte of the comment, preceded by a null byte
+	if data[125] == 0 && data[126] != 0 {
+		tags.TrackNumber = uint(data[126])
+	}
+	return tags, nil
+}
+
+func decodeID3v1Text(text []byte) string {
+	if index := bytes.IndexByte(text, 0); index != -1 {
+		text = text[:index]
+	}
+	return strings.TrimSpace(decodeLatin1(text))
+}
+
+// id3v1Genres are the genres of ID3v1 tags and of the Winamp extension, by number.
+// ID3v2 genre frames can refer to them too.
+var id3v1Genres = [...]string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz", "Metal",
+	"New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno", "Industrial",
+	"Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk",
+	"Fusion", "Trance", "Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic",
+	"Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult", "Gangsta",
+	"Top 40", "Christian Rap", "Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes",
+	"Trailer", "Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival", "Celtic", "Bluegrass",
+	"Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock", "Big Band", "Chorus", "Easy Listening", "Acoustic",
+	"Humour", "Speech", "Chanson", "Opera", "Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove",
+	"Satire", "Slow Jam", "Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall",
+}
+
+// id3v1GenreName returns the name of the genre numbered number. It returns an empty string for unknown numbers,
+// 255 means that the tag has no genre.
+func id3v1GenreName(number byte) string {
+	if int(number) < len(id3v1Genres) {
+		return id3v1Genres[number]
+	}
+	return ""
+}
+
+// parseID3Genre reads the value of a genre frame. It can be a name ("Metal"), a genre number ("9"),
+// references to genre numbers in parentheses followed by an optional refinement ("(9)" or "(9)Symphonic Metal")
+// or the "(RX)" (remix) and "(CR)" (cover) keywords. Refinements are preferred to the referenced genres.
+func parseID3Genre(value string) string {
+	value = strings.TrimSpace(value)
+	if number, err := strconv.ParseUint(value, 10, 8); err == nil {
+		return id3v1GenreName(byte(number))
+	}
+	var referenced string
+	// "((" escapes a name starting with a parenthesis
+	for strings.HasPrefix(value, "(") && !strings.HasPrefix(value, "((") {
+		end := strings.Index(value, ")")
+		if end == -1 {
+			break
+		}
+		reference := value[1:end]
+		value = value[end+1:]
+		if referenced != "" {
+			continue
+		}
+		switch reference {
+		case "RX":
+			referenced = "Remix"
+		case "CR":
+			referenced = "Cover"
+		default:
+			if number, err := strconv.ParseUint(reference, 10, 8); err == nil {
+				referenced = id3v1GenreName(byte(number))
+			}
+		}
+	}
+	if refinement := strings.TrimSpace(strings.TrimPrefix(value, "(")); refinement != "" {
+		return refinement
+	}
+	return referenced
+}
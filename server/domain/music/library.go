@@ -0,0 +1,123 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+var (
+	// ErrFolderNotFound is returned when a folder is not in the library index
+	ErrFolderNotFound = errors.New("folder not found in the library index")
+	// ErrSongNotFound is returned when a song is not in the library index
+	ErrSongNotFound = errors.New("song not found in the library index")
+)
+
+// IndexedSong represents a Song stored in the library index along with what is needed
+// to tell whether its file changed since it was last scanned.
+type IndexedSong struct {
+	Song
+	Path             string    // Path from the root music folder. For example "Nightwish/Once/01 - Dark Chest of Wonders.flac"
+	ModificationTime time.Time // Modification time of the file when it was last scanned
+	Size             int64     // Size in bytes of the file when it was last scanned
+	HasPicture       bool      // Whether the tags of the file embed a picture, which is then the song's cover art
+}
+
+// FolderPath returns the path of the folder containing the song. For example "Nightwish/Once"
+// It returns "." for songs at the root of the music library.
+func (i *IndexedSong) FolderPath() string {
+	return path.Dir(i.Path)
+}
+
+// SongStore retrieves songs from the library index
+type SongStore interface {
+	// GetSong returns the song identified by songID. It returns ErrSongNotFound when there is no such song.
+	GetSong(ctx context.Context, songID uint) (*IndexedSong, error)
+}
+
+// LibraryIndex stores the folders, songs, covers and playlist files of the music library so that they can be
+// listed and queried without reading the filesystem.
+type LibraryIndex interface {
+	SongStore
+	// BeginScan records the start of a new scan and returns its identifier
+	BeginScan(ctx context.Context) (int64, error)
+	// SaveCover saves the cover image file of a folder and returns its identifier.
+	// Existing covers keep their identifiers.
+	SaveCover(ctx context.Context, scanID int64, cover Cover) (uint, error)
+	// SaveFolder saves the folder and its songs. Existing folders and songs keep their identifiers.
+	// Songs with a picture get it as cover art, other songs get the folder's cover.
+	// A folder without cover gets the cover of its first song with a picture.
+	SaveFolder(ctx context.Context, scanID int64, folder SubFolder, songs []IndexedSong) error
+	// SavePlaylistFile saves the playlist file and the paths of its songs. Existing playlist files keep their identifiers.
+	// The folder containing the playlist file must have been saved first.
+	SavePlaylistFile(ctx context.Context, scanID int64, playlist PlaylistFile) error
+	// KeepFolder keeps the folder at folderPath and its sub-folders, songs, covers and playlist files as they are
+	// in the index, as if they had been saved during the scan. It is used for the folders that cannot be read.
+	KeepFolder(ctx context.Context, scanID int64, folderPath string) error
+	// EndScan removes the folders, songs, covers and playlist files that were not saved during the scan
+	EndScan(ctx context.Context, scanID int64) error
+	// EndFolderScan works like EndScan for a scan of the folder at folderPath and its sub-folders only.
+	// The rest of the index is left untouched.
+	EndFolderScan(ctx context.Context, scanID int64, folderPath string) error
+	// ListFolder returns the sub-folders and songs of the folder at folderPath.
+	// It returns ErrFolderNotFound when the folder is not in the index.
+	ListFolder(ctx context.Context, folderPath string) ([]SubFolder, []IndexedSong, error)
+}
+
+// LibraryStatistics summarizes the contents of the library index
+type LibraryStatistics struct {
+	Folders   uint
+	Songs     uint
+	Covers    uint
+	Playlists uint      // Number of playlist files
+	Duration  uint      // Total duration of the songs in seconds
+	Size      int64     // Total size of the song files in bytes
+	LastScan  time.Time // End of the last finished scan. It is the zero Time when no scan has finished yet.
+}
+
+// indexedMusicLibraryExplorer implements MusicLibraryExplorer by reading the library index
+type indexedMusicLibraryExplorer struct {
+	index LibraryIndex
+}
+
+// NewIndexedMusicLibraryExplorer creates a new MusicLibraryExplorer that reads the library index
+// instead of the filesystem.
+func NewIndexedMusicLibraryExplorer(index LibraryIndex) MusicLibraryExplorer {
+	return &indexedMusicLibraryExplorer{index}
+}
+
+func (i *indexedMusicLibraryExplorer) ListContents(folderPath string) ([]SubFolder, []Song, error) {
+	folderPath = path.Clean(folderPath)
+	subFolders, indexedSongs, err := i.index.ListFolder(context.Background(), folderPath)
+	if errors.Is(err, ErrFolderNotFound) && folderPath == "." {
+		// The library has not been scanned yet
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read the %v folder from the library index: %w", folderPath, err)
+	}
+	songs := make([]Song, 0, len(indexedSongs))
+	for _, indexedSong := range indexedSongs {
+		songs = append(songs, indexedSong.Song)
+	}
+	return subFolders, songs, nil
+}
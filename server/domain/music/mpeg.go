@@ -0,0 +1,171 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// See http://www.mp3-tech.org/programmer/frame_header.html
+const (
+	mpegVersion25 = 0
+	mpegVersion2  = 2
+	mpegVersion1  = 3
+	mpegLayer3    = 1
+	mpegLayer2    = 2
+	mpegLayer1    = 3
+	mpegMono      = 3
+	// We stop looking for the first MPEG frame after this many bytes
+	mpegSearchWindow = 64 * 1024
+)
+
+var (
+	mpeg1Bitrates = [4][16]uint{
+		mpegLayer1: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		mpegLayer2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		mpegLayer3: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	}
+	mpeg2Bitrates = [4][16]uint{
+		mpegLayer1: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		mpegLayer2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		mpegLayer3: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mpegSampleRates = [4][3]uint{
+		mpegVersion1:  {44100, 48000, 32000},
+		mpegVersion2:  {22050, 24000, 16000},
+		mpegVersion25: {11025, 12000, 8000},
+	}
+)
+
+// mpegFrameHeader represents the 4-byte header found at the start of each MPEG audio frame
+type mpegFrameHeader struct {
+	version    byte
+	layer      byte
+	bitrate    uint // in kilobits per second
+	sampleRate uint // in Hertz
+	isMono     bool
+}
+
+func parseMPEGFrameHeader(data []byte) (*mpegFrameHeader, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+	version := (data[1] >> 3) & 0x03
+	layer := (data[1] >> 1) & 0x03
+	bitrateIndex := data[2] >> 4
+	sampleRateIndex := (data[2] >> 2) & 0x03
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 0x0F || sampleRateIndex == 3 {
+		return nil, false
+	}
+	bitrate := mpeg2Bitrates[layer][bitrateIndex]
+	if version == mpegVersion1 {
+		bitrate = mpeg1Bitrates[layer][bitrateIndex]
+	}
+	return &mpegFrameHeader{
+		version:    version,
+		layer:      layer,
+		bitrate:    bitrate,
+		sampleRate: mpegSampleRates[version][sampleRateIndex],
+		isMono:     data[3]>>6 == mpegMono,
+	}, true
+}
+
+func (h *mpegFrameHeader) samplesPerFrame() uint {
+	switch {
+	case h.layer == mpegLayer1:
+		return 384
+	case h.layer == mpegLayer3 && h.version != mpegVersion1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// sideInformationSize returns the size of the Layer III side information that follows the header.
+// Xing headers are written right after it.
+func (h *mpegFrameHeader) sideInformationSize() int {
+	if h.version == mpegVersion1 {
+		if h.isMono {
+			return 17
+		}
+		return 32
+	}
+	if h.isMono {
+		return 9
+	}
+	return 17
+}
+
+// readMPEGDuration finds the first MPEG frame between audioStart and audioEnd and computes
+// the duration in seconds. It uses the frame count from a Xing or VBRI header when there is one
+// (variable bitrate files), otherwise it assumes a constant bitrate.
+func readMPEGDuration(file io.ReadSeeker, audioStart int64, audioEnd int64) (uint, error) {
+	if _, err := file.Seek(audioStart, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not seek to the MPEG audio frames: %w", err)
+	}
+	windowSize := audioEnd - audioStart
+	if windowSize > mpegSearchWindow {
+		windowSize = mpegSearchWindow
+	}
+	if windowSize <= 0 {
+		return 0, errors.New("there is no MPEG audio frame")
+	}
+	window, err := readFull(file, int(windowSize))
+	if err != nil {
+		return 0, fmt.Errorf("could not read the MPEG audio frames: %w", err)
+	}
+
+	for offset := 0; offset+4 <= len(window); offset++ {
+		header, ok := parseMPEGFrameHeader(window[offset:])
+		if !ok {
+			continue
+		}
+		frame := window[offset:]
+		if frameCount := readVBRFrameCount(frame, header); frameCount != 0 {
+			return uint(uint64(frameCount) * uint64(header.samplesPerFrame()) / uint64(header.sampleRate)), nil
+		}
+		audioBytes := audioEnd - audioStart - int64(offset)
+		return uint(audioBytes * 8 / int64(header.bitrate*1000)), nil
+	}
+	return 0, errors.New("could not find an MPEG audio frame")
+}
+
+// readVBRFrameCount reads the number of frames from a Xing/Info or VBRI header in the first frame.
+// It returns zero when there is no such header.
+func readVBRFrameCount(frame []byte, header *mpegFrameHeader) uint32 {
+	const xingFramesFlag = 0x01
+	xingOffset := 4 + header.sideInformationSize()
+	if len(frame) >= xingOffset+12 {
+		tag := string(frame[xingOffset : xingOffset+4])
+		if tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(frame[xingOffset+4 : xingOffset+8])
+			if flags&xingFramesFlag != 0 {
+				return binary.BigEndian.Uint32(frame[xingOffset+8 : xingOffset+12])
+			}
+			return 0
+		}
+	}
+	const vbriOffset = 4 + 32
+	if len(frame) >= vbriOffset+18 && string(frame[vbriOffset:vbriOffset+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[vbriOffset+14 : vbriOffset+18])
+	}
+	return 0
+}
@@ -19,25 +19,41 @@ package music
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"path"
-	"strings"
+	"time"
 )
 
-const MusicPath = "/music" // It is a volume in the Docker image
+// MusicPath prefixes the URIs of the music files. It is also the default path of the music library,
+// which is a volume in the Docker image.
+const MusicPath = "/music"
 
 // SubFolder represents a folder that is either top-level or a child of a top-level
 // music folder. A SubFolder can have zero or many children SubFolders.
 type SubFolder struct {
 	Name string // Basename of the folder. For example "Dark Passion Play"
 	Path string // Absolute path to the folder. For example "/music/Symphonic Metal/Nightwish/Dark Passion Play/"
+	// Identifier of the folder's cover art in the library index. It is zero when the folder has no cover art
+	CoverID uint
+	// Modification time of the folder when it was last scanned. It is the zero Time when it is unknown
+	ModificationTime time.Time
 }
 
-// Song represents a music file. It is distinguished by media type (audio/mp3, audio/flac, etc.)
+// Song represents a music file. It is distinguished by media type (audio/mpeg, audio/flac, etc.), see AudioFormat.
 // Most of its fields mirror tags such as ID3 tags for MP3.
 type Song struct {
-	Title string // Title of the song
-	URI   string // URI to play the song. For example "/music/Symphonic Metal/Nightwish/Dark Passion Play/7 Days to the Wolves.ogg"
+	ID          uint   // Identifier of the song in the library index. It is zero when the library is not indexed
+	Title       string // Title of the song. Defaults to the file name when the song has no title tag
+	TrackNumber uint   // Track number of the song in its disk. For example 3
+	DiskNumber  uint   // Disk number of the song. For example 1
+	Artist      string // Name of the main artist. For example "Nightwish"
+	Album       string // Name of the album. For example "Dark Passion Play"
+	Genre       string // Genre of the song. For example "Symphonic Metal"
+	Duration    uint   // Duration of the song in seconds. For example 423
+	URI         string // URI to play the song. For example "/music/Symphonic Metal/Nightwish/Dark Passion Play/7 Days to the Wolves.ogg"
+	Type        string // MIME type of the song. For example "audio/ogg"
+	CoverID     uint   // Identifier of the song's cover art in the library index. It is zero when the song has no cover art
 }
 
 // MusicLibraryExplorer allows to explore the contents of the music library folders. It needs a MusicLibraryFileSystem.
@@ -71,23 +87,58 @@ func (b *baseMusicLibraryExplorer) ListContents(folderPath string) ([]SubFolder,
 		filePath := path.Join(folderPath, entry.Name())
 		if entry.IsDir() {
 			subFolders = append(subFolders, SubFolder{Name: entry.Name(), Path: filePath})
-		} else if isFileASong(entry) {
-			songURI := path.Join(MusicPath, filePath)
-			songs = append(songs, Song{Title: entry.Name(), URI: songURI})
+		} else if isFileASong(b.filesystem, filePath) {
+			songs = append(songs, readSong(b.filesystem, filePath))
 		}
 	}
 	return subFolders, songs, nil
 }
 
-var supportedExtensions = [3]string{".mp3", ".flac", ".ogg"}
+// readSong builds a Song from the tags of the file at filePath. When the tags cannot be read,
+// the song is still listed with its file name as title.
+func readSong(filesystem fs.FS, filePath string) Song {
+	song, _ := readSongAndPicture(filesystem, filePath)
+	return song
+}
 
-func isFileASong(entry fs.DirEntry) bool {
-	for _, extension := range supportedExtensions {
-		if strings.HasSuffix(entry.Name(), extension) {
-			return true
-		}
+// readSongAndPicture works like readSong and also tells whether the song's tags embed a picture
+func readSongAndPicture(filesystem fs.FS, filePath string) (Song, bool) {
+	fileName := path.Base(filePath)
+	song := Song{
+		Title: fileName,
+		URI:   path.Join(MusicPath, filePath),
+	}
+	if format, ok := audioFormatOfFileName(fileName); ok {
+		song.Type = format.MIMEType
+	}
+	file, err := filesystem.Open(filePath)
+	if err != nil {
+		return song, false
+	}
+	defer file.Close()
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		return song, false
+	}
+	format, ok := detectAudioFormat(seeker, fileName)
+	if !ok {
+		return song, false
+	}
+	song.Type = format.MIMEType
+	tags, err := readTagsOfFormat(seeker, fileName, format)
+	if err != nil {
+		return song, false
+	}
+	if tags.Title != "" {
+		song.Title = tags.Title
 	}
-	return false
+	song.TrackNumber = tags.TrackNumber
+	song.DiskNumber = tags.DiskNumber
+	song.Artist = tags.Artist
+	song.Album = tags.Album
+	song.Genre = tags.Genre
+	song.Duration = tags.Duration
+	return song, tags.Picture != nil
 }
 
 // NewMusicLibraryExplorer creates a new MusicLibraryExplorer
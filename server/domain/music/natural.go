@@ -0,0 +1,80 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NaturalLess tells whether a sorts before b in natural order: letters are compared regardless of case and
+// runs of digits are compared by their value, so that "track 2.flac" sorts before "Track 10.flac".
+// Names that only differ by case or by leading zeros are ordered by their bytes, so that the order is total.
+func NaturalLess(a string, b string) bool {
+	if comparison := naturalCompare(a, b); comparison != 0 {
+		return comparison < 0
+	}
+	return a < b
+}
+
+func naturalCompare(a string, b string) int {
+	for a != "" && b != "" {
+		if isASCIIDigit(a[0]) && isASCIIDigit(b[0]) {
+			var numberA, numberB string
+			numberA, a = splitLeadingDigits(a)
+			numberB, b = splitLeadingDigits(b)
+			if comparison := compareNumbers(numberA, numberB); comparison != 0 {
+				return comparison
+			}
+			continue
+		}
+		runeA, sizeA := utf8.DecodeRuneInString(a)
+		runeB, sizeB := utf8.DecodeRuneInString(b)
+		if lowerA, lowerB := unicode.ToLower(runeA), unicode.ToLower(runeB); lowerA != lowerB {
+			if lowerA < lowerB {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) - len(b)
+}
+
+// compareNumbers compares two runs of digits of any length by their value
+func compareNumbers(a string, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func splitLeadingDigits(text string) (string, string) {
+	end := 0
+	for end < len(text) && isASCIIDigit(text[end]) {
+		end++
+	}
+	return text[:end], text[end:]
+}
+
+func isASCIIDigit(character byte) bool {
+	return '0' <= character && character <= '9'
+}
@@ -0,0 +1,56 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestNaturalLess(t *testing.T) {
+	t.Run("it orders numbers by value and letters regardless of case", func(t *testing.T) {
+		names := []string{"Track 10.flac", "track 2.flac", "Track 1.flac", "bonus.flac", "Album 2", "album 10", "Album 2b", "CD1"}
+		sort.Slice(names, func(i, j int) bool { return music.NaturalLess(names[i], names[j]) })
+
+		want := []string{"Album 2", "Album 2b", "album 10", "bonus.flac", "CD1", "Track 1.flac", "track 2.flac", "Track 10.flac"}
+		for index := range want {
+			if names[index] != want[index] {
+				t.Fatalf("expected %q, got %q", want, names)
+			}
+		}
+	})
+
+	t.Run("it orders names that only differ by case or leading zeros", func(t *testing.T) {
+		for _, pair := range [][2]string{{"01 Nemo", "1 Nemo"}, {"NEMO", "nemo"}, {"Nemo", "Nemo 2"}, {"99", "100"}} {
+			if !music.NaturalLess(pair[0], pair[1]) || music.NaturalLess(pair[1], pair[0]) {
+				t.Errorf("expected %q to sort before %q", pair[0], pair[1])
+			}
+		}
+		if music.NaturalLess("Ōkami", "Ōkami") {
+			t.Errorf("expected a name not to sort before itself")
+		}
+	})
+
+	t.Run("it compares numbers longer than integers", func(t *testing.T) {
+		if !music.NaturalLess("123456789012345678901234567890", "123456789012345678901234567891") {
+			t.Errorf("expected long numbers to be compared by value")
+		}
+	})
+}
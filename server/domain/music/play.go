@@ -0,0 +1,62 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"time"
+)
+
+// PlayThreshold is the share of a song that must be streamed for it to count as played
+const PlayThreshold = 0.5
+
+// Play represents a song listened to by a user. When the song is no longer in the library, Song only has
+// the title, artist and album it had when it was played: its ID is 0 and its URI is empty.
+type Play struct {
+	ID       uint // ID is the play's identifier. For example 42
+	Song     Song
+	PlayedAt time.Time // When the user started listening to the song
+}
+
+// SongPlayCount represents how many times a user listened to a song. Songs that are no longer in the
+// library are counted like in Play, by their path.
+type SongPlayCount struct {
+	Song         Song
+	PlayCount    uint
+	LastPlayedAt time.Time
+}
+
+// PlayStore saves the songs users listen to. Every method takes the identifier of the listening user,
+// users only see their own plays.
+type PlayStore interface {
+	// RecordPlay saves that the user listened to the song. It returns ErrSongNotFound when the song
+	// is not in the library index.
+	RecordPlay(ctx context.Context, userID uint, songID uint, playedAt time.Time) (*Play, error)
+	// RecordPlayOfPath works like RecordPlay for the song at songPath in the music library
+	RecordPlayOfPath(ctx context.Context, userID uint, songPath string, playedAt time.Time) error
+	// ListRecentPlays returns the last plays of the user, most recent first, including the plays of
+	// songs that are no longer in the library
+	ListRecentPlays(ctx context.Context, userID uint, limit uint) ([]Play, error)
+	// ListPlayCounts returns the songs the user listened to, most played first
+	ListPlayCounts(ctx context.Context, userID uint, limit uint) ([]SongPlayCount, error)
+}
+
+// IsPlayed returns true when enough of a song of size bytes was streamed for it to count as played
+func IsPlayed(streamed int64, size int64) bool {
+	return size > 0 && float64(streamed) >= PlayThreshold*float64(size)
+}
@@ -0,0 +1,40 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestIsPlayed(t *testing.T) {
+	for _, c := range []struct {
+		streamed, size int64
+		want           bool
+	}{
+		{streamed: 50, size: 100, want: true},
+		{streamed: 100, size: 100, want: true},
+		{streamed: 49, size: 100, want: false},
+		{streamed: 0, size: 0, want: false},
+	} {
+		if got := music.IsPlayed(c.streamed, c.size); got != c.want {
+			t.Errorf("IsPlayed(%d, %d) = %v, want %v", c.streamed, c.size, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrPlaylistNotFound is returned when the playlist does not exist or belongs to another user
+	ErrPlaylistNotFound = errors.New("the playlist could not be found")
+	// ErrPlaylistEntryNotFound is returned when the entry is not part of the playlist
+	ErrPlaylistEntryNotFound = errors.New("the playlist entry could not be found")
+	// ErrStalePlaylistOrder is returned when reordering a playlist with entries that do not match
+	// its current entries, because it has been edited in the meantime.
+	ErrStalePlaylistOrder = errors.New("the new order does not match the current entries of the playlist")
+)
+
+// PlaylistSummary represents a playlist without its songs
+type PlaylistSummary struct {
+	ID        uint   // ID is the playlist's identifier. For example 12
+	Name      string // Name of the playlist. For example "Road trip"
+	SongCount uint   // Number of songs in the playlist
+}
+
+// Playlist represents an ordered list of songs owned by a user
+type Playlist struct {
+	PlaylistSummary
+	Entries []PlaylistEntry // Entries of the playlist, in order
+}
+
+// PlaylistEntry represents a song in a playlist. The same song can be added many times to a playlist,
+// each entry has its own identifier.
+type PlaylistEntry struct {
+	ID   uint // ID is the entry's identifier. It does not change when the playlist is reordered.
+	Song Song
+}
+
+// PlaylistStore saves the playlists of users. Every method takes the identifier of the owner of the playlist
+// and returns ErrPlaylistNotFound when the playlist belongs to someone else.
+type PlaylistStore interface {
+	ListPlaylists(ctx context.Context, ownerID uint) ([]PlaylistSummary, error)
+	CreatePlaylist(ctx context.Context, ownerID uint, name string) (*PlaylistSummary, error)
+	GetPlaylist(ctx context.Context, ownerID uint, playlistID uint) (*Playlist, error)
+	RenamePlaylist(ctx context.Context, ownerID uint, playlistID uint, name string) error
+	// AppendSongs adds the songs at the end of the playlist. It returns ErrSongNotFound when one of
+	// the songs is not in the library index, in which case none of them is added.
+	AppendSongs(ctx context.Context, ownerID uint, playlistID uint, songIDs []uint) error
+	// RemoveEntry removes the entry from the playlist. It returns ErrPlaylistEntryNotFound when the
+	// entry is not part of the playlist.
+	RemoveEntry(ctx context.Context, ownerID uint, playlistID uint, entryID uint) error
+	// ReorderEntries orders the playlist entries following entryIDs. entryIDs must list every entry of
+	// the playlist exactly once, otherwise it returns ErrStalePlaylistOrder and leaves the order unchanged.
+	ReorderEntries(ctx context.Context, ownerID uint, playlistID uint, entryIDs []uint) error
+	DeletePlaylist(ctx context.Context, ownerID uint, playlistID uint) error
+}
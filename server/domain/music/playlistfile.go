@@ -0,0 +1,271 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PlaylistFile represents a playlist file found in the music library, such as an M3U8 or XSPF file
+type PlaylistFile struct {
+	Path      string   // Path from the root music folder. For example "Road trip.m3u8"
+	Name      string   // Title of the playlist. Defaults to the file name without its extension
+	SongPaths []string // Paths from the root music folder of the playlist's songs, in order
+}
+
+// LibraryPlaylist represents a playlist file saved in the library index
+type LibraryPlaylist struct {
+	PlaylistSummary
+	Path string // Path from the root music folder. For example "Road trip.m3u8"
+}
+
+// LibraryPlaylistStore retrieves the playlist files from the library index
+type LibraryPlaylistStore interface {
+	// ListLibraryPlaylists returns the playlist files of the library, ordered by path
+	ListLibraryPlaylists(ctx context.Context) ([]LibraryPlaylist, error)
+	// GetLibraryPlaylist returns the playlist file and its songs that are in the library index.
+	// It returns ErrPlaylistNotFound when there is no such playlist file.
+	GetLibraryPlaylist(ctx context.Context, playlistID uint) (*Playlist, error)
+}
+
+var playlistExtensions = [3]string{".m3u", ".m3u8", ".xspf"}
+
+func isFileAPlaylist(fileName string) bool {
+	extension := strings.ToLower(path.Ext(fileName))
+	for _, playlistExtension := range playlistExtensions {
+		if extension == playlistExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPlaylistFile reads the M3U, M3U8 or XSPF playlist file at filePath. Its entries are resolved
+// relative to the folder of the playlist. Absolute entries are either song URIs, in MusicPath, or
+// paths on disk, matched against rootPaths which maps the name of each library root to its folder.
+// Entries outside the music library and remote entries are skipped.
+// It returns ErrUnsupportedFormat for other file extensions.
+func ReadPlaylistFile(file io.Reader, filePath string, rootPaths map[string]string) (*PlaylistFile, error) {
+	fileName := path.Base(filePath)
+	extension := strings.ToLower(path.Ext(fileName))
+	playlist := &PlaylistFile{Path: filePath, Name: strings.TrimSuffix(fileName, path.Ext(fileName))}
+	var (
+		name    string
+		entries []string
+		err     error
+	)
+	switch extension {
+	case ".m3u", ".m3u8":
+		name, entries, err = readM3U(file)
+	case ".xspf":
+		name, entries, err = readXSPF(file)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the playlist %s: %w", filePath, err)
+	}
+	if name != "" {
+		playlist.Name = name
+	}
+	folderPath := path.Dir(filePath)
+	for _, entry := range entries {
+		if songPath, ok := resolvePlaylistEntry(folderPath, entry, rootPaths); ok {
+			playlist.SongPaths = append(playlist.SongPaths, songPath)
+		}
+	}
+	return playlist, nil
+}
+
+func readM3U(file io.Reader) (string, []string, error) {
+	var (
+		name    string
+		entries []string
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if !utf8.ValidString(line) {
+			// .m3u files are often encoded in Latin-1
+			line = decodeLatin1([]byte(line))
+		}
+		if strings.HasPrefix(line, "#PLAYLIST:") {
+			name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return name, entries, scanner.Err()
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration uint   `xml:"duration,omitempty"` // Milliseconds
+}
+
+func readXSPF(file io.Reader) (string, []string, error) {
+	var playlist xspfPlaylist
+	if err := xml.NewDecoder(file).Decode(&playlist); err != nil {
+		return "", nil, err
+	}
+	var entries []string
+	for _, track := range playlist.Tracks {
+		location := strings.TrimSpace(track.Location)
+		if location == "" {
+			continue
+		}
+		// XSPF locations are URIs, M3U entries are paths
+		uri, err := url.Parse(location)
+		if err != nil {
+			continue
+		}
+		if uri.Scheme != "" && uri.Scheme != "file" {
+			continue
+		}
+		entries = append(entries, uri.Path)
+	}
+	return strings.TrimSpace(playlist.Title), entries, nil
+}
+
+// resolvePlaylistEntry returns the path from the root music folder of the playlist entry.
+// It returns false when the entry is not in the music library.
+func resolvePlaylistEntry(folderPath string, entry string, rootPaths map[string]string) (string, bool) {
+	if strings.HasPrefix(entry, "file://") {
+		uri, err := url.Parse(entry)
+		if err != nil {
+			return "", false
+		}
+		entry = uri.Path
+	} else if strings.Contains(entry, "://") {
+		return "", false // Remote stream
+	}
+	entry = strings.ReplaceAll(entry, `\`, "/")
+	var resolved string
+	if songPath, ok := resolveSongURI(entry, rootPaths); ok {
+		resolved = songPath
+	} else if songPath, ok := resolveRootPath(entry, rootPaths); ok {
+		resolved = songPath
+	} else if path.IsAbs(entry) {
+		return "", false
+	} else {
+		resolved = path.Join(folderPath, entry)
+	}
+	if resolved == "." || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// resolveSongURI returns the path of the song whose URI is entry, such as the entries of exported playlists.
+// Its first folder after MusicPath must be the name of a root.
+func resolveSongURI(entry string, rootPaths map[string]string) (string, bool) {
+	relative := strings.TrimPrefix(path.Clean(entry), MusicPath+"/")
+	if relative == path.Clean(entry) {
+		return "", false
+	}
+	if _, ok := rootPaths[strings.SplitN(relative, "/", 2)[0]]; !ok {
+		return "", false
+	}
+	return relative, true
+}
+
+// resolveRootPath returns the path of entry in the library root whose folder on disk contains it.
+// When roots are nested, the deepest one wins.
+func resolveRootPath(entry string, rootPaths map[string]string) (string, bool) {
+	cleaned := path.Clean(entry)
+	var resolved, longestRootPath string
+	for name, rootPath := range rootPaths {
+		rootPath = path.Clean(strings.ReplaceAll(rootPath, `\`, "/"))
+		if !strings.HasPrefix(cleaned, strings.TrimSuffix(rootPath, "/")+"/") || len(rootPath) <= len(longestRootPath) {
+			continue
+		}
+		resolved = path.Join(name, strings.TrimPrefix(cleaned, rootPath))
+		longestRootPath = rootPath
+	}
+	return resolved, resolved != ""
+}
+
+// WriteM3U8 writes the playlist as an extended M3U file encoded in UTF-8.
+// Songs are written with their absolute path in MusicPath.
+func WriteM3U8(writer io.Writer, playlist *Playlist) error {
+	buffered := bufio.NewWriter(writer)
+	buffered.WriteString("#EXTM3U\n")
+	buffered.WriteString("#PLAYLIST:" + singleLine(playlist.Name) + "\n")
+	for _, entry := range playlist.Entries {
+		song := entry.Song
+		title := song.Title
+		if song.Artist != "" {
+			title = song.Artist + " - " + title
+		}
+		buffered.WriteString("#EXTINF:" + strconv.FormatUint(uint64(song.Duration), 10) + "," + singleLine(title) + "\n")
+		buffered.WriteString(song.URI + "\n")
+	}
+	return buffered.Flush()
+}
+
+// WriteXSPF writes the playlist as an XSPF file. Songs are located with file URIs in MusicPath.
+func WriteXSPF(writer io.Writer, playlist *Playlist) error {
+	document := xspfPlaylist{Version: "1", Title: playlist.Name}
+	for _, entry := range playlist.Entries {
+		song := entry.Song
+		location := url.URL{Scheme: "file", Path: song.URI}
+		document.Tracks = append(document.Tracks, xspfTrack{
+			Location: location.String(),
+			Title:    song.Title,
+			Creator:  song.Artist,
+			Album:    song.Album,
+			Duration: song.Duration * 1000,
+		})
+	}
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
+
+func singleLine(text string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+}
@@ -0,0 +1,174 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+// rootPaths are two library roots on disk, one of them in the other
+var rootPaths = map[string]string{"music": "/home/mike/Music", "lossless": "/home/mike/Music/FLAC/"}
+
+func TestReadPlaylistFile(t *testing.T) {
+	t.Run("it resolves the entries of an M3U8 file relative to its folder or to the music folder", func(t *testing.T) {
+		file := strings.NewReader("\ufeff#EXTM3U\n#PLAYLIST:Road trip\n#EXTINF:423,Nightwish - Ghost Love Score\n" +
+			"Once/ghost.mp3\r\n\n/music/music/Blind Guardian/nightfall.flac\n..\\Kanno\\Tank!.ogg\n")
+
+		playlist, err := music.ReadPlaylistFile(file, "music/Nightwish/best.m3u8", rootPaths)
+
+		tests.AssertNoError(t, err)
+		if playlist.Name != "Road trip" || playlist.Path != "music/Nightwish/best.m3u8" {
+			t.Errorf("unexpected playlist %+v", playlist)
+		}
+		assertSongPathsEqual(
+			t,
+			playlist.SongPaths,
+			"music/Nightwish/Once/ghost.mp3",
+			"music/Blind Guardian/nightfall.flac",
+			"music/Kanno/Tank!.ogg",
+		)
+	})
+
+	t.Run("it matches absolute entries against the folders of the library roots on disk", func(t *testing.T) {
+		file := strings.NewReader("/home/mike/Music/Kanno/Tank!.ogg\n" +
+			"/home/mike/Music/FLAC/Nightwish/Once/ghost.flac\nfile:///home/mike/Music/FLAC/Blind%20Guardian/nightfall.flac\n")
+
+		playlist, err := music.ReadPlaylistFile(file, "lossless/road.m3u", rootPaths)
+
+		tests.AssertNoError(t, err)
+		assertSongPathsEqual(
+			t,
+			playlist.SongPaths,
+			"music/Kanno/Tank!.ogg",
+			"lossless/Nightwish/Once/ghost.flac",
+			"lossless/Blind Guardian/nightfall.flac",
+		)
+	})
+
+	t.Run("it skips remote entries and entries outside the music library", func(t *testing.T) {
+		file := strings.NewReader("http://radio.example.com/stream\n/home/user/song.mp3\n/music/Nightwish/song.mp3\n" +
+			"../../../escape.mp3\nsit.flac\n")
+
+		playlist, err := music.ReadPlaylistFile(file, "music/Nightwish/best.m3u", rootPaths)
+
+		tests.AssertNoError(t, err)
+		if playlist.Name != "best" {
+			t.Errorf("expected the name to default to the file name, got %s", playlist.Name)
+		}
+		assertSongPathsEqual(t, playlist.SongPaths, "music/Nightwish/sit.flac")
+	})
+
+	t.Run("it reads M3U files encoded in Latin-1", func(t *testing.T) {
+		file := bytes.NewReader([]byte("Bj\xf6rk/J\xf3ga.mp3\n"))
+
+		playlist, err := music.ReadPlaylistFile(file, "latin.m3u", nil)
+
+		tests.AssertNoError(t, err)
+		assertSongPathsEqual(t, playlist.SongPaths, "Björk/Jóga.mp3")
+	})
+
+	t.Run("it resolves the locations of an XSPF file", func(t *testing.T) {
+		file := strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+	<title>Symphonic</title>
+	<trackList>
+		<track><location>file:///music/music/Nightwish/Once/Ghost%20Love%20Score.mp3</location></track>
+		<track><location>Once/Nemo.mp3</location></track>
+		<track><location>https://example.com/song.mp3</location></track>
+	</trackList>
+</playlist>`)
+
+		playlist, err := music.ReadPlaylistFile(file, "music/Nightwish/symphonic.xspf", rootPaths)
+
+		tests.AssertNoError(t, err)
+		if playlist.Name != "Symphonic" {
+			t.Errorf("expected the name to be read from the title, got %s", playlist.Name)
+		}
+		assertSongPathsEqual(t, playlist.SongPaths, "music/Nightwish/Once/Ghost Love Score.mp3", "music/Nightwish/Once/Nemo.mp3")
+	})
+
+	t.Run("given an invalid XSPF file, it returns an error", func(t *testing.T) {
+		_, err := music.ReadPlaylistFile(strings.NewReader("<playlist"), "broken.xspf", nil)
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given another file extension, it returns ErrUnsupportedFormat", func(t *testing.T) {
+		_, err := music.ReadPlaylistFile(strings.NewReader(""), "playlist.pls", nil)
+		if !errors.Is(err, music.ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+}
+
+func TestWritePlaylistFile(t *testing.T) {
+	playlist := &music.Playlist{
+		PlaylistSummary: music.PlaylistSummary{ID: 12, Name: "Road trip", SongCount: 2},
+		Entries: []music.PlaylistEntry{
+			{ID: 1, Song: music.Song{Title: "Ghost Love Score", Artist: "Nightwish", Duration: 600, URI: "/music/music/Nightwish/Once/Ghost Love Score.mp3"}},
+			{ID: 2, Song: music.Song{Title: "Tank!", Duration: 210, URI: "/music/lossless/Kanno/Tank!.ogg"}},
+		},
+	}
+
+	t.Run("it writes an M3U8 file that can be read back", func(t *testing.T) {
+		var buffer bytes.Buffer
+		tests.AssertNoError(t, music.WriteM3U8(&buffer, playlist))
+
+		if !strings.Contains(buffer.String(), "#EXTINF:600,Nightwish - Ghost Love Score\n") {
+			t.Errorf("expected the song to be described, got %s", buffer.String())
+		}
+		read, err := music.ReadPlaylistFile(&buffer, "exported.m3u8", rootPaths)
+		tests.AssertNoError(t, err)
+		if read.Name != "Road trip" {
+			t.Errorf("expected the name to be written, got %s", read.Name)
+		}
+		assertSongPathsEqual(t, read.SongPaths, "music/Nightwish/Once/Ghost Love Score.mp3", "lossless/Kanno/Tank!.ogg")
+	})
+
+	t.Run("it writes an XSPF file that can be read back", func(t *testing.T) {
+		var buffer bytes.Buffer
+		tests.AssertNoError(t, music.WriteXSPF(&buffer, playlist))
+
+		if !strings.Contains(buffer.String(), "<location>file:///music/music/Nightwish/Once/Ghost%20Love%20Score.mp3</location>") {
+			t.Errorf("expected the song location to be a file URI, got %s", buffer.String())
+		}
+		read, err := music.ReadPlaylistFile(&buffer, "exported.xspf", rootPaths)
+		tests.AssertNoError(t, err)
+		if read.Name != "Road trip" {
+			t.Errorf("expected the name to be written, got %s", read.Name)
+		}
+		assertSongPathsEqual(t, read.SongPaths, "music/Nightwish/Once/Ghost Love Score.mp3", "lossless/Kanno/Tank!.ogg")
+	})
+}
+
+func assertSongPathsEqual(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected song paths %v, got %v", want, got)
+	}
+	for index := range want {
+		if got[index] != want[index] {
+			t.Errorf("expected song path %s at position %d, got %s", want[index], index, got[index])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRootName is returned when the name of a library root cannot be used as a folder name
+var ErrInvalidRootName = errors.New("invalid library root name")
+
+// LibraryRoot is one of the folders the music library is made of. For example the folder of lossless files
+// on one disk and the folder of podcasts on another disk.
+type LibraryRoot struct {
+	Name       string // Name of the root. It is the first folder of the paths of its files. For example "lossless"
+	Path       string // Path of the root's folder on disk. Absolute entries of playlist files are matched against it.
+	FileSystem MusicLibraryFileSystem
+}
+
+// NewRootsFileSystem combines the roots of the music library in a single MusicLibraryFileSystem.
+// Its top-level folders are the roots: the file "Nightwish/Once/ghost.flac" of the root named
+// "lossless" is at "lossless/Nightwish/Once/ghost.flac". Song URIs thus tell which root a song belongs to.
+// The top-level folder lists every root, even the ones that cannot be read: a scan reports them as
+// unreadable folders and keeps their contents, so that it never mistakes an unmounted root for an empty one.
+func NewRootsFileSystem(roots []LibraryRoot) (MusicLibraryFileSystem, error) {
+	byName := make(map[string]MusicLibraryFileSystem, len(roots))
+	paths := make(map[string]string, len(roots))
+	names := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if !IsValidRootName(root.Name) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRootName, root.Name)
+		}
+		if _, ok := byName[root.Name]; ok {
+			return nil, fmt.Errorf("%w: %q is used by two roots", ErrInvalidRootName, root.Name)
+		}
+		byName[root.Name] = root.FileSystem
+		if root.Path != "" {
+			paths[root.Name] = root.Path
+		}
+		names = append(names, root.Name)
+	}
+	sort.Strings(names)
+	return &rootsFileSystem{byName, paths, names}, nil
+}
+
+// IsValidRootName returns true when the name can be the name of a single folder
+func IsValidRootName(name string) bool {
+	return name != "" && name != "." && name != ".." && fs.ValidPath(name) && !strings.Contains(name, "/")
+}
+
+// rootPathsFileSystem is a MusicLibraryFileSystem that knows where its roots are on disk
+type rootPathsFileSystem interface {
+	MusicLibraryFileSystem
+	// RootPaths maps the name of each root to the path of its folder on disk
+	RootPaths() map[string]string
+}
+
+// rootsFileSystem implements MusicLibraryFileSystem and rootPathsFileSystem
+type rootsFileSystem struct {
+	roots map[string]MusicLibraryFileSystem
+	paths map[string]string
+	names []string // Sorted names of the roots
+}
+
+func (r *rootsFileSystem) RootPaths() map[string]string {
+	return r.paths
+}
+
+// resolve returns the file system of the root of name and the path of name in this root
+func (r *rootsFileSystem) resolve(operation string, name string) (MusicLibraryFileSystem, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: operation, Path: name, Err: fs.ErrInvalid}
+	}
+	parts := strings.SplitN(name, "/", 2)
+	root, ok := r.roots[parts[0]]
+	if !ok {
+		return nil, "", &fs.PathError{Op: operation, Path: name, Err: fs.ErrNotExist}
+	}
+	if len(parts) == 1 {
+		return root, ".", nil
+	}
+	return root, parts[1], nil
+}
+
+func (r *rootsFileSystem) Open(name string) (fs.File, error) {
+	if name != "." {
+		root, rootPath, err := r.resolve("open", name)
+		if err != nil {
+			return nil, err
+		}
+		if rootPath != "." {
+			return root.Open(rootPath)
+		}
+	}
+	// The top-level folder and the folders of the roots are named after their path in this file system
+	entries, err := r.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rootsDirectory{name: path.Base(name), entries: entries}, nil
+}
+
+func (r *rootsFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		root, rootPath, err := r.resolve("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		return root.ReadDir(rootPath)
+	}
+	entries := make([]fs.DirEntry, 0, len(r.names))
+	for _, rootName := range r.names {
+		entries = append(entries, rootEntry(rootName))
+	}
+	return entries, nil
+}
+
+// rootEntry is the folder of a root in the top-level folder. It implements fs.DirEntry and fs.FileInfo.
+type rootEntry string
+
+func (e rootEntry) Name() string               { return string(e) }
+func (e rootEntry) IsDir() bool                { return true }
+func (e rootEntry) Type() fs.FileMode          { return fs.ModeDir }
+func (e rootEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e rootEntry) Size() int64                { return 0 }
+func (e rootEntry) Mode() fs.FileMode          { return fs.ModeDir | 0o555 }
+func (e rootEntry) ModTime() time.Time         { return time.Time{} }
+func (e rootEntry) Sys() interface{}           { return nil }
+
+// rootsDirectory is the top-level folder or the folder of a root, opened. It implements fs.ReadDirFile.
+type rootsDirectory struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *rootsDirectory) Stat() (fs.FileInfo, error) { return rootEntry(d.name), nil }
+
+func (d *rootsDirectory) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *rootsDirectory) Close() error { return nil }
+
+func (d *rootsDirectory) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
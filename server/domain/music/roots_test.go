@@ -0,0 +1,132 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestRootsFileSystem(t *testing.T) {
+	lossless := fstest.MapFS{
+		"Nightwish/Once/ghost.flac": {Data: []byte("flac")},
+		"Nightwish/cover.jpg":       {},
+	}
+	podcasts := fstest.MapFS{"episode.mp3": {Data: []byte("mp3")}}
+
+	t.Run("it lists the roots as top-level folders and their files under them", func(t *testing.T) {
+		filesystem, err := music.NewRootsFileSystem([]music.LibraryRoot{
+			{Name: "podcasts", FileSystem: podcasts},
+			{Name: "lossless", FileSystem: lossless},
+		})
+		tests.AssertNoError(t, err)
+
+		err = fstest.TestFS(filesystem, "lossless/Nightwish/Once/ghost.flac", "lossless/Nightwish/cover.jpg", "podcasts/episode.mp3")
+		tests.AssertNoError(t, err)
+		data, err := fs.ReadFile(filesystem, "podcasts/episode.mp3")
+		tests.AssertNoError(t, err)
+		if string(data) != "mp3" {
+			t.Errorf("expected the file of the podcasts root, got %q", data)
+		}
+	})
+
+	t.Run("it does not find files outside of the roots", func(t *testing.T) {
+		filesystem, err := music.NewRootsFileSystem([]music.LibraryRoot{{Name: "podcasts", FileSystem: podcasts}})
+		tests.AssertNoError(t, err)
+
+		_, err = filesystem.Open("lossless/Nightwish/cover.jpg")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected fs.ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("the scanner saves the songs with URIs telling their root", func(t *testing.T) {
+		filesystem, err := music.NewRootsFileSystem([]music.LibraryRoot{{Name: "lossless", FileSystem: lossless}})
+		tests.AssertNoError(t, err)
+		index := newStubLibraryIndex()
+
+		_, err = music.NewScanner(filesystem, index).Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		ghost := index.songs["lossless/Nightwish/Once"][0]
+		if ghost.Path != "lossless/Nightwish/Once/ghost.flac" || ghost.URI != "/music/lossless/Nightwish/Once/ghost.flac" {
+			t.Errorf("unexpected song %+v", ghost)
+		}
+	})
+
+	t.Run("the scanner matches absolute playlist entries against the folders of the roots on disk", func(t *testing.T) {
+		playlists := fstest.MapFS{"best.m3u": {Data: []byte("/mnt/flac/Nightwish/Once/ghost.flac\n/srv/podcasts/episode.mp3\n")}}
+		filesystem, err := music.NewRootsFileSystem([]music.LibraryRoot{
+			{Name: "lossless", Path: "/mnt/flac", FileSystem: lossless},
+			{Name: "podcasts", Path: "/srv/podcasts", FileSystem: podcasts},
+			{Name: "playlists", Path: "/srv/playlists", FileSystem: playlists},
+		})
+		tests.AssertNoError(t, err)
+		index := newStubLibraryIndex()
+
+		_, err = music.NewScanner(filesystem, index).Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if len(index.playlists) != 1 {
+			t.Fatalf("expected the playlist file to be saved, got %+v", index.playlists)
+		}
+		assertSongPathsEqual(t, index.playlists[0].SongPaths, "lossless/Nightwish/Once/ghost.flac", "podcasts/episode.mp3")
+	})
+
+	t.Run("when a root cannot be read, the scan keeps its contents in the index and scans the other roots", func(t *testing.T) {
+		filesystem, err := music.NewRootsFileSystem([]music.LibraryRoot{
+			{Name: "lossless", FileSystem: lossless},
+			{Name: "shared", FileSystem: &fsWithUnreadableFolder{fstest.MapFS{}, "."}},
+		})
+		tests.AssertNoError(t, err)
+		index := newStubLibraryIndex()
+
+		report, err := music.NewScanner(filesystem, index).Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if len(report.UnreadableFolders) != 1 || report.UnreadableFolders[0] != "shared" {
+			t.Errorf("expected the shared root to be reported, got %v", report.UnreadableFolders)
+		}
+		if len(index.keptFolders) != 1 || index.keptFolders[0] != "shared" {
+			t.Errorf("expected the shared root to be kept in the index, got %v", index.keptFolders)
+		}
+		if len(index.songs["lossless/Nightwish/Once"]) != 1 {
+			t.Errorf("expected the songs of the other roots to be saved, got %v", index.songs)
+		}
+	})
+
+	for name, roots := range map[string][]music.LibraryRoot{
+		"an empty name":       {{Name: "", FileSystem: podcasts}},
+		"a name with a slash": {{Name: "lossless/flac", FileSystem: lossless}},
+		"a dot dot name":      {{Name: "..", FileSystem: lossless}},
+		"a duplicate name":    {{Name: "music", FileSystem: lossless}, {Name: "music", FileSystem: podcasts}},
+	} {
+		t.Run("given "+name+", it returns ErrInvalidRootName", func(t *testing.T) {
+			_, err := music.NewRootsFileSystem(roots)
+			if !errors.Is(err, music.ErrInvalidRootName) {
+				t.Errorf("expected ErrInvalidRootName, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// Scanner walks the music library folders and saves their contents in the LibraryIndex
+type Scanner interface {
+	// Scan walks the whole music library. Songs whose file did not change since the last scan
+	// are not read again. Playlist files are always read again. Folders, songs and playlist files
+	// that disappeared are removed from the index.
+	Scan(ctx context.Context) (*ScanReport, error)
+	// ScanFolder works like Scan for the folder at folderPath and its sub-folders only. It scans the closest
+	// parent folder instead when folderPath is not a folder of the library anymore, or when its parent folder
+	// is not in the index yet. Scanning "." scans the whole library.
+	ScanFolder(ctx context.Context, folderPath string) (*ScanReport, error)
+}
+
+// ScanReport sums up what a scan found
+type ScanReport struct {
+	Folders           uint     // Number of folders saved in the index
+	Songs             uint     // Number of songs saved in the index
+	ReadSongs         uint     // Number of songs whose tags were read because they are new or have changed
+	Playlists         uint     // Number of playlist files saved in the index
+	UnreadableFolders []string // Paths of the folders that could not be read. They are skipped and keep their contents in the index.
+	UnreadableFiles   []string // Paths of the playlist files that could not be read. They are skipped.
+}
+
+// baseScanner implements Scanner. It runs one scan at a time: a scan removes from the index
+// what it did not save, including what a concurrent scan would be saving.
+type baseScanner struct {
+	filesystem MusicLibraryFileSystem
+	index      LibraryIndex
+	mutex      sync.Mutex
+}
+
+// NewScanner creates a new Scanner
+func NewScanner(filesystem MusicLibraryFileSystem, index LibraryIndex) Scanner {
+	return &baseScanner{filesystem: filesystem, index: index}
+}
+
+func (b *baseScanner) Scan(ctx context.Context) (*ScanReport, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.scanLibrary(ctx)
+}
+
+func (b *baseScanner) ScanFolder(ctx context.Context, folderPath string) (*ScanReport, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	folderPath, err := b.closestScannableFolder(ctx, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if folderPath == "." {
+		return b.scanLibrary(ctx)
+	}
+	scanID, err := b.index.BeginScan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin the scan: %w", err)
+	}
+	report := &ScanReport{}
+	folder := SubFolder{Name: path.Base(folderPath), Path: folderPath}
+	if err = b.scanFolder(ctx, scanID, folder, report); err != nil {
+		return nil, err
+	}
+	// Like the root folder during a full scan, the folder must be readable. Otherwise its whole contents
+	// would be removed from the index, for example while its disk is unmounted.
+	if len(report.UnreadableFolders) != 0 && report.UnreadableFolders[0] == folderPath {
+		return nil, fmt.Errorf("could not read the folder %s", folderPath)
+	}
+	if err = b.index.EndFolderScan(ctx, scanID, folderPath); err != nil {
+		return nil, fmt.Errorf("could not end the scan of %s: %w", folderPath, err)
+	}
+	return report, nil
+}
+
+// closestScannableFolder returns folderPath or its closest parent that is a folder of the library
+// and whose parent folder is in the index. It returns "." when there is no such folder.
+func (b *baseScanner) closestScannableFolder(ctx context.Context, folderPath string) (string, error) {
+	folderPath = path.Clean(folderPath)
+	if !fs.ValidPath(folderPath) {
+		return "", fmt.Errorf("%q is not a path of the music library", folderPath)
+	}
+	for ; folderPath != "."; folderPath = path.Dir(folderPath) {
+		if info, err := fs.Stat(b.filesystem, folderPath); err != nil || !info.IsDir() {
+			continue
+		}
+		_, _, err := b.index.ListFolder(ctx, path.Dir(folderPath))
+		if err == nil {
+			return folderPath, nil
+		}
+		if !errors.Is(err, ErrFolderNotFound) {
+			return "", fmt.Errorf("could not read the folder %v from the library index: %w", path.Dir(folderPath), err)
+		}
+	}
+	return ".", nil
+}
+
+func (b *baseScanner) scanLibrary(ctx context.Context) (*ScanReport, error) {
+	scanID, err := b.index.BeginScan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin the scan: %w", err)
+	}
+	report := &ScanReport{}
+	root := SubFolder{Name: ".", Path: "."}
+	if err = b.scanFolder(ctx, scanID, root, report); err != nil {
+		return nil, err
+	}
+	if err = b.index.EndScan(ctx, scanID); err != nil {
+		return nil, fmt.Errorf("could not end the scan: %w", err)
+	}
+	return report, nil
+}
+
+// scanFolder saves the folder before walking its sub-folders, so that parents are always
+// in the index before their children.
+func (b *baseScanner) scanFolder(ctx context.Context, scanID int64, folder SubFolder, report *ScanReport) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := b.filesystem.ReadDir(folder.Path)
+	if err != nil {
+		if folder.Path == "." {
+			return fmt.Errorf("could not read the root music library folder: %w", err)
+		}
+		// The folder may be unreadable for a while only, its contents must not be removed from the index
+		if err = b.index.KeepFolder(ctx, scanID, folder.Path); err != nil {
+			return fmt.Errorf("could not keep the unreadable folder %v in the index: %w", folder.Path, err)
+		}
+		report.UnreadableFolders = append(report.UnreadableFolders, folder.Path)
+		return nil
+	}
+	if info, err := fs.Stat(b.filesystem, folder.Path); err == nil {
+		folder.ModificationTime = info.ModTime()
+	}
+	previousSongs, err := b.previouslyIndexedSongs(ctx, folder.Path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		subFolders    []SubFolder
+		songs         []IndexedSong
+		playlistPaths []string
+		fileNames     []string
+	)
+	for _, entry := range entries {
+		filePath := path.Join(folder.Path, entry.Name())
+		if entry.IsDir() {
+			subFolders = append(subFolders, SubFolder{Name: entry.Name(), Path: filePath})
+			continue
+		}
+		fileNames = append(fileNames, entry.Name())
+		if isFileAPlaylist(entry.Name()) {
+			playlistPaths = append(playlistPaths, filePath)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		previous, ok := previousSongs[filePath]
+		if ok && previous.Size == info.Size() && previous.ModificationTime.Equal(info.ModTime()) {
+			songs = append(songs, previous)
+			continue
+		}
+		if !isFileASong(b.filesystem, filePath) {
+			continue
+		}
+		song, hasPicture := readSongAndPicture(b.filesystem, filePath)
+		songs = append(songs, IndexedSong{
+			Song:             song,
+			Path:             filePath,
+			ModificationTime: info.ModTime(),
+			Size:             info.Size(),
+			HasPicture:       hasPicture,
+		})
+		report.ReadSongs++
+	}
+	if folder.CoverID, err = b.saveCoverFile(ctx, scanID, folder.Path, fileNames); err != nil {
+		return err
+	}
+	if err = b.index.SaveFolder(ctx, scanID, folder, songs); err != nil {
+		return fmt.Errorf("could not save the folder %v in the library index: %w", folder.Path, err)
+	}
+	report.Folders++
+	report.Songs += uint(len(songs))
+
+	for _, playlistPath := range playlistPaths {
+		playlist, err := readPlaylistFile(b.filesystem, playlistPath)
+		if err != nil {
+			report.UnreadableFiles = append(report.UnreadableFiles, playlistPath)
+			continue
+		}
+		if err = b.index.SavePlaylistFile(ctx, scanID, *playlist); err != nil {
+			return fmt.Errorf("could not save the playlist %v in the library index: %w", playlistPath, err)
+		}
+		report.Playlists++
+	}
+
+	for _, subFolder := range subFolders {
+		if err = b.scanFolder(ctx, scanID, subFolder, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *baseScanner) previouslyIndexedSongs(ctx context.Context, folderPath string) (map[string]IndexedSong, error) {
+	_, songs, err := b.index.ListFolder(ctx, folderPath)
+	if errors.Is(err, ErrFolderNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the folder %v from the library index: %w", folderPath, err)
+	}
+	previousSongs := make(map[string]IndexedSong, len(songs))
+	for _, song := range songs {
+		previousSongs[song.Path] = song
+	}
+	return previousSongs, nil
+}
+
+func readPlaylistFile(filesystem fs.FS, filePath string) (*PlaylistFile, error) {
+	file, err := filesystem.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rootPaths map[string]string
+	if roots, ok := filesystem.(rootPathsFileSystem); ok {
+		rootPaths = roots.RootPaths()
+	}
+	return ReadPlaylistFile(file, filePath, rootPaths)
+}
+
+// saveCoverFile saves the folder's cover image file, if any, and returns its identifier. It returns zero
+// when the folder has no cover image file.
+func (b *baseScanner) saveCoverFile(ctx context.Context, scanID int64, folderPath string, fileNames []string) (uint, error) {
+	coverFileName := findCoverFile(fileNames)
+	if coverFileName == "" {
+		return 0, nil
+	}
+	coverPath := path.Join(folderPath, coverFileName)
+	info, err := fs.Stat(b.filesystem, coverPath)
+	if err != nil {
+		return 0, nil
+	}
+	cover := Cover{Path: coverPath, ModificationTime: info.ModTime(), Size: info.Size()}
+	coverID, err := b.index.SaveCover(ctx, scanID, cover)
+	if err != nil {
+		return 0, fmt.Errorf("could not save the cover %v in the library index: %w", coverPath, err)
+	}
+	return coverID, nil
+}
@@ -0,0 +1,369 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestScanner(t *testing.T) {
+	modificationTime := time.Date(2021, time.May, 8, 12, 0, 0, 0, time.UTC)
+
+	t.Run("it saves every folder and song of the library in the index and ends the scan", func(t *testing.T) {
+		testFS := fstest.MapFS{
+			"Nightwish/Once/ghost.mp3": {Data: newID3v23MP3(t), ModTime: modificationTime},
+			"Nightwish/cover.jpg":      {},
+			"sit.flac":                 {ModTime: modificationTime},
+		}
+		index := newStubLibraryIndex()
+		scanner := music.NewScanner(testFS, index)
+
+		report, err := scanner.Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if report.Folders != 3 || report.Songs != 2 || report.ReadSongs != 2 {
+			t.Errorf("unexpected scan report %+v", report)
+		}
+		if !index.hasEnded {
+			t.Errorf("expected the scan to be ended")
+		}
+		ghost := index.songs["Nightwish/Once"][0]
+		if ghost.Title != "Ghost Love Score" || ghost.URI != "/music/Nightwish/Once/ghost.mp3" {
+			t.Errorf("expected the song's tags to be saved, got %+v", ghost)
+		}
+	})
+
+	t.Run("it saves the cover image file of each folder and flags the songs embedding a picture", func(t *testing.T) {
+		frame := newAPICFrame(t, 3, []byte("\xff\xd8\xffpicture"))
+		testFS := fstest.MapFS{
+			"Nightwish/Once/ghost.mp3":  {Data: append(newID3v2Tag(3, frame), newCBRFrames(t, 16000)...)},
+			"Nightwish/Once/Folder.jpg": {Data: []byte("\xff\xd8\xff"), ModTime: modificationTime},
+			"Nightwish/Once/back.jpg":   {},
+			"Nightwish/Once/cover.png":  {Data: []byte("\x89PNG"), ModTime: modificationTime},
+		}
+		index := newStubLibraryIndex()
+		scanner := music.NewScanner(testFS, index)
+
+		_, err := scanner.Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if len(index.covers) != 1 || index.covers[0].Path != "Nightwish/Once/cover.png" || index.covers[0].Size != 4 {
+			t.Fatalf("expected cover.png to be saved as the folder's cover, got %+v", index.covers)
+		}
+		if index.savedFolders["Nightwish/Once"].CoverID != 1 {
+			t.Errorf("expected the folder to reference its cover, got %+v", index.savedFolders["Nightwish/Once"])
+		}
+		if index.savedFolders["Nightwish"].CoverID != 0 {
+			t.Errorf("expected a folder without cover image file to have no cover, got %+v", index.savedFolders["Nightwish"])
+		}
+		if !index.songs["Nightwish/Once"][0].HasPicture {
+			t.Errorf("expected the song to be flagged as embedding a picture")
+		}
+	})
+
+	t.Run("it saves the playlist files of the library and skips the ones it cannot read", func(t *testing.T) {
+		testFS := fstest.MapFS{
+			"Nightwish/best.m3u8":   {Data: []byte("#EXTM3U\nOnce/ghost.mp3\n")},
+			"Nightwish/broken.xspf": {Data: []byte("<playlist")},
+		}
+		index := newStubLibraryIndex()
+		scanner := music.NewScanner(testFS, index)
+
+		report, err := scanner.Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if report.Playlists != 1 || len(report.UnreadableFiles) != 1 || report.UnreadableFiles[0] != "Nightwish/broken.xspf" {
+			t.Errorf("unexpected scan report %+v", report)
+		}
+		if len(index.playlists) != 1 || index.playlists[0].SongPaths[0] != "Nightwish/Once/ghost.mp3" {
+			t.Errorf("expected the playlist file to be saved, got %+v", index.playlists)
+		}
+	})
+
+	t.Run("it does not read again the songs whose file did not change", func(t *testing.T) {
+		testFS := fstest.MapFS{
+			"unchanged.mp3": {ModTime: modificationTime},
+			"changed.mp3":   {ModTime: modificationTime.Add(time.Hour)},
+		}
+		index := newStubLibraryIndex()
+		index.songs["."] = []music.IndexedSong{
+			{Song: music.Song{ID: 1, Title: "Unchanged"}, Path: "unchanged.mp3", ModificationTime: modificationTime},
+			{Song: music.Song{ID: 2, Title: "Changed"}, Path: "changed.mp3", ModificationTime: modificationTime},
+		}
+		scanner := music.NewScanner(testFS, index)
+
+		report, err := scanner.Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if report.ReadSongs != 1 {
+			t.Errorf("expected only one song to be read, got %d", report.ReadSongs)
+		}
+		assertIndexedSongsContain(t, index.songs["."], "unchanged.mp3", "Unchanged")
+		assertIndexedSongsContain(t, index.songs["."], "changed.mp3", "changed.mp3")
+	})
+
+	t.Run("it skips the sub-folders it cannot read and keeps their contents in the index", func(t *testing.T) {
+		testFS := fstest.MapFS{
+			"locked": {Mode: fs.ModeDir},
+		}
+		index := newStubLibraryIndex()
+		scanner := music.NewScanner(&fsWithUnreadableFolder{testFS, "locked"}, index)
+
+		report, err := scanner.Scan(context.Background())
+
+		tests.AssertNoError(t, err)
+		if len(report.UnreadableFolders) != 1 || report.UnreadableFolders[0] != "locked" {
+			t.Errorf("expected the locked folder to be reported, got %v", report.UnreadableFolders)
+		}
+		if len(index.keptFolders) != 1 || index.keptFolders[0] != "locked" {
+			t.Errorf("expected the locked folder to be kept in the index, got %v", index.keptFolders)
+		}
+	})
+
+	t.Run("when the root folder cannot be read, it returns an error", func(t *testing.T) {
+		scanner := music.NewScanner(&fsWithUnreadableFolder{fstest.MapFS{}, "."}, newStubLibraryIndex())
+
+		_, err := scanner.Scan(context.Background())
+		tests.AssertError(t, err)
+	})
+
+	t.Run("when the index cannot save a folder, it returns an error", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.shouldErrorOnSave = true
+		scanner := music.NewScanner(fstest.MapFS{"sit.flac": {}}, index)
+
+		_, err := scanner.Scan(context.Background())
+		tests.AssertError(t, err)
+	})
+}
+
+func TestScanFolder(t *testing.T) {
+	testFS := fstest.MapFS{
+		"Nightwish/Once/ghost.mp3":      {},
+		"Nightwish/Century/nemo.mp3":    {},
+		"Nightwish/Century/wish.flac":   {},
+		"Epica/The Quantum/kingdom.mp3": {},
+	}
+
+	t.Run("it scans only the folder and its sub-folders", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.folders["Nightwish"] = []music.SubFolder{{Name: "Once", Path: "Nightwish/Once"}}
+		scanner := music.NewScanner(testFS, index)
+
+		report, err := scanner.ScanFolder(context.Background(), "Nightwish/Century/")
+
+		tests.AssertNoError(t, err)
+		if report.Folders != 1 || report.Songs != 2 || index.endedFolder != "Nightwish/Century" || index.hasEnded {
+			t.Errorf("unexpected report %+v for the scan of %q", report, index.endedFolder)
+		}
+	})
+
+	t.Run("when the parent folder is not in the index, it scans the parent folder", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.folders["."] = []music.SubFolder{{Name: "Epica", Path: "Epica"}}
+		scanner := music.NewScanner(testFS, index)
+
+		report, err := scanner.ScanFolder(context.Background(), "Nightwish/Century")
+
+		tests.AssertNoError(t, err)
+		if report.Folders != 3 || index.endedFolder != "Nightwish" {
+			t.Errorf("unexpected report %+v for the scan of %q", report, index.endedFolder)
+		}
+	})
+
+	t.Run("when the folder was removed, it scans the closest parent folder", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.folders["."] = []music.SubFolder{{Name: "Nightwish", Path: "Nightwish"}}
+		scanner := music.NewScanner(testFS, index)
+
+		_, err := scanner.ScanFolder(context.Background(), "Nightwish/Oceanborn/Stargazers")
+
+		tests.AssertNoError(t, err)
+		if index.endedFolder != "Nightwish" {
+			t.Errorf("expected the scan of Nightwish, got %q", index.endedFolder)
+		}
+	})
+
+	t.Run("given the root folder, it scans the whole library", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		scanner := music.NewScanner(testFS, index)
+
+		_, err := scanner.ScanFolder(context.Background(), ".")
+
+		tests.AssertNoError(t, err)
+		if !index.hasEnded || index.endedFolder != "" {
+			t.Errorf("expected a full scan")
+		}
+	})
+
+	t.Run("when the folder cannot be read, it returns an error and keeps its contents in the index", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.folders["."] = []music.SubFolder{{Name: "Nightwish", Path: "Nightwish"}}
+		scanner := music.NewScanner(&fsWithUnreadableFolder{testFS, "Nightwish"}, index)
+
+		_, err := scanner.ScanFolder(context.Background(), "Nightwish")
+
+		tests.AssertError(t, err)
+		if index.endedFolder != "" {
+			t.Errorf("expected the scan not to be ended")
+		}
+	})
+}
+
+func TestIndexedMusicLibraryExplorer(t *testing.T) {
+	t.Run("it lists the sub-folders and songs of the folder from the index", func(t *testing.T) {
+		index := newStubLibraryIndex()
+		index.folders["Nightwish"] = []music.SubFolder{{Name: "Once", Path: "Nightwish/Once"}}
+		index.songs["Nightwish"] = []music.IndexedSong{
+			{Song: music.Song{ID: 3, Title: "Nemo", URI: "/music/Nightwish/nemo.mp3"}, Path: "Nightwish/nemo.mp3"},
+		}
+		explorer := music.NewIndexedMusicLibraryExplorer(index)
+
+		folders, songs, err := explorer.ListContents("Nightwish/")
+
+		tests.AssertNoError(t, err)
+		assertSubFoldersContain(t, folders, music.SubFolder{Name: "Once", Path: "Nightwish/Once"})
+		assertSongsContain(t, songs, music.Song{Title: "Nemo", URI: "/music/Nightwish/nemo.mp3"})
+	})
+
+	t.Run("when the library has not been scanned yet, the root folder is empty", func(t *testing.T) {
+		explorer := music.NewIndexedMusicLibraryExplorer(newStubLibraryIndex())
+
+		folders, songs, err := explorer.ListContents(".")
+
+		tests.AssertNoError(t, err)
+		if len(folders) != 0 || len(songs) != 0 {
+			t.Errorf("expected the root folder to be empty")
+		}
+	})
+
+	t.Run("given a folder that is not in the index, it returns an error", func(t *testing.T) {
+		explorer := music.NewIndexedMusicLibraryExplorer(newStubLibraryIndex())
+
+		_, _, err := explorer.ListContents("unknown")
+		if !errors.Is(err, music.ErrFolderNotFound) {
+			t.Errorf("expected ErrFolderNotFound, got %v", err)
+		}
+	})
+}
+
+func assertIndexedSongsContain(t *testing.T, songs []music.IndexedSong, songPath string, wantTitle string) {
+	t.Helper()
+	for _, song := range songs {
+		if song.Path == songPath {
+			if song.Title != wantTitle {
+				t.Errorf("song %s title %s does not equal %s", songPath, song.Title, wantTitle)
+			}
+			return
+		}
+	}
+	t.Errorf("could not find wanted song %s in slice %v", songPath, songs)
+}
+
+// stubLibraryIndex keeps the index in memory, keyed by folder path
+type stubLibraryIndex struct {
+	folders           map[string][]music.SubFolder
+	songs             map[string][]music.IndexedSong
+	savedFolders      map[string]music.SubFolder
+	playlists         []music.PlaylistFile
+	covers            []music.Cover
+	hasEnded          bool
+	endedFolder       string   // Folder of the last scan of a folder
+	keptFolders       []string // Folders kept as they are in the index
+	shouldErrorOnSave bool
+}
+
+func newStubLibraryIndex() *stubLibraryIndex {
+	return &stubLibraryIndex{
+		folders:      make(map[string][]music.SubFolder),
+		songs:        make(map[string][]music.IndexedSong),
+		savedFolders: make(map[string]music.SubFolder),
+	}
+}
+
+func (s *stubLibraryIndex) BeginScan(_ context.Context) (int64, error) {
+	return 1, nil
+}
+
+func (s *stubLibraryIndex) SaveFolder(_ context.Context, _ int64, folder music.SubFolder, songs []music.IndexedSong) error {
+	if s.shouldErrorOnSave {
+		return errors.New("Could not save folder")
+	}
+	s.savedFolders[folder.Path] = folder
+	s.songs[folder.Path] = songs
+	return nil
+}
+
+func (s *stubLibraryIndex) SaveCover(_ context.Context, _ int64, cover music.Cover) (uint, error) {
+	s.covers = append(s.covers, cover)
+	return uint(len(s.covers)), nil
+}
+
+func (s *stubLibraryIndex) SavePlaylistFile(_ context.Context, _ int64, playlist music.PlaylistFile) error {
+	s.playlists = append(s.playlists, playlist)
+	return nil
+}
+
+func (s *stubLibraryIndex) KeepFolder(_ context.Context, _ int64, folderPath string) error {
+	s.keptFolders = append(s.keptFolders, folderPath)
+	return nil
+}
+
+func (s *stubLibraryIndex) EndScan(_ context.Context, _ int64) error {
+	s.hasEnded = true
+	return nil
+}
+
+func (s *stubLibraryIndex) EndFolderScan(_ context.Context, _ int64, folderPath string) error {
+	s.endedFolder = folderPath
+	return nil
+}
+
+func (s *stubLibraryIndex) ListFolder(_ context.Context, folderPath string) ([]music.SubFolder, []music.IndexedSong, error) {
+	folders, hasFolders := s.folders[folderPath]
+	songs, hasSongs := s.songs[folderPath]
+	if !hasFolders && !hasSongs {
+		return nil, nil, music.ErrFolderNotFound
+	}
+	return folders, songs, nil
+}
+
+func (s *stubLibraryIndex) GetSong(_ context.Context, _ uint) (*music.IndexedSong, error) {
+	return nil, music.ErrSongNotFound
+}
+
+// fsWithUnreadableFolder returns an error when reading the given folder
+type fsWithUnreadableFolder struct {
+	fstest.MapFS
+	unreadable string
+}
+
+func (f *fsWithUnreadableFolder) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == f.unreadable {
+		return nil, fs.ErrPermission
+	}
+	return f.MapFS.ReadDir(name)
+}
@@ -0,0 +1,217 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrScrobblingTokenRejected is returned when the scrobbling service does not accept the token of a user
+	ErrScrobblingTokenRejected = errors.New("the scrobbling service rejected the token")
+	// ErrScrobblingUnavailable is returned when the scrobbling service cannot be reached or asks to try again later
+	ErrScrobblingUnavailable = errors.New("the scrobbling service is unavailable")
+)
+
+const (
+	// maximumScrobbleBatch is the number of listens of a user submitted at once
+	maximumScrobbleBatch = 100
+	// firstScrobbleRetryDelay is how long a listen waits after a first failed submission. It doubles after every failure.
+	firstScrobbleRetryDelay = time.Minute
+	// maximumScrobbleRetryDelay bounds the delay between two submissions of a listen
+	maximumScrobbleRetryDelay = 6 * time.Hour
+)
+
+// Listen represents a song a user listened to, as it is submitted to a scrobbling service
+type Listen struct {
+	Song       Song
+	ListenedAt time.Time // When the user started listening to the song
+}
+
+// Scrobbler forwards the songs users listen to to a scrobbling service, such as ListenBrainz.
+// Users authenticate to the service with their own token.
+type Scrobbler interface {
+	// NowPlaying tells the service that the user just started listening to the song
+	NowPlaying(ctx context.Context, token string, song Song) error
+	// Submit saves the listens in the history of the user on the service. It returns ErrScrobblingTokenRejected when
+	// the token is not valid and ErrScrobblingUnavailable when the listens should be submitted again later.
+	Submit(ctx context.Context, token string, listens []Listen) error
+}
+
+// NowPlayingNotifier is told about the songs users start listening to
+type NowPlayingNotifier interface {
+	// NowPlaying tells that the user started listening to the song at songPath in the music library
+	NowPlaying(ctx context.Context, userID uint, songPath string) error
+}
+
+// PendingScrobble is a play waiting to be submitted to the scrobbling service
+type PendingScrobble struct {
+	ID       uint // Identifier of the pending scrobble. For example 42
+	UserID   uint
+	Token    string // Scrobbling token of the user
+	Listen   Listen
+	Attempts uint // Number of failed submissions
+}
+
+// ScrobbleStore saves the scrobbling tokens of users and the queue of their plays waiting to be submitted.
+// Plays of users who have a token are queued when they are recorded.
+type ScrobbleStore interface {
+	// GetScrobblingToken returns the token of the user. It is empty when the user does not scrobble.
+	GetScrobblingToken(ctx context.Context, userID uint) (string, error)
+	// SaveScrobblingToken replaces the token of the user. An empty token stops scrobbling and empties the user's queue.
+	SaveScrobblingToken(ctx context.Context, userID uint, token string) error
+	// ListPendingScrobbles returns the queued plays that can be submitted at now, oldest first
+	ListPendingScrobbles(ctx context.Context, now time.Time, limit uint) ([]PendingScrobble, error)
+	// DeleteScrobbles removes submitted plays from the queue
+	DeleteScrobbles(ctx context.Context, scrobbleIDs []uint) error
+	// PostponeScrobbles counts a failed submission of the plays and keeps them in the queue until retryAt
+	PostponeScrobbles(ctx context.Context, scrobbleIDs []uint, retryAt time.Time) error
+	// GetSongOfPath returns the song at songPath in the music library. It returns ErrSongNotFound when there is no such song.
+	GetSongOfPath(ctx context.Context, songPath string) (*Song, error)
+}
+
+// ScrobbleSubmitter forwards the plays of the users who have a scrobbling token to the Scrobbler.
+// Plays that cannot be submitted, for example while the server is offline, are retried later with a growing delay.
+// It implements NowPlayingNotifier.
+type ScrobbleSubmitter struct {
+	scrobbler Scrobbler
+	store     ScrobbleStore
+}
+
+// NewScrobbleSubmitter creates a new ScrobbleSubmitter
+func NewScrobbleSubmitter(scrobbler Scrobbler, store ScrobbleStore) *ScrobbleSubmitter {
+	return &ScrobbleSubmitter{scrobbler, store}
+}
+
+// NowPlaying tells the scrobbling service that the user started listening to the song at songPath.
+// It does nothing when the user has no scrobbling token. "Now playing" notifications are not retried.
+func (s *ScrobbleSubmitter) NowPlaying(ctx context.Context, userID uint, songPath string) error {
+	token, err := s.store.GetScrobblingToken(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not retrieve the scrobbling token of user #%d: %w", userID, err)
+	}
+	if token == "" {
+		return nil
+	}
+	song, err := s.store.GetSongOfPath(ctx, songPath)
+	if err != nil {
+		return fmt.Errorf("could not retrieve the song %s: %w", songPath, err)
+	}
+	if err = s.scrobbler.NowPlaying(ctx, token, *song); err != nil {
+		return fmt.Errorf("could not notify that user #%d is playing %s: %w", userID, songPath, err)
+	}
+	return nil
+}
+
+// ScrobbleReport summarizes a submission of the queued plays
+type ScrobbleReport struct {
+	Submitted int     // Number of plays submitted
+	Postponed int     // Number of plays that could not be submitted and stay in the queue
+	Dropped   int     // Number of plays that could not be submitted and were removed from the queue
+	Failures  []error // Why the postponed or dropped plays could not be submitted, one error per user
+}
+
+// SubmitPending submits the queued plays that are due at now, in batches per user. A user whose submission fails
+// does not prevent the other users' plays from being submitted. When the service is unavailable, the plays are
+// postponed. When it rejects the token of the user, the token is removed, which stops scrobbling and empties
+// the user's queue. Plays rejected for any other reason would be rejected again, they are dropped.
+func (s *ScrobbleSubmitter) SubmitPending(ctx context.Context, now time.Time) (*ScrobbleReport, error) {
+	pending, err := s.store.ListPendingScrobbles(ctx, now, maximumScrobbleBatch)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve the pending scrobbles: %w", err)
+	}
+	var (
+		byUser  = make(map[uint][]PendingScrobble)
+		userIDs []uint
+		report  = &ScrobbleReport{}
+	)
+	for _, scrobble := range pending {
+		if _, ok := byUser[scrobble.UserID]; !ok {
+			userIDs = append(userIDs, scrobble.UserID)
+		}
+		byUser[scrobble.UserID] = append(byUser[scrobble.UserID], scrobble)
+	}
+	for _, userID := range userIDs {
+		scrobbles := byUser[userID]
+		ids := make([]uint, 0, len(scrobbles))
+		listens := make([]Listen, 0, len(scrobbles))
+		for _, scrobble := range scrobbles {
+			ids = append(ids, scrobble.ID)
+			listens = append(listens, scrobble.Listen)
+		}
+		submitErr := s.scrobbler.Submit(ctx, scrobbles[0].Token, listens)
+		if submitErr != nil {
+			report.Failures = append(report.Failures, fmt.Errorf("could not submit the listens of user #%d: %w", userID, submitErr))
+			if err = s.handleFailure(ctx, userID, scrobbles, ids, submitErr, now, report); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err = s.store.DeleteScrobbles(ctx, ids); err != nil {
+			return report, fmt.Errorf("could not remove the submitted scrobbles of user #%d: %w", userID, err)
+		}
+		report.Submitted += len(ids)
+	}
+	return report, nil
+}
+
+// handleFailure postpones, or drops, the scrobbles of the user whose submission failed with submitErr
+func (s *ScrobbleSubmitter) handleFailure(
+	ctx context.Context,
+	userID uint,
+	scrobbles []PendingScrobble,
+	ids []uint,
+	submitErr error,
+	now time.Time,
+	report *ScrobbleReport,
+) error {
+	switch {
+	case errors.Is(submitErr, ErrScrobblingUnavailable):
+		retryAt := now.Add(scrobbleRetryDelay(scrobbles[0].Attempts))
+		if err := s.store.PostponeScrobbles(ctx, ids, retryAt); err != nil {
+			return fmt.Errorf("could not postpone the scrobbles of user #%d: %w", userID, err)
+		}
+		report.Postponed += len(ids)
+	case errors.Is(submitErr, ErrScrobblingTokenRejected):
+		if err := s.store.SaveScrobblingToken(ctx, userID, ""); err != nil {
+			return fmt.Errorf("could not remove the rejected scrobbling token of user #%d: %w", userID, err)
+		}
+		report.Dropped += len(ids)
+	default:
+		if err := s.store.DeleteScrobbles(ctx, ids); err != nil {
+			return fmt.Errorf("could not remove the rejected scrobbles of user #%d: %w", userID, err)
+		}
+		report.Dropped += len(ids)
+	}
+	return nil
+}
+
+// scrobbleRetryDelay returns how long to wait before submitting again a listen that failed attempts times already
+func scrobbleRetryDelay(attempts uint) time.Duration {
+	delay := firstScrobbleRetryDelay
+	for i := uint(0); i < attempts && delay < maximumScrobbleRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maximumScrobbleRetryDelay {
+		return maximumScrobbleRetryDelay
+	}
+	return delay
+}
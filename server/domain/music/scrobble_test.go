@@ -0,0 +1,196 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestScrobbleSubmitter(t *testing.T) {
+	ctx := context.Background()
+	monday := time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC)
+	ghost := music.Song{ID: 1, Title: "Ghost Love Score", Artist: "Nightwish"}
+	newStore := func() *stubScrobbleStore {
+		return &stubScrobbleStore{
+			tokens: map[uint]string{1: "first", 2: "second"},
+			pending: []music.PendingScrobble{
+				{ID: 10, UserID: 1, Token: "first", Listen: music.Listen{Song: ghost, ListenedAt: monday}},
+				{ID: 11, UserID: 2, Token: "second", Listen: music.Listen{Song: ghost, ListenedAt: monday}, Attempts: 2},
+				{ID: 12, UserID: 1, Token: "first", Listen: music.Listen{Song: ghost, ListenedAt: monday.Add(time.Hour)}},
+			},
+		}
+	}
+
+	t.Run("it submits the pending listens in one batch per user and removes them from the queue", func(t *testing.T) {
+		store := newStore()
+		scrobbler := &stubScrobbler{}
+		submitter := music.NewScrobbleSubmitter(scrobbler, store)
+
+		report, err := submitter.SubmitPending(ctx, monday)
+		tests.AssertNoError(t, err)
+
+		if report.Submitted != 3 || report.Postponed != 0 || len(scrobbler.submitted["first"]) != 2 || len(scrobbler.submitted["second"]) != 1 {
+			t.Errorf("unexpected report %+v and submissions %+v", report, scrobbler.submitted)
+		}
+		if len(store.deleted) != 3 {
+			t.Errorf("expected the submitted scrobbles to be deleted, got %v", store.deleted)
+		}
+	})
+
+	t.Run("when the service is unavailable, it postpones the listens of this user with a growing delay", func(t *testing.T) {
+		store := newStore()
+		scrobbler := &stubScrobbler{failingTokens: map[string]error{"second": music.ErrScrobblingUnavailable}}
+		submitter := music.NewScrobbleSubmitter(scrobbler, store)
+
+		report, err := submitter.SubmitPending(ctx, monday)
+		tests.AssertNoError(t, err)
+
+		if report.Submitted != 2 || report.Postponed != 1 || len(report.Failures) != 1 ||
+			!errors.Is(report.Failures[0], music.ErrScrobblingUnavailable) {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if len(store.postponed) != 1 || store.postponed[0] != 11 || !store.retryAt.Equal(monday.Add(4*time.Minute)) {
+			t.Errorf("expected the scrobble #11 to be postponed 4 minutes, got %v until %v", store.postponed, store.retryAt)
+		}
+	})
+
+	t.Run("when the service rejects the token of a user, it removes the token and drops the user's listens", func(t *testing.T) {
+		store := newStore()
+		scrobbler := &stubScrobbler{failingTokens: map[string]error{"first": music.ErrScrobblingTokenRejected}}
+		submitter := music.NewScrobbleSubmitter(scrobbler, store)
+
+		report, err := submitter.SubmitPending(ctx, monday)
+		tests.AssertNoError(t, err)
+
+		if report.Submitted != 1 || report.Postponed != 0 || report.Dropped != 2 || len(report.Failures) != 1 ||
+			!errors.Is(report.Failures[0], music.ErrScrobblingTokenRejected) {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if store.tokens[1] != "" || store.tokens[2] != "second" {
+			t.Errorf("expected only the rejected token to be removed, got %v", store.tokens)
+		}
+		if len(store.postponed) != 0 {
+			t.Errorf("expected no scrobble to be postponed, got %v", store.postponed)
+		}
+	})
+
+	t.Run("when the service rejects the listens for another reason, it drops them instead of retrying", func(t *testing.T) {
+		store := newStore()
+		scrobbler := &stubScrobbler{failingTokens: map[string]error{"second": errors.New("could not submit the listens, 400 Bad Request")}}
+		submitter := music.NewScrobbleSubmitter(scrobbler, store)
+
+		report, err := submitter.SubmitPending(ctx, monday)
+		tests.AssertNoError(t, err)
+
+		if report.Submitted != 2 || report.Postponed != 0 || report.Dropped != 1 || len(report.Failures) != 1 {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if len(store.postponed) != 0 || len(store.deleted) != 3 || store.tokens[2] != "second" {
+			t.Errorf("expected the scrobble #11 to be deleted and the token kept, got %v deleted and %v", store.deleted, store.tokens)
+		}
+	})
+
+	t.Run("it notifies the song the user is playing", func(t *testing.T) {
+		scrobbler := &stubScrobbler{}
+		submitter := music.NewScrobbleSubmitter(scrobbler, newStore())
+
+		tests.AssertNoError(t, submitter.NowPlaying(ctx, 1, "music/Nightwish/Once/ghost.flac"))
+
+		if scrobbler.nowPlaying["first"].ID != ghost.ID {
+			t.Errorf("expected the song to be notified with the user's token, got %+v", scrobbler.nowPlaying)
+		}
+	})
+
+	t.Run("when the user has no token, it does not notify the song the user is playing", func(t *testing.T) {
+		scrobbler := &stubScrobbler{}
+		submitter := music.NewScrobbleSubmitter(scrobbler, newStore())
+
+		tests.AssertNoError(t, submitter.NowPlaying(ctx, 3, "music/Nightwish/Once/ghost.flac"))
+
+		if len(scrobbler.nowPlaying) != 0 {
+			t.Errorf("expected no notification, got %+v", scrobbler.nowPlaying)
+		}
+	})
+}
+
+type stubScrobbler struct {
+	failingTokens map[string]error
+	submitted     map[string][]music.Listen
+	nowPlaying    map[string]music.Song
+}
+
+func (s *stubScrobbler) NowPlaying(_ context.Context, token string, song music.Song) error {
+	if s.nowPlaying == nil {
+		s.nowPlaying = make(map[string]music.Song)
+	}
+	s.nowPlaying[token] = song
+	return nil
+}
+
+func (s *stubScrobbler) Submit(_ context.Context, token string, listens []music.Listen) error {
+	if err := s.failingTokens[token]; err != nil {
+		return err
+	}
+	if s.submitted == nil {
+		s.submitted = make(map[string][]music.Listen)
+	}
+	s.submitted[token] = append(s.submitted[token], listens...)
+	return nil
+}
+
+type stubScrobbleStore struct {
+	tokens    map[uint]string
+	pending   []music.PendingScrobble
+	deleted   []uint
+	postponed []uint
+	retryAt   time.Time
+}
+
+func (s *stubScrobbleStore) GetScrobblingToken(_ context.Context, userID uint) (string, error) {
+	return s.tokens[userID], nil
+}
+
+func (s *stubScrobbleStore) SaveScrobblingToken(_ context.Context, userID uint, token string) error {
+	s.tokens[userID] = token
+	return nil
+}
+
+func (s *stubScrobbleStore) ListPendingScrobbles(_ context.Context, _ time.Time, _ uint) ([]music.PendingScrobble, error) {
+	return s.pending, nil
+}
+
+func (s *stubScrobbleStore) DeleteScrobbles(_ context.Context, scrobbleIDs []uint) error {
+	s.deleted = append(s.deleted, scrobbleIDs...)
+	return nil
+}
+
+func (s *stubScrobbleStore) PostponeScrobbles(_ context.Context, scrobbleIDs []uint, retryAt time.Time) error {
+	s.postponed = append(s.postponed, scrobbleIDs...)
+	s.retryAt = retryAt
+	return nil
+}
+
+func (s *stubScrobbleStore) GetSongOfPath(_ context.Context, _ string) (*music.Song, error) {
+	return &music.Song{ID: 1, Title: "Ghost Love Score", Artist: "Nightwish"}, nil
+}
@@ -0,0 +1,241 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptySearch is returned when the search query does not contain any term
+var ErrEmptySearch = errors.New("the search query does not contain any term")
+
+// Matches in a title weigh more than matches in an artist name, etc.
+const (
+	titleWeight  = 8
+	artistWeight = 4
+	albumWeight  = 2
+	folderWeight = 1
+)
+
+// SearchQuery represents a search in the music library and the page of results to return
+type SearchQuery struct {
+	Terms  string // What the user typed. For example "nightwish ghost"
+	Limit  uint   // Maximum number of songs, albums and artists to return
+	Offset uint   // Number of songs, albums and artists to skip
+}
+
+// Album represents a group of songs sharing the same album and artist tags
+type Album struct {
+	Name      string // Name of the album. For example "Once"
+	Artist    string // Name of the album's artist. For example "Nightwish"
+	SongCount uint   // Number of songs of the album matching the search
+}
+
+// Artist represents a group of songs sharing the same artist tag
+type Artist struct {
+	Name      string // Name of the artist. For example "Nightwish"
+	SongCount uint   // Number of songs of the artist matching the search
+}
+
+// SearchResults represents one page of ranked search results. Totals count all the results, not only this page.
+type SearchResults struct {
+	Songs        []Song
+	Albums       []Album
+	Artists      []Artist
+	TotalSongs   uint
+	TotalAlbums  uint
+	TotalArtists uint
+}
+
+// SearchIndex finds the songs whose title, artist, album or folder name contain words
+// starting with every one of the given terms.
+type SearchIndex interface {
+	FindSongs(ctx context.Context, terms []string) ([]IndexedSong, error)
+}
+
+// Searcher searches the music library
+type Searcher interface {
+	// Search returns the songs, albums and artists matching the query, best matches first.
+	// It returns ErrEmptySearch when the query has no terms.
+	Search(ctx context.Context, query SearchQuery) (*SearchResults, error)
+}
+
+// baseSearcher implements Searcher
+type baseSearcher struct {
+	index SearchIndex
+}
+
+// NewSearcher creates a new Searcher
+func NewSearcher(index SearchIndex) Searcher {
+	return &baseSearcher{index}
+}
+
+func (b *baseSearcher) Search(ctx context.Context, query SearchQuery) (*SearchResults, error) {
+	terms := splitSearchTerms(query.Terms)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+	candidates, err := b.index.FindSongs(ctx, terms)
+	if err != nil {
+		return nil, fmt.Errorf("could not search for %v in the library index: %w", terms, err)
+	}
+
+	var (
+		songs   []rankedSong
+		albums  = make(map[Album]*rankedAlbum)
+		artists = make(map[string]*rankedArtist)
+	)
+	for _, candidate := range candidates {
+		folderName := path.Base(candidate.FolderPath())
+		titleScore := matchScore(terms, candidate.Title)
+		artistScore := matchScore(terms, candidate.Artist)
+		albumScore := matchScore(terms, candidate.Album)
+		score := titleWeight*titleScore + artistWeight*artistScore + albumWeight*albumScore +
+			folderWeight*matchScore(terms, folderName)
+		songs = append(songs, rankedSong{candidate.Song, score})
+
+		if albumScore > 0 && candidate.Album != "" {
+			key := Album{Name: candidate.Album, Artist: candidate.Artist}
+			if albums[key] == nil {
+				albums[key] = &rankedAlbum{Album: key}
+			}
+			albums[key].SongCount++
+			albums[key].score = math.Max(albums[key].score, albumScore)
+		}
+		if artistScore > 0 && candidate.Artist != "" {
+			if artists[candidate.Artist] == nil {
+				artists[candidate.Artist] = &rankedArtist{Artist: Artist{Name: candidate.Artist}}
+			}
+			artists[candidate.Artist].SongCount++
+			artists[candidate.Artist].score = math.Max(artists[candidate.Artist].score, artistScore)
+		}
+	}
+
+	results := &SearchResults{
+		TotalSongs:   uint(len(songs)),
+		TotalAlbums:  uint(len(albums)),
+		TotalArtists: uint(len(artists)),
+	}
+	sort.SliceStable(songs, func(i, j int) bool {
+		return ranksBefore(songs[i].score, songs[i].Title, songs[j].score, songs[j].Title)
+	})
+	start, end := pageBounds(len(songs), query)
+	for _, song := range songs[start:end] {
+		results.Songs = append(results.Songs, song.Song)
+	}
+
+	sortedAlbums := make([]*rankedAlbum, 0, len(albums))
+	for _, album := range albums {
+		sortedAlbums = append(sortedAlbums, album)
+	}
+	sort.Slice(sortedAlbums, func(i, j int) bool {
+		a, b := sortedAlbums[i], sortedAlbums[j]
+		if a.score == b.score && strings.EqualFold(a.Name, b.Name) {
+			return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+		}
+		return ranksBefore(a.score, a.Name, b.score, b.Name)
+	})
+	start, end = pageBounds(len(sortedAlbums), query)
+	for _, album := range sortedAlbums[start:end] {
+		results.Albums = append(results.Albums, album.Album)
+	}
+
+	sortedArtists := make([]*rankedArtist, 0, len(artists))
+	for _, artist := range artists {
+		sortedArtists = append(sortedArtists, artist)
+	}
+	sort.Slice(sortedArtists, func(i, j int) bool {
+		a, b := sortedArtists[i], sortedArtists[j]
+		return ranksBefore(a.score, a.Name, b.score, b.Name)
+	})
+	start, end = pageBounds(len(sortedArtists), query)
+	for _, artist := range sortedArtists[start:end] {
+		results.Artists = append(results.Artists, artist.Artist)
+	}
+	return results, nil
+}
+
+type rankedSong struct {
+	Song
+	score float64
+}
+
+type rankedAlbum struct {
+	Album
+	score float64
+}
+
+type rankedArtist struct {
+	Artist
+	score float64
+}
+
+// ranksBefore orders results by descending score, then by name
+func ranksBefore(scoreA float64, nameA string, scoreB float64, nameB string) bool {
+	if scoreA != scoreB {
+		return scoreA > scoreB
+	}
+	return strings.ToLower(nameA) < strings.ToLower(nameB)
+}
+
+// pageBounds returns the start and end indices of the page of results described by query
+func pageBounds(length int, query SearchQuery) (int, int) {
+	if query.Offset >= uint(length) {
+		return length, length
+	}
+	end := uint(length)
+	if query.Limit > 0 && query.Offset+query.Limit < end {
+		end = query.Offset + query.Limit
+	}
+	return int(query.Offset), int(end)
+}
+
+// splitSearchTerms lowercases the query and splits it into words
+func splitSearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchScore returns how well the terms match text: one point per term that starts a word of text,
+// one more point when the term is the whole word. It returns zero when no term matches.
+func matchScore(terms []string, text string) float64 {
+	words := splitSearchTerms(text)
+	var score float64
+	for _, term := range terms {
+		best := 0.0
+		for _, word := range words {
+			if word == term {
+				best = 2
+				break
+			}
+			if strings.HasPrefix(word, term) {
+				best = 1
+			}
+		}
+		score += best
+	}
+	return score
+}
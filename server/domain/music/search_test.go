@@ -0,0 +1,107 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestSearcher(t *testing.T) {
+	nemo := music.IndexedSong{Song: music.Song{ID: 1, Title: "Nemo", Artist: "Nightwish", Album: "Once"}, Path: "Nightwish/Once/nemo.mp3"}
+	ghost := music.IndexedSong{Song: music.Song{ID: 2, Title: "Ghost Love Score", Artist: "Nightwish", Album: "Once"}, Path: "Nightwish/Once/ghost.mp3"}
+	nightfall := music.IndexedSong{Song: music.Song{ID: 3, Title: "Nightfall", Artist: "Blind Guardian", Album: "Nightfall in Middle-Earth"}, Path: "Blind Guardian/nightfall.flac"}
+	index := &stubSearchIndex{songs: []music.IndexedSong{nemo, ghost, nightfall}}
+
+	t.Run("it ranks title matches before artist and album matches", func(t *testing.T) {
+		searcher := music.NewSearcher(index)
+
+		results, err := searcher.Search(context.Background(), music.SearchQuery{Terms: "Nightfall"})
+
+		tests.AssertNoError(t, err)
+		if results.TotalSongs != 3 || results.Songs[0].Title != "Nightfall" {
+			t.Errorf("expected Nightfall to be the best match, got %+v", results.Songs)
+		}
+		if index.terms[0] != "nightfall" {
+			t.Errorf("expected the terms to be lowercased, got %v", index.terms)
+		}
+	})
+
+	t.Run("it groups the matching songs into albums and artists", func(t *testing.T) {
+		searcher := music.NewSearcher(index)
+
+		results, err := searcher.Search(context.Background(), music.SearchQuery{Terms: "night"})
+
+		tests.AssertNoError(t, err)
+		if results.TotalArtists != 1 || results.Artists[0] != (music.Artist{Name: "Nightwish", SongCount: 2}) {
+			t.Errorf("unexpected artists %+v", results.Artists)
+		}
+		if results.TotalAlbums != 1 || results.Albums[0].Name != "Nightfall in Middle-Earth" {
+			t.Errorf("unexpected albums %+v", results.Albums)
+		}
+	})
+
+	t.Run("it returns the page of results described by the query", func(t *testing.T) {
+		searcher := music.NewSearcher(index)
+
+		results, err := searcher.Search(context.Background(), music.SearchQuery{Terms: "night", Limit: 1, Offset: 1})
+
+		tests.AssertNoError(t, err)
+		if results.TotalSongs != 3 || len(results.Songs) != 1 {
+			t.Errorf("expected one song out of three, got %+v", results)
+		}
+		if len(results.Artists) != 0 {
+			t.Errorf("expected the single artist to be on the first page, got %+v", results.Artists)
+		}
+	})
+
+	t.Run("given a query without any word, it returns ErrEmptySearch", func(t *testing.T) {
+		searcher := music.NewSearcher(index)
+
+		_, err := searcher.Search(context.Background(), music.SearchQuery{Terms: " - "})
+		if !errors.Is(err, music.ErrEmptySearch) {
+			t.Errorf("expected ErrEmptySearch, got %v", err)
+		}
+	})
+
+	t.Run("when the index cannot be searched, it returns an error", func(t *testing.T) {
+		searcher := music.NewSearcher(&stubSearchIndex{shouldError: true})
+
+		_, err := searcher.Search(context.Background(), music.SearchQuery{Terms: "nemo"})
+		tests.AssertError(t, err)
+	})
+}
+
+// stubSearchIndex returns all its songs, whatever the terms
+type stubSearchIndex struct {
+	songs       []music.IndexedSong
+	terms       []string
+	shouldError bool
+}
+
+func (s *stubSearchIndex) FindSongs(_ context.Context, terms []string) ([]music.IndexedSong, error) {
+	s.terms = terms
+	if s.shouldError {
+		return nil, errors.New("This error should be expected in tests")
+	}
+	return s.songs, nil
+}
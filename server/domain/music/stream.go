@@ -0,0 +1,78 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ErrNotASong is returned when a file of the music library is not a song, for example a cover image
+var ErrNotASong = errors.New("the file is not a song")
+
+// SongFile is a song of the music library opened to be streamed. It must be closed.
+type SongFile struct {
+	fs.File
+	Path             string // Path of the song in the music library. For example "music/Nightwish/Once/ghost.mp3"
+	Type             string // MIME type of the song. For example "audio/mpeg"
+	Size             int64  // Size of the file in bytes
+	ModificationTime time.Time
+}
+
+// OpenSongFile opens the song at songPath in the music library. It returns ErrSongNotFound when there is
+// no file at songPath, including for folders and for paths leading outside of the library, and ErrNotASong
+// when the file is not a song.
+func OpenSongFile(filesystem fs.FS, songPath string) (*SongFile, error) {
+	if !fs.ValidPath(songPath) || songPath == "." {
+		return nil, fmt.Errorf("invalid path %q: %w", songPath, ErrSongNotFound)
+	}
+	file, err := filesystem.Open(songPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not open %s: %w", songPath, ErrSongNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", songPath, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not read the information of %s: %w", songPath, err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("%s is a folder: %w", songPath, ErrSongNotFound)
+	}
+	format, ok := audioFormatOfFileName(info.Name())
+	if seeker, isSeeker := file.(io.ReadSeeker); isSeeker {
+		format, ok = detectAudioFormat(seeker, info.Name())
+	}
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("could not open %s: %w", songPath, ErrNotASong)
+	}
+	return &SongFile{
+		File:             file,
+		Path:             songPath,
+		Type:             format.MIMEType,
+		Size:             info.Size(),
+		ModificationTime: info.ModTime(),
+	}, nil
+}
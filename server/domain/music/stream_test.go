@@ -0,0 +1,76 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestOpenSongFile(t *testing.T) {
+	modificationTime := time.Date(2021, time.May, 8, 12, 0, 0, 0, time.UTC)
+	testFS := fstest.MapFS{
+		"music/Nightwish/ghost.flac": {Data: []byte("flac"), ModTime: modificationTime},
+		"music/Nightwish/cover.jpg":  {Data: []byte("jpeg")},
+		"music/folder.mp3":           {Mode: fs.ModeDir | 0o755},
+	}
+
+	t.Run("it opens the song with its MIME type, size and modification time", func(t *testing.T) {
+		song, err := music.OpenSongFile(testFS, "music/Nightwish/ghost.flac")
+		tests.AssertNoError(t, err)
+		defer song.Close()
+
+		if song.Type != "audio/flac" || song.Size != 4 || !song.ModificationTime.Equal(modificationTime) {
+			t.Errorf("unexpected song file %+v", song)
+		}
+		contents, err := io.ReadAll(song)
+		tests.AssertNoError(t, err)
+		if string(contents) != "flac" {
+			t.Errorf("expected the contents of the song, got %q", contents)
+		}
+	})
+
+	t.Run("given a file that is not a song, it returns ErrNotASong", func(t *testing.T) {
+		_, err := music.OpenSongFile(testFS, "music/Nightwish/cover.jpg")
+		if !errors.Is(err, music.ErrNotASong) {
+			t.Errorf("expected ErrNotASong, got %v", err)
+		}
+	})
+
+	for name, songPath := range map[string]string{
+		"a missing file":          "music/Nightwish/missing.mp3",
+		"a folder":                "music/folder.mp3",
+		"a path outside the root": "../etc/song.mp3",
+		"an absolute path":        "/music/Nightwish/ghost.flac",
+		"the top-level folder":    ".",
+	} {
+		t.Run("given "+name+", it returns ErrSongNotFound", func(t *testing.T) {
+			_, err := music.OpenSongFile(testFS, songPath)
+			if !errors.Is(err, music.ErrSongNotFound) {
+				t.Errorf("expected ErrSongNotFound, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LibrarySynchronizer keeps the library index in sync with the music library. When files change, it scans again
+// the folders containing them once no other change happened for a while, so that copying an album results in a
+// single scan. It also scans the whole library periodically, for the file systems that do not notify their changes.
+type LibrarySynchronizer struct {
+	scanner          Scanner
+	filesystem       MusicLibraryFileSystem
+	settleDelay      time.Duration
+	fullScanInterval time.Duration
+	onScan           func(folderPath string, report *ScanReport, err error)
+}
+
+// NewLibrarySynchronizer creates a new LibrarySynchronizer. Folders are scanned settleDelay after the last change.
+// A zero fullScanInterval disables the periodic scans of the whole library. onScan is called after every scan,
+// with "." as folderPath for the scans of the whole library.
+func NewLibrarySynchronizer(
+	scanner Scanner,
+	filesystem MusicLibraryFileSystem,
+	settleDelay time.Duration,
+	fullScanInterval time.Duration,
+	onScan func(folderPath string, report *ScanReport, err error),
+) *LibrarySynchronizer {
+	return &LibrarySynchronizer{scanner, filesystem, settleDelay, fullScanInterval, onScan}
+}
+
+// Run scans the whole library, then keeps the index in sync until ctx is done. changedPaths receives the paths
+// of the files and folders of the library that were created, written, moved or removed. It can be nil when
+// changes are not watched, the index is then only updated by the periodic scans.
+func (s *LibrarySynchronizer) Run(ctx context.Context, changedPaths <-chan string) {
+	s.scan(ctx, ".")
+	var fullScans <-chan time.Time
+	if s.fullScanInterval > 0 {
+		ticker := time.NewTicker(s.fullScanInterval)
+		defer ticker.Stop()
+		fullScans = ticker.C
+	}
+	settled := time.NewTimer(s.settleDelay)
+	defer settled.Stop()
+	stopTimer(settled)
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case changedPath, ok := <-changedPaths:
+			if !ok {
+				changedPaths = nil
+				continue
+			}
+			pending[changedPath] = true
+			stopTimer(settled)
+			settled.Reset(s.settleDelay)
+		case <-settled.C:
+			for _, folderPath := range foldersToScan(s.filesystem, pending) {
+				s.scan(ctx, folderPath)
+			}
+			pending = make(map[string]bool)
+		case <-fullScans:
+			s.scan(ctx, ".")
+			pending = make(map[string]bool)
+			stopTimer(settled)
+		}
+	}
+}
+
+func (s *LibrarySynchronizer) scan(ctx context.Context, folderPath string) {
+	report, err := s.scanner.ScanFolder(ctx, folderPath)
+	s.onScan(folderPath, report, err)
+}
+
+// stopTimer stops the timer and drains its channel, so that it can be reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// foldersToScan returns the folders to scan again for the changed files and folders: changed folders themselves,
+// and the parent folder of changed files and of removed folders. Folders inside another folder to scan are left out.
+func foldersToScan(filesystem fs.FS, changedPaths map[string]bool) []string {
+	candidates := make([]string, 0, len(changedPaths))
+	for changedPath := range changedPaths {
+		changedPath = path.Clean(changedPath)
+		if info, err := fs.Stat(filesystem, changedPath); err == nil && info.IsDir() {
+			candidates = append(candidates, changedPath)
+		} else {
+			candidates = append(candidates, path.Dir(changedPath))
+		}
+	}
+	// Parent folders sort before their sub-folders
+	sort.Strings(candidates)
+	var folders []string
+	for _, candidate := range candidates {
+		if !isInAnyFolder(candidate, folders) {
+			folders = append(folders, candidate)
+		}
+	}
+	return folders
+}
+
+func isInAnyFolder(filePath string, folders []string) bool {
+	for _, folder := range folders {
+		if folder == "." || filePath == folder || strings.HasPrefix(filePath, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
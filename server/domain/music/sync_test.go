@@ -0,0 +1,122 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+)
+
+func TestLibrarySynchronizer(t *testing.T) {
+	testFS := fstest.MapFS{
+		"music/Nightwish/Once/ghost.mp3":   {},
+		"music/Nightwish/Century/nemo.mp3": {},
+		"music/Epica/kingdom.mp3":          {},
+	}
+
+	run := func(t *testing.T, fullScanInterval time.Duration) (chan<- string, <-chan string) {
+		changes := make(chan string)
+		scanned := make(chan string, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		synchronizer := music.NewLibrarySynchronizer(
+			&stubScanner{},
+			testFS,
+			20*time.Millisecond,
+			fullScanInterval,
+			func(folderPath string, _ *music.ScanReport, _ error) {
+				select {
+				case scanned <- folderPath:
+				default: // Periodic scans go on after the test
+				}
+			},
+		)
+		go synchronizer.Run(ctx, changes)
+		if got := receiveScan(t, scanned); got != "." {
+			t.Fatalf("expected a first scan of the whole library, got %q", got)
+		}
+		return changes, scanned
+	}
+
+	t.Run("once the changes settle, it scans the folders that changed", func(t *testing.T) {
+		changes, scanned := run(t, 0)
+
+		changes <- "music/Nightwish/Once/ghost.mp3"
+		changes <- "music/Nightwish/Once"
+		changes <- "music/Epica/removed.mp3"
+		changes <- "music/Epica/kingdom.mp3"
+
+		got := []string{receiveScan(t, scanned), receiveScan(t, scanned)}
+		sort.Strings(got)
+		if strings.Join(got, ",") != "music/Epica,music/Nightwish/Once" {
+			t.Errorf("expected one scan of each changed folder, got %v", got)
+		}
+		select {
+		case extra := <-scanned:
+			t.Errorf("expected no other scan, got %q", extra)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("it leaves out the folders inside another folder to scan", func(t *testing.T) {
+		changes, scanned := run(t, 0)
+
+		changes <- "music/Nightwish/Century/nemo.mp3"
+		changes <- "music/Nightwish"
+
+		if got := receiveScan(t, scanned); got != "music/Nightwish" {
+			t.Errorf("expected the scan of music/Nightwish, got %q", got)
+		}
+	})
+
+	t.Run("it scans the whole library periodically", func(t *testing.T) {
+		_, scanned := run(t, 10*time.Millisecond)
+
+		if got := receiveScan(t, scanned); got != "." {
+			t.Errorf("expected a periodic scan of the whole library, got %q", got)
+		}
+	})
+}
+
+func receiveScan(t *testing.T, scanned <-chan string) string {
+	t.Helper()
+	select {
+	case folderPath := <-scanned:
+		return folderPath
+	case <-time.After(time.Second):
+		t.Fatal("expected a scan")
+		return ""
+	}
+}
+
+// stubScanner does nothing
+type stubScanner struct{}
+
+func (s *stubScanner) Scan(_ context.Context) (*music.ScanReport, error) {
+	return &music.ScanReport{}, nil
+}
+
+func (s *stubScanner) ScanFolder(_ context.Context, _ string) (*music.ScanReport, error) {
+	return &music.ScanReport{}, nil
+}
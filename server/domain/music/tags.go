@@ -0,0 +1,133 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Tags represents the metadata read from a music file's tags (ID3 for MP3, Vorbis comments
+// for FLAC and Ogg). Fields are left empty (or zero) when the tag is absent from the file.
+type Tags struct {
+	Title       string   // Title of the song. For example "7 Days to the Wolves"
+	Artist      string   // Name of the main artist. For example "Nightwish"
+	Album       string   // Name of the album. For example "Dark Passion Play"
+	Genre       string   // Genre of the song. For example "Symphonic Metal"
+	TrackNumber uint     // Track number of the song in its disk. For example 3
+	DiskNumber  uint     // Disk number of the song. For example 1
+	Duration    uint     // Duration of the song in seconds. For example 423
+	Picture     *Picture // Picture embedded in the tags, preferably the front cover. Nil when there is none
+}
+
+// ErrUnsupportedFormat is returned when trying to read tags from a file whose format is not supported
+var ErrUnsupportedFormat = errors.New("unsupported music file format")
+
+// ReadTags reads the tags of the given music file. It recognises the file's format from fileName's
+// extension and from its first bytes, see AudioFormat. It returns ErrUnsupportedFormat when the format
+// is not supported or when its tags cannot be read, and an error when the file's contents cannot be parsed.
+func ReadTags(file io.ReadSeeker, fileName string) (*Tags, error) {
+	format, ok := detectAudioFormat(file, fileName)
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	return readTagsOfFormat(file, fileName, format)
+}
+
+// readTagsOfFormat works like ReadTags for a file whose format is already detected
+func readTagsOfFormat(file io.ReadSeeker, fileName string, format AudioFormat) (*Tags, error) {
+	if format.readTags == nil {
+		return nil, ErrUnsupportedFormat
+	}
+	tags, err := format.readTags(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the tags of %v: %w", fileName, err)
+	}
+	return tags, nil
+}
+
+// mergeMissing fills tags' empty fields with the ones from fallback
+func (t *Tags) mergeMissing(fallback *Tags) {
+	if t.Title == "" {
+		t.Title = fallback.Title
+	}
+	if t.Artist == "" {
+		t.Artist = fallback.Artist
+	}
+	if t.Album == "" {
+		t.Album = fallback.Album
+	}
+	if t.Genre == "" {
+		t.Genre = fallback.Genre
+	}
+	if t.TrackNumber == 0 {
+		t.TrackNumber = fallback.TrackNumber
+	}
+	if t.DiskNumber == 0 {
+		t.DiskNumber = fallback.DiskNumber
+	}
+	if t.Duration == 0 {
+		t.Duration = fallback.Duration
+	}
+	if t.Picture == nil {
+		t.Picture = fallback.Picture
+	}
+}
+
+// parsePositionNumber parses track or disk numbers. They can be "3" or "3/12" (third of twelve).
+// It returns zero when the number cannot be parsed.
+func parsePositionNumber(value string) uint {
+	value = strings.TrimSpace(value)
+	if index := strings.Index(value, "/"); index != -1 {
+		value = value[:index]
+	}
+	number, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(number)
+}
+
+// remainingBytes returns the number of bytes between the current offset and the end of the file
+func remainingBytes(file io.Seeker) (int64, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("could not read the offset in the file: %w", err)
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("could not seek to the end of the file: %w", err)
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not seek back in the file: %w", err)
+	}
+	return end - offset, nil
+}
+
+// readFull reads exactly n bytes from reader
+func readFull(reader io.Reader, n int) ([]byte, error) {
+	buffer := make([]byte, n)
+	_, err := io.ReadFull(reader, buffer)
+	if err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
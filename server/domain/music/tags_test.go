@@ -0,0 +1,338 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestReadTags(t *testing.T) {
+	t.Run("given an unsupported extension, it returns ErrUnsupportedFormat", func(t *testing.T) {
+		_, err := music.ReadTags(bytes.NewReader([]byte{}), "cover.jpg")
+		if !errors.Is(err, music.ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+
+	t.Run("given a file without tags, it returns an error", func(t *testing.T) {
+		_, err := music.ReadTags(bytes.NewReader([]byte("not music")), "empty.mp3")
+		tests.AssertError(t, err)
+	})
+
+	t.Run(`given an MP3 file with an ID3v2.3 tag and a Xing header,
+		it reads the tags and computes the duration from the frame count`, func(t *testing.T) {
+		data := newID3v23MP3(t)
+		got, err := music.ReadTags(bytes.NewReader(data), "song.mp3")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{
+			Title:       "Ghost Love Score",
+			Artist:      "Nightwish",
+			Album:       "Once",
+			TrackNumber: 10,
+			DiskNumber:  1,
+			Duration:    5,
+		})
+	})
+
+	t.Run(`given an MP3 file with an ID3v2.4 tag in UTF-16, it decodes the text frames`, func(t *testing.T) {
+		frames := append(
+			newID3v2Frame(t, 4, "TIT2", append([]byte{1, 0xFF, 0xFE}, utf16LE("Ōkami")...)),
+			newID3v2Frame(t, 4, "TPE1", append([]byte{3}, []byte("大神")...))...,
+		)
+		data := append(newID3v2Tag(4, frames), newCBRFrames(t, 16000)...)
+		got, err := music.ReadTags(bytes.NewReader(data), "song.MP3")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{Title: "Ōkami", Artist: "大神", Duration: 1})
+	})
+
+	t.Run("given an MP3 file with a genre frame, it resolves the references to ID3v1 genres", func(t *testing.T) {
+		for value, want := range map[string]string{
+			"Symphonic Metal":       "Symphonic Metal",
+			"9":                     "Metal",
+			"(9)":                   "Metal",
+			"(9)Symphonic Metal":    "Symphonic Metal",
+			"(RX)(17)":              "Remix",
+			"((Parenthesized) Rock": "(Parenthesized) Rock",
+			"(200)":                 "",
+		} {
+			frame := newID3v2Frame(t, 3, "TCON", append([]byte{0}, value...))
+			data := append(newID3v2Tag(3, frame), newCBRFrames(t, 16000)...)
+			got, err := music.ReadTags(bytes.NewReader(data), "song.mp3")
+
+			tests.AssertNoError(t, err)
+			if got.Genre != want {
+				t.Errorf("expected the genre %q to be read as %q, got %q", value, want, got.Genre)
+			}
+		}
+	})
+
+	t.Run(`given an MP3 file with only an ID3v1 tag, it reads the tags
+		and computes the duration from the constant bitrate`, func(t *testing.T) {
+		data := append(newCBRFrames(t, 160000), newID3v1Tag("Amaranth", "Nightwish", "Dark Passion Play", 4, 9)...)
+		got, err := music.ReadTags(bytes.NewReader(data), "song.mp3")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{
+			Title:       "Amaranth",
+			Artist:      "Nightwish",
+			Album:       "Dark Passion Play",
+			Genre:       "Metal",
+			TrackNumber: 4,
+			Duration:    10,
+		})
+	})
+
+	t.Run("given a FLAC file, it reads the Vorbis comments and the duration", func(t *testing.T) {
+		data := newFLAC(t, 44100, 44100*65, newVorbisComment(t,
+			"TITLE=Eva", "artist=Nightwish", "ALBUM=Dark Passion Play", "GENRE=Symphonic Metal", "TRACKNUMBER=5/13",
+			"DISCNUMBER=2",
+		))
+		got, err := music.ReadTags(bytes.NewReader(data), "song.flac")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{
+			Title:       "Eva",
+			Artist:      "Nightwish",
+			Album:       "Dark Passion Play",
+			Genre:       "Symphonic Metal",
+			TrackNumber: 5,
+			DiskNumber:  2,
+			Duration:    65,
+		})
+	})
+
+	t.Run("given an ID3v2 tag whose size is larger than the file, it returns an error", func(t *testing.T) {
+		data := append([]byte{'I', 'D', '3', 3, 0, 0}, synchsafe(200<<20)...)
+		data = append(data, newID3v2Frame(t, 3, "TIT2", []byte("\x00Eva"))...)
+
+		_, err := music.ReadTags(bytes.NewReader(data), "song.mp3")
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given a FLAC file with a truncated STREAMINFO block, it returns an error", func(t *testing.T) {
+		data := append([]byte("fLaC"), 0x80, 0, 0, 10)
+		data = append(data, make([]byte, 10)...)
+
+		_, err := music.ReadTags(bytes.NewReader(data), "song.flac")
+		if err == nil || strings.Contains(err.Error(), "%!w") {
+			t.Errorf("expected an error telling the block is too short, got %v", err)
+		}
+	})
+
+	t.Run("given a FLAC file without the stream marker, it returns an error", func(t *testing.T) {
+		_, err := music.ReadTags(bytes.NewReader([]byte("OggS")), "song.flac")
+		tests.AssertError(t, err)
+	})
+
+	t.Run("given an Ogg Vorbis file, it reads the Vorbis comments and the duration", func(t *testing.T) {
+		identification := make([]byte, 30)
+		copy(identification, "\x01vorbis")
+		binary.LittleEndian.PutUint32(identification[12:16], 44100)
+		comments := append([]byte("\x03vorbis"), newVorbisComment(t, "TITLE=Bye Bye Beautiful", "ARTIST=Nightwish")...)
+		data := newOgg(t, identification, comments, 44100*30)
+		got, err := music.ReadTags(bytes.NewReader(data), "song.ogg")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{Title: "Bye Bye Beautiful", Artist: "Nightwish", Duration: 30})
+	})
+
+	t.Run("given an Ogg Opus file, it reads the comments and the duration", func(t *testing.T) {
+		identification := make([]byte, 19)
+		copy(identification, "OpusHead")
+		binary.LittleEndian.PutUint16(identification[10:12], 312)
+		comments := append([]byte("OpusTags"), newVorbisComment(t, "TITLE=Whoever Brings the Night")...)
+		data := newOgg(t, identification, comments, 48000*42+312)
+		got, err := music.ReadTags(bytes.NewReader(data), "song.ogg")
+
+		tests.AssertNoError(t, err)
+		assertTagsEqual(t, got, &music.Tags{Title: "Whoever Brings the Night", Duration: 42})
+	})
+}
+
+func TestBaseMusicLibraryExplorerWithTags(t *testing.T) {
+	t.Run(`it fills songs with their tags and MIME type
+		and falls back to the file name when tags cannot be read`, func(t *testing.T) {
+		testFS := fstest.MapFS{
+			"Once/ghost.mp3":  {Data: newID3v23MP3(t)},
+			"Once/broken.ogg": {Data: []byte("broken")},
+		}
+		explorer := music.NewMusicLibraryExplorer(testFS)
+		_, songs, err := explorer.ListContents("Once")
+
+		tests.AssertNoError(t, err)
+		assertSongsContain(t, songs, music.Song{Title: "Ghost Love Score", URI: "/music/Once/ghost.mp3"})
+		assertSongsContain(t, songs, music.Song{Title: "broken.ogg", URI: "/music/Once/broken.ogg"})
+		for _, song := range songs {
+			if song.Title == "Ghost Love Score" && (song.Artist != "Nightwish" || song.Type != "audio/mpeg") {
+				t.Errorf("expected song %v to have its artist and MIME type filled", song)
+			}
+		}
+	})
+}
+
+func assertTagsEqual(t *testing.T, got *music.Tags, want *music.Tags) {
+	t.Helper()
+	if *got != *want {
+		t.Errorf("tags %+v do not equal %+v", *got, *want)
+	}
+}
+
+// newID3v23MP3 builds an MP3 with an ID3v2.3 tag followed by a Xing header announcing
+// 200 MPEG-1 Layer III frames at 44.1kHz: 200 * 1152 / 44100 = 5 seconds
+func newID3v23MP3(t *testing.T) []byte {
+	t.Helper()
+	var frames []byte
+	frames = append(frames, newID3v2Frame(t, 3, "TIT2", append([]byte{0}, "Ghost Love Score"...))...)
+	frames = append(frames, newID3v2Frame(t, 3, "TPE1", append([]byte{0}, "Nightwish"...))...)
+	frames = append(frames, newID3v2Frame(t, 3, "TALB", append([]byte{0}, "Once"...))...)
+	frames = append(frames, newID3v2Frame(t, 3, "TRCK", append([]byte{0}, "10/11"...))...)
+	frames = append(frames, newID3v2Frame(t, 3, "TPOS", append([]byte{0}, "1"...))...)
+	frames = append(frames, make([]byte, 32)...) // padding
+
+	audio := make([]byte, 417)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00}) // MPEG-1 Layer III, 128kbps, 44.1kHz, stereo
+	copy(audio[36:], "Xing")
+	binary.BigEndian.PutUint32(audio[40:44], 0x01)
+	binary.BigEndian.PutUint32(audio[44:48], 200)
+	return append(newID3v2Tag(3, frames), audio...)
+}
+
+func newID3v2Tag(majorVersion byte, frames []byte) []byte {
+	header := []byte{'I', 'D', '3', majorVersion, 0, 0}
+	return append(append(header, synchsafe(uint32(len(frames)))...), frames...)
+}
+
+func newID3v2Frame(t *testing.T, majorVersion byte, id string, data []byte) []byte {
+	t.Helper()
+	frame := []byte(id)
+	if majorVersion == 4 {
+		frame = append(frame, synchsafe(uint32(len(data)))...)
+	} else {
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(data)))
+		frame = append(frame, size...)
+	}
+	frame = append(frame, 0, 0)
+	return append(frame, data...)
+}
+
+func synchsafe(value uint32) []byte {
+	return []byte{byte(value >> 21 & 0x7F), byte(value >> 14 & 0x7F), byte(value >> 7 & 0x7F), byte(value & 0x7F)}
+}
+
+func utf16LE(text string) []byte {
+	var encoded []byte
+	for _, r := range text {
+		encoded = append(encoded, byte(r), byte(r>>8))
+	}
+	return encoded
+}
+
+// newCBRFrames builds audioBytes bytes of audio starting with an MPEG-1 Layer III 128kbps frame header
+func newCBRFrames(t *testing.T, audioBytes int) []byte {
+	t.Helper()
+	audio := make([]byte, audioBytes)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return audio
+}
+
+func newID3v1Tag(title string, artist string, album string, track byte, genre byte) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	tag[126] = track
+	tag[127] = genre
+	return tag
+}
+
+func newVorbisComment(t *testing.T, comments ...string) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	vendor := "test vendor"
+	writeLittleEndian(t, buffer, uint32(len(vendor)))
+	buffer.WriteString(vendor)
+	writeLittleEndian(t, buffer, uint32(len(comments)))
+	for _, comment := range comments {
+		writeLittleEndian(t, buffer, uint32(len(comment)))
+		buffer.WriteString(comment)
+	}
+	return buffer.Bytes()
+}
+
+func newFLAC(t *testing.T, sampleRate uint64, totalSamples uint64, vorbisComment []byte) []byte {
+	t.Helper()
+	buffer := bytes.NewBufferString("fLaC")
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint64(streamInfo[10:18], sampleRate<<44|1<<41|15<<36|totalSamples)
+	buffer.Write([]byte{0, 0, 0, 34})
+	buffer.Write(streamInfo)
+	buffer.Write([]byte{1, 0, 0, 8}) // PADDING block
+	buffer.Write(make([]byte, 8))
+	length := len(vorbisComment)
+	buffer.Write([]byte{0x80 | 4, byte(length >> 16), byte(length >> 8), byte(length)})
+	buffer.Write(vorbisComment)
+	return buffer.Bytes()
+}
+
+// newOgg builds three Ogg pages: the identification header, the comment header and
+// an empty last page carrying the final granule position
+func newOgg(t *testing.T, identification []byte, comments []byte, lastGranule uint64) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	writeOggPage(t, buffer, 0, identification)
+	writeOggPage(t, buffer, 0, comments)
+	writeOggPage(t, buffer, lastGranule, []byte{})
+	return buffer.Bytes()
+}
+
+func writeOggPage(t *testing.T, buffer *bytes.Buffer, granule uint64, packet []byte) {
+	t.Helper()
+	var lacing []byte
+	remaining := len(packet)
+	for remaining >= 255 {
+		lacing = append(lacing, 255)
+		remaining -= 255
+	}
+	lacing = append(lacing, byte(remaining))
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:14], granule)
+	header[26] = byte(len(lacing))
+	buffer.Write(header)
+	buffer.Write(lacing)
+	buffer.Write(packet)
+}
+
+func writeLittleEndian(t *testing.T, buffer *bytes.Buffer, value uint32) {
+	t.Helper()
+	if err := binary.Write(buffer, binary.LittleEndian, value); err != nil {
+		t.Fatalf("could not write test data %v", err)
+	}
+}
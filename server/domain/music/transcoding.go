@@ -0,0 +1,92 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedTranscodingFormat is returned when songs cannot be transcoded to the requested format
+	ErrUnsupportedTranscodingFormat = errors.New("unsupported transcoding format")
+	// ErrInvalidBitrate is returned when the requested bitrate is out of bounds
+	ErrInvalidBitrate = errors.New("invalid transcoding bitrate")
+)
+
+// Bounds of the transcoding bitrates, in kbit/s
+const (
+	minimumBitrate = 32
+	maximumBitrate = 320
+)
+
+// TranscodingFormat is an audio format songs can be transcoded to
+type TranscodingFormat struct {
+	Name           string // Name of the format in URIs. For example "opus"
+	MIMEType       string // MIME type of the transcoded songs. For example "audio/ogg"
+	DefaultBitrate uint   // Bitrate in kbit/s used when none is requested
+}
+
+var transcodingFormats = map[string]TranscodingFormat{
+	"opus": {Name: "opus", MIMEType: "audio/ogg", DefaultBitrate: 128},
+	"mp3":  {Name: "mp3", MIMEType: "audio/mpeg", DefaultBitrate: 192},
+	"aac":  {Name: "aac", MIMEType: "audio/aac", DefaultBitrate: 160},
+}
+
+// TranscodingProfile describes how to transcode a song
+type TranscodingProfile struct {
+	Format  TranscodingFormat
+	Bitrate uint // Bitrate in kbit/s. For example 128
+}
+
+// NewTranscodingProfile validates the requested format and bitrate. Bitrate zero means the format's default bitrate.
+// It returns ErrUnsupportedTranscodingFormat or ErrInvalidBitrate when they are not valid.
+func NewTranscodingProfile(formatName string, bitrate uint) (TranscodingProfile, error) {
+	format, ok := transcodingFormats[strings.ToLower(formatName)]
+	if !ok {
+		return TranscodingProfile{}, fmt.Errorf("cannot transcode to %q: %w", formatName, ErrUnsupportedTranscodingFormat)
+	}
+	if bitrate == 0 {
+		bitrate = format.DefaultBitrate
+	}
+	if bitrate < minimumBitrate || bitrate > maximumBitrate {
+		return TranscodingProfile{}, fmt.Errorf(
+			"bitrate must be between %d and %d kbit/s, got %d: %w",
+			minimumBitrate,
+			maximumBitrate,
+			bitrate,
+			ErrInvalidBitrate,
+		)
+	}
+	return TranscodingProfile{Format: format, Bitrate: bitrate}, nil
+}
+
+// String identifies the profile, for example "opus-128"
+func (p TranscodingProfile) String() string {
+	return fmt.Sprintf("%s-%d", p.Format.Name, p.Bitrate)
+}
+
+// Transcoder converts music files to other audio formats
+type Transcoder interface {
+	// Transcode reads the music file at sourcePath, converts it following profile and writes the result to output
+	// as it goes. It stops as soon as ctx is done.
+	Transcode(ctx context.Context, sourcePath string, profile TranscodingProfile, output io.Writer) error
+}
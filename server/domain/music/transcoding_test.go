@@ -0,0 +1,62 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/server/domain/music"
+	"github.com/hyzual/mike-sierra-sierra/tests"
+)
+
+func TestNewTranscodingProfile(t *testing.T) {
+	t.Run("given a format and a bitrate, it returns the matching profile", func(t *testing.T) {
+		profile, err := music.NewTranscodingProfile("Opus", 96)
+
+		tests.AssertNoError(t, err)
+		if profile.Format.MIMEType != "audio/ogg" || profile.Bitrate != 96 || profile.String() != "opus-96" {
+			t.Errorf("unexpected profile %+v", profile)
+		}
+	})
+
+	t.Run("given no bitrate, it uses the format's default bitrate", func(t *testing.T) {
+		profile, err := music.NewTranscodingProfile("mp3", 0)
+
+		tests.AssertNoError(t, err)
+		if profile.Bitrate != 192 {
+			t.Errorf("expected the default bitrate of 192 kbit/s, got %d", profile.Bitrate)
+		}
+	})
+
+	t.Run("given an unknown format, it returns ErrUnsupportedTranscodingFormat", func(t *testing.T) {
+		_, err := music.NewTranscodingProfile("wav", 0)
+		if !errors.Is(err, music.ErrUnsupportedTranscodingFormat) {
+			t.Errorf("expected ErrUnsupportedTranscodingFormat, got %v", err)
+		}
+	})
+
+	t.Run("given a bitrate out of bounds, it returns ErrInvalidBitrate", func(t *testing.T) {
+		for _, bitrate := range []uint{8, 512} {
+			_, err := music.NewTranscodingProfile("aac", bitrate)
+			if !errors.Is(err, music.ErrInvalidBitrate) {
+				t.Errorf("expected ErrInvalidBitrate for %d kbit/s, got %v", bitrate, err)
+			}
+		}
+	})
+}
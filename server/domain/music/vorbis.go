@@ -0,0 +1,358 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package music
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// See https://xiph.org/flac/format.html
+const (
+	flacStreamInfoBlock    = 0
+	flacVorbisCommentBlock = 4
+	flacPictureBlock       = 6
+	flacLastBlockFlag      = 0x80
+	flacStreamInfoSize     = 18 // Bytes of the STREAMINFO block up to the total samples
+)
+
+// readFLACTags reads the STREAMINFO block for the duration and the VORBIS_COMMENT block for the tags
+func readFLACTags(file io.ReadSeeker) (*Tags, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to the start of the file: %w", err)
+	}
+	// Some taggers wrongly prepend an ID3v2 tag to FLAC files, skip it
+	start, err := readID3v2(file, &Tags{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to the FLAC stream: %w", err)
+	}
+	marker, err := readFull(file, 4)
+	if err != nil || string(marker) != "fLaC" {
+		return nil, errors.New("could not find the FLAC stream marker")
+	}
+
+	tags := &Tags{}
+	for {
+		blockHeader, err := readFull(file, 4)
+		if err != nil {
+			return nil, fmt.Errorf("could not read a FLAC metadata block header: %w", err)
+		}
+		blockType := blockHeader[0] &^ flacLastBlockFlag
+		blockLength := int(blockHeader[1])<<16 | int(blockHeader[2])<<8 | int(blockHeader[3])
+		switch blockType {
+		case flacStreamInfoBlock:
+			block, err := readFull(file, blockLength)
+			if err != nil {
+				return nil, fmt.Errorf("could not read the FLAC STREAMINFO block: %w", err)
+			}
+			if len(block) < flacStreamInfoSize {
+				return nil, fmt.Errorf("the FLAC STREAMINFO block is too short: %d bytes", len(block))
+			}
+			// 20 bits of sample rate, 3 bits of channels, 5 bits of bits per sample, 36 bits of total samples
+			packed := binary.BigEndian.Uint64(block[10:flacStreamInfoSize])
+			sampleRate := packed >> 44
+			totalSamples := packed & (1<<36 - 1)
+			if sampleRate != 0 {
+				tags.Duration = uint(totalSamples / sampleRate)
+			}
+		case flacVorbisCommentBlock:
+			block, err := readFull(file, blockLength)
+			if err != nil {
+				return nil, fmt.Errorf("could not read the FLAC VORBIS_COMMENT block: %w", err)
+			}
+			comments, err := parseVorbisComment(block)
+			if err != nil {
+				return nil, err
+			}
+			comments.Duration = tags.Duration
+			comments.Picture = preferredPicture(tags.Picture, comments.Picture)
+			tags = comments
+		case flacPictureBlock:
+			block, err := readFull(file, blockLength)
+			if err != nil {
+				return nil, fmt.Errorf("could not read the FLAC PICTURE block: %w", err)
+			}
+			if picture, ok := parseFLACPicture(block); ok {
+				tags.Picture = preferredPicture(tags.Picture, picture)
+			}
+		default:
+			if _, err := file.Seek(int64(blockLength), io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("could not skip a FLAC metadata block: %w", err)
+			}
+		}
+		if blockHeader[0]&flacLastBlockFlag != 0 {
+			return tags, nil
+		}
+	}
+}
+
+// parseFLACPicture parses the contents of a FLAC PICTURE block.
+// See https://xiph.org/flac/format.html#metadata_block_picture
+func parseFLACPicture(block []byte) (*Picture, bool) {
+	reader := bytes.NewReader(block)
+	var pictureType, mimeLength uint32
+	if binary.Read(reader, binary.BigEndian, &pictureType) != nil || binary.Read(reader, binary.BigEndian, &mimeLength) != nil {
+		return nil, false
+	}
+	if int64(mimeLength) > int64(reader.Len()) {
+		return nil, false
+	}
+	mimeType, _ := readFull(reader, int(mimeLength))
+	var descriptionLength uint32
+	if binary.Read(reader, binary.BigEndian, &descriptionLength) != nil || int64(descriptionLength) > int64(reader.Len()) {
+		return nil, false
+	}
+	// Skip the description, width, height, color depth and number of colors
+	if _, err := reader.Seek(int64(descriptionLength)+16, io.SeekCurrent); err != nil {
+		return nil, false
+	}
+	var dataLength uint32
+	if binary.Read(reader, binary.BigEndian, &dataLength) != nil || dataLength == 0 || int64(dataLength) > int64(reader.Len()) {
+		return nil, false
+	}
+	data, err := readFull(reader, int(dataLength))
+	if err != nil {
+		return nil, false
+	}
+	return &Picture{
+		MIMEType:     normalizeImageMIMEType(strings.ToLower(string(mimeType)), data),
+		Data:         data,
+		isFrontCover: pictureType == frontCoverPictureType,
+	}, true
+}
+
+// See https://xiph.org/ogg/doc/framing.html and https://wiki.xiph.org/OggOpus
+const (
+	oggPageHeaderSize = 27
+	// Opus granule positions always count samples at 48kHz
+	opusGranuleRate = 48000
+	// We look for the last Ogg page in this many bytes at the end of the file
+	oggLastPageWindow = 64 * 1024
+)
+
+// readOggTags reads the identification and comment headers of the first logical stream
+// (Vorbis or Opus) and computes the duration from the granule position of the last page.
+func readOggTags(file io.ReadSeeker) (*Tags, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to the start of the file: %w", err)
+	}
+	reader := &oggPacketReader{reader: file}
+	identification, err := reader.nextPacket()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the Ogg identification header: %w", err)
+	}
+	commentHeader, err := reader.nextPacket()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the Ogg comment header: %w", err)
+	}
+
+	var (
+		sampleRate uint64
+		preSkip    uint64
+		comments   []byte
+	)
+	switch {
+	case len(identification) >= 16 && bytes.HasPrefix(identification, []byte("\x01vorbis")):
+		sampleRate = uint64(binary.LittleEndian.Uint32(identification[12:16]))
+		if !bytes.HasPrefix(commentHeader, []byte("\x03vorbis")) {
+			return nil, errors.New("could not find the Vorbis comment header")
+		}
+		comments = commentHeader[7:]
+	case len(identification) >= 12 && bytes.HasPrefix(identification, []byte("OpusHead")):
+		sampleRate = opusGranuleRate
+		preSkip = uint64(binary.LittleEndian.Uint16(identification[10:12]))
+		if !bytes.HasPrefix(commentHeader, []byte("OpusTags")) {
+			return nil, errors.New("could not find the Opus comment header")
+		}
+		comments = commentHeader[8:]
+	default:
+		return nil, errors.New("the Ogg stream is neither Vorbis nor Opus")
+	}
+
+	tags, err := parseVorbisComment(comments)
+	if err != nil {
+		return nil, err
+	}
+	granule, err := readLastOggGranulePosition(file)
+	if err == nil && sampleRate != 0 && granule > preSkip {
+		tags.Duration = uint((granule - preSkip) / sampleRate)
+	}
+	return tags, nil
+}
+
+// oggPacketReader reassembles packets from the segments of consecutive Ogg pages
+type oggPacketReader struct {
+	reader   io.Reader
+	segments []byte // lacing values of the current page that have not been read yet
+}
+
+func (o *oggPacketReader) nextPacket() ([]byte, error) {
+	var packet []byte
+	for {
+		if len(o.segments) == 0 {
+			if err := o.readPageHeader(); err != nil {
+				return nil, err
+			}
+		}
+		segmentLength := o.segments[0]
+		o.segments = o.segments[1:]
+		segment, err := readFull(o.reader, int(segmentLength))
+		if err != nil {
+			return nil, err
+		}
+		packet = append(packet, segment...)
+		// A lacing value lower than 255 ends the packet
+		if segmentLength < 255 {
+			return packet, nil
+		}
+	}
+}
+
+func (o *oggPacketReader) readPageHeader() error {
+	header, err := readFull(o.reader, oggPageHeaderSize)
+	if err != nil {
+		return err
+	}
+	if string(header[0:4]) != "OggS" {
+		return errors.New("could not find the Ogg page capture pattern")
+	}
+	segments, err := readFull(o.reader, int(header[26]))
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return errors.New("the Ogg page does not contain any segment")
+	}
+	o.segments = segments
+	return nil
+}
+
+// readLastOggGranulePosition searches for the last Ogg page at the end of the file
+// and returns its granule position: the number of samples since the start of the stream.
+func readLastOggGranulePosition(file io.ReadSeeker) (uint64, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	windowStart := size - oggLastPageWindow
+	if windowStart < 0 {
+		windowStart = 0
+	}
+	if _, err = file.Seek(windowStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+	window, err := readFull(file, int(size-windowStart))
+	if err != nil {
+		return 0, err
+	}
+	index := bytes.LastIndex(window, []byte("OggS"))
+	if index == -1 || index+14 > len(window) {
+		return 0, errors.New("could not find the last Ogg page")
+	}
+	return binary.LittleEndian.Uint64(window[index+6 : index+14]), nil
+}
+
+// parseVorbisComment parses a Vorbis comment structure (without its framing bit).
+// See https://www.xiph.org/vorbis/doc/v-comment.html
+func parseVorbisComment(data []byte) (*Tags, error) {
+	reader := bytes.NewReader(data)
+	var vendorLength uint32
+	if err := binary.Read(reader, binary.LittleEndian, &vendorLength); err != nil {
+		return nil, fmt.Errorf("could not read the Vorbis comment vendor: %w", err)
+	}
+	if _, err := reader.Seek(int64(vendorLength), io.SeekCurrent); err != nil {
+		return nil, fmt.Errorf("could not skip the Vorbis comment vendor: %w", err)
+	}
+	var count uint32
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("could not read the Vorbis comments count: %w", err)
+	}
+
+	tags := &Tags{}
+	for i := uint32(0); i < count; i++ {
+		var length uint32
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			return nil, fmt.Errorf("could not read a Vorbis comment length: %w", err)
+		}
+		if int64(length) > int64(reader.Len()) {
+			return nil, errors.New("a Vorbis comment is longer than its header")
+		}
+		comment, err := readFull(reader, int(length))
+		if err != nil {
+			return nil, fmt.Errorf("could not read a Vorbis comment: %w", err)
+		}
+		key, value, ok := splitVorbisComment(string(comment))
+		if !ok {
+			continue
+		}
+		// Comments can be repeated, only keep the first value
+		switch key {
+		case "TITLE":
+			if tags.Title == "" {
+				tags.Title = value
+			}
+		case "ARTIST":
+			if tags.Artist == "" {
+				tags.Artist = value
+			}
+		case "ALBUM":
+			if tags.Album == "" {
+				tags.Album = value
+			}
+		case "GENRE":
+			if tags.Genre == "" {
+				tags.Genre = value
+			}
+		case "TRACKNUMBER":
+			if tags.TrackNumber == 0 {
+				tags.TrackNumber = parsePositionNumber(value)
+			}
+		case "DISCNUMBER":
+			if tags.DiskNumber == 0 {
+				tags.DiskNumber = parsePositionNumber(value)
+			}
+		case "METADATA_BLOCK_PICTURE":
+			// Ogg files embed pictures as base64-encoded FLAC PICTURE blocks
+			block, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+			if picture, ok := parseFLACPicture(block); ok {
+				tags.Picture = preferredPicture(tags.Picture, picture)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// splitVorbisComment splits "KEY=value". Keys are case-insensitive so they are returned uppercased.
+func splitVorbisComment(comment string) (string, string, bool) {
+	index := strings.Index(comment, "=")
+	if index == -1 {
+		return "", "", false
+	}
+	return strings.ToUpper(comment[:index]), strings.TrimSpace(comment[index+1:]), true
+}
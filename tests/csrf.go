@@ -0,0 +1,80 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfFieldName must match server.CSRFFieldName. The server package cannot be imported here,
+// its own tests use this package.
+const csrfFieldName = "csrf-token"
+
+// CSRFTokenIssuer creates the cookies and the tokens accepted by the protection against cross-site
+// request forgery. It is implemented by server.CSRFProtection.
+type CSRFTokenIssuer interface {
+	NewToken() (*http.Cookie, string, error)
+}
+
+// NewPostFormRequest creates a new POST request to url with the given form. When issuer is not nil,
+// the request carries a valid anti-CSRF cookie and token.
+func NewPostFormRequest(t *testing.T, issuer CSRFTokenIssuer, url string, form url.Values) *http.Request {
+	t.Helper()
+	var cookie *http.Cookie
+	if issuer != nil {
+		var (
+			token string
+			err   error
+		)
+		cookie, token, err = issuer.NewToken()
+		if err != nil {
+			t.Fatalf("could not create an anti-CSRF token: %v", err)
+		}
+		form = cloneValues(form)
+		form.Set(csrfFieldName, token)
+	}
+	request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	return request
+}
+
+// AssertCSRFCookieSet verifies that the response gives an anti-CSRF cookie to the browser
+func AssertCSRFCookieSet(t *testing.T, response *httptest.ResponseRecorder) {
+	t.Helper()
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == "csrf" && cookie.Value != "" && cookie.HttpOnly {
+			return
+		}
+	}
+	t.Errorf("expected an anti-CSRF cookie, got %v", response.Result().Cookies())
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for key, value := range values {
+		clone[key] = append([]string(nil), value...)
+	}
+	return clone
+}
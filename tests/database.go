@@ -0,0 +1,47 @@
+/*
+ *   Copyright (C) 2021  Joris MASSON
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU Affero General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU Affero General Public License for more details.
+ *
+ *   You should have received a copy of the GNU Affero General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyzual/mike-sierra-sierra/database"
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+)
+
+// NewDatabase creates a new SQLite database in a temporary directory and runs the
+// migrations on it. The database is closed at the end of the test.
+func NewDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	databasePath := filepath.Join(t.TempDir(), "mike.db")
+	db, err := sql.Open("sqlite3", "file:"+databasePath+"?mode=rwc&"+database.ConnectionOptions)
+	if err != nil {
+		t.Fatalf("could not open the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	if err = database.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("could not migrate the test database: %v", err)
+	}
+	return db
+}
@@ -142,5 +142,8 @@ func (s *stubSessionStore) DeleteByID(ctx context.Context, id string) error {
 
 // DeleteByUserKey mocks sessionup Store's method
 func (s *stubSessionStore) DeleteByUserKey(ctx context.Context, key string, expID ...string) error {
-	return errors.New("This method is not supposed to be call in the tests")
+	if s.shouldThrowOnDelete {
+		return errors.New("Could not delete the sessions")
+	}
+	return nil
 }